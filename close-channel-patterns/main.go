@@ -10,6 +10,8 @@ func main() {
 
 	// ManySendersManyReceivers()
 
+	// ManySendersManyReceiversSafeChan()
+
 	// ManySendersOneReceiverThirdPartyClose()
 
 
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"close-channel-patterns/safechan"
+	"context"
+	"errors"
 	"log"
 	"math/rand/v2"
 	"strconv"
@@ -100,3 +103,66 @@ func ManySendersManyReceivers() {
 
 	wgR.Wait()
 }
+
+// ManySendersManyReceiversSafeChan is ManySendersManyReceivers with the moderator and toStopCh of safechan.
+func ManySendersManyReceiversSafeChan() {
+	const (
+		maxNum = 1e4
+
+		sNum     = 10_000
+		sStopVal = 333
+
+		rNum     = 1_000
+		rStopVal = 777
+	)
+
+	dataCh := safechan.New[int](0)
+
+	var (
+		wgS sync.WaitGroup
+		wgR sync.WaitGroup
+	)
+
+	for i := range sNum {
+		wgS.Add(1)
+		go func(id string) {
+			defer wgS.Done()
+
+			for {
+				v := rand.IntN(maxNum)
+				if v == sStopVal {
+					dataCh.Stop(errors.New("sender-" + id))
+					return
+				}
+
+				if dataCh.Send(context.Background(), v) != nil {
+					return
+				}
+			}
+		}(strconv.Itoa(i))
+	}
+
+	for i := range rNum {
+		wgR.Add(1)
+		go func(id string) {
+			defer wgR.Done()
+
+			for {
+				v, err := dataCh.Recv(context.Background())
+				if err != nil {
+					return
+				}
+				if v == rStopVal {
+					dataCh.Stop(errors.New("receiver-" + id))
+					return
+				}
+
+				log.Println(v)
+			}
+		}(strconv.Itoa(i))
+	}
+
+	wgS.Wait()
+	wgR.Wait()
+	log.Println("Stopped by:", dataCh.Err())
+}
@@ -0,0 +1,77 @@
+/*
+Package safechan wraps the channel closing patterns of the demos into types.
+
+The demos follow the principle of not closing a channel from the receiver side and not closing a channel with
+multiple concurrent senders. Each of them hand-rolls the same parts: the toStopCh of capacity 1 the first stopper
+wins, the moderator that closes the stoppedCh, and the senders checking the stoppedCh before every send. Closer is
+that stop signal, SafeChan is the data channel on top of it that is closed by the stop once no sender is in the
+middle of a send.
+*/
+package safechan
+
+import "errors"
+
+// ErrClosed is the cause of the stop by Close
+var ErrClosed = errors.New("safechan: closed")
+
+/*
+Closer is the stop signal any sender, receiver or third party can trigger with a cause. The first stop wins: the
+toStop channel of capacity 1 takes its token, and the winner is the moderator that records the cause and closes
+the done channel. The other stops wait for it.
+*/
+type Closer struct {
+	toStop chan struct{}
+	done   chan struct{}
+	cause  error
+}
+
+func NewCloser() *Closer {
+	return &Closer{toStop: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+// Stop triggers the stop with the cause, ErrClosed if nil. It's idempotent and returns once the stop is signalled
+func (c *Closer) Stop(cause error) {
+	if c.stop(cause) {
+		c.signal()
+	}
+}
+
+// Close is Stop with ErrClosed
+func (c *Closer) Close() {
+	c.Stop(nil)
+}
+
+// stop reports whether the call won the stop, the winner must call signal
+func (c *Closer) stop(cause error) bool {
+	select {
+	case c.toStop <- struct{}{}:
+		if cause == nil {
+			cause = ErrClosed
+		}
+		c.cause = cause
+		return true
+	default:
+		<-c.done
+		return false
+	}
+}
+
+// signal closes the done channel, the cause is visible to the ones that saw it closed
+func (c *Closer) signal() {
+	close(c.done)
+}
+
+// Done returns the channel closed by the stop
+func (c *Closer) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the cause of the stop, nil until it's stopped
+func (c *Closer) Err() error {
+	select {
+	case <-c.done:
+		return c.cause
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,145 @@
+package safechan
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+SafeChan is the channel of N senders and M receivers any of which can stop it. The senders never see the channel
+closed: a send holds the read lock, and the stop closes the channel under the write lock, so it waits for the sends in
+flight, which give up as soon as the stop is signalled.
+*/
+type SafeChan[T any] struct {
+	ch     chan T
+	closer *Closer
+	mu     sync.RWMutex
+	// Closed after the data channel
+	closed chan struct{}
+}
+
+// New returns the channel of the buffer size
+func New[T any](size int) *SafeChan[T] {
+	return &SafeChan[T]{ch: make(chan T, size), closer: NewCloser(), closed: make(chan struct{})}
+}
+
+/*
+Send sends v, blocking until a receiver or the buffer takes it. It returns ErrClosed once the channel is stopped, even
+if the send could still proceed, and ctx.Err() when ctx is done.
+*/
+func (s *SafeChan[T]) Send(ctx context.Context, v T) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// The stop has the priority over the send that's ready too
+	select {
+	case <-s.closer.done:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case <-s.closer.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.ch <- v:
+		return nil
+	}
+}
+
+// TrySend sends v if a receiver or the buffer takes it at once, it returns false after the stop instead of panicking
+func (s *SafeChan[T]) TrySend(v T) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	select {
+	case <-s.closer.done:
+		return false
+	default:
+	}
+
+	select {
+	case s.ch <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+C returns the channel for the receivers to range over. It's closed after the stop, once the sends in flight are done,
+and the range still receives the values left in the buffer.
+*/
+func (s *SafeChan[T]) C() <-chan T {
+	return s.ch
+}
+
+/*
+Recv receives a value. Unlike ranging over C it returns the cause of the stop as soon as the channel is stopped,
+leaving the buffered values to Drain, and ctx.Err() when ctx is done.
+*/
+func (s *SafeChan[T]) Recv(ctx context.Context) (T, error) {
+	var zero T
+
+	select {
+	case <-s.closer.done:
+		return zero, s.closer.cause
+	default:
+	}
+
+	select {
+	case <-s.closer.done:
+		return zero, s.closer.cause
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case v := <-s.ch:
+		return v, nil
+	}
+}
+
+/*
+Stop stops the channel with the cause, ErrClosed if nil: the senders and the Recv receivers give up, and the channel
+is closed. Stop is idempotent and it returns once the channel is closed, the cause of the first stop wins.
+*/
+func (s *SafeChan[T]) Stop(cause error) {
+	if !s.closer.stop(cause) {
+		<-s.closed
+		return
+	}
+
+	s.closer.signal()
+	s.mu.Lock()
+	close(s.ch)
+	s.mu.Unlock()
+	close(s.closed)
+}
+
+// Close is Stop with ErrClosed
+func (s *SafeChan[T]) Close() {
+	s.Stop(nil)
+}
+
+// Done returns the channel closed by the stop, before the data channel is closed
+func (s *SafeChan[T]) Done() <-chan struct{} {
+	return s.closer.Done()
+}
+
+// Err returns the cause of the stop, nil until it's stopped
+func (s *SafeChan[T]) Err() error {
+	return s.closer.Err()
+}
+
+/*
+Drain waits for the channel to be stopped and closed and returns the values left in its buffer, so nothing that was
+sent is lost silently. The receivers ranging over C concurrently may take some of them.
+*/
+func (s *SafeChan[T]) Drain() []T {
+	<-s.closed
+
+	var rest []T
+	for v := range s.ch {
+		rest = append(rest, v)
+	}
+	return rest
+}
@@ -0,0 +1,208 @@
+package safechan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	senders   = 5_000
+	receivers = 1_000
+)
+
+func TestCloser(t *testing.T) {
+	c := NewCloser()
+	if c.Err() != nil {
+		t.Error("Err() before the stop")
+	}
+
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Stop(fmt.Errorf("stopper %d", i))
+			// The stop is signalled when Stop returns
+			<-c.Done()
+		}()
+	}
+	wg.Wait()
+
+	cause := c.Err()
+	c.Close()
+	if cause == nil || c.Err() != cause {
+		t.Errorf("Err() = %v, then %v", cause, c.Err())
+	}
+}
+
+// TestSafeChanRange hammers the channel with the senders and receivers stopping it at random
+func TestSafeChanRange(t *testing.T) {
+	var (
+		s                      = New[int](16)
+		sent, received, stoppd atomic.Int64
+		wgS, wgR               sync.WaitGroup
+	)
+
+	for i := range senders {
+		wgS.Add(1)
+		go func() {
+			defer wgS.Done()
+
+			for {
+				if rand.IntN(1_000) == 0 {
+					s.Stop(fmt.Errorf("sender %d", i))
+					stoppd.Add(1)
+					return
+				}
+				if err := s.Send(context.Background(), i); err != nil {
+					if !errors.Is(err, ErrClosed) {
+						t.Error(err)
+					}
+					return
+				}
+				sent.Add(1)
+			}
+		}()
+	}
+
+	for i := range receivers {
+		wgR.Add(1)
+		go func() {
+			defer wgR.Done()
+
+			for range s.C() {
+				received.Add(1)
+				if rand.IntN(100_000) == 0 {
+					s.Stop(fmt.Errorf("receiver %d", i))
+				}
+			}
+		}()
+	}
+
+	wgS.Wait()
+	wgR.Wait()
+
+	// The range receives everything sent, the buffer is empty
+	if rest := s.Drain(); len(rest) != 0 || sent.Load() != received.Load() {
+		t.Errorf("%d sent, %d received, %d left", sent.Load(), received.Load(), len(rest))
+	}
+	if s.Err() == nil || stoppd.Load() == 0 {
+		t.Errorf("Err() = %v after %d stops", s.Err(), stoppd.Load())
+	}
+
+	// The stopped channel doesn't panic
+	s.Close()
+	if s.TrySend(1) {
+		t.Error("TrySend() after the stop")
+	}
+	if err := s.Send(context.Background(), 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send() after the stop = %v", err)
+	}
+}
+
+// TestSafeChanRecv stops the Recv receivers early, Drain collects what's left in the buffer
+func TestSafeChanRecv(t *testing.T) {
+	var (
+		s              = New[int](64)
+		sent, received atomic.Int64
+		wgS, wgR       sync.WaitGroup
+		third, cause   = make(chan struct{}), errors.New("third party")
+		ctx, cancel    = context.WithTimeout(context.Background(), 10*time.Second)
+	)
+	defer cancel()
+
+	for i := range senders {
+		wgS.Add(1)
+		go func() {
+			defer wgS.Done()
+
+			for {
+				var ok bool
+				if i%2 == 0 {
+					// The failed TrySend yields, so the spinning senders don't starve the rest
+					if ok = s.TrySend(i); !ok {
+						time.Sleep(time.Millisecond)
+					}
+				} else {
+					ok = s.Send(ctx, i) == nil
+				}
+				if ok {
+					sent.Add(1)
+				}
+				select {
+				case <-s.Done():
+					return
+				default:
+				}
+			}
+		}()
+	}
+
+	for range receivers {
+		wgR.Add(1)
+		go func() {
+			defer wgR.Done()
+
+			for {
+				if _, err := s.Recv(ctx); err != nil {
+					if err != cause {
+						t.Errorf("Recv() = %v", err)
+					}
+					return
+				}
+				received.Add(1)
+			}
+		}()
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.Stop(cause)
+		close(third)
+	}()
+
+	rest := s.Drain()
+	<-third
+	wgS.Wait()
+	wgR.Wait()
+
+	if got := received.Load() + int64(len(rest)); got != sent.Load() {
+		t.Errorf("%d sent, %d received and %d drained", sent.Load(), received.Load(), len(rest))
+	}
+	if s.Err() != cause {
+		t.Errorf("Err() = %v", s.Err())
+	}
+}
+
+func TestSafeChanSendContext(t *testing.T) {
+	s := New[int](0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Send(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send() without receivers = %v", err)
+	}
+	if _, err := s.Recv(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Recv() without senders = %v", err)
+	}
+
+	// The blocked sender gives up on the stop, the stop doesn't wait for it longer
+	errc := make(chan error)
+	go func() {
+		errc <- s.Send(context.Background(), 1)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+	if err := <-errc; !errors.Is(err, ErrClosed) {
+		t.Errorf("the blocked Send() = %v", err)
+	}
+	if _, ok := <-s.C(); ok {
+		t.Error("C() isn't closed")
+	}
+}
@@ -0,0 +1,114 @@
+package chansync
+
+import "context"
+
+/*
+BARRIER
+1. A Barrier makes a fixed number of parties wait for each other: the first n-1 calls to Await block, the n-th
+	releases them all.
+2. It's cyclic: the released parties can meet at the barrier again, so it's a WaitGroup whose generation ends when
+	the n-th party arrives rather than when the counter drops to zero.
+*/
+
+type barrierState struct {
+	arrived int
+	// Closed when the generation is released
+	release chan struct{}
+}
+
+type Barrier struct {
+	parties int
+	state   chan barrierState
+}
+
+func NewBarrier(parties int) *Barrier {
+	b := &Barrier{parties: parties, state: make(chan barrierState, 1)}
+	b.state <- barrierState{release: make(chan struct{})}
+	return b
+}
+
+/*
+Await blocks until all the parties have called it. The party whose ctx is done leaves the barrier with ctx.Err(), the
+others keep waiting for a party to take its place.
+*/
+func (b *Barrier) Await(ctx context.Context) error {
+	st := <-b.state
+	st.arrived++
+	if st.arrived == b.parties {
+		// The last party releases the generation and starts the next one
+		close(st.release)
+		b.state <- barrierState{release: make(chan struct{})}
+		return nil
+	}
+	release := st.release
+	b.state <- st
+
+	select {
+	case <-release:
+		return nil
+	case <-ctx.Done():
+		st := <-b.state
+		select {
+		case <-release:
+			// The generation was released after ctx was done, we're counted in it
+			b.state <- st
+			return nil
+		default:
+			st.arrived--
+			b.state <- st
+			return ctx.Err()
+		}
+	}
+}
+
+/*
+COUNTDOWNLATCH
+1. A latch is a one shot WaitGroup: it's created with the count, and once the count reaches zero it stays open.
+*/
+
+type CountDownLatch struct {
+	count chan int
+	done  chan struct{}
+}
+
+func NewCountDownLatch(count int) *CountDownLatch {
+	l := &CountDownLatch{count: make(chan int, 1), done: make(chan struct{})}
+	if count <= 0 {
+		close(l.done)
+	}
+	l.count <- max(count, 0)
+	return l
+}
+
+// CountDown decrements the count, the count that reaches zero opens the latch
+func (l *CountDownLatch) CountDown() {
+	n := <-l.count
+	if n > 0 {
+		n--
+		if n == 0 {
+			close(l.done)
+		}
+	}
+	l.count <- n
+}
+
+func (l *CountDownLatch) Count() int {
+	n := <-l.count
+	l.count <- n
+	return n
+}
+
+// Done returns the channel closed when the latch opens
+func (l *CountDownLatch) Done() <-chan struct{} {
+	return l.done
+}
+
+// Wait blocks until the latch opens or ctx is done
+func (l *CountDownLatch) Wait(ctx context.Context) error {
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
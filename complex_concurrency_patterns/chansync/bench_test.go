@@ -0,0 +1,132 @@
+package chansync
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+)
+
+// The benchmarks compare the channel primitives with their sync equivalents under contention
+
+func BenchmarkMutex(b *testing.B) {
+	m := NewMutex()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Lock()
+			m.Unlock()
+		}
+	})
+}
+
+func BenchmarkSyncMutex(b *testing.B) {
+	var m sync.Mutex
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Lock()
+			m.Unlock()
+		}
+	})
+}
+
+func BenchmarkRWMutexRead(b *testing.B) {
+	rw := NewRWMutex()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rw.RLock()
+			rw.RUnlock()
+		}
+	})
+}
+
+func BenchmarkSyncRWMutexRead(b *testing.B) {
+	var rw sync.RWMutex
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rw.RLock()
+			rw.RUnlock()
+		}
+	})
+}
+
+func BenchmarkSemaphore(b *testing.B) {
+	s := NewSemaphore(4)
+	ctx := context.Background()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = s.Acquire(ctx, 1)
+			s.Release(1)
+		}
+	})
+}
+
+func BenchmarkChannelSemaphore(b *testing.B) {
+	s := make(chan struct{}, 4)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s <- struct{}{}
+			<-s
+		}
+	})
+}
+
+func BenchmarkOnce(b *testing.B) {
+	o := NewOnce()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			o.Do(func() {})
+		}
+	})
+}
+
+func BenchmarkSyncOnce(b *testing.B) {
+	var o sync.Once
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			o.Do(func() {})
+		}
+	})
+}
+
+func BenchmarkWaitGroup(b *testing.B) {
+	wg := NewWaitGroup()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wg.Add(1)
+			wg.Done()
+		}
+	})
+}
+
+func BenchmarkSyncWaitGroup(b *testing.B) {
+	var wg sync.WaitGroup
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wg.Add(1)
+			wg.Done()
+		}
+	})
+}
+
+func BenchmarkPool(b *testing.B) {
+	p := NewPool(64, func() *bytes.Buffer { return new(bytes.Buffer) }, func(buf *bytes.Buffer) *bytes.Buffer {
+		buf.Reset()
+		return buf
+	})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.Put(p.Get())
+		}
+	})
+}
+
+func BenchmarkSyncPool(b *testing.B) {
+	p := sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buf := p.Get().(*bytes.Buffer)
+			buf.Reset()
+			p.Put(buf)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package chansync
+
+import "context"
+
+/*
+BROADCASTER
+1. sync.Cond can't be used in a select, and its Wait can't give up. The channel version of Broadcast is closing a
+	channel: all the receivers wake up at once.
+2. A channel can be closed only once, so every Broadcast closes the current channel and replaces it with a new one,
+	the waiters listen to the channel that was current when they started waiting.
+3. Unlike sync.Cond there is no lock to check the condition under: a waiter takes the channel with Listen, then
+	checks the condition, and waits on the channel if it doesn't hold. A Broadcast in between closes the channel it
+	took, so the waiter doesn't miss it.
+*/
+
+type Broadcaster chan chan struct{}
+
+func NewBroadcaster() Broadcaster {
+	b := make(Broadcaster, 1)
+	b <- make(chan struct{})
+	return b
+}
+
+// Listen returns the channel closed by the next Broadcast
+func (b Broadcaster) Listen() <-chan struct{} {
+	c := <-b
+	b <- c
+	return c
+}
+
+// Wait blocks until the next Broadcast or until ctx is done
+func (b Broadcaster) Wait(ctx context.Context) error {
+	select {
+	case <-b.Listen():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Broadcast wakes all the goroutines waiting
+func (b Broadcaster) Broadcast() {
+	c := <-b
+	close(c)
+	b <- make(chan struct{})
+}
@@ -0,0 +1,423 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const goroutines = 64
+
+// stress runs f in goroutines concurrently and waits for them
+func stress(f func(i int)) {
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// blocked reports whether f is still blocked after a while
+func blocked(f func()) (<-chan struct{}, bool) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	select {
+	case <-done:
+		return done, false
+	case <-time.After(20 * time.Millisecond):
+		return done, true
+	}
+}
+
+// expired returns the context done in a moment
+func expired(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestOnce(t *testing.T) {
+	var (
+		o     = NewOnce()
+		calls atomic.Int32
+	)
+	stress(func(int) {
+		o.Do(func() {
+			time.Sleep(time.Millisecond)
+			calls.Add(1)
+		})
+		// Do returns once f has returned
+		if calls.Load() != 1 {
+			t.Error("Do returned before f")
+		}
+	})
+
+	// The panicking f counts as done
+	o = NewOnce()
+	func() {
+		defer func() { _ = recover() }()
+		o.Do(func() { panic("boom") })
+	}()
+	o.Do(func() { t.Error("f called after the panic") })
+}
+
+func TestMutex(t *testing.T) {
+	var (
+		m       = NewMutex()
+		counter int
+	)
+	stress(func(int) {
+		for range 100 {
+			m.Lock()
+			counter++
+			m.Unlock()
+		}
+	})
+	if counter != goroutines*100 {
+		t.Errorf("counter %d", counter)
+	}
+
+	m.Lock()
+	if m.TryLock() {
+		t.Error("TryLock() of the locked mutex")
+	}
+	if err := m.LockContext(expired(t)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LockContext() = %v", err)
+	}
+	m.Unlock()
+	if !m.TryLock() {
+		t.Error("TryLock() of the free mutex")
+	}
+	m.Unlock()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Unlock() of the unlocked mutex didn't panic")
+		}
+	}()
+	m.Unlock()
+}
+
+func TestSemaphore(t *testing.T) {
+	const size = 10
+
+	var (
+		s       = NewSemaphore(size)
+		holding atomic.Int64
+	)
+	stress(func(i int) {
+		n := int64(i%size + 1)
+		for range 20 {
+			if err := s.Acquire(context.Background(), n); err != nil {
+				t.Error(err)
+				return
+			}
+			if h := holding.Add(n); h > size {
+				t.Errorf("%d tokens held", h)
+			}
+			holding.Add(-n)
+			s.Release(n)
+		}
+	})
+
+	if !s.TryAcquire(size) || s.TryAcquire(1) {
+		t.Error("TryAcquire() of the whole semaphore")
+	}
+	s.Release(size)
+}
+
+func TestSemaphoreOrder(t *testing.T) {
+	s := NewSemaphore(4)
+	s.TryAcquire(3)
+
+	// The big request waits at the front, the small one can't overtake it
+	big, ok := blocked(func() { _ = s.Acquire(context.Background(), 4) })
+	if !ok {
+		t.Fatal("Acquire(4) didn't block")
+	}
+	if s.TryAcquire(1) {
+		t.Error("TryAcquire(1) overtook the waiter")
+	}
+
+	// The cancelled waiter leaves the queue unchanged
+	if err := s.Acquire(expired(t), 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() = %v", err)
+	}
+	if err := s.Acquire(expired(t), 5); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() over the size = %v", err)
+	}
+
+	s.Release(3)
+	<-big
+	s.Release(4)
+	if !s.TryAcquire(4) {
+		t.Error("the tokens weren't returned")
+	}
+}
+
+func TestRWMutex(t *testing.T) {
+	var (
+		rw               = NewRWMutex()
+		readers, writers atomic.Int32
+	)
+	stress(func(i int) {
+		for range 50 {
+			if i%8 == 0 {
+				rw.Lock()
+				if w := writers.Add(1); w != 1 || readers.Load() != 0 {
+					t.Errorf("%d writers with %d readers", w, readers.Load())
+				}
+				writers.Add(-1)
+				rw.Unlock()
+				continue
+			}
+
+			rw.RLock()
+			readers.Add(1)
+			if writers.Load() != 0 {
+				t.Error("a reader with the writer")
+			}
+			readers.Add(-1)
+			rw.RUnlock()
+		}
+	})
+
+	rw.RLock()
+	if !rw.TryRLock() {
+		t.Error("TryRLock() with a reader")
+	}
+	if rw.TryLock() {
+		t.Error("TryLock() with the readers")
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	if !rw.TryLock() || rw.TryRLock() {
+		t.Error("TryLock() of the free mutex")
+	}
+	if err := rw.RLockContext(expired(t)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RLockContext() = %v", err)
+	}
+	rw.Unlock()
+}
+
+func TestRWMutexWriterNotStarved(t *testing.T) {
+	var (
+		rw   = NewRWMutex()
+		stop = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	// The readers overlap, so there is always at least one reader in
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				rw.RLock()
+				time.Sleep(time.Millisecond)
+				rw.RUnlock()
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rw.LockContext(ctx); err != nil {
+		t.Fatalf("the writer was starved: %v", err)
+	}
+
+	// The readers wait for the writer now
+	if _, ok := blocked(rw.RLock); !ok {
+		t.Error("RLock() with the writer didn't block")
+	}
+	rw.Unlock()
+}
+
+func TestRWMutexLockCancelled(t *testing.T) {
+	rw := NewRWMutex()
+	rw.RLock()
+
+	if err := rw.LockContext(expired(t)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LockContext() = %v", err)
+	}
+	// The writer that gave up doesn't hold the turnstile
+	if !rw.TryRLock() {
+		t.Error("TryRLock() after the cancelled writer")
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Error("TryLock() of the free mutex")
+	}
+}
+
+func TestPool(t *testing.T) {
+	var (
+		allocs, cleans int
+		p              = NewPool(2,
+			func() []byte { allocs++; return make([]byte, 0, 8) },
+			func(b []byte) []byte { cleans++; return b[:0] })
+	)
+
+	b := p.Get()
+	p.Put(append(b, "dirty"...))
+	if b := p.Get(); len(b) != 0 {
+		t.Errorf("the recycled object %q", b)
+	}
+	if allocs != 1 || cleans != 1 {
+		t.Errorf("%d allocs, %d cleans", allocs, cleans)
+	}
+
+	// The full pool drops the objects
+	for range 3 {
+		p.Put(nil)
+	}
+	for range 3 {
+		p.Get()
+	}
+	if allocs != 2 || cleans != 3 {
+		t.Errorf("%d allocs, %d cleans", allocs, cleans)
+	}
+}
+
+func TestWaitGroup(t *testing.T) {
+	wg := NewWaitGroup()
+	wg.Wait()
+
+	// The group is reusable
+	for range 3 {
+		var done atomic.Int32
+		wg.Add(goroutines)
+		for range goroutines {
+			go func() {
+				done.Add(1)
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+		if n := done.Load(); n != goroutines {
+			t.Errorf("Wait() returned after %d of %d", n, goroutines)
+		}
+	}
+
+	wg.Add(1)
+	if err := wg.WaitContext(expired(t)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitContext() = %v", err)
+	}
+	wg.Done()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("the negative counter didn't panic")
+		}
+		wg.Wait()
+	}()
+	wg.Done()
+}
+
+func TestBarrier(t *testing.T) {
+	const rounds = 10
+
+	var (
+		b       = NewBarrier(goroutines)
+		arrived [rounds]atomic.Int32
+	)
+	stress(func(int) {
+		for round := range rounds {
+			arrived[round].Add(1)
+			if err := b.Await(context.Background()); err != nil {
+				t.Error(err)
+			}
+			// Nobody passes the barrier before everybody arrives
+			if n := arrived[round].Load(); n != goroutines {
+				t.Errorf("round %d passed with %d arrived", round, n)
+			}
+		}
+	})
+
+	// The party that gave up isn't counted
+	b = NewBarrier(2)
+	if err := b.Await(expired(t)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Await() = %v", err)
+	}
+	if _, ok := blocked(func() { _ = b.Await(context.Background()) }); !ok {
+		t.Error("Await() passed with one party")
+	}
+	if err := b.Await(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCountDownLatch(t *testing.T) {
+	l := NewCountDownLatch(goroutines)
+
+	waiting, ok := blocked(func() { _ = l.Wait(context.Background()) })
+	if !ok {
+		t.Fatal("Wait() didn't block")
+	}
+	if err := l.Wait(expired(t)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v", err)
+	}
+
+	stress(func(int) { l.CountDown() })
+	<-waiting
+	<-l.Done()
+
+	// The open latch stays open
+	l.CountDown()
+	if n := l.Count(); n != 0 {
+		t.Errorf("count %d", n)
+	}
+	if err := NewCountDownLatch(0).Wait(expired(t)); err != nil {
+		t.Errorf("Wait() of the zero latch = %v", err)
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	var (
+		b     = NewBroadcaster()
+		ready sync.WaitGroup
+		woken atomic.Int32
+	)
+
+	ready.Add(goroutines)
+	done, _ := blocked(func() {
+		stress(func(int) {
+			c := b.Listen()
+			ready.Done()
+			<-c
+			woken.Add(1)
+		})
+	})
+	ready.Wait()
+	b.Broadcast()
+	<-done
+	if n := woken.Load(); n != goroutines {
+		t.Errorf("%d woken", n)
+	}
+
+	// The broadcast before Wait isn't seen by it
+	if err := b.Wait(expired(t)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Package chansync implements the synchronization primitives of the sync package and a few it doesn't have with
+channels and select only, to show their expressive power. The state of a primitive that is more than a token is
+kept in a 1-sized channel: receiving the state locks it, sending it back unlocks it.
+*/
+package chansync
+
+import (
+	"container/list"
+	"context"
+)
+
+/*
+MUTEX
+*/
+
+// Mutex is a Semaphore of size 1: the lock is the value in the buffered channel
+type Mutex chan struct{}
+
+func NewMutex() Mutex {
+	return make(Mutex, 1)
+}
+
+// Lock acquires the mutex by filling the buffer of the channel
+func (m Mutex) Lock() {
+	m <- struct{}{}
+}
+
+// LockContext is Lock that gives up when ctx is done
+func (m Mutex) LockContext(ctx context.Context) error {
+	select {
+	case m <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryLock acquires the mutex if it's free and returns true, it returns false otherwise
+func (m Mutex) TryLock() bool {
+	// Select with default case: if no cases are ready just fall in the default block
+	select {
+	case m <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Unlock frees the buffer of the channel, it panics if the mutex isn't locked like the sync one does
+func (m Mutex) Unlock() {
+	select {
+	case <-m:
+	default:
+		panic("chansync: unlock of unlocked mutex")
+	}
+}
+
+/*
+WEIGHTED SEMAPHORE
+1. A semaphore of size N of struct{} tokens is just a channel of capacity N, but a weighted one can't take its N
+	tokens from the channel one by one: two goroutines taking 3 tokens each of 4 would deadlock holding 2 each.
+2. So the semaphore keeps the count of the taken tokens in its state, with the queue of the waiters. Every waiter
+	has a channel that's closed when its tokens are taken for it.
+3. The waiters are served in order: a big request at the front of the queue blocks the small ones behind it, so it
+	isn't starved by them.
+*/
+
+type waiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+type semState struct {
+	cur     int64
+	waiters list.List
+}
+
+// Semaphore limits the access to a resource of size tokens
+type Semaphore struct {
+	size  int64
+	state chan *semState
+}
+
+func NewSemaphore(size int64) *Semaphore {
+	s := &Semaphore{size: size, state: make(chan *semState, 1)}
+	s.state <- &semState{}
+	return s
+}
+
+/*
+Acquire takes n tokens, blocking until they are available or ctx is done. It fails with ctx.Err() leaving the
+semaphore unchanged, n over the size of the semaphore blocks until then.
+*/
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	st := <-s.state
+	if s.size-st.cur >= n && st.waiters.Len() == 0 {
+		st.cur += n
+		s.state <- st
+		return nil
+	}
+	if n > s.size {
+		// It can never be satisfied
+		s.state <- st
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	w := waiter{n: n, ready: make(chan struct{})}
+	elem := st.waiters.PushBack(w)
+	s.state <- st
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		st := <-s.state
+		select {
+		case <-w.ready:
+			// The tokens were taken for us after ctx was done, put them back
+			st.cur -= n
+			s.notify(st)
+		default:
+			front := st.waiters.Front() == elem
+			st.waiters.Remove(elem)
+			// The waiters behind us may fit now
+			if front && s.size > st.cur {
+				s.notify(st)
+			}
+		}
+		s.state <- st
+		return ctx.Err()
+	}
+}
+
+// TryAcquire takes n tokens if they are available without waiting
+func (s *Semaphore) TryAcquire(n int64) bool {
+	st := <-s.state
+	ok := s.size-st.cur >= n && st.waiters.Len() == 0
+	if ok {
+		st.cur += n
+	}
+	s.state <- st
+	return ok
+}
+
+// Release returns n tokens to the semaphore
+func (s *Semaphore) Release(n int64) {
+	st := <-s.state
+	st.cur -= n
+	if st.cur < 0 {
+		st.cur += n
+		s.state <- st
+		panic("chansync: semaphore released more than held")
+	}
+	s.notify(st)
+	s.state <- st
+}
+
+// notify takes the tokens for the waiters in order while they fit
+func (s *Semaphore) notify(st *semState) {
+	for {
+		front := st.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(waiter)
+		if s.size-st.cur < w.n {
+			return
+		}
+		st.cur += w.n
+		st.waiters.Remove(front)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,29 @@
+package chansync
+
+/*
+ONCE
+*/
+
+type Once chan struct{}
+
+func NewOnce() Once {
+	o := make(Once, 1)
+	// Filling buffer of Once so that it'll be full
+	o <- struct{}{}
+
+	return o
+}
+
+// Do calls f if it's the first call of Do, the other calls block until f returns
+func (o Once) Do(f func()) {
+	// Read from a closed chan always succeeds
+	// This only blocks during initialization.
+	if _, ok := <-o; !ok {
+		return
+	}
+
+	// Only one goroutine will get here as there's only one value in the channel. The channel is closed even if f
+	// panics, as sync.Once does, otherwise the waiting goroutines would block forever
+	defer close(o)
+	f()
+}
@@ -0,0 +1,43 @@
+package chansync
+
+/*
+POOL
+1. Our pool is fixed size: the buffered channel is the storage of the objects.
+2. The cleaner is called if and only if the returned object is recycled.
+*/
+
+type Pool[T any] struct {
+	buf   chan T    // object buffer
+	alloc func() T  // function to allocate new objects when it's needed
+	clean func(T) T // function that cleans returned objects
+}
+
+// NewPool returns a new pool created by using size, alloc, clean parameters, clean may be nil
+func NewPool[T any](size int, alloc func() T, clean func(T) T) *Pool[T] {
+	return &Pool[T]{
+		buf:   make(chan T, size),
+		alloc: alloc,
+		clean: clean,
+	}
+}
+
+// Get returns [cleaned] object from the pool's buffer or a new one
+func (p *Pool[T]) Get() T {
+	select {
+	case x := <-p.buf:
+		if p.clean != nil {
+			return p.clean(x)
+		}
+		return x
+	default:
+		return p.alloc()
+	}
+}
+
+// Put puts a given object to the pool's buffer or if it's full leaves the object to be collected
+func (p *Pool[T]) Put(x T) {
+	select {
+	case p.buf <- x:
+	default:
+	}
+}
@@ -0,0 +1,130 @@
+package chansync
+
+import "context"
+
+/*
+RWMUTEX
+1. The RWMutex of the article starves the writers when there is always at least one reader. This one is fair: it
+	has a turnstile, a Mutex every goroutine passes on its way in.
+2. A reader locks the turnstile, counts itself in and unlocks the turnstile at once, so the readers still share
+	the lock.
+3. A writer locks the turnstile and holds it until Unlock: the readers that come after it queue up at the
+	turnstile, and the writer only waits for the readers already in to leave. The last of them closes the drained
+	channel the writer waits on.
+4. The turnstile is a channel, and the goroutines blocked sending to a channel are served in order, so the readers
+	and the writers take their turns.
+*/
+
+type rwState struct {
+	readers int
+	// Not nil while a writer waits for the readers to leave
+	drained chan struct{}
+}
+
+type RWMutex struct {
+	turnstile Mutex
+	state     chan rwState
+}
+
+func NewRWMutex() RWMutex {
+	rw := RWMutex{turnstile: NewMutex(), state: make(chan rwState, 1)}
+	rw.state <- rwState{}
+	return rw
+}
+
+func (rw RWMutex) Lock() {
+	_ = rw.LockContext(context.Background())
+}
+
+// LockContext locks the mutex for writing, it gives up when ctx is done
+func (rw RWMutex) LockContext(ctx context.Context) error {
+	if err := rw.turnstile.LockContext(ctx); err != nil {
+		return err
+	}
+
+	st := <-rw.state
+	if st.readers == 0 {
+		rw.state <- st
+		return nil
+	}
+	drained := make(chan struct{})
+	st.drained = drained
+	rw.state <- st
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		st := <-rw.state
+		st.drained = nil
+		rw.state <- st
+		rw.turnstile.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryLock locks the mutex for writing if there are neither readers nor writers
+func (rw RWMutex) TryLock() bool {
+	if !rw.turnstile.TryLock() {
+		return false
+	}
+
+	st := <-rw.state
+	free := st.readers == 0
+	rw.state <- st
+	if !free {
+		rw.turnstile.Unlock()
+	}
+	return free
+}
+
+// Unlock opens the turnstile, the readers can't be in while the writer holds it
+func (rw RWMutex) Unlock() {
+	rw.turnstile.Unlock()
+}
+
+func (rw RWMutex) RLock() {
+	_ = rw.RLockContext(context.Background())
+}
+
+// RLockContext locks the mutex for reading, it gives up when ctx is done
+func (rw RWMutex) RLockContext(ctx context.Context) error {
+	if err := rw.turnstile.LockContext(ctx); err != nil {
+		return err
+	}
+	rw.enter()
+	rw.turnstile.Unlock()
+	return nil
+}
+
+// TryRLock locks the mutex for reading if there are no writers, neither holding it nor waiting for it
+func (rw RWMutex) TryRLock() bool {
+	if !rw.turnstile.TryLock() {
+		return false
+	}
+	rw.enter()
+	rw.turnstile.Unlock()
+	return true
+}
+
+func (rw RWMutex) enter() {
+	st := <-rw.state
+	st.readers++
+	rw.state <- st
+}
+
+func (rw RWMutex) RUnlock() {
+	st := <-rw.state
+	if st.readers == 0 {
+		rw.state <- st
+		panic("chansync: RUnlock of unlocked RWMutex")
+	}
+
+	st.readers--
+	// The last reader lets the waiting writer in
+	if st.readers == 0 && st.drained != nil {
+		close(st.drained)
+		st.drained = nil
+	}
+	rw.state <- st
+}
@@ -0,0 +1,94 @@
+package chansync
+
+import "context"
+
+/*
+WAITGROUP
+1. A generation begins when the counter moves from 0 to a positive number and ends when the counter reaches 0.
+2. When a generation ends all waiters of that generation are unblocked.
+*/
+
+type generation struct {
+	// A barrier for waiters to wait on.
+	// This will never be used for sending, only receive and close
+	wait chan struct{}
+
+	// The counter for remaining jobs to wait for
+	n int
+}
+
+func newGeneration() generation {
+	return generation{wait: make(chan struct{})}
+}
+
+// end unlocks the waiters by closing the channel
+func (g generation) end() {
+	close(g.wait)
+}
+
+// Here we use a channel to protect the current generation.
+// This is basically a mutex for the state of the WaitGroup
+type WaitGroup chan generation
+
+func NewWaitGroup() WaitGroup {
+	wg := make(WaitGroup, 1)
+	g := newGeneration()
+
+	// On a new waitgroup waits should just return,
+	// so it behaves exactly as after a terminated generation.
+	g.end()
+	wg <- g
+	return wg
+}
+
+func (wg WaitGroup) Add(delta int) {
+	// Acquire the current generation
+	g := <-wg
+	current := g
+
+	if g.n == 0 {
+		// We were at 0, create the next generation
+		g = newGeneration()
+	}
+
+	g.n += delta
+
+	if g.n < 0 {
+		// Put the generation back, so the recovered panic doesn't leave the group locked
+		wg <- current
+		// This is the same behavior of stdlib
+		panic("chansync: negative WaitGroup counter")
+	}
+
+	if g.n == 0 {
+		// We reached zero, signal waiters to return from Wait
+		g.end()
+	}
+
+	wg <- g
+}
+
+func (wg WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+func (wg WaitGroup) Wait() {
+	<-wg.wait()
+}
+
+// WaitContext is Wait that gives up when ctx is done
+func (wg WaitGroup) WaitContext(ctx context.Context) error {
+	select {
+	case <-wg.wait():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wait returns the channel of the current generation
+func (wg WaitGroup) wait() <-chan struct{} {
+	g := <-wg
+	wg <- g
+	return g.wait
+}
@@ -45,6 +45,12 @@ import (
 		- One with context + go
 	The first one is better if the amount of channels is known at compile time, while the other one
 	should be used when it's not.
+
+	THE PACKAGE
+1. The timedchan package has the generic versions of these ops: Send, SendFirst, SendAll, which reports
+	the channels that got the message, and RecvAny.
+2. They use the select with the nil masking for up to 4 channels and reflect.Select for more, and the
+	deadline timers are pooled, reused the way the timers article recommends.
 */
 
 /*
@@ -0,0 +1,290 @@
+/*
+Package timedchan is the generic version of the timed channel ops of the one channel writing article: a send with a
+deadline, a send to the first ready of N channels, a send to all of them, and a receive from any of them.
+
+	SELECT
+
+ 1. The number of the select cases is fixed at compile time, but a nil channel is never ready, so a select of
+    maxStatic cases serves any number of channels up to maxStatic: the missing ones are nil. And a channel that is
+    done with is disabled by setting it to nil, the masking of FirstComeFirsServedSelect.
+
+ 2. For more channels the ops fall back to reflect.Select, which is much slower, but works for any count.
+
+    TIMERS
+ 1. The deadline is a time.Timer from a pool rather than a context.WithTimeout, so an op with a deadline doesn't
+    allocate.
+ 2. The module is go 1.23, so the timer channels are synchronous: after Stop or Reset returns no stale value can be
+    received. The Stop-and-drain dance of TimerDeadlockA and TimerDeadlockB is neither needed nor done, a timer goes
+    back to the pool stopped and comes out of it reset.
+ 3. With the asynctimerchan=1 GODEBUG setting the old behavior comes back, and this reuse would be wrong.
+*/
+package timedchan
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned when the deadline passes before the op is done
+	ErrTimeout = errors.New("timedchan: timeout")
+	// ErrClosed is returned by RecvAny when all the channels are closed
+	ErrClosed = errors.New("timedchan: all channels closed")
+)
+
+// maxStatic is the number of the channels served by a static select
+const maxStatic = 4
+
+var timers = sync.Pool{
+	New: func() any {
+		t := time.NewTimer(time.Hour)
+		t.Stop()
+		return t
+	},
+}
+
+// acquire returns a pooled timer firing in d, nil for d that isn't positive
+func acquire(d time.Duration) *time.Timer {
+	if d <= 0 {
+		return nil
+	}
+	t := timers.Get().(*time.Timer)
+	t.Reset(d)
+	return t
+}
+
+// release stops the timer and puts it back to the pool
+func release(t *time.Timer) {
+	if t == nil {
+		return
+	}
+	t.Stop()
+	timers.Put(t)
+}
+
+// expired returns the channel of the timer, nil for no deadline is never ready
+func expired(t *time.Timer) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+/*
+Send sends v to c within d, no deadline if d isn't positive. It returns ErrTimeout when the deadline passes and
+ctx.Err() when ctx is done.
+*/
+func Send[T any](ctx context.Context, d time.Duration, c chan<- T, v T) error {
+	// The ready channel wins over the expired deadline
+	select {
+	case c <- v:
+		return nil
+	default:
+	}
+
+	t := acquire(d)
+	defer release(t)
+
+	select {
+	case c <- v:
+		return nil
+	case <-expired(t):
+		return ErrTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendFirst sends v to the first ready of the channels within d and returns its index, nil channels are skipped
+func SendFirst[T any](ctx context.Context, d time.Duration, v T, chans ...chan<- T) (int, error) {
+	t := acquire(d)
+	defer release(t)
+
+	s := newSender(ctx, expired(t), v, chans)
+	return s.send()
+}
+
+/*
+SendAll sends v to every channel once within d, in the order they become ready. It reports which channels got v, and
+returns ErrTimeout or ctx.Err() if not all of them did. Nil channels are skipped and reported as not sent.
+*/
+func SendAll[T any](ctx context.Context, d time.Duration, v T, chans ...chan<- T) ([]bool, error) {
+	t := acquire(d)
+	defer release(t)
+
+	var (
+		sent    = make([]bool, len(chans))
+		s       = newSender(ctx, expired(t), v, chans)
+		pending int
+	)
+	for _, c := range chans {
+		if c != nil {
+			pending++
+		}
+	}
+
+	for ; pending > 0; pending-- {
+		i, err := s.send()
+		if err != nil {
+			return sent, err
+		}
+		sent[i] = true
+		s.disable(i)
+	}
+	return sent, nil
+}
+
+// sender is the select of the sends of v to the channels, the timeout and ctx
+type sender[T any] struct {
+	ctx     context.Context
+	timeout <-chan time.Time
+	v       T
+	// The static select serves up to maxStatic channels
+	static [maxStatic]chan<- T
+	// The cases of reflect.Select for more channels, the last two are the timeout and ctx
+	cases []reflect.SelectCase
+}
+
+func newSender[T any](ctx context.Context, timeout <-chan time.Time, v T, chans []chan<- T) sender[T] {
+	s := sender[T]{ctx: ctx, timeout: timeout, v: v}
+	if len(chans) <= maxStatic {
+		copy(s.static[:], chans)
+		return s
+	}
+
+	// ValueOf(&v).Elem() keeps the type of v even if it's a nil interface
+	value := reflect.ValueOf(&v).Elem()
+	s.cases = make([]reflect.SelectCase, 0, len(chans)+2)
+	for _, c := range chans {
+		s.cases = append(s.cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c), Send: value})
+	}
+	s.cases = append(s.cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timeout)},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	)
+	return s
+}
+
+// send sends v to the first ready channel and returns its index
+func (s *sender[T]) send() (int, error) {
+	if s.cases != nil {
+		n := len(s.cases) - 2
+		switch i, _, _ := reflect.Select(s.cases); i {
+		case n:
+			return -1, ErrTimeout
+		case n + 1:
+			return -1, s.ctx.Err()
+		default:
+			return i, nil
+		}
+	}
+
+	select {
+	case s.static[0] <- s.v:
+		return 0, nil
+	case s.static[1] <- s.v:
+		return 1, nil
+	case s.static[2] <- s.v:
+		return 2, nil
+	case s.static[3] <- s.v:
+		return 3, nil
+	case <-s.timeout:
+		return -1, ErrTimeout
+	case <-s.ctx.Done():
+		return -1, s.ctx.Err()
+	}
+}
+
+// disable excludes the channel from the next selects
+func (s *sender[T]) disable(i int) {
+	if s.cases != nil {
+		// The case of the zero Value is ignored by reflect.Select
+		s.cases[i].Chan = reflect.Value{}
+		return
+	}
+	s.static[i] = nil
+}
+
+/*
+RecvAny receives a value from the first ready of the channels within d and returns it with the index of its channel.
+The closed channels are skipped, ErrClosed is returned once all of them are closed, nil channels are never ready.
+*/
+func RecvAny[T any](ctx context.Context, d time.Duration, chans ...<-chan T) (T, int, error) {
+	t := acquire(d)
+	defer release(t)
+
+	var (
+		timeout = expired(t)
+		zero    T
+		open    int
+	)
+	for _, c := range chans {
+		if c != nil {
+			open++
+		}
+	}
+
+	if len(chans) > maxStatic {
+		cases := make([]reflect.SelectCase, 0, len(chans)+2)
+		for _, c := range chans {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)})
+		}
+		cases = append(cases,
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timeout)},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		)
+
+		for n := len(chans); ; {
+			switch i, x, ok := reflect.Select(cases); {
+			case i == n:
+				return zero, -1, ErrTimeout
+			case i == n+1:
+				return zero, -1, ctx.Err()
+			case ok:
+				// The comma ok form turns the nil of an interface T into its zero value
+				v, _ := x.Interface().(T)
+				return v, i, nil
+			default:
+				cases[i].Chan = reflect.Value{}
+				if open--; open == 0 {
+					return zero, -1, ErrClosed
+				}
+			}
+		}
+	}
+
+	var static [maxStatic]<-chan T
+	copy(static[:], chans)
+
+	for {
+		var (
+			i  int
+			x  T
+			ok bool
+		)
+		select {
+		case x, ok = <-static[0]:
+		case x, ok = <-static[1]:
+			i = 1
+		case x, ok = <-static[2]:
+			i = 2
+		case x, ok = <-static[3]:
+			i = 3
+		case <-timeout:
+			return zero, -1, ErrTimeout
+		case <-ctx.Done():
+			return zero, -1, ctx.Err()
+		}
+
+		if ok {
+			return x, i, nil
+		}
+		static[i] = nil
+		if open--; open == 0 {
+			return zero, -1, ErrClosed
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package timedchan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// receiveAfter receives a value from c after d
+func receiveAfter[T any](d time.Duration, c <-chan T) {
+	go func() {
+		time.Sleep(d)
+		<-c
+	}()
+}
+
+func TestSend(t *testing.T) {
+	ctx := context.Background()
+
+	buffered := make(chan int, 1)
+	if err := Send(ctx, time.Nanosecond, buffered, 1); err != nil {
+		t.Errorf("Send() to the ready channel = %v", err)
+	}
+	if err := Send(ctx, 10*time.Millisecond, buffered, 2); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Send() to the full channel = %v", err)
+	}
+
+	c := make(chan int)
+	receiveAfter(10*time.Millisecond, c)
+	if err := Send(ctx, 0, c, 1); err != nil {
+		t.Errorf("Send() without the deadline = %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := Send(cancelled, time.Hour, c, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Send() with the cancelled context = %v", err)
+	}
+}
+
+/*
+TestTimerReuse checks the pitfalls of TimerDeadlockA and TimerDeadlockB: a timer that fired goes back to the pool, and
+its stale value must neither expire the next op early nor block the release.
+*/
+func TestTimerReuse(t *testing.T) {
+	ctx := context.Background()
+
+	for i := range 100 {
+		if err := Send(ctx, time.Microsecond, make(chan int), i); !errors.Is(err, ErrTimeout) {
+			t.Fatalf("Send() to the blocked channel = %v", err)
+		}
+
+		c := make(chan int)
+		receiveAfter(time.Millisecond, c)
+		if err := Send(ctx, time.Second, c, i); err != nil {
+			t.Fatalf("iteration %d: Send() after the timeout = %v", i, err)
+		}
+	}
+}
+
+// channels returns n unbuffered channels, their send and receive ends
+func channels(n int) ([]chan int, []chan<- int, []<-chan int) {
+	var (
+		cs    = make([]chan int, n)
+		sends = make([]chan<- int, n)
+		recvs = make([]<-chan int, n)
+	)
+	for i := range cs {
+		cs[i] = make(chan int)
+		sends[i], recvs[i] = cs[i], cs[i]
+	}
+	return cs, sends, recvs
+}
+
+func TestSendFirst(t *testing.T) {
+	ctx := context.Background()
+
+	// The static select and the reflect.Select
+	for _, n := range []int{2, maxStatic, 8} {
+		t.Run(fmt.Sprint(n, " channels"), func(t *testing.T) {
+			cs, sends, _ := channels(n)
+
+			ready := n - 1
+			receiveAfter(5*time.Millisecond, cs[ready])
+			if i, err := SendFirst(ctx, time.Second, 42, sends...); i != ready || err != nil {
+				t.Errorf("SendFirst() = %d, %v", i, err)
+			}
+
+			if i, err := SendFirst(ctx, 10*time.Millisecond, 42, sends...); i != -1 || !errors.Is(err, ErrTimeout) {
+				t.Errorf("SendFirst() to the blocked channels = %d, %v", i, err)
+			}
+		})
+	}
+}
+
+func TestSendAll(t *testing.T) {
+	ctx := context.Background()
+
+	for _, n := range []int{2, maxStatic, 8} {
+		t.Run(fmt.Sprint(n, " channels"), func(t *testing.T) {
+			cs, sends, _ := channels(n)
+
+			// Every other channel is ready, the nil one is skipped
+			sends[1] = nil
+			for i := 0; i < n; i += 2 {
+				receiveAfter(time.Duration(n-i)*time.Millisecond, cs[i])
+			}
+
+			// With 2 channels the nil one is the only one not ready
+			sent, err := SendAll(ctx, 100*time.Millisecond, 42, sends...)
+			if timeout := errors.Is(err, ErrTimeout); timeout != (n > 2) || (!timeout && err != nil) {
+				t.Errorf("SendAll() = %v", err)
+			}
+			for i, ok := range sent {
+				if ok != (i%2 == 0) {
+					t.Errorf("sent to the channel %d: %t", i, ok)
+				}
+			}
+
+			// All the channels ready
+			for i := 0; i < n; i++ {
+				if sends[i] != nil {
+					receiveAfter(0, cs[i])
+				}
+			}
+			if _, err := SendAll(ctx, time.Second, 42, sends...); err != nil {
+				t.Errorf("SendAll() to the ready channels = %v", err)
+			}
+		})
+	}
+}
+
+func TestRecvAny(t *testing.T) {
+	ctx := context.Background()
+
+	for _, n := range []int{2, maxStatic, 8} {
+		t.Run(fmt.Sprint(n, " channels"), func(t *testing.T) {
+			cs, _, recvs := channels(n)
+
+			// The closed channels are skipped
+			close(cs[0])
+			go func() {
+				time.Sleep(5 * time.Millisecond)
+				cs[n-1] <- 42
+			}()
+			if v, i, err := RecvAny(ctx, time.Second, recvs...); v != 42 || i != n-1 || err != nil {
+				t.Errorf("RecvAny() = %d, %d, %v", v, i, err)
+			}
+
+			if _, _, err := RecvAny(ctx, 10*time.Millisecond, recvs...); !errors.Is(err, ErrTimeout) {
+				t.Errorf("RecvAny() from the blocked channels = %v", err)
+			}
+
+			for _, c := range cs[1:] {
+				close(c)
+			}
+			if _, _, err := RecvAny(ctx, time.Second, recvs...); !errors.Is(err, ErrClosed) {
+				t.Errorf("RecvAny() from the closed channels = %v", err)
+			}
+		})
+	}
+
+	// The nil of an interface type is received as such
+	errs := make([]chan error, 8)
+	recvs := make([]<-chan error, 8)
+	for i := range errs {
+		errs[i] = make(chan error, 1)
+		recvs[i] = errs[i]
+	}
+	errs[3] <- nil
+	if err, i, recvErr := RecvAny(ctx, time.Second, recvs...); err != nil || i != 3 || recvErr != nil {
+		t.Errorf("RecvAny() of nil = %v, %d, %v", err, i, recvErr)
+	}
+}
+
+func BenchmarkSendTimer(b *testing.B) {
+	c := make(chan int, 1)
+	b.ReportAllocs()
+	for range b.N {
+		_ = Send(context.Background(), time.Second, c, 1)
+		<-c
+	}
+}
+
+func BenchmarkSendContext(b *testing.B) {
+	c := make(chan int, 1)
+	b.ReportAllocs()
+	for range b.N {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		select {
+		case c <- 1:
+		case <-ctx.Done():
+		}
+		cancel()
+		<-c
+	}
+}
+
+func BenchmarkSendFirst(b *testing.B) {
+	for _, n := range []int{2, 8} {
+		b.Run(fmt.Sprint(n, " channels"), func(b *testing.B) {
+			cs, sends, _ := channels(n)
+			last := make(chan int, 1)
+			cs[n-1], sends[n-1] = last, last
+
+			b.ReportAllocs()
+			for range b.N {
+				_, _ = SendFirst(context.Background(), time.Second, 1, sends...)
+				<-last
+			}
+		})
+	}
+}
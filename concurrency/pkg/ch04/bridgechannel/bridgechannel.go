@@ -1,14 +1,15 @@
 package bridgechannel
 
 import (
-	"concurrency/pkg/ch04/readerdonewrapper"
+	"concurrency/pkg/ch04/pipeline"
+	"context"
 	"fmt"
 )
 
 /*
 The technique that defines destructuring a chanel of channels into a simple channel is called "bridging"
 
-chanStream <- <- chan interface{} is a channel that is being given some channels during runtime.
+chanStream <-chan <-chan T is a channel that is being given some channels during runtime.
 */
 
 /*
@@ -16,58 +17,23 @@ Thanks to "Bridge()", we can use the channel of channels from within a single ra
 is specific to this concern.
 */
 
-func Bridge(done <-chan struct{}, chanStream <-chan <-chan interface{}) <-chan interface{} {
-	// This channel will be given values from the subchannels that chanStream has.
-	dataProducer := make(chan interface{})
-
-	go func() {
-		defer close(dataProducer)
-
-		for {
-			var subProducer <-chan interface{}
-
-			select {
-			// Try to pull the channel
-			case underlyingStream, ok := <-chanStream:
-				if !ok {
-					return
-				}
-
-				subProducer = underlyingStream
-
-			case <-done:
-				return
-
-			}
-
-			for val := range readerdonewrapper.IsReaderDone(done, subProducer) {
-				select {
-				// Send the value to the
-				case dataProducer <- val:
-				default:
-				}
-
-			}
-
-		}
-	}()
-
-	return dataProducer
-}
+/*
+The bridge itself is pipeline.Bridge, it reads the channels in turn until the context is done.
+*/
 
 func Using() {
 	// The parentheses is required.
-	genVals := func() <-chan (<-chan interface{}) {
+	genVals := func() <-chan (<-chan int) {
 
 		// The parentheses is required.
-		chanStream := make(chan (<-chan interface{}))
+		chanStream := make(chan (<-chan int))
 
 		go func() {
 			defer close(chanStream)
 
 			for i := 0; i < 10; i++ {
 				// The channel is buffered so that this goroutine won't be blocked.
-				stream := make(chan interface{}, 1)
+				stream := make(chan int, 1)
 
 				stream <- i
 				close(stream)
@@ -79,8 +45,8 @@ func Using() {
 		return chanStream
 	}
 
-	// Channels will be invoked with LIFO principle
-	for v := range Bridge(nil, genVals()) {
+	// Channels are read in the order they were sent
+	for v := range pipeline.Bridge(context.Background(), genVals()) {
 		fmt.Printf(" %v", v)
 	}
 }
@@ -2,6 +2,7 @@ package fanoutin
 
 import (
 	"concurrency/pkg/ch04/pipeline"
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -43,28 +44,10 @@ func FanInOutUsing() {
 	var (
 		processorsNumber = runtime.GOMAXPROCS(0)
 
-		randomNumber = func() interface{} {
+		randomNumber = func() int {
 			return rand.Intn(topRandIntegerBoundary)
 		}
 
-		toIntStream = func(done <-chan struct{}, dataProducer <-chan interface{}) <-chan int {
-			ints := make(chan int)
-
-			go func() {
-				defer close(ints)
-
-				for val := range dataProducer {
-					select {
-					case <-done:
-						return
-					case ints <- val.(int):
-					}
-				}
-			}()
-
-			return ints
-		}
-
 		getPrime = func(num int) int {
 			dividersCounter := 0
 			for i := int(math.Sqrt(float64(num))) + 1; i > 0; i-- {
@@ -140,15 +123,16 @@ func FanInOutUsing() {
 		}
 	)
 
-	// Canceling channel
-	done := make(chan struct{})
-	defer close(done)
+	// Canceling context and its channel
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := ctx.Done()
 
 	// The start point of primes finding
 	start := time.Now()
 
 	// Random numbers generator and multiple goroutines
-	randIntStream := toIntStream(done, pipeline.RepeatFunction(done, randomNumber))
+	randIntStream := pipeline.RepeatFunc(ctx, randomNumber)
 
 	/*
 		FAN-OUT EXAMPLE.
@@ -163,7 +147,7 @@ func FanInOutUsing() {
 	multiplexedFindersStream := fanIn(done, primeFinders...)
 
 	fmt.Println("Primes and non-primes:")
-	for val := range pipeline.LimitedJunction(done, multiplexedFindersStream, 10) {
+	for val := range pipeline.Take(ctx, multiplexedFindersStream, 10) {
 		fmt.Println(val)
 	}
 
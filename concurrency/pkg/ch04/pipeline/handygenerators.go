@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/rand"
 	"time"
@@ -11,54 +12,52 @@ import (
 A generator for a pipeline is any function that converts a set of discrete values into a stream of values on a channel.
 */
 
-// Repeat will send to a channel repeatable discrete elements until we close the done channel.
-func Repeat(done <-chan struct{}, values ...any) <-chan any {
-	valueStream := make(chan any)
+// From sends the values once.
+func From[T any](ctx context.Context, values ...T) <-chan T {
+	valueStream := make(chan T)
 
 	go func() {
 		defer close(valueStream)
 
-		for {
-			for _, val := range values {
-				select {
-				case <-done:
-					return
-				case valueStream <- val:
-				}
+		for _, val := range values {
+			select {
+			case <-ctx.Done():
+				return
+			case valueStream <- val:
 			}
 		}
-
 	}()
 
 	return valueStream
 }
 
-func LimitedJunction(done <-chan struct{}, dataProducer <-chan interface{}, limit int) <-chan interface{} {
-	limitedStream := make(chan interface{})
+// Repeat will send to a channel repeatable discrete elements until the context is done.
+func Repeat[T any](ctx context.Context, values ...T) <-chan T {
+	valueStream := make(chan T)
 
 	go func() {
-		defer close(limitedStream)
+		defer close(valueStream)
 
-		for i := 0; i < limit; i++ {
-			select {
-			case <-done:
-				return
-			// The valueStream gives the value and takeStream accepts it.
-			case limitedStream <- <-dataProducer:
+		for {
+			for _, val := range values {
+				select {
+				case <-ctx.Done():
+					return
+				case valueStream <- val:
+				}
 			}
 		}
+
 	}()
 
-	return limitedStream
+	return valueStream
 }
 
-func LimitedJunctionUsing() {
-	done := make(chan struct{})
-	time.AfterFunc(time.Microsecond*10, func() {
-		close(done)
-	})
+func TakeUsing() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond*10)
+	defer cancel()
 
-	for v := range LimitedJunction(done, Repeat(done, struct {
+	for v := range Take(ctx, Repeat(ctx, struct {
 		name    string
 		surname string
 		age     uint
@@ -70,13 +69,14 @@ func LimitedJunctionUsing() {
 	fmt.Println()
 }
 
-func RepeatFunction(done <-chan struct{}, fn func() any) <-chan any {
-	valueStream := make(chan any)
+// RepeatFunc sends the values of fn until the context is done.
+func RepeatFunc[T any](ctx context.Context, fn func() T) <-chan T {
+	valueStream := make(chan T)
 	go func() {
 		defer close(valueStream)
 		for {
 			select {
-			case <-done:
+			case <-ctx.Done():
 				return
 			case valueStream <- fn():
 			}
@@ -86,41 +86,22 @@ func RepeatFunction(done <-chan struct{}, fn func() any) <-chan any {
 	return valueStream
 }
 
-func RepeatFunctionUsing() {
-	done := make(chan struct{})
-	defer close(done)
+func RepeatFuncUsing() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	rand := func() any { return rand.Int() }
-
-	for v := range LimitedJunction(done, RepeatFunction(done, rand), 5) {
+	for v := range Take(ctx, RepeatFunc(ctx, rand.Int), 5) {
 		fmt.Println(v)
 	}
 }
 
-func StringTaker(done <-chan struct{}, valueStream <-chan interface{}) <-chan string {
-	stringStream := make(chan string)
-
-	go func() {
-		defer close(stringStream)
-
-		for v := range valueStream {
-			select {
-			case <-done:
-				return
-			case stringStream <- v.(string):
-			}
-		}
-	}()
-
-	return stringStream
-}
-
-func StringTakerUsing() {
-	done := make(chan struct{})
-	defer close(done)
+// The stream is typed, so there's no stage asserting the type of the values anymore.
+func TypedStreamUsing() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	var buff bytes.Buffer
-	for v := range StringTaker(done, LimitedJunction(done, Repeat(done, `I`, `am.`), 10)) {
+	for v := range Take(ctx, Repeat(ctx, `I`, `am.`), 10) {
 		buff.WriteString(v)
 	}
 
@@ -0,0 +1,283 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// noLeaks fails the test if the goroutines started by it are still running when it ends
+func noLeaks(t *testing.T) {
+	t.Helper()
+
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Errorf("%d goroutines leaked:\n%s", runtime.NumGoroutine()-before, buf[:runtime.Stack(buf, true)])
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func collect[T any](in <-chan T) []T {
+	var values []T
+	for v := range in {
+		values = append(values, v)
+	}
+	return values
+}
+
+func seq(n int) []int {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = i
+	}
+	return values
+}
+
+func square(_ context.Context, n int) (int, error) {
+	return n * n, nil
+}
+
+func TestStages(t *testing.T) {
+	noLeaks(t)
+
+	p, ctx := New(context.Background())
+	evens := Filter(p, From(ctx, seq(10)...), func(_ context.Context, n int) (bool, error) { return n%2 == 0, nil })
+	squares := Map(p, evens, square)
+	words := FlatMap(p, squares, func(_ context.Context, n int) ([]string, error) {
+		return []string{fmt.Sprint(n), "|"}, nil
+	})
+
+	got, err := Collect(p, words)
+	if err != nil || strings.Join(got, "") != "0|4|16|36|64|" {
+		t.Errorf("Collect() = %v, %v", got, err)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	noLeaks(t)
+
+	// The later values finish first, so only the ordered stage keeps the order
+	slow := func(_ context.Context, n int) (int, error) {
+		time.Sleep(time.Duration(20-n) * time.Millisecond)
+		return n, nil
+	}
+
+	p, ctx := New(context.Background())
+	got, err := Collect(p, Map(p, From(ctx, seq(20)...), slow, Workers(8), Ordered()))
+	if err != nil || !slices.Equal(got, seq(20)) {
+		t.Errorf("ordered = %v, %v", got, err)
+	}
+
+	p, ctx = New(context.Background())
+	start := time.Now()
+	got, err = Collect(p, Map(p, From(ctx, seq(20)...), slow, Workers(20), Buffer(20)))
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("20 workers took %v", elapsed)
+	}
+	if slices.Sort(got); err != nil || !slices.Equal(got, seq(20)) {
+		t.Errorf("unordered = %v, %v", got, err)
+	}
+}
+
+func TestFirstError(t *testing.T) {
+	for _, opts := range [][]StageOption{nil, {Workers(4)}, {Workers(4), Ordered()}} {
+		t.Run(fmt.Sprint(len(opts)), func(t *testing.T) {
+			noLeaks(t)
+
+			errBoom := errors.New("boom")
+			p, ctx := New(context.Background())
+			out := Map(p, Repeat(ctx, seq(10)...), func(ctx context.Context, n int) (int, error) {
+				if n == 7 {
+					return 0, errBoom
+				}
+				return n, nil
+			}, opts...)
+
+			// The infinite source stops once the stage fails
+			for range Map(p, out, square) {
+			}
+			if err := p.Wait(); !errors.Is(err, errBoom) {
+				t.Errorf("Wait() = %v", err)
+			}
+		})
+	}
+}
+
+func TestPanic(t *testing.T) {
+	noLeaks(t)
+
+	p, ctx := New(context.Background())
+	_, err := Collect(p, Map(p, From(ctx, 1, 0), func(_ context.Context, n int) (int, error) {
+		return 1 / n, nil
+	}))
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Errorf("Collect() error %v", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	noLeaks(t)
+
+	// The consumer stops early, nothing is blocked afterwards
+	p, ctx := New(context.Background())
+	squares := Map(p, Repeat(ctx, 1, 2, 3), square, Workers(3), Ordered())
+	if got := collect(Take(ctx, squares, 5)); !slices.Equal(got, []int{1, 4, 9, 1, 4}) {
+		t.Errorf("Take() = %v", got)
+	}
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Errorf("Wait() after Stop() = %v", err)
+	}
+
+	// The cancelled parent is reported
+	parent, cancel := context.WithCancel(context.Background())
+	p, ctx = New(parent)
+	out := Map(p, Repeat(ctx, 1), square)
+	<-out
+	cancel()
+	for range out {
+	}
+	if err := p.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() after the parent was cancelled = %v", err)
+	}
+}
+
+func TestTeeMergeBridge(t *testing.T) {
+	noLeaks(t)
+	ctx := context.Background()
+
+	out1, out2 := Tee(ctx, From(ctx, seq(5)...))
+	done := make(chan []int)
+	go func() { done <- collect(out2) }()
+	if got1, got2 := collect(out1), <-done; !slices.Equal(got1, seq(5)) || !slices.Equal(got2, seq(5)) {
+		t.Errorf("Tee() = %v and %v", got1, got2)
+	}
+
+	merged := collect(Merge(ctx, From(ctx, 0, 1, 2), From(ctx, 3, 4), From[int](ctx)))
+	if slices.Sort(merged); !slices.Equal(merged, seq(5)) {
+		t.Errorf("Merge() = %v", merged)
+	}
+
+	chans := make(chan (<-chan int), 3)
+	chans <- From(ctx, 0, 1)
+	chans <- From(ctx, 2)
+	chans <- From(ctx, 3, 4)
+	close(chans)
+	if got := collect(Bridge(ctx, chans)); !slices.Equal(got, seq(5)) {
+		t.Errorf("Bridge() = %v", got)
+	}
+}
+
+func TestOrDone(t *testing.T) {
+	noLeaks(t)
+
+	// The input is never closed, the cancellation alone ends the range
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out := OrDone(ctx, in)
+	go func() { in <- 1 }()
+	if v := <-out; v != 1 {
+		t.Errorf("OrDone() = %d", v)
+	}
+	cancel()
+	if _, ok := <-out; ok {
+		t.Error("OrDone() sent after the cancellation")
+	}
+
+	// The stages built on the context stop with it
+	ctx, cancel = context.WithCancel(context.Background())
+	merged := Merge(ctx, Repeat(ctx, 1), RepeatFunc(ctx, func() int { return 2 }))
+	tee1, _ := Tee(ctx, Bridge(ctx, Repeat(ctx, merged)))
+	<-tee1
+	cancel()
+}
+
+func TestOr(t *testing.T) {
+	noLeaks(t)
+
+	var (
+		first, cancelFirst = context.WithCancel(context.Background())
+		errLast            = errors.New("last is done")
+		last, cancelLast   = context.WithCancelCause(context.Background())
+	)
+	defer cancelFirst()
+
+	ctx, cancel := Or(first, context.Background(), last)
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatal("Or() is done before its parents")
+	}
+
+	// Any parent ends it, with its cause
+	cancelLast(errLast)
+	<-ctx.Done()
+	if !errors.Is(context.Cause(ctx), errLast) {
+		t.Errorf("Cause() = %v, want %v", context.Cause(ctx), errLast)
+	}
+
+	// Its own cancel releases the parents
+	ctx, cancel = Or(context.Background(), context.Background())
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("Or() isn't done after its cancel")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	noLeaks(t)
+	ctx := context.Background()
+
+	got := collect(Batch(ctx, From(ctx, seq(7)...), 3, 0))
+	if want := [][]int{{0, 1, 2}, {3, 4, 5}, {6}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("Batch() = %v, want %v", got, want)
+	}
+
+	// The slow source gets its batches on time
+	in := make(chan int)
+	batches := Batch(ctx, in, 100, 10*time.Millisecond)
+	in <- 1
+	in <- 2
+	if b := <-batches; !slices.Equal(b, []int{1, 2}) {
+		t.Errorf("the timed batch %v", b)
+	}
+	in <- 3
+	close(in)
+	if b := <-batches; !slices.Equal(b, []int{3}) {
+		t.Errorf("the last batch %v", b)
+	}
+	if _, ok := <-batches; ok {
+		t.Error("Batch() isn't closed")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	noLeaks(t)
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		size, step int
+		want       [][]int
+	}{
+		{3, 1, [][]int{{0, 1, 2}, {1, 2, 3}, {2, 3, 4}, {3, 4, 5}}},
+		{2, 2, [][]int{{0, 1}, {2, 3}, {4, 5}}},
+		{2, 3, [][]int{{0, 1}, {3, 4}}},
+		{4, 3, [][]int{{0, 1, 2, 3}}},
+	} {
+		got := collect(Window(ctx, From(ctx, seq(6)...), c.size, c.step))
+		if !slices.EqualFunc(got, c.want, slices.Equal) {
+			t.Errorf("Window(%d, %d) = %v, want %v", c.size, c.step, got, c.want)
+		}
+	}
+}
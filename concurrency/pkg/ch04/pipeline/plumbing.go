@@ -0,0 +1,301 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+The plumbing stages move the values around without failing, so they take the context alone and stop once it's done.
+Every one of them closes its output when it stops.
+*/
+
+// OrDone forwards the values of in until it's closed or ctx is done, so the range over the result can be stopped
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case out <- v:
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+/*
+Or returns the context done as soon as any of ctxs is, with the cause of the first one done. It's preventinggorleak.Or
+for the contexts: no tree of goroutines, context.AfterFunc watches the parents. It carries the values of the first
+context, cancel releases the watching.
+*/
+func Or(ctxs ...context.Context) (context.Context, context.CancelFunc) {
+	if len(ctxs) == 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	ctx, cancel := context.WithCancelCause(ctxs[0])
+	stops := make([]func() bool, 0, len(ctxs)-1)
+	for _, parent := range ctxs[1:] {
+		stops = append(stops, context.AfterFunc(parent, func() {
+			cancel(context.Cause(parent))
+		}))
+	}
+
+	return ctx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancel(context.Canceled)
+	}
+}
+
+// Take forwards the first n values of in
+func Take[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		for i := 0; i < n; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case out <- v:
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+/*
+Tee sends every value of in to both outputs, the next value is read only when both have taken the current one, so
+both outputs must be read.
+*/
+func Tee[T any](ctx context.Context, in <-chan T) (_, _ <-chan T) {
+	out1, out2 := make(chan T), make(chan T)
+
+	go func() {
+		defer close(out1)
+		defer close(out2)
+
+		for v := range OrDone(ctx, in) {
+			// The sent channel is set to nil for its case not to be chosen again
+			out1, out2 := out1, out2
+			for range 2 {
+				select {
+				case <-ctx.Done():
+					return
+				case out1 <- v:
+					out1 = nil
+				case out2 <- v:
+					out2 = nil
+				}
+			}
+		}
+	}()
+
+	return out1, out2
+}
+
+// Merge sends the values of all the inputs to the single output, the order between the inputs isn't kept
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		go func() {
+			defer wg.Done()
+
+			for v := range OrDone(ctx, in) {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- v:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Bridge flattens the channel of channels into one channel, the channels are read in turn
+func Bridge[T any](ctx context.Context, chans <-chan <-chan T) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		for in := range OrDone(ctx, chans) {
+			for v := range OrDone(ctx, in) {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- v:
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+/*
+Batch groups the values into the slices of size. A batch is sent earlier when maxWait has passed since its first
+value, unless maxWait is zero. The incomplete batch is sent when in is closed.
+*/
+func Batch[T any](ctx context.Context, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	size = max(size, 1)
+	out := make(chan []T)
+
+	go func() {
+		defer close(out)
+
+		var (
+			batch []T
+			timer *time.Timer
+			// nil while there's no timer, so the case isn't chosen
+			expired <-chan time.Time
+		)
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		flush := func() bool {
+			if timer != nil {
+				// Before Go 1.23 the tick of the stopped timer stays in its channel and would expire the next batch
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				expired = nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- batch:
+				batch = nil
+				return true
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+
+				batch = append(batch, v)
+				if len(batch) == 1 && maxWait > 0 {
+					if timer == nil {
+						timer = time.NewTimer(maxWait)
+					} else {
+						timer.Reset(maxWait)
+					}
+					expired = timer.C
+				}
+				if len(batch) == size && !flush() {
+					return
+				}
+
+			case <-expired:
+				expired = nil
+				if !flush() {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+/*
+Window sends the sliding windows of size values moving by step values, e.g. the windows of 3 by 1 over 1..5 are
+[1 2 3], [2 3 4] and [3 4 5]. Every window is a new slice, the values left short of a window when in is closed are
+dropped.
+*/
+func Window[T any](ctx context.Context, in <-chan T, size, step int) <-chan []T {
+	size, step = max(size, 1), max(step, 1)
+	out := make(chan []T)
+
+	go func() {
+		defer close(out)
+
+		var (
+			window []T
+			// The values to skip before the next window when the step is longer than the window
+			skip int
+		)
+		for v := range OrDone(ctx, in) {
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			window = append(window, v)
+			if len(window) < size {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- append([]T(nil), window...):
+			}
+
+			if step < size {
+				window = append(window[:0], window[step:]...)
+			} else {
+				window, skip = window[:0], step-size
+			}
+		}
+	}()
+
+	return out
+}
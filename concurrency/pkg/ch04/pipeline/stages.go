@@ -0,0 +1,320 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+/*
+Pipeline ties together the stages that may fail: Map, Filter and FlatMap. The first error of a stage cancels the
+context of the pipeline, so every stage, the failing ones and the plumbing ones built with the same context, stops
+and closes its output. Wait returns that error once the failing stages have exited.
+
+The consumer that stops reading early must call Stop, or the stages stay blocked on their sends:
+
+	p, ctx := pipeline.New(ctx)
+	squares := pipeline.Map(p, pipeline.From(ctx, 1, 2, 3), square, pipeline.Workers(4), pipeline.Ordered())
+	for v := range pipeline.Take(ctx, squares, 2) {
+		...
+	}
+	p.Stop()
+	err := p.Wait()
+*/
+type Pipeline struct {
+	ctx    context.Context
+	parent context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	once sync.Once
+	err  error
+}
+
+// New returns the pipeline and its context, the plumbing stages take the context
+func New(ctx context.Context) (*Pipeline, context.Context) {
+	p := &Pipeline{parent: ctx}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p, p.ctx
+}
+
+// Context returns the context of the pipeline, it's cancelled by the first error and by Stop
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Stop cancels the pipeline without an error
+func (p *Pipeline) Stop() {
+	p.cancel()
+}
+
+// Wait waits for the failing stages and returns the first error of them, or the error of the parent context if it
+// was cancelled
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	if p.err != nil {
+		return p.err
+	}
+	return p.parent.Err()
+}
+
+func (p *Pipeline) fail(err error) {
+	// The errors after the cancellation are the consequences of it
+	if err == nil || p.ctx.Err() != nil {
+		return
+	}
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+func (p *Pipeline) goStage(fn func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		fn()
+	}()
+}
+
+// Collect reads the output of the last stage until it's closed, then stops the pipeline and waits for it
+func Collect[T any](p *Pipeline, in <-chan T) ([]T, error) {
+	var values []T
+	for v := range in {
+		values = append(values, v)
+	}
+
+	p.Stop()
+	return values, p.Wait()
+}
+
+// StageOption configures Map, Filter and FlatMap
+type StageOption func(c *stageConfig)
+
+type stageConfig struct {
+	workers int
+	ordered bool
+	buffer  int
+}
+
+// Workers sets the number of the goroutines of the stage, one by default
+func Workers(n int) StageOption {
+	return func(c *stageConfig) {
+		c.workers = max(n, 1)
+	}
+}
+
+// Ordered makes the stage with several workers emit the results in the order of the input. Without it the results
+// come as soon as they're ready
+func Ordered() StageOption {
+	return func(c *stageConfig) {
+		c.ordered = true
+	}
+}
+
+// Buffer sets the capacity of the output channel of the stage
+func Buffer(n int) StageOption {
+	return func(c *stageConfig) {
+		c.buffer = max(n, 0)
+	}
+}
+
+// Map emits fn of every value
+func Map[T, U any](p *Pipeline, in <-chan T, fn func(context.Context, T) (U, error), opts ...StageOption) <-chan U {
+	return stage(p, in, opts, func(ctx context.Context, v T, emit func(U) bool) error {
+		u, err := fn(ctx, v)
+		if err != nil {
+			return err
+		}
+		emit(u)
+		return nil
+	})
+}
+
+// Filter emits the values fn keeps
+func Filter[T any](p *Pipeline, in <-chan T, fn func(context.Context, T) (bool, error), opts ...StageOption) <-chan T {
+	return stage(p, in, opts, func(ctx context.Context, v T, emit func(T) bool) error {
+		keep, err := fn(ctx, v)
+		if err != nil {
+			return err
+		}
+		if keep {
+			emit(v)
+		}
+		return nil
+	})
+}
+
+// FlatMap emits every value fn returns for a value
+func FlatMap[T, U any](p *Pipeline, in <-chan T, fn func(context.Context, T) ([]U, error), opts ...StageOption) <-chan U {
+	return stage(p, in, opts, func(ctx context.Context, v T, emit func(U) bool) error {
+		us, err := fn(ctx, v)
+		if err != nil {
+			return err
+		}
+		for _, u := range us {
+			if !emit(u) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// stage runs fn for every value of in on the workers, emit returns false when the pipeline is cancelled
+func stage[T, U any](p *Pipeline, in <-chan T, opts []StageOption, fn func(context.Context, T, func(U) bool) error) <-chan U {
+	c := stageConfig{workers: 1}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	out := make(chan U, c.buffer)
+	if c.ordered && c.workers > 1 {
+		ordered(p, in, out, c.workers, fn)
+	} else {
+		unordered(p, in, out, c.workers, fn)
+	}
+	return out
+}
+
+func unordered[T, U any](p *Pipeline, in <-chan T, out chan<- U, workers int, fn func(context.Context, T, func(U) bool) error) {
+	ctx := p.ctx
+	emit := func(u U) bool {
+		select {
+		case out <- u:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		p.goStage(func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case v, ok := <-in:
+					if !ok {
+						return
+					}
+					if err := call(ctx, fn, v, emit); err != nil {
+						p.fail(err)
+						return
+					}
+				}
+			}
+		})
+	}
+
+	p.goStage(func() {
+		wg.Wait()
+		close(out)
+	})
+}
+
+/*
+ordered fans the values out to the workers and back in the order of the input. Every value gets its own buffered
+result channel, the dispatcher queues them in the order of the input, and the emitter waits for each of them in turn.
+The workers never block on the results, so at most about twice the number of the workers values are in flight.
+*/
+func ordered[T, U any](p *Pipeline, in <-chan T, out chan<- U, workers int, fn func(context.Context, T, func(U) bool) error) {
+	type job struct {
+		value  T
+		result chan []U
+	}
+
+	var (
+		ctx   = p.ctx
+		jobs  = make(chan job, workers)
+		order = make(chan chan []U, workers)
+	)
+
+	// The dispatcher
+	p.goStage(func() {
+		defer close(jobs)
+		defer close(order)
+
+		for {
+			var j job
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				j = job{value: v, result: make(chan []U, 1)}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case order <- j.result:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- j:
+			}
+		}
+	})
+
+	for range workers {
+		p.goStage(func() {
+			for j := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+
+				var results []U
+				err := call(ctx, fn, j.value, func(u U) bool {
+					results = append(results, u)
+					return true
+				})
+				if err != nil {
+					p.fail(err)
+					return
+				}
+				j.result <- results
+			}
+		})
+	}
+
+	// The emitter
+	p.goStage(func() {
+		defer close(out)
+
+		for result := range order {
+			var results []U
+			select {
+			case <-ctx.Done():
+				return
+			case results = <-result:
+			}
+
+			for _, u := range results {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- u:
+				}
+			}
+		}
+	})
+}
+
+// call runs fn, its panic fails the stage
+func call[T, U any](ctx context.Context, fn func(context.Context, T, func(U) bool) error, v T, emit func(U) bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("pipeline: stage panicked: %v", r)
+		}
+	}()
+
+	return fn(ctx, v, emit)
+}
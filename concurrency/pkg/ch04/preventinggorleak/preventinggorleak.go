@@ -116,7 +116,7 @@ func SimpleWriterCancelation() {
 	time.Sleep(1 * time.Second)
 }
 
-// The case when we want union all the "done" channel into a single one.
+// The case when we want union all the "done" channel into a single one. pipeline.Or is the same for the contexts.
 func Or(doneChannels ...<-chan struct{}) <-chan struct{} {
 	switch len(doneChannels) {
 	case 0:
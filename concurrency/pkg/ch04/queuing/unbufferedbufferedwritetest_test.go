@@ -3,6 +3,7 @@ package queuing
 import (
 	"bufio"
 	"concurrency/pkg/ch04/pipeline"
+	"context"
 	"io"
 	"log"
 	"os"
@@ -32,11 +33,11 @@ func tmpFileOrFatal() *os.File {
 }
 
 func performWrite(b *testing.B, writer io.Writer) {
-	done := make(chan struct{})
-	defer close(done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	b.ResetTimer()
-	for bt := range pipeline.LimitedJunction(done, pipeline.Repeat(done, byte(0)), b.N) {
-		writer.Write([]byte{bt.(byte)})
+	for bt := range pipeline.Take(ctx, pipeline.Repeat(ctx, byte(0)), b.N) {
+		writer.Write([]byte{bt})
 	}
 }
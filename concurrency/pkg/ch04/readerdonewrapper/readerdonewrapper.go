@@ -1,37 +1,38 @@
 package readerdonewrapper
 
+import (
+	"concurrency/pkg/ch04/pipeline"
+	"context"
+	"fmt"
+	"time"
+)
+
 /*
 OrDone channel allows us to check whether the done channel has been already closed, at the moment we read from it.
+
+The wrapper itself is pipeline.OrDone: the context takes the place of the done channel, and the stream is typed.
 */
 
-func IsReaderDone(done <-chan struct{}, dataProducer <-chan interface{}) <-chan interface{} {
-	// Create the additional channel we will send data into
-	valStream := make(chan interface{})
+func Using() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
 
+	// The channel nobody closes: ranging over it directly would block forever. Its sender stops with the context
+	neverClosed := make(chan int)
 	go func() {
-		defer close(valStream)
-
-		for {
+		for i := 0; ; i++ {
 			select {
-			// If the done channel has been already closed
-			case <-done:
+			case <-ctx.Done():
 				return
-			// Check whether the dataProducer has been already closed
-			case val, ok := <-dataProducer:
-				if !ok {
-					return
-				}
-
-				// Repeatedly check whether the done channel is closed or not at the moment we've reached this statements
-				select {
-				case <-done:
-					return
-					// If not, send the given value
-				case valStream <- val:
-				}
+			case neverClosed <- i:
 			}
+			time.Sleep(time.Millisecond)
 		}
 	}()
 
-	return valStream
+	for v := range pipeline.OrDone(ctx, neverClosed) {
+		fmt.Printf("%v ", v)
+	}
+
+	fmt.Println()
 }
@@ -1,8 +1,10 @@
 package errpropagation
 
 import (
+	"concurrency/pkg/ch05/errpropagation/errs"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"runtime/debug"
@@ -78,6 +80,12 @@ Remember that in either case, with well-or malformed errors, we will have includ
 information
 */
 
+/*
+MyError captures the whole debug.Stack as a string and leaves the rest to the Misc map. The errs package is the
+well-formed error as a package: the kinds to branch on, the stack captured once at the bottom, the user message kept
+apart from the detail, the ID and the fingerprint, see UsingErrs.
+*/
+
 type MyError struct {
 	Inner      error
 	Message    string
@@ -154,3 +162,36 @@ func Using() {
 		handleError(1, err, msg)
 	}
 }
+
+// UsingErrs is Using with the layers wrapping their errors with errs
+func UsingErrs() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	isGloballyExec := func(path string) (bool, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, errs.Wrap(err, "lowlevel.isGloballyExec", errs.WithField("path", path))
+		}
+		return info.Mode().Perm()&0100 == 0100, nil
+	}
+
+	runJob := func(id string) error {
+		const jobBinPath = "/bad/job/binary"
+
+		isExecutable, err := isGloballyExec(jobBinPath)
+		if err != nil {
+			return errs.Wrap(err, "intermediate.runJob", errs.WithKind(errs.Unavailable), errs.WithField("job", id),
+				errs.WithMessage(fmt.Sprintf("cannot run job %q: requisite binaries not available", id)))
+		} else if !isExecutable {
+			return errs.New(errs.Internal, "intermediate.runJob", "job binary is not executable")
+		}
+
+		return errs.Wrap(exec.Command(jobBinPath, "--id="+id).Run(), "intermediate.runJob")
+	}
+
+	if err := runJob("1"); err != nil {
+		// The log has the detail and the stack, the user has the message and the ID to report
+		logger.Error("job failed", "err", err)
+		fmt.Println(errs.Message(err))
+	}
+}
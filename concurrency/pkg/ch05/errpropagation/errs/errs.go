@@ -0,0 +1,275 @@
+// Package errs is the well-formed error of errpropagation as a package. Every layer of the program wraps the
+// incoming errors with its operation, so the message reads as the path of the call, and the error carries what the
+// layers know:
+//   - the kind, what the callers branch on, e.g. to map the error to an HTTP status;
+//   - the stack of the call that created the error, captured once at the bottom;
+//   - the message for the user, kept apart from the internal detail, which is only logged;
+//   - the ID the user can report and the fingerprint of the stack to aggregate the like issues.
+package errs
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Kind is the class of an error. The kinds are errors themselves, so errors.Is(err, errs.NotFound) works
+type Kind int
+
+const (
+	// The zero kind is unset: the layer inherits the kind of the error it wraps
+	_ Kind = iota
+	NotFound
+	Invalid
+	Conflict
+	Unavailable
+	// Internal is the kind of the bugs and of the errors no layer classified
+	Internal
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case Invalid:
+		return "invalid"
+	case Conflict:
+		return "conflict"
+	case Unavailable:
+		return "unavailable"
+	case Internal:
+		return "internal"
+	default:
+		return "unset"
+	}
+}
+
+func (k Kind) Error() string {
+	return k.String()
+}
+
+// Error is a layer of a well-formed error
+type Error struct {
+	// The operation of the layer, e.g. "intermediate.runJob"
+	Op   string
+	Kind Kind
+	// The internal detail, it's in Error() and in the logs, never shown to the user
+	Detail string
+	// The message for the user, see Message
+	UserMessage string
+	Fields      map[string]any
+	Err         error
+
+	// The time and the stack are captured by the layer at the bottom
+	Time  time.Time
+	depth int
+	stack []uintptr
+	id    string
+}
+
+// Option configures the layer created by New and Wrap
+type Option func(e *Error)
+
+// WithKind sets the kind of the layer
+func WithKind(kind Kind) Option {
+	return func(e *Error) {
+		e.Kind = kind
+	}
+}
+
+// WithMessage sets the message for the user, it must not leak the internals
+func WithMessage(msg string) Option {
+	return func(e *Error) {
+		e.UserMessage = msg
+	}
+}
+
+// WithField adds the context of the error for the logs, e.g. the ID of the job or the host
+func WithField(key string, value any) Option {
+	return func(e *Error) {
+		if e.Fields == nil {
+			e.Fields = make(map[string]any)
+		}
+		e.Fields[key] = value
+	}
+}
+
+// WithStackDepth sets the depth of the stack captured by the layer, StackDepth by default. Zero captures none
+func WithStackDepth(depth int) Option {
+	return func(e *Error) {
+		e.depth = depth
+	}
+}
+
+// New returns the error of the operation, the bottom layer
+func New(kind Kind, op, detail string, opts ...Option) error {
+	e := &Error{Op: op, Kind: kind, Detail: detail, depth: StackDepth}
+	return e.build(opts)
+}
+
+/*
+Wrap wraps err in the layer of the operation, nil for nil err. The layer inherits the kind and the message of err
+unless the options set them. The stack is captured only if no layer below has it.
+*/
+func Wrap(err error, op string, opts ...Option) error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Op: op, Err: err, depth: StackDepth}
+	return e.build(opts)
+}
+
+func (e *Error) build(opts []Option) *Error {
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	// The layer below has the time, the ID and the stack of the error already
+	var below *Error
+	if errors.As(e.Err, &below) {
+		e.Time = below.Time
+		return e
+	}
+	e.Time = time.Now().UTC()
+	e.id = newID()
+	// The frames of build and of New or Wrap are skipped
+	e.stack = callers(e.depth, 4)
+	return e
+}
+
+// Error is the internal message: the path of the operations, the detail and the cause
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Op)
+	if e.Detail != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Detail)
+	}
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the kind of the layer, so errors.Is(err, kind) matches the kind of any layer of err
+func (e *Error) Is(target error) bool {
+	kind, ok := target.(Kind)
+	return ok && e.Kind != 0 && e.Kind == kind
+}
+
+/*
+LogValue logs the whole error: the kind, the ID and the fingerprint, the user message, the fields of all the layers
+and the stack.
+*/
+func (e *Error) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("msg", e.Error()),
+		slog.String("kind", KindOf(e).String()),
+		slog.String("id", ID(e)),
+		slog.Time("time", e.Time),
+	}
+	if msg := userMessage(e); msg != "" {
+		attrs = append(attrs, slog.String("user_msg", msg))
+	}
+	if fields := Fields(e); len(fields) > 0 {
+		group := make([]any, 0, len(fields))
+		for k, v := range fields {
+			group = append(group, slog.Any(k, v))
+		}
+		attrs = append(attrs, slog.Group("fields", group...))
+	}
+	if frames := Stack(e); len(frames) > 0 {
+		attrs = append(attrs, slog.String("fingerprint", Fingerprint(e)), slog.Any("stack", frames))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// KindOf returns the kind of the outermost layer of err that has it, Internal if none has
+func KindOf(err error) Kind {
+	for _, e := range layers(err) {
+		if e.Kind != 0 {
+			return e.Kind
+		}
+	}
+	return Internal
+}
+
+// defaultMessages are the messages for the user of the errors without one
+var defaultMessages = map[Kind]string{
+	NotFound:    "The requested resource was not found.",
+	Invalid:     "The request is invalid.",
+	Conflict:    "The request conflicts with the current state of the resource.",
+	Unavailable: "The service is temporarily unavailable, please try again later.",
+	Internal:    "There was an unexpected issue: please report this as a bug.",
+}
+
+/*
+Message returns the message for the user: the one of the outermost layer that has it, or the generic one of the kind,
+never the internal detail. The message of an Internal error refers to its ID for the user to report.
+*/
+func Message(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := userMessage(err)
+	if msg == "" {
+		msg = defaultMessages[KindOf(err)]
+	}
+	if id := ID(err); id != "" && KindOf(err) == Internal {
+		msg += " [logID: " + id + "]"
+	}
+	return msg
+}
+
+func userMessage(err error) string {
+	for _, e := range layers(err) {
+		if e.UserMessage != "" {
+			return e.UserMessage
+		}
+	}
+	return ""
+}
+
+// Fields returns the fields of all the layers of err, the outer layers win
+func Fields(err error) map[string]any {
+	fields := make(map[string]any)
+	for _, e := range layers(err) {
+		for k, v := range e.Fields {
+			if _, ok := fields[k]; !ok {
+				fields[k] = v
+			}
+		}
+	}
+	return fields
+}
+
+// ID returns the ID of the bottom layer, the one to cross-reference the user reports with the logs
+func ID(err error) string {
+	var id string
+	for _, e := range layers(err) {
+		if e.id != "" {
+			id = e.id
+		}
+	}
+	return id
+}
+
+// layers returns the layers of err from the outermost one, following the first cause of the joined errors
+func layers(err error) []*Error {
+	var all []*Error
+	for err != nil {
+		var e *Error
+		if !errors.As(err, &e) {
+			break
+		}
+		all = append(all, e)
+		err = e.Err
+	}
+	return all
+}
@@ -0,0 +1,263 @@
+package errs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The layers of errpropagation: lowlevel, intermediate and the handler
+
+func isGloballyExec(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, Wrap(err, "lowlevel.isGloballyExec", WithField("path", path))
+	}
+	return info.Mode().Perm()&0100 == 0100, nil
+}
+
+func runJob(id string) error {
+	if _, err := isGloballyExec("/bad/job/binary"); err != nil {
+		return Wrap(err, "intermediate.runJob", WithKind(Unavailable), WithField("job", id),
+			WithMessage("The job can't run: the requisite binaries are not available."))
+	}
+	return nil
+}
+
+func TestLayers(t *testing.T) {
+	err := runJob("1")
+
+	if want := "intermediate.runJob: lowlevel.isGloballyExec: stat /bad/job/binary: no such file or directory"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+	if !errors.Is(err, Unavailable) || errors.Is(err, NotFound) {
+		t.Error("errors.Is doesn't match the kind")
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("errors.Is doesn't match the cause")
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		t.Error("errors.As doesn't find the cause")
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Op != "intermediate.runJob" {
+		t.Errorf("errors.As found %v", e)
+	}
+
+	if KindOf(err) != Unavailable {
+		t.Errorf("KindOf() = %v", KindOf(err))
+	}
+	if fields := Fields(err); fields["path"] != "/bad/job/binary" || fields["job"] != "1" {
+		t.Errorf("Fields() = %v", fields)
+	}
+	if e.Time.IsZero() || e.Time.Location().String() != "UTC" {
+		t.Errorf("Time = %v", e.Time)
+	}
+}
+
+func TestKinds(t *testing.T) {
+	inner := New(NotFound, "store.Get", "no row with id 7")
+
+	// The layer without a kind inherits it, the layer with a kind overrides it
+	if KindOf(Wrap(inner, "service.Get")) != NotFound {
+		t.Error("the kind isn't inherited")
+	}
+	if KindOf(Wrap(inner, "service.Get", WithKind(Conflict))) != Conflict {
+		t.Error("the kind isn't overridden")
+	}
+	if KindOf(errors.New("malformed")) != Internal {
+		t.Error("the malformed error isn't Internal")
+	}
+	if Wrap(nil, "service.Get") != nil {
+		t.Error("Wrap(nil) isn't nil")
+	}
+}
+
+func TestStack(t *testing.T) {
+	err := Wrap(New(Invalid, "parse", "bad input"), "handler")
+
+	stack := Stack(err)
+	if len(stack) == 0 || !strings.Contains(stack[0], "errs.TestStack") {
+		t.Fatalf("Stack() = %v", stack)
+	}
+	// The stack is captured at the bottom, the layers above share it
+	var e *Error
+	errors.As(err, &e)
+	if e.stack != nil {
+		t.Error("the wrapping layer captured a stack")
+	}
+
+	if got := Stack(New(Invalid, "parse", "bad input", WithStackDepth(1))); len(got) != 1 {
+		t.Errorf("Stack() of depth 1 has %d frames", len(got))
+	}
+	if got := Stack(New(Invalid, "parse", "bad input", WithStackDepth(0))); got != nil {
+		t.Errorf("Stack() of depth 0 = %v", got)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	var prints []string
+	for range 2 {
+		prints = append(prints, Fingerprint(runJob("1")))
+	}
+	other := Fingerprint(New(Internal, "other", "other"))
+
+	if prints[0] == "" || prints[0] != prints[1] || prints[0] == other {
+		t.Errorf("fingerprints %v, other %q", prints, other)
+	}
+	if ID(runJob("1")) == ID(runJob("1")) {
+		t.Error("the IDs of two errors are the same")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	err := runJob("1")
+	if msg := Message(err); msg != "The job can't run: the requisite binaries are not available." {
+		t.Errorf("Message() = %q", msg)
+	}
+
+	// The internal detail never leaks
+	bug := Wrap(New(Internal, "db.Query", "password authentication failed for user admin"), "service.List")
+	msg := Message(bug)
+	if strings.Contains(msg, "password") || !strings.HasPrefix(msg, defaultMessages[Internal]) || !strings.Contains(msg, ID(bug)) {
+		t.Errorf("Message() = %q", msg)
+	}
+	if msg := Message(errors.New("malformed")); msg != defaultMessages[Internal] {
+		t.Errorf("Message() of the malformed error = %q", msg)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusOK},
+		{New(NotFound, "op", ""), http.StatusNotFound},
+		{New(Invalid, "op", ""), http.StatusBadRequest},
+		{New(Conflict, "op", ""), http.StatusConflict},
+		{New(Unavailable, "op", ""), http.StatusServiceUnavailable},
+		{New(Internal, "op", ""), http.StatusInternalServerError},
+		{Wrap(context.DeadlineExceeded, "op"), http.StatusGatewayTimeout},
+		{errors.New("malformed"), http.StatusInternalServerError},
+	} {
+		if got := HTTPStatus(tt.err); got != tt.want {
+			t.Errorf("HTTPStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+
+	err := Wrap(New(Internal, "db.Query", "secret detail"), "handler")
+	w := httptest.NewRecorder()
+	WriteHTTP(w, err)
+
+	var body struct{ Error, ID string }
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusInternalServerError || body.ID != ID(err) || strings.Contains(body.Error, "secret") {
+		t.Errorf("WriteHTTP() wrote %d %+v", w.Code, body)
+	}
+}
+
+func TestLogValue(t *testing.T) {
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("job failed", "err", runJob("1"))
+
+	var entry struct {
+		Err struct {
+			Msg, Kind, ID, UserMsg, Fingerprint string
+			Fields                              map[string]any
+			Stack                               []string
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	e := entry.Err
+	if e.Kind != "unavailable" || e.ID == "" || e.Fingerprint == "" || len(e.Stack) == 0 || e.Fields["job"] != "1" ||
+		!strings.HasPrefix(e.Msg, "intermediate.runJob") {
+		t.Errorf("logged %s", buf.String())
+	}
+}
+
+func TestGroup(t *testing.T) {
+	g, ctx := WithContext(context.Background())
+	g.Go(func() error {
+		return New(NotFound, "a", "missing")
+	})
+	g.Go(func() error {
+		<-ctx.Done()
+		return Wrap(context.Cause(ctx), "b")
+	})
+	g.Go(func() error {
+		return nil
+	})
+
+	err := g.Wait()
+	errs := Errors(err)
+	if len(errs) != 2 {
+		t.Fatalf("Wait() = %v", err)
+	}
+	// All the causes are kept, in the order they returned
+	if !errors.Is(errs[0], NotFound) || !errors.Is(errs[1], NotFound) || errs[1].Error() != "b: a: missing" {
+		t.Errorf("errors %v", errs)
+	}
+	if ctx.Err() == nil {
+		t.Error("the context isn't cancelled")
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Error("failed", "err", err)
+	if !strings.Contains(buf.String(), "err.0.kind=\"not found\"") || !strings.Contains(buf.String(), "err.1.msg=") {
+		t.Errorf("logged %s", buf.String())
+	}
+}
+
+func TestGroupPanic(t *testing.T) {
+	var g Group
+	g.Go(func() error {
+		panic("boom")
+	})
+	g.Go(func() error {
+		return errors.New("plain")
+	})
+
+	errs := Errors(g.Wait())
+	if len(errs) != 2 {
+		t.Fatalf("errors %v", errs)
+	}
+	var panicked error
+	for _, err := range errs {
+		if KindOf(err) == Internal && strings.Contains(err.Error(), "panic: boom") {
+			panicked = err
+		}
+	}
+	if panicked == nil {
+		t.Fatalf("errors %v", errs)
+	}
+	if stack := Stack(panicked); !strings.Contains(strings.Join(stack, "\n"), "TestGroupPanic") {
+		t.Errorf("the stack of the panic %v", stack)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	if Join(nil, nil) != nil {
+		t.Error("Join() of nils isn't nil")
+	}
+	single := New(Invalid, "op", "")
+	if Join(nil, single) != single {
+		t.Error("Join() of one error isn't the error")
+	}
+	if err := Join(single, errors.New("plain")); err.Error() != "2 errors: op; plain" {
+		t.Errorf("Join() = %v", err)
+	}
+}
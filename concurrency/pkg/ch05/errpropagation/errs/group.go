@@ -0,0 +1,127 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Multi is the errors of several operations, all the causes are kept. errors.Is and errors.As look into all of them
+type Multi struct {
+	Errs []error
+}
+
+// Join returns the Multi of the non-nil errs, nil if there are none and the error itself if there is one
+func Join(errs ...error) error {
+	var m Multi
+	for _, err := range errs {
+		if err != nil {
+			m.Errs = append(m.Errs, err)
+		}
+	}
+
+	switch len(m.Errs) {
+	case 0:
+		return nil
+	case 1:
+		return m.Errs[0]
+	default:
+		return &m
+	}
+}
+
+func (m *Multi) Error() string {
+	msgs := make([]string, len(m.Errs))
+	for i, err := range m.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(m.Errs), strings.Join(msgs, "; "))
+}
+
+func (m *Multi) Unwrap() []error {
+	return m.Errs
+}
+
+// LogValue logs every error of m, the well-formed ones with their stacks
+func (m *Multi) LogValue() slog.Value {
+	attrs := make([]slog.Attr, len(m.Errs))
+	for i, err := range m.Errs {
+		attrs[i] = slog.Any(strconv.Itoa(i), err)
+	}
+	return slog.GroupValue(attrs...)
+}
+
+/*
+Group is the errgroup that keeps all the errors: Wait returns the Multi of the errors of all the goroutines, in the
+order they returned, not only the first one. The goroutine that panics is an Internal error with the stack of the
+panic. The zero Group is ready to use and never cancels.
+*/
+type Group struct {
+	wg     sync.WaitGroup
+	cancel context.CancelCauseFunc
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// WithContext returns the Group whose context is cancelled by the first error, or when Wait returns
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Group{cancel: cancel}, ctx
+}
+
+// Go runs f in a new goroutine
+func (g *Group) Go(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		if err := g.call(f); err != nil {
+			g.mu.Lock()
+			g.errs = append(g.errs, err)
+			g.mu.Unlock()
+
+			if g.cancel != nil {
+				g.cancel(err)
+			}
+		}
+	}()
+}
+
+func (g *Group) call(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// The deferred call runs on top of the frames of the panic, so they are in the stack
+			err = New(Internal, "errs.Group", fmt.Sprintf("panic: %v", r))
+		}
+	}()
+	return f()
+}
+
+// Wait waits for all the goroutines and returns their errors joined
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel(context.Canceled)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return Join(g.errs...)
+}
+
+// Errors returns the errors of err, err itself if it isn't a Multi or joined by errors.Join
+func Errors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	var m interface{ Unwrap() []error }
+	if errors.As(err, &m) {
+		return m.Unwrap()
+	}
+	return []error{err}
+}
@@ -0,0 +1,47 @@
+package errs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// HTTPStatus maps err to the status of the response, 200 for nil
+func HTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+
+	switch KindOf(err) {
+	case NotFound:
+		return http.StatusNotFound
+	case Invalid:
+		return http.StatusBadRequest
+	case Conflict:
+		return http.StatusConflict
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+/*
+WriteHTTP writes the response of err: its status and the JSON with the message for the user and the ID of the error,
+never the internal detail. The caller logs err with the same ID.
+*/
+func WriteHTTP(w http.ResponseWriter, err error) {
+	body := struct {
+		Error string `json:"error"`
+		ID    string `json:"id,omitempty"`
+	}{Message(err), ID(err)}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(HTTPStatus(err))
+	json.NewEncoder(w).Encode(body)
+}
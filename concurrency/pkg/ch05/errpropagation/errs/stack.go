@@ -0,0 +1,83 @@
+package errs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"strconv"
+)
+
+// StackDepth is the default number of the frames captured by the bottom layer, zero disables the capture
+var StackDepth = 32
+
+// callers returns up to depth program counters of the stack, skipping the frames of the capture
+func callers(depth, skip int) []uintptr {
+	if depth <= 0 {
+		return nil
+	}
+	pcs := make([]uintptr, depth)
+	return pcs[:runtime.Callers(skip, pcs)]
+}
+
+// Stack returns the stack captured by the bottom layer of err as "function file:line" frames, the caller first
+func Stack(err error) []string {
+	pcs := stackOf(err)
+	if len(pcs) == 0 {
+		return nil
+	}
+
+	var (
+		lines  = make([]string, 0, len(pcs))
+		frames = runtime.CallersFrames(pcs)
+	)
+	for {
+		f, more := frames.Next()
+		lines = append(lines, fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line))
+		if !more {
+			return lines
+		}
+	}
+}
+
+/*
+Fingerprint returns the hash of the functions and the lines of the stack of err, the same for every error created at
+the same place by the same path, so the bug trackers can aggregate the like issues. It's empty without a stack.
+*/
+func Fingerprint(err error) string {
+	pcs := stackOf(err)
+	if len(pcs) == 0 {
+		return ""
+	}
+
+	var (
+		h      = fnv.New64a()
+		frames = runtime.CallersFrames(pcs)
+	)
+	for {
+		f, more := frames.Next()
+		h.Write([]byte(f.Function))
+		h.Write([]byte(strconv.Itoa(f.Line)))
+		if !more {
+			return strconv.FormatUint(h.Sum64(), 16)
+		}
+	}
+}
+
+func stackOf(err error) []uintptr {
+	var stack []uintptr
+	for _, e := range layers(err) {
+		if e.stack != nil {
+			stack = e.stack
+		}
+	}
+	return stack
+}
+
+// newID returns the random ID of an error
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
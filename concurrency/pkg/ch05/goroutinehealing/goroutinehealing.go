@@ -1,9 +1,9 @@
 package goroutinehealing
 
 import (
-	"concurrency/pkg/ch04/bridgechannel"
 	"concurrency/pkg/ch04/pipeline"
-	pgl "concurrency/pkg/ch04/preventinggorleak"
+	"concurrency/pkg/ch05/goroutinehealing/supervisor"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -23,8 +23,13 @@ reference to a function that can start the goroutine.
 2. The goroutine is monitored is called "ward".
 */
 
+/*
+The steward watches a single ward and restarts it on any failure. The supervisor package grows it into supervisor trees
+with the restart policies and strategies, restart intensity limits and backoffs, see UsingSupervisor.
+*/
+
 // The goroutine that can be monitored and restarted.
-type startGorFn func(done <-chan struct{}, pulseInterval time.Duration) (heartbeat <-chan struct{})
+type startGorFn func(ctx context.Context, pulseInterval time.Duration) (heartbeat <-chan struct{})
 
 /*
 timeout is the timeout of the function will being motitored
@@ -32,20 +37,22 @@ startGor is used to restart the unhealthy goroutine
 */
 func NewSteward(timeout time.Duration, startGor startGorFn) startGorFn {
 
-	return func(done <-chan struct{}, pulseInterval time.Duration) <-chan struct{} {
+	return func(ctx context.Context, pulseInterval time.Duration) <-chan struct{} {
 		// Steward's timeout to give an upward goroutine the info that it's still alive
 		heartbeat := make(chan struct{})
 
 		go func() {
 			defer close(heartbeat)
 
-			// The variables to control the liveness of the ward.
+			// The variables to control the liveness of the ward. The ward's context is done with the steward's one, so
+			// it takes the place of preventinggorleak.Or
 			var (
-				wardDone      chan struct{}
+				stopWard      context.CancelFunc
 				wardHeartbeat <-chan struct{}
 				startWard     = func() {
-					wardDone = make(chan struct{})
-					wardHeartbeat = startGor(pgl.Or(done, wardDone), timeout/2)
+					var wardCtx context.Context
+					wardCtx, stopWard = context.WithCancel(ctx)
+					wardHeartbeat = startGor(wardCtx, timeout/2)
 				}
 
 				pulse = time.NewTicker(pulseInterval)
@@ -53,6 +60,7 @@ func NewSteward(timeout time.Duration, startGor startGorFn) startGorFn {
 			defer pulse.Stop()
 
 			startWard()
+			defer func() { stopWard() }()
 
 		monitorLoop:
 			for {
@@ -77,12 +85,12 @@ func NewSteward(timeout time.Duration, startGor startGorFn) startGorFn {
 						// Check whether the timeout is reached
 					case <-timeoutStream:
 						log.Println("steward: ward unhealthy; restarting")
-						close(wardDone)
+						stopWard()
 						startWard()
 						continue monitorLoop
 
 						// Check whether the all work has done or not.
-					case <-done:
+					case <-ctx.Done():
 						return
 
 					}
@@ -101,11 +109,11 @@ func UsingFirst() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.Ltime | log.LUTC)
 
-	doWork := func(done <-chan struct{}, _ time.Duration) <-chan struct{} {
+	doWork := func(ctx context.Context, _ time.Duration) <-chan struct{} {
 		log.Println("ward: Hi, I'm irresponsible!")
 
 		go func() {
-			<-done
+			<-ctx.Done()
 			log.Println("ward: i'm halting")
 		}()
 
@@ -114,27 +122,27 @@ func UsingFirst() {
 
 	doWorkWithSteward := NewSteward(4*time.Second, doWork)
 
-	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
 	time.AfterFunc(9*time.Second, func() {
 		log.Println("main: halting steward and ward.")
-		close(done)
+		cancel()
 	})
 
-	for range doWorkWithSteward(done, 4*time.Second) {
+	for range doWorkWithSteward(ctx, 4*time.Second) {
 	}
 
 	log.Println("Done.")
 }
 
 func UsingSecond() {
-	doWorkFn := func(done <-chan struct{}, intList ...int) (startGorFn, <-chan interface{}) {
+	doWorkFn := func(ctx context.Context, intList ...int) (startGorFn, <-chan int) {
 		var (
-			intChanStream = make(chan (<-chan interface{}))
-			intStream     = bridgechannel.Bridge(done, intChanStream)
+			intChanStream = make(chan (<-chan int))
+			intStream     = pipeline.Bridge(ctx, intChanStream)
 
-			doWork = func(done <-chan struct{}, pulseInterval time.Duration) <-chan struct{} {
+			doWork = func(ctx context.Context, pulseInterval time.Duration) <-chan struct{} {
 				var (
-					intStream = make(chan interface{})
+					intStream = make(chan int)
 					heartbeat = make(chan struct{})
 				)
 
@@ -143,7 +151,7 @@ func UsingSecond() {
 
 					select {
 					case intChanStream <- intStream:
-					case <-done:
+					case <-ctx.Done():
 						return
 					}
 
@@ -169,7 +177,7 @@ func UsingSecond() {
 									}
 								case intStream <- intVal:
 									continue valueLoop
-								case <-done:
+								case <-ctx.Done():
 									return
 								}
 							}
@@ -187,14 +195,44 @@ func UsingSecond() {
 	log.SetFlags(log.Ltime | log.LUTC)
 	log.SetOutput(os.Stdout)
 
-	done := make(chan struct{})
-	defer close(done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	doWork, intStream := doWorkFn(done, 1, 2, -1, 3, 4, 5)
+	doWork, intStream := doWorkFn(ctx, 1, 2, -1, 3, 4, 5)
 	doWorkWithSteward := NewSteward(1*time.Millisecond, doWork)
-	doWorkWithSteward(done, 1*time.Hour)
+	doWorkWithSteward(ctx, 1*time.Hour)
 
-	for intVal := range pipeline.LimitedJunction(done, intStream, 6) {
+	for intVal := range pipeline.Take(ctx, intStream, 6) {
 		fmt.Printf("Received: %v\n", intVal)
 	}
 }
+
+// UsingSupervisor is UsingFirst with the supervisor in place of the steward.
+func UsingSupervisor() {
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Ltime | log.LUTC)
+
+	doWork := func(done <-chan struct{}, _ time.Duration) <-chan struct{} {
+		log.Println("ward: Hi, I'm irresponsible!")
+
+		go func() {
+			<-done
+			log.Println("ward: i'm halting")
+		}()
+
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 9*time.Second)
+	defer cancel()
+
+	sup := supervisor.New(
+		supervisor.Config{Name: "steward", OnEvent: func(e supervisor.Event) { log.Println(e) }},
+		supervisor.Spec{Name: "ward", Run: supervisor.Pulses(doWork, 2*time.Second), HeartbeatTimeout: 4 * time.Second},
+	)
+	if err := sup.Run(ctx); err != nil {
+		log.Println(err)
+	}
+
+	log.Println("Done.")
+}
@@ -0,0 +1,87 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventKind is what happened to a child
+type EventKind int
+
+const (
+	Started EventKind = iota
+	// Exited is the child returned nil
+	Exited
+	// Failed is the child returned an error or panicked
+	Failed
+	// Hung is the child missed its heartbeat
+	Hung
+	// Restarting is the child is going to be started after the backoff
+	Restarting
+	// Stopped is the child was cancelled by the supervisor and returned
+	Stopped
+	// Abandoned is the child was cancelled by the supervisor and didn't return in the shutdown timeout
+	Abandoned
+	// GaveUp is the supervisor exceeded its restart intensity and fails
+	GaveUp
+)
+
+var eventKinds = [...]string{"started", "exited", "failed", "hung", "restarting", "stopped", "abandoned", "gave up"}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventKinds) {
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+	return eventKinds[k]
+}
+
+// Event is a structured record of what the supervisor did
+type Event struct {
+	Time       time.Time
+	Supervisor string
+	Child      string
+	Kind       EventKind
+	// The failure of the child, or the one that caused the restart
+	Err error
+	// The number of the restarts within the period, including this one
+	Restarts int
+	// The delay before the restart
+	Backoff time.Duration
+}
+
+func (e Event) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s/%s %s", e.Supervisor, e.Child, e.Kind)
+	if e.Kind == Restarting {
+		fmt.Fprintf(&b, " in %s (restart %d)", e.Backoff, e.Restarts)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+/*
+Pulses adapts the ward of the heartbeat pattern, the function that pulses its heartbeat channel every pulseInterval
+until done is closed like heartbeats.DoWork, to a child. Every pulse is a beat, the closed heartbeat channel is the
+exit of the child.
+*/
+func Pulses(start func(done <-chan struct{}, pulseInterval time.Duration) <-chan struct{}, pulseInterval time.Duration) RunFunc {
+	return func(ctx context.Context, beat func()) error {
+		heartbeat := start(ctx.Done(), pulseInterval)
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case _, ok := <-heartbeat:
+				if !ok {
+					return nil
+				}
+				beat()
+			}
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+The steward of goroutinehealing restarts a single ward when its heartbeat stops. A supervisor is the same idea grown
+into the Erlang shape: it starts a list of children, watches their exits and their heartbeats, and restarts them
+according to their restart policy and its strategy. A supervisor is a child itself, so they nest into a tree, and a
+supervisor that restarts its children too often gives up and fails, so its own supervisor restarts the whole subtree.
+*/
+
+// Restart is the policy of restarting a child
+type Restart int
+
+const (
+	// Permanent children are always restarted
+	Permanent Restart = iota
+	// Transient children are restarted only when they fail
+	Transient
+	// Temporary children are never restarted
+	Temporary
+)
+
+// Strategy is what else is restarted with the failed child
+type Strategy int
+
+const (
+	// OneForOne restarts the failed child alone
+	OneForOne Strategy = iota
+	// OneForAll restarts all the children
+	OneForAll
+	// RestForOne restarts the failed child and the children started after it
+	RestForOne
+)
+
+var (
+	// ErrTooManyRestarts is the error of the supervisor that exceeded its restart intensity
+	ErrTooManyRestarts = errors.New("supervisor: too many restarts")
+	// ErrHung is the failure of the child that missed its heartbeat
+	ErrHung = errors.New("supervisor: no heartbeat")
+)
+
+/*
+RunFunc is the work of a child. It returns when ctx is cancelled, nil means the child is done with its work. beat is
+the heartbeat of the child, it must be called at least every Spec.HeartbeatTimeout when the timeout is set.
+*/
+type RunFunc func(ctx context.Context, beat func()) error
+
+// Spec describes a child
+type Spec struct {
+	Name    string
+	Run     RunFunc
+	Restart Restart
+	// The child that doesn't beat for this long is considered hung: it's cancelled, abandoned and restarted as
+	// failed. Zero turns the heartbeat check off
+	HeartbeatTimeout time.Duration
+	// How long the supervisor waits for the cancelled child to return, 5 seconds by default. The child that takes
+	// longer is abandoned
+	ShutdownTimeout time.Duration
+}
+
+// Config is the restart policy of a supervisor
+type Config struct {
+	Name     string
+	Strategy Strategy
+	// The supervisor fails with ErrTooManyRestarts when there are more than MaxRestarts restarts within Period,
+	// 3 restarts in 5 seconds by default
+	MaxRestarts int
+	Period      time.Duration
+	// The delay before a restart doubles with every restart within Period from MinBackoff up to MaxBackoff,
+	// 10 milliseconds to a second by default
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnEvent is called for every start, exit and restart of the children, from the supervisor goroutine
+	OnEvent func(Event)
+	Clock   Clock
+}
+
+// Supervisor runs and restarts the children
+type Supervisor struct {
+	config Config
+	specs  []Spec
+}
+
+// New returns the supervisor of the children
+func New(config Config, specs ...Spec) *Supervisor {
+	if config.MaxRestarts <= 0 {
+		config.MaxRestarts = 3
+	}
+	if config.Period <= 0 {
+		config.Period = 5 * time.Second
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 10 * time.Millisecond
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = max(time.Second, config.MinBackoff)
+	}
+	if config.Clock == nil {
+		config.Clock = realClock{}
+	}
+
+	return &Supervisor{config: config, specs: specs}
+}
+
+// Spec returns the spec that runs the supervisor as a child of another one
+func (s *Supervisor) Spec(restart Restart) Spec {
+	return Spec{
+		Name: s.config.Name,
+		Run: func(ctx context.Context, _ func()) error {
+			return s.Run(ctx)
+		},
+		Restart: restart,
+	}
+}
+
+/*
+Run starts the children in order and supervises them until ctx is done, then stops them in the reverse order and
+returns nil. It returns nil as well when no child is left to run, and ErrTooManyRestarts, wrapping the last failure,
+when the restart intensity is exceeded.
+*/
+func (s *Supervisor) Run(ctx context.Context) error {
+	r := &runner{
+		Supervisor: s,
+		ctx:        ctx,
+		children:   make([]*child, len(s.specs)),
+		exits:      make(chan exit, len(s.specs)),
+		quit:       make(chan struct{}),
+	}
+	defer close(r.quit)
+
+	for i, spec := range s.specs {
+		if spec.ShutdownTimeout <= 0 {
+			spec.ShutdownTimeout = 5 * time.Second
+		}
+		r.children[i] = &child{spec: spec}
+	}
+	for i := range r.children {
+		r.start(i)
+	}
+
+	return r.loop()
+}
+
+type child struct {
+	spec Spec
+	// The generation of the child tells the exits of the current run from the ones of the abandoned runs
+	gen     int
+	running bool
+	pending bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+type exit struct {
+	index, gen int
+	err        error
+	hung       bool
+}
+
+// runner is the state of a run of the supervisor
+type runner struct {
+	*Supervisor
+	ctx      context.Context
+	children []*child
+	exits    chan exit
+	quit     chan struct{}
+	restarts []time.Time
+	timer    Timer
+}
+
+func (r *runner) loop() error {
+	for {
+		if !r.active() {
+			return nil
+		}
+
+		var fired <-chan time.Time
+		if r.timer != nil {
+			fired = r.timer.C()
+		}
+
+		select {
+		case <-r.ctx.Done():
+			r.stopAll()
+			return nil
+
+		case e := <-r.exits:
+			if err := r.handle(e); err != nil {
+				r.stopAll()
+				return err
+			}
+
+		case <-fired:
+			r.timer = nil
+			for i, c := range r.children {
+				if c.pending {
+					c.pending = false
+					r.start(i)
+				}
+			}
+		}
+	}
+}
+
+func (r *runner) active() bool {
+	for _, c := range r.children {
+		if c.running || c.pending {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *runner) start(i int) {
+	c := r.children[i]
+	c.gen++
+	c.running = true
+	c.done = make(chan struct{})
+
+	var ctx context.Context
+	ctx, c.cancel = context.WithCancel(r.ctx)
+
+	var (
+		gen, done = c.gen, c.done
+		beat      = func() {}
+	)
+	if timeout := c.spec.HeartbeatTimeout; timeout > 0 {
+		beats := make(chan struct{}, 1)
+		beat = func() {
+			select {
+			case beats <- struct{}{}:
+			default:
+			}
+		}
+		// The timer is created here, so the fake clock sees it once the start is reported
+		go r.watch(ctx, exit{index: i, gen: gen, err: ErrHung, hung: true}, beats, r.config.Clock.NewTimer(timeout))
+	}
+
+	go func() {
+		err := call(ctx, c.spec.Run, beat)
+		close(done)
+		r.send(exit{index: i, gen: gen, err: err})
+	}()
+
+	r.emit(Event{Kind: Started, Child: c.spec.Name})
+}
+
+// watch reports the child hung when the timer fires before a beat, every beat restarts the timer
+func (r *runner) watch(ctx context.Context, hung exit, beats <-chan struct{}, t Timer) {
+	timeout := r.children[hung.index].spec.HeartbeatTimeout
+	for {
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-beats:
+			t.Stop()
+			t = r.config.Clock.NewTimer(timeout)
+		case <-t.C():
+			r.send(hung)
+			return
+		}
+	}
+}
+
+func (r *runner) send(e exit) {
+	select {
+	case r.exits <- e:
+	case <-r.quit:
+	}
+}
+
+func (r *runner) handle(e exit) error {
+	c := r.children[e.index]
+	if !c.running || c.gen != e.gen {
+		// The exit of a stopped or abandoned run
+		return nil
+	}
+	c.running = false
+	c.cancel()
+
+	switch {
+	case e.hung:
+		r.emit(Event{Kind: Hung, Child: c.spec.Name, Err: e.err})
+	case e.err != nil:
+		r.emit(Event{Kind: Failed, Child: c.spec.Name, Err: e.err})
+	default:
+		r.emit(Event{Kind: Exited, Child: c.spec.Name})
+	}
+
+	if c.spec.Restart == Temporary || c.spec.Restart == Transient && e.err == nil {
+		return nil
+	}
+
+	now := r.config.Clock.Now()
+	recent := r.restarts[:0]
+	for _, t := range r.restarts {
+		if now.Sub(t) < r.config.Period {
+			recent = append(recent, t)
+		}
+	}
+	r.restarts = append(recent, now)
+	if len(r.restarts) > r.config.MaxRestarts {
+		r.emit(Event{Kind: GaveUp, Child: c.spec.Name, Err: e.err, Restarts: len(r.restarts) - 1})
+		return fmt.Errorf("%w: %s: %s: %w", ErrTooManyRestarts, r.config.Name, c.spec.Name, e.err)
+	}
+
+	restart := []int{e.index}
+	first := len(r.children)
+	switch r.config.Strategy {
+	case OneForAll:
+		first = 0
+	case RestForOne:
+		first = e.index + 1
+	}
+	// The siblings are stopped in the reverse order of the start
+	for i := len(r.children) - 1; i >= first; i-- {
+		if i == e.index {
+			continue
+		}
+		if r.stop(i) && r.children[i].spec.Restart != Temporary {
+			restart = append(restart, i)
+		}
+	}
+
+	backoff := r.config.MinBackoff << (len(r.restarts) - 1)
+	if backoff > r.config.MaxBackoff || backoff <= 0 {
+		backoff = r.config.MaxBackoff
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = r.config.Clock.NewTimer(backoff)
+
+	for _, i := range restart {
+		r.children[i].pending = true
+		r.emit(Event{
+			Kind: Restarting, Child: r.children[i].spec.Name, Err: e.err, Restarts: len(r.restarts), Backoff: backoff,
+		})
+	}
+	return nil
+}
+
+// stop cancels the running child and waits for it for the shutdown timeout, false means it wasn't running
+func (r *runner) stop(i int) bool {
+	c := r.children[i]
+	if !c.running {
+		return false
+	}
+	c.running = false
+	c.cancel()
+
+	t := r.config.Clock.NewTimer(c.spec.ShutdownTimeout)
+	defer t.Stop()
+
+	select {
+	case <-c.done:
+		r.emit(Event{Kind: Stopped, Child: c.spec.Name})
+	case <-t.C():
+		r.emit(Event{Kind: Abandoned, Child: c.spec.Name})
+	}
+	return true
+}
+
+func (r *runner) stopAll() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	for i := len(r.children) - 1; i >= 0; i-- {
+		r.children[i].pending = false
+		r.stop(i)
+	}
+}
+
+func (r *runner) emit(e Event) {
+	if r.config.OnEvent == nil {
+		return
+	}
+	e.Time = r.config.Clock.Now()
+	e.Supervisor = r.config.Name
+	r.config.OnEvent(e)
+}
+
+// call runs the child, its panic is a failure like a returned error
+func call(ctx context.Context, run RunFunc, beat func()) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("supervisor: child panicked: %v", p)
+		}
+	}()
+
+	return run(ctx, beat)
+}
@@ -0,0 +1,397 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock fires its timers only when it's advanced
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	active := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			active = append(active, t)
+		} else {
+			t.c <- c.now
+		}
+	}
+	c.timers = active
+}
+
+// waitTimers waits until n timers are active
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		active := len(c.timers)
+		c.mu.Unlock()
+		if active == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers are active, want %d", active, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, active := range t.clock.timers {
+		if active == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// controlled is the child that fails with the error sent to it, or returns nil when the channel is closed
+type controlled chan error
+
+func (c controlled) run(ctx context.Context, _ func()) error {
+	select {
+	case err := <-c:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+type harness struct {
+	t      *testing.T
+	clock  *fakeClock
+	events chan Event
+	result chan error
+	cancel context.CancelFunc
+}
+
+func start(t *testing.T, config Config, specs ...Spec) *harness {
+	t.Helper()
+
+	h := &harness{t: t, clock: newFakeClock(), events: make(chan Event, 100), result: make(chan error, 1)}
+	config.Clock = h.clock
+	config.OnEvent = func(e Event) { h.events <- e }
+
+	var ctx context.Context
+	ctx, h.cancel = context.WithCancel(context.Background())
+	t.Cleanup(h.cancel)
+
+	s := New(config, specs...)
+	go func() { h.result <- s.Run(ctx) }()
+	return h
+}
+
+// expect checks the next events, a want is the child name and the kind
+func (h *harness) expect(want ...any) []Event {
+	h.t.Helper()
+
+	var got []Event
+	for i := 0; i < len(want); i += 2 {
+		select {
+		case e := <-h.events:
+			if e.Child != want[i] || e.Kind != want[i+1] {
+				h.t.Fatalf("event %q, want %s %s", e, want[i], want[i+1])
+			}
+			got = append(got, e)
+		case <-time.After(5 * time.Second):
+			h.t.Fatalf("no event, want %s %s", want[i], want[i+1])
+		}
+	}
+	return got
+}
+
+// expectUnordered checks the next events come in any order, a want is the child name and the kind
+func (h *harness) expectUnordered(want ...any) {
+	h.t.Helper()
+
+	missing := make(map[[2]any]bool)
+	for i := 0; i < len(want); i += 2 {
+		missing[[2]any{want[i], want[i+1]}] = true
+	}
+	for len(missing) > 0 {
+		select {
+		case e := <-h.events:
+			key := [2]any{e.Child, e.Kind}
+			if !missing[key] {
+				h.t.Fatalf("event %q, want one of %v", e, missing)
+			}
+			delete(missing, key)
+		case <-time.After(5 * time.Second):
+			h.t.Fatalf("no event, want %v", missing)
+		}
+	}
+}
+
+func (h *harness) wait() error {
+	h.t.Helper()
+
+	select {
+	case err := <-h.result:
+		return err
+	case <-time.After(5 * time.Second):
+		h.t.Fatal("Run() didn't return")
+		return nil
+	}
+}
+
+var errBoom = errors.New("boom")
+
+func TestOneForOne(t *testing.T) {
+	a, b := make(controlled), make(controlled)
+	h := start(t, Config{Name: "root"}, Spec{Name: "a", Run: a.run}, Spec{Name: "b", Run: b.run})
+	h.expect("a", Started, "b", Started)
+
+	a <- errBoom
+	events := h.expect("a", Failed, "a", Restarting)
+	if e := events[1]; e.Supervisor != "root" || !errors.Is(e.Err, errBoom) || e.Backoff != 10*time.Millisecond ||
+		e.Restarts != 1 {
+		t.Errorf("restarting event %+v", e)
+	}
+
+	h.clock.Advance(10 * time.Millisecond)
+	h.expect("a", Started)
+
+	h.cancel()
+	h.expect("b", Stopped, "a", Stopped)
+	if err := h.wait(); err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestOneForAll(t *testing.T) {
+	a, b, c := make(controlled), make(controlled), make(controlled)
+	h := start(t, Config{Strategy: OneForAll},
+		Spec{Name: "a", Run: a.run}, Spec{Name: "b", Run: b.run}, Spec{Name: "c", Run: c.run})
+	h.expect("a", Started, "b", Started, "c", Started)
+
+	b <- errBoom
+	h.expect("b", Failed, "c", Stopped, "a", Stopped, "b", Restarting, "c", Restarting, "a", Restarting)
+	h.clock.Advance(10 * time.Millisecond)
+	h.expect("a", Started, "b", Started, "c", Started)
+}
+
+func TestRestForOne(t *testing.T) {
+	a, b, c := make(controlled), make(controlled), make(controlled)
+	h := start(t, Config{Strategy: RestForOne},
+		Spec{Name: "a", Run: a.run}, Spec{Name: "b", Run: b.run}, Spec{Name: "c", Run: c.run, Restart: Temporary})
+	h.expect("a", Started, "b", Started, "c", Started)
+
+	// The temporary child is stopped but not restarted
+	b <- errBoom
+	h.expect("b", Failed, "c", Stopped, "b", Restarting)
+	h.clock.Advance(10 * time.Millisecond)
+	h.expect("b", Started)
+
+	a <- errBoom
+	h.expect("a", Failed, "b", Stopped, "a", Restarting, "b", Restarting)
+}
+
+func TestRestartPolicies(t *testing.T) {
+	var (
+		transient, temporary = make(controlled), make(controlled)
+		h                    = start(t, Config{},
+			Spec{Name: "transient", Run: transient.run, Restart: Transient},
+			Spec{Name: "temporary", Run: temporary.run, Restart: Temporary})
+	)
+	h.expect("transient", Started, "temporary", Started)
+
+	transient <- errBoom
+	h.expect("transient", Failed, "transient", Restarting)
+	h.clock.Advance(10 * time.Millisecond)
+	h.expect("transient", Started)
+
+	temporary <- errBoom
+	h.expect("temporary", Failed)
+	close(transient)
+	h.expect("transient", Exited)
+
+	// Nothing is left to run
+	if err := h.wait(); err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestIntensity(t *testing.T) {
+	failing := make(controlled)
+	h := start(t, Config{Name: "root", MaxRestarts: 2, Period: time.Minute, MaxBackoff: 15 * time.Millisecond},
+		Spec{Name: "a", Run: failing.run})
+	h.expect("a", Started)
+
+	for _, backoff := range []time.Duration{10 * time.Millisecond, 15 * time.Millisecond} {
+		failing <- errBoom
+		if e := h.expect("a", Failed, "a", Restarting)[1]; e.Backoff != backoff {
+			t.Errorf("backoff %s, want %s", e.Backoff, backoff)
+		}
+		h.clock.Advance(backoff)
+		h.expect("a", Started)
+	}
+
+	failing <- errBoom
+	h.expect("a", Failed, "a", GaveUp)
+	if err := h.wait(); !errors.Is(err, ErrTooManyRestarts) || !errors.Is(err, errBoom) {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestIntensityPeriod(t *testing.T) {
+	failing := make(controlled)
+	h := start(t, Config{MaxRestarts: 1, Period: time.Minute}, Spec{Name: "a", Run: failing.run})
+	h.expect("a", Started)
+
+	// The restarts older than the period are forgotten, the backoff starts over
+	for range 3 {
+		failing <- errBoom
+		if e := h.expect("a", Failed, "a", Restarting)[1]; e.Restarts != 1 || e.Backoff != 10*time.Millisecond {
+			t.Errorf("restarting event %+v", e)
+		}
+		h.clock.Advance(time.Minute)
+		h.expect("a", Started)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	silent := func(ctx context.Context, _ func()) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	h := start(t, Config{}, Spec{Name: "silent", Run: silent, HeartbeatTimeout: time.Second})
+	h.expect("silent", Started)
+
+	h.clock.Advance(time.Second)
+	if e := h.expect("silent", Hung, "silent", Restarting)[0]; !errors.Is(e.Err, ErrHung) {
+		t.Errorf("hung event %+v", e)
+	}
+	h.clock.Advance(10 * time.Millisecond)
+	h.expect("silent", Started)
+}
+
+func TestPulses(t *testing.T) {
+	// The ward of the heartbeat pattern: it pulses twice and finishes
+	ward := func(done <-chan struct{}, _ time.Duration) <-chan struct{} {
+		heartbeat := make(chan struct{})
+		go func() {
+			defer close(heartbeat)
+			for range 2 {
+				select {
+				case heartbeat <- struct{}{}:
+				case <-done:
+					return
+				}
+			}
+		}()
+		return heartbeat
+	}
+
+	beats := 0
+	err := Pulses(ward, time.Second)(context.Background(), func() { beats++ })
+	if err != nil || beats != 2 {
+		t.Errorf("Pulses() = %v after %d beats", err, beats)
+	}
+}
+
+func TestAbandoned(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	h := start(t, Config{}, Spec{Name: "stuck", ShutdownTimeout: time.Second, Run: func(context.Context, func()) error {
+		<-stuck
+		return nil
+	}})
+	h.expect("stuck", Started)
+
+	h.cancel()
+	h.clock.waitTimers(t, 1)
+	h.clock.Advance(time.Second)
+	h.expect("stuck", Abandoned)
+	if err := h.wait(); err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestNested(t *testing.T) {
+	var (
+		failing = make(controlled)
+		clock   = newFakeClock()
+		events  = make(chan Event, 100)
+		onEvent = func(e Event) { events <- e }
+		inner   = New(Config{Name: "inner", MaxRestarts: 1, Clock: clock, OnEvent: onEvent},
+			Spec{Name: "a", Run: failing.run})
+		h = &harness{t: t, clock: clock, events: events, result: make(chan error, 1)}
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := New(Config{Name: "root", Clock: clock, OnEvent: onEvent}, inner.Spec(Permanent))
+	go func() { h.result <- root.Run(ctx) }()
+
+	// The inner supervisor starts its children concurrently with the root reporting its start
+	h.expectUnordered("a", Started, "inner", Started)
+
+	failing <- errBoom
+	h.expect("a", Failed, "a", Restarting)
+	clock.Advance(10 * time.Millisecond)
+	h.expect("a", Started)
+
+	// The inner supervisor gives up and the root one restarts the whole subtree
+	failing <- errBoom
+	subtree := h.expect("a", Failed, "a", GaveUp, "inner", Failed, "inner", Restarting)
+	if subtree[1].Supervisor != "inner" || subtree[2].Supervisor != "root" || !errors.Is(subtree[2].Err, ErrTooManyRestarts) {
+		t.Errorf("events %v", subtree)
+	}
+	clock.Advance(10 * time.Millisecond)
+	h.expectUnordered("inner", Started, "a", Started)
+
+	cancel()
+	h.expect("a", Stopped, "inner", Stopped)
+	if err := h.wait(); err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package limiter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var errState = errors.New("limiter: corrupted state")
+
+// validateBucket reports the rate and the burst a bucket can't be filled with
+func validateBucket(r Rate, burst int) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	if burst < 1 {
+		return fmt.Errorf("limiter: invalid burst %d", burst)
+	}
+	return nil
+}
+
+/*
+TokenBucket is the bucket of burst tokens refilled at the rate, the algorithm of golang.org/x/time/rate. The state is
+the number of the tokens and the time they were counted at. The rate and the burst must be positive.
+*/
+func TokenBucket(r Rate, burst int) (Algorithm, error) {
+	if err := validateBucket(r, burst); err != nil {
+		return nil, err
+	}
+	return tokenBucket{interval: r.interval(), burst: burst}, nil
+}
+
+type tokenBucket struct {
+	interval time.Duration
+	burst    int
+}
+
+func (b tokenBucket) take(state []byte, now time.Time, n int) ([]byte, Decision, time.Duration, error) {
+	if n > b.burst {
+		return nil, Decision{}, 0, ErrExceedsLimit
+	}
+
+	tokens := float64(b.burst)
+	if state != nil {
+		if len(state) != 16 {
+			return nil, Decision{}, 0, errState
+		}
+		last := time.Unix(0, int64(binary.BigEndian.Uint64(state[8:])))
+		tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+		if elapsed := now.Sub(last); elapsed > 0 {
+			tokens = min(float64(b.burst), tokens+float64(elapsed)/float64(b.interval))
+		}
+	}
+
+	d := Decision{Limit: b.burst}
+	if tokens < float64(n) {
+		d.Remaining = int(tokens)
+		d.Reset = b.refill(tokens)
+		d.RetryAfter = b.refill(tokens + float64(b.burst-n))
+		return nil, d, 0, nil
+	}
+
+	tokens -= float64(n)
+	d.Allowed, d.Remaining, d.Reset = true, int(tokens), b.refill(tokens)
+
+	next := binary.BigEndian.AppendUint64(nil, math.Float64bits(tokens))
+	next = binary.BigEndian.AppendUint64(next, uint64(now.UnixNano()))
+	return next, d, d.Reset, nil
+}
+
+// refill is the time the bucket with the tokens takes to fill up
+func (b tokenBucket) refill(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((float64(b.burst) - tokens) * float64(b.interval)))
+}
+
+/*
+GCRA is the generic cell rate algorithm: the token bucket of burst refilled at the rate, kept as a single timestamp,
+the theoretical arrival time of the next request were the requests evenly spaced. A request is allowed if it's not
+earlier than burst intervals before that time. The rate and the burst must be positive.
+*/
+func GCRA(r Rate, burst int) (Algorithm, error) {
+	if err := validateBucket(r, burst); err != nil {
+		return nil, err
+	}
+	return gcra{interval: r.interval(), burst: burst}, nil
+}
+
+type gcra struct {
+	interval time.Duration
+	burst    int
+}
+
+func (g gcra) take(state []byte, now time.Time, n int) ([]byte, Decision, time.Duration, error) {
+	if n > g.burst {
+		return nil, Decision{}, 0, ErrExceedsLimit
+	}
+
+	tat := now
+	if state != nil {
+		if len(state) != 8 {
+			return nil, Decision{}, 0, errState
+		}
+		if stored := time.Unix(0, int64(binary.BigEndian.Uint64(state))); stored.After(now) {
+			tat = stored
+		}
+	}
+
+	var (
+		tolerance = g.interval * time.Duration(g.burst)
+		next      = tat.Add(g.interval * time.Duration(n))
+		d         = Decision{Limit: g.burst}
+	)
+	if allowAt := next.Add(-tolerance); allowAt.After(now) {
+		d.Remaining = int((tolerance - tat.Sub(now)) / g.interval)
+		d.Reset = tat.Sub(now)
+		d.RetryAfter = allowAt.Sub(now)
+		return nil, d, 0, nil
+	}
+
+	d.Allowed = true
+	d.Remaining = int((tolerance - next.Sub(now)) / g.interval)
+	d.Reset = next.Sub(now)
+	return binary.BigEndian.AppendUint64(nil, uint64(next.UnixNano())), d, d.Reset, nil
+}
+
+/*
+FixedWindow counts the requests in the windows of the period aligned to the zero time, so every replica agrees on
+the windows. It's the cheapest algorithm, but it allows twice the rate across the border of two windows. The rate
+must be positive.
+*/
+func FixedWindow(r Rate) (Algorithm, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return fixedWindow{limit: r.Count, period: r.Period}, nil
+}
+
+type fixedWindow struct {
+	limit  int
+	period time.Duration
+}
+
+func (w fixedWindow) take(state []byte, now time.Time, n int) ([]byte, Decision, time.Duration, error) {
+	if n > w.limit {
+		return nil, Decision{}, 0, ErrExceedsLimit
+	}
+
+	var (
+		start = now.Truncate(w.period)
+		count int
+	)
+	if state != nil {
+		if len(state) != 16 {
+			return nil, Decision{}, 0, errState
+		}
+		if int64(binary.BigEndian.Uint64(state)) == start.UnixNano() {
+			count = int(binary.BigEndian.Uint64(state[8:]))
+		}
+	}
+
+	d := Decision{Limit: w.limit, Reset: start.Add(w.period).Sub(now)}
+	if count+n > w.limit {
+		d.Remaining = w.limit - count
+		d.RetryAfter = d.Reset
+		return nil, d, 0, nil
+	}
+
+	count += n
+	d.Allowed, d.Remaining = true, w.limit-count
+
+	next := binary.BigEndian.AppendUint64(nil, uint64(start.UnixNano()))
+	next = binary.BigEndian.AppendUint64(next, uint64(count))
+	return next, d, d.Reset, nil
+}
+
+/*
+SlidingLog keeps the time of every allowed request within the last period, so the rate is exact at any moment, at
+the cost of the state growing with the limit. The rate must be positive.
+*/
+func SlidingLog(r Rate) (Algorithm, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return slidingLog{limit: r.Count, period: r.Period}, nil
+}
+
+type slidingLog struct {
+	limit  int
+	period time.Duration
+}
+
+func (l slidingLog) take(state []byte, now time.Time, n int) ([]byte, Decision, time.Duration, error) {
+	if n > l.limit {
+		return nil, Decision{}, 0, ErrExceedsLimit
+	}
+	if len(state)%8 != 0 {
+		return nil, Decision{}, 0, errState
+	}
+
+	// The log is sorted, the requests older than the period are dropped
+	log := make([]time.Time, 0, len(state)/8+n)
+	for i := 0; i < len(state); i += 8 {
+		if t := time.Unix(0, int64(binary.BigEndian.Uint64(state[i:]))); now.Sub(t) < l.period {
+			log = append(log, t)
+		}
+	}
+
+	d := Decision{Limit: l.limit}
+	if len(log)+n > l.limit {
+		d.Remaining = l.limit - len(log)
+		d.Reset = log[len(log)-1].Add(l.period).Sub(now)
+		// The time the request that makes the room for n leaves the window
+		d.RetryAfter = log[len(log)+n-l.limit-1].Add(l.period).Sub(now)
+		return nil, d, 0, nil
+	}
+
+	for range n {
+		log = append(log, now)
+	}
+	d.Allowed, d.Remaining, d.Reset = true, l.limit-len(log), l.period
+
+	next := make([]byte, 0, len(log)*8)
+	for _, t := range log {
+		next = binary.BigEndian.AppendUint64(next, uint64(t.UnixNano()))
+	}
+	return next, d, d.Reset, nil
+}
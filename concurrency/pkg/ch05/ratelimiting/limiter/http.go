@@ -0,0 +1,69 @@
+package limiter
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// KeyFunc returns the key a request is limited by
+type KeyFunc func(r *http.Request) string
+
+// ByIP limits by the address of the client. Behind a proxy the address is the one of the proxy, the key must be
+// taken from the header the proxy sets then
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/*
+Middleware limits the requests by their keys. Every response carries the RateLimit-Limit, RateLimit-Remaining and
+RateLimit-Reset headers of the IETF draft, the denied request is answered with 429 Too Many Requests and Retry-After.
+The failing store lets the request through: its outage shouldn't take the service down. The contention on the key is
+answered with 429 too, and the rest of the errors, e.g. the limit that can't give a token, with 500.
+*/
+func Middleware(l Limiter, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d, err := l.AllowN(r.Context(), key(r), 1)
+			switch {
+			case errors.Is(err, ErrStore):
+				log.Print(err)
+				next.ServeHTTP(w, r)
+				return
+			case errors.Is(err, ErrContention):
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			case err != nil:
+				log.Print(err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			h.Set("RateLimit-Reset", seconds(d.Reset))
+
+			if !d.Allowed {
+				h.Set("Retry-After", seconds(d.RetryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds rounds the duration up to the whole seconds of the headers
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,174 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+The limiters of the ratelimiting package live in the memory of one process. To limit a service of several replicas,
+the state of the limits has to be shared, so here the algorithms are pure functions from the old state of a key to the
+new one, and the state itself lives in a Store: in the memory of the process, or in an external store every replica
+talks to. The store only needs to compare-and-swap a value with a TTL, the limiter retries the lost races.
+*/
+
+var (
+	// ErrExceedsLimit is returned for the request of more tokens than the limit can ever give at once
+	ErrExceedsLimit = errors.New("limiter: the request exceeds the limit")
+	// ErrContention is returned when the state of the key kept changing under the limiter
+	ErrContention = errors.New("limiter: too much contention on the key")
+	// ErrStore wraps the errors of the store
+	ErrStore = errors.New("limiter: store failed")
+)
+
+// maxAttempts is the number of the compare-and-swap attempts before ErrContention
+const maxAttempts = 10
+
+// Rate is Count events per Period
+type Rate struct {
+	Count  int
+	Period time.Duration
+}
+
+func PerSecond(n int) Rate {
+	return Rate{Count: n, Period: time.Second}
+}
+
+func PerMinute(n int) Rate {
+	return Rate{Count: n, Period: time.Minute}
+}
+
+// interval is the time between two events at the rate
+func (r Rate) interval() time.Duration {
+	return r.Period / time.Duration(r.Count)
+}
+
+// validate reports the rate no algorithm can limit by, a rate of over an event per nanosecond included
+func (r Rate) validate() error {
+	if r.Count <= 0 || r.Period <= 0 || r.interval() == 0 {
+		return fmt.Errorf("limiter: invalid rate %s", r)
+	}
+	return nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d per %s", r.Count, r.Period)
+}
+
+// Decision is the answer of the limiter to a request
+type Decision struct {
+	Allowed bool
+	// The quota of the key and what's left of it after the request
+	Limit     int
+	Remaining int
+	// The time until the full quota is available again
+	Reset time.Duration
+	// The time until the denied request can be allowed, zero for the allowed one
+	RetryAfter time.Duration
+}
+
+// Limiter limits the requests per key, e.g. per user or per IP
+type Limiter interface {
+	// AllowN takes n tokens of the key if they are available
+	AllowN(ctx context.Context, key string, n int) (Decision, error)
+}
+
+/*
+Algorithm is a rate limiting algorithm. take applies the request of n tokens at now to the state, nil for the fresh
+key, and returns the new state with the TTL after which the key is fresh again and its state may be forgotten.
+*/
+type Algorithm interface {
+	take(state []byte, now time.Time, n int) (next []byte, d Decision, ttl time.Duration, err error)
+}
+
+// Option configures the limiter
+type Option func(l *limiter)
+
+// WithClock sets the time source, time.Now by default
+func WithClock(now func() time.Time) Option {
+	return func(l *limiter) {
+		l.now = now
+	}
+}
+
+// WithPrefix prefixes the keys in the store, so the limiters can share it
+func WithPrefix(prefix string) Option {
+	return func(l *limiter) {
+		l.prefix = prefix
+	}
+}
+
+type limiter struct {
+	algorithm Algorithm
+	store     Store
+	now       func() time.Time
+	prefix    string
+}
+
+// New returns the limiter keeping the state of the algorithm in the store
+func New(algorithm Algorithm, store Store, opts ...Option) Limiter {
+	l := &limiter{algorithm: algorithm, store: store, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func (l *limiter) AllowN(ctx context.Context, key string, n int) (Decision, error) {
+	if n <= 0 {
+		return Decision{}, fmt.Errorf("limiter: %d tokens requested", n)
+	}
+	key = l.prefix + key
+
+	for range maxAttempts {
+		if err := ctx.Err(); err != nil {
+			return Decision{}, err
+		}
+
+		state, version, err := l.store.Get(ctx, key)
+		if err != nil {
+			return Decision{}, fmt.Errorf("%w: get %q: %w", ErrStore, key, err)
+		}
+
+		next, d, ttl, err := l.algorithm.take(state, l.now(), n)
+		if err != nil || !d.Allowed {
+			// The denied request doesn't change the state
+			return d, err
+		}
+
+		swapped, err := l.store.CompareAndSwap(ctx, key, version, next, ttl)
+		if err != nil {
+			return Decision{}, fmt.Errorf("%w: set %q: %w", ErrStore, key, err)
+		}
+		if swapped {
+			return d, nil
+		}
+	}
+
+	return Decision{}, ErrContention
+}
+
+// Allow takes a token of the key
+func Allow(ctx context.Context, l Limiter, key string) (Decision, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait waits until a token of the key is taken or ctx is done
+func Wait(ctx context.Context, l Limiter, key string) error {
+	for {
+		d, err := l.AllowN(ctx, key, 1)
+		if err != nil || d.Allowed {
+			return err
+		}
+
+		t := time.NewTimer(d.RetryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// must returns the algorithm of a valid configuration
+func must(a Algorithm, err error) Algorithm {
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+/*
+remoteStore is the in-process fake of an external store: the states are copied like they're sent over the network,
+the first conflicts swaps lose to a concurrent writer, and err fails every call.
+*/
+type remoteStore struct {
+	mu        sync.Mutex
+	states    map[string][]byte
+	versions  map[string]uint64
+	conflicts int
+	err       error
+}
+
+func newRemoteStore() *remoteStore {
+	return &remoteStore{states: make(map[string][]byte), versions: make(map[string]uint64)}
+}
+
+func (s *remoteStore) Get(_ context.Context, key string) ([]byte, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, 0, s.err
+	}
+	return append([]byte(nil), s.states[key]...), s.versions[key], nil
+}
+
+func (s *remoteStore) CompareAndSwap(_ context.Context, key string, version uint64, state []byte, _ time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return false, s.err
+	}
+	if s.conflicts > 0 {
+		s.conflicts--
+		s.versions[key]++
+	}
+	if s.versions[key] != version {
+		return false, nil
+	}
+	s.states[key] = append([]byte(nil), state...)
+	s.versions[key]++
+	return true, nil
+}
+
+func TestAlgorithms(t *testing.T) {
+	type step struct {
+		advance    time.Duration
+		n          int
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}
+
+	oneBySecond := []step{
+		{0, 1, true, 2, 0},
+		{0, 1, true, 1, 0},
+		{0, 1, true, 0, 0},
+		{0, 1, false, 0, time.Second},
+		{500 * time.Millisecond, 1, false, 0, 500 * time.Millisecond},
+		{500 * time.Millisecond, 1, true, 0, 0},
+		{2 * time.Second, 2, true, 0, 0},
+	}
+
+	for name, c := range map[string]struct {
+		algorithm Algorithm
+		steps     []step
+	}{
+		"token bucket": {must(TokenBucket(PerSecond(1), 3)), oneBySecond},
+		"gcra":         {must(GCRA(PerSecond(1), 3)), oneBySecond},
+		"fixed window": {must(FixedWindow(PerSecond(3))), []step{
+			{0, 2, true, 1, 0},
+			{0, 1, true, 0, 0},
+			{0, 1, false, 0, time.Second},
+			{500 * time.Millisecond, 1, false, 0, 500 * time.Millisecond},
+			{500 * time.Millisecond, 3, true, 0, 0},
+		}},
+		"sliding log": {must(SlidingLog(PerSecond(3))), []step{
+			{0, 1, true, 2, 0},
+			{400 * time.Millisecond, 1, true, 1, 0},
+			{400 * time.Millisecond, 1, true, 0, 0},
+			{0, 1, false, 0, 200 * time.Millisecond},
+			{0, 2, false, 0, 600 * time.Millisecond},
+			{200 * time.Millisecond, 1, true, 0, 0},
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				ctx   = context.Background()
+				clock = newFakeClock()
+				l     = New(c.algorithm, NewMemoryStore(clock.Now), WithClock(clock.Now))
+			)
+
+			for i, s := range c.steps {
+				clock.Advance(s.advance)
+				d, err := l.AllowN(ctx, "key", s.n)
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if d.Allowed != s.allowed || d.Remaining != s.remaining || d.RetryAfter != s.retryAfter {
+					t.Errorf("step %d: %+v, want allowed %t, remaining %d, retry after %s",
+						i, d, s.allowed, s.remaining, s.retryAfter)
+				}
+			}
+
+			if _, err := l.AllowN(ctx, "key", 4); !errors.Is(err, ErrExceedsLimit) {
+				t.Errorf("AllowN(4) error %v", err)
+			}
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		clock = newFakeClock()
+		store = NewMemoryStore(clock.Now)
+		l     = New(must(FixedWindow(PerMinute(1))), store, WithClock(clock.Now))
+	)
+
+	for _, key := range []string{"alice", "bob"} {
+		if d, err := Allow(ctx, l, key); err != nil || !d.Allowed {
+			t.Errorf("the first request of %s: %+v, %v", key, d, err)
+		}
+	}
+	if d, _ := Allow(ctx, l, "alice"); d.Allowed {
+		t.Error("the second request of alice was allowed")
+	}
+
+	// The limiters sharing the store don't share the keys
+	other := New(must(FixedWindow(PerMinute(1))), store, WithClock(clock.Now), WithPrefix("other:"))
+	if d, _ := Allow(ctx, other, "alice"); !d.Allowed {
+		t.Error("the prefixed limiter denied alice")
+	}
+
+	// The keys are evicted once their windows end
+	if n := store.Len(); n != 3 {
+		t.Errorf("%d keys", n)
+	}
+	clock.Advance(time.Minute)
+	if n := store.Len(); n != 0 {
+		t.Errorf("%d keys after a minute", n)
+	}
+	if d, _ := Allow(ctx, l, "alice"); !d.Allowed {
+		t.Error("alice was denied in the next window")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	const limit = 20
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(nil), "remote": newRemoteStore()} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				clock   = newFakeClock()
+				l       = New(must(SlidingLog(PerMinute(limit))), store, WithClock(clock.Now))
+				allowed atomic.Int32
+				wg      sync.WaitGroup
+			)
+			for range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					for {
+						d, err := Allow(context.Background(), l, "key")
+						if errors.Is(err, ErrContention) {
+							continue
+						}
+						if err != nil {
+							t.Error(err)
+						}
+						if d.Allowed {
+							allowed.Add(1)
+						}
+						return
+					}
+				}()
+			}
+			wg.Wait()
+
+			if n := allowed.Load(); n != limit {
+				t.Errorf("%d requests allowed, want %d", n, limit)
+			}
+		})
+	}
+}
+
+func TestContention(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		store = newRemoteStore()
+		l     = New(must(GCRA(PerSecond(10), 10)), store)
+	)
+
+	store.conflicts = maxAttempts - 1
+	if d, err := Allow(ctx, l, "key"); err != nil || !d.Allowed {
+		t.Errorf("Allow() after %d lost races = %+v, %v", maxAttempts-1, d, err)
+	}
+
+	store.conflicts = maxAttempts
+	if _, err := Allow(ctx, l, "key"); !errors.Is(err, ErrContention) {
+		t.Errorf("Allow() after %d lost races error %v", maxAttempts, err)
+	}
+
+	store.conflicts, store.err = 0, errors.New("connection refused")
+	if _, err := Allow(ctx, l, "key"); !errors.Is(err, store.err) {
+		t.Errorf("Allow() with the failing store error %v", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	clock := newFakeClock()
+	l := New(must(TokenBucket(PerMinute(1), 1)), NewMemoryStore(clock.Now), WithClock(clock.Now))
+
+	if err := Wait(context.Background(), l, "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx, l, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() for the empty bucket = %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var (
+		clock = newFakeClock()
+		store = newRemoteStore()
+		l     = New(must(FixedWindow(PerMinute(2))), store, WithClock(clock.Now))
+		ok    = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		h     = Middleware(l, ByIP)(ok)
+	)
+	clock.Advance(15 * time.Second)
+
+	request := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i, want := range []struct {
+		code      int
+		remaining string
+	}{{http.StatusOK, "1"}, {http.StatusOK, "0"}, {http.StatusTooManyRequests, "0"}} {
+		w := request("192.0.2.1:1234")
+		if w.Code != want.code || w.Header().Get("RateLimit-Remaining") != want.remaining ||
+			w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Reset") != "45" {
+			t.Errorf("request %d: %d %v", i, w.Code, w.Header())
+		}
+		if retry := w.Header().Get("Retry-After"); (want.code == http.StatusTooManyRequests) != (retry == "45") {
+			t.Errorf("request %d: Retry-After %q", i, retry)
+		}
+	}
+
+	// The other client from the same IP is limited too, the other IP isn't
+	if w := request("192.0.2.1:5678"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("the other port: %d", w.Code)
+	}
+	if w := request("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("the other IP: %d", w.Code)
+	}
+
+	// The failing store lets the requests through
+	store.err = errors.New("connection refused")
+	if w := request("192.0.2.1:1234"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("the failing store: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for name, construct := range map[string]func() (Algorithm, error){
+		"zero count":     func() (Algorithm, error) { return TokenBucket(PerSecond(0), 1) },
+		"zero burst":     func() (Algorithm, error) { return GCRA(PerSecond(1), 0) },
+		"zero period":    func() (Algorithm, error) { return FixedWindow(Rate{Count: 1}) },
+		"zero interval":  func() (Algorithm, error) { return GCRA(Rate{Count: 2, Period: time.Nanosecond}, 1) },
+		"negative count": func() (Algorithm, error) { return SlidingLog(PerMinute(-1)) },
+	} {
+		if a, err := construct(); err == nil {
+			t.Errorf("%s: got %v, want an error", name, a)
+		}
+	}
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	var (
+		store = newRemoteStore()
+		l     = New(must(GCRA(PerSecond(10), 1)), store)
+		ok    = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		h     = Middleware(l, ByIP)(ok)
+	)
+
+	code := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	store.conflicts = maxAttempts
+	if got := code(); got != http.StatusTooManyRequests {
+		t.Errorf("the contention: %d, want 429", got)
+	}
+
+	// The limit can't give a token, the misconfigured limiter doesn't let the requests through
+	l = New(failing{}, store)
+	h = Middleware(l, ByIP)(ok)
+	if got := code(); got != http.StatusInternalServerError {
+		t.Errorf("the limit error: %d, want 500", got)
+	}
+}
+
+// failing is the algorithm that can't give any token
+type failing struct{}
+
+func (failing) take([]byte, time.Time, int) ([]byte, Decision, time.Duration, error) {
+	return nil, Decision{}, 0, ErrExceedsLimit
+}
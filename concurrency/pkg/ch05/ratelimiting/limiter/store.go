@@ -0,0 +1,102 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+Store keeps the state of the keys. It's the one thing the replicas of a service share, e.g. Redis with WATCH/MULTI or
+memcached with its CAS tokens can implement it.
+*/
+type Store interface {
+	// Get returns the state of the key and its version, nil and zero for the missing or the expired key
+	Get(ctx context.Context, key string) (state []byte, version uint64, err error)
+	// CompareAndSwap sets the state of the key expiring in ttl if its version is still the one Get returned, false
+	// means the key was changed in between
+	CompareAndSwap(ctx context.Context, key string, version uint64, state []byte, ttl time.Duration) (bool, error)
+}
+
+// sweepInterval is how often MemoryStore drops the expired keys
+const sweepInterval = time.Minute
+
+/*
+MemoryStore is the store in the memory of the process. The key expires when its TTL passes without a request, i.e.
+when its limit is fresh again, so the idle users and IPs don't pile up: the expired keys are dropped on access and
+by the sweep that runs every minute along with the writes.
+*/
+type MemoryStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]entry
+	version   uint64
+	lastSweep time.Time
+}
+
+type entry struct {
+	state   []byte
+	version uint64
+	expires time.Time
+}
+
+// NewMemoryStore returns the empty store, now is time.Now if nil
+func NewMemoryStore(now func() time.Time) *MemoryStore {
+	if now == nil {
+		now = time.Now
+	}
+	return &MemoryStore{now: now, entries: make(map[string]entry), lastSweep: now()}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || !s.now().Before(e.expires) {
+		return nil, 0, nil
+	}
+	return e.state, e.version, nil
+}
+
+func (s *MemoryStore) CompareAndSwap(_ context.Context, key string, version uint64, state []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	current := uint64(0)
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		current = e.version
+	}
+	if current != version {
+		return false, nil
+	}
+
+	// The versions are unique across the keys, so the recreated key doesn't match the version of the expired one
+	s.version++
+	s.entries[key] = entry{state: state, version: s.version, expires: now.Add(ttl)}
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+	return true, nil
+}
+
+// Len returns the number of the keys that haven't expired
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(s.now())
+	return len(s.entries)
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,278 @@
+package hedge
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+RequestsReplicationUsing fires all the replicas at once, so every request costs N times the load. Hedging is the
+cheap version of it: the request is sent once, and only if it hasn't answered within the latency most requests answer
+in, a backup request is sent, and the first answer wins. So only the slow tail of the requests is replicated, and the
+budget caps how much extra load the backups may add when the whole backend slows down.
+*/
+
+// Config tunes when the backup requests are sent and how many of them
+type Config struct {
+	// The delay before a backup request, 100 milliseconds by default. With Percentile set it's only used until
+	// enough latencies are observed
+	Delay time.Duration
+	// The percentile of the observed latencies used as the delay, e.g. 0.95. Zero keeps the delay fixed
+	Percentile float64
+	// The maximum number of the replicas of a request including the first one, 2 by default
+	MaxReplicas int
+	// The share of the backup requests in the requests, e.g. 0.1 for at most 10% extra load. Zero means no limit
+	Budget float64
+	// How many backup requests the budget may save up while the requests are fast, 10 by default
+	BudgetBurst int
+}
+
+const (
+	// window is the number of the last latencies the percentile is taken of
+	window = 256
+	// minSamples is the number of the latencies needed before the percentile replaces the fixed delay
+	minSamples = 20
+)
+
+// Stats are the counters of a hedger
+type Stats struct {
+	Requests int64
+	// The backup requests sent and the requests a backup won
+	Hedges    int64
+	HedgeWins int64
+	// The backup requests the budget didn't allow
+	Denied int64
+}
+
+// Hedger sends the backup requests, it's safe for concurrent use
+type Hedger struct {
+	cfg Config
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	delay     time.Duration
+	stale     bool
+	tokens    float64
+
+	requests, hedges, hedgeWins, denied atomic.Int64
+}
+
+func New(cfg Config) *Hedger {
+	if cfg.Delay <= 0 {
+		cfg.Delay = 100 * time.Millisecond
+	}
+	if cfg.MaxReplicas < 1 {
+		cfg.MaxReplicas = 2
+	}
+	if cfg.BudgetBurst <= 0 {
+		cfg.BudgetBurst = 10
+	}
+	return &Hedger{
+		cfg:       cfg,
+		latencies: make([]time.Duration, 0, window),
+		delay:     cfg.Delay,
+		tokens:    float64(cfg.BudgetBurst),
+	}
+}
+
+// Delay returns the current delay before a backup request
+func (h *Hedger) Delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stale {
+		h.delay = percentile(h.latencies, h.cfg.Percentile)
+		h.stale = false
+	}
+	return h.delay
+}
+
+func (h *Hedger) Stats() Stats {
+	return Stats{
+		Requests:  h.requests.Load(),
+		Hedges:    h.hedges.Load(),
+		HedgeWins: h.hedgeWins.Load(),
+		Denied:    h.denied.Load(),
+	}
+}
+
+// observe records the latency of a successful replica
+func (h *Hedger) observe(latency time.Duration) {
+	if h.cfg.Percentile <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < window {
+		h.latencies = append(h.latencies, latency)
+	} else {
+		h.latencies[h.next] = latency
+		h.next = (h.next + 1) % window
+	}
+	h.stale = len(h.latencies) >= minSamples
+}
+
+// percentile returns the p-th percentile of the latencies
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// request earns the budget its share of a backup request
+func (h *Hedger) request() {
+	h.requests.Add(1)
+	if h.cfg.Budget <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tokens = min(h.tokens+h.cfg.Budget, float64(h.cfg.BudgetBurst))
+}
+
+// spend takes a backup request from the budget
+func (h *Hedger) spend() bool {
+	if h.cfg.Budget > 0 {
+		h.mu.Lock()
+		ok := h.tokens >= 1
+		if ok {
+			h.tokens--
+		}
+		h.mu.Unlock()
+
+		if !ok {
+			h.denied.Add(1)
+			return false
+		}
+	}
+
+	h.hedges.Add(1)
+	return true
+}
+
+/*
+Do calls fn and, while it doesn't return, its backup replicas every Delay up to MaxReplicas as the budget allows. The
+replica that fails is replaced by a backup at once. Do returns the value of the first successful replica and its
+number, zero for the first request; the contexts of the other replicas are cancelled. If all the replicas fail, the
+errors are joined.
+*/
+func Do[T any](ctx context.Context, h *Hedger, fn func(ctx context.Context, replica int) (T, error)) (T, int, error) {
+	v, replica, cancel, err := race(ctx, h, fn, nil)
+	if cancel != nil {
+		cancel()
+	}
+	return v, replica, err
+}
+
+type attempt[T any] struct {
+	value   T
+	replica int
+	err     error
+}
+
+/*
+race runs the replicas and returns the winner with the cancel of its context, which the caller cancels once it's done
+with the value. release, if set, is called with the values of the replicas that succeeded after the winner.
+*/
+func race[T any](
+	ctx context.Context, h *Hedger, fn func(ctx context.Context, replica int) (T, error), release func(T),
+) (T, int, context.CancelFunc, error) {
+	var (
+		results = make(chan attempt[T], h.cfg.MaxReplicas)
+		cancels = make([]context.CancelFunc, 0, h.cfg.MaxReplicas)
+		running int
+		errs    []error
+		zero    T
+	)
+
+	launch := func() {
+		replica := len(cancels)
+		replicaCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		running++
+
+		go func() {
+			start := time.Now()
+			v, err := fn(replicaCtx, replica)
+			if err == nil {
+				h.observe(time.Since(start))
+			}
+			results <- attempt[T]{value: v, replica: replica, err: err}
+		}()
+	}
+
+	// finish cancels the losers and lets the ones still running release their values
+	finish := func(winner int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+		if release != nil && running > 0 {
+			go func(running int) {
+				for range running {
+					if a := <-results; a.err == nil {
+						release(a.value)
+					}
+				}
+			}(running)
+		}
+	}
+
+	// hedge launches a backup if the replicas and the budget allow it
+	hedge := func() bool {
+		if len(cancels) == h.cfg.MaxReplicas || !h.spend() {
+			return false
+		}
+		launch()
+		return true
+	}
+
+	h.request()
+	launch()
+
+	t := time.NewTimer(h.Delay())
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if hedge() {
+				t.Reset(h.Delay())
+			}
+		case a := <-results:
+			running--
+			if a.err == nil {
+				if a.replica > 0 {
+					h.hedgeWins.Add(1)
+				}
+				finish(a.replica)
+				return a.value, a.replica, cancels[a.replica], nil
+			}
+
+			errs = append(errs, a.err)
+			if ctx.Err() == nil && hedge() {
+				continue
+			}
+			if running == 0 {
+				finish(-1)
+				return zero, 0, nil, errors.Join(errs...)
+			}
+		case <-ctx.Done():
+			finish(-1)
+			return zero, 0, nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package hedge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sleep waits for d or the cancellation of ctx
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func TestDo(t *testing.T) {
+	for name, c := range map[string]struct {
+		latencies []time.Duration
+		winner    int
+		hedges    int64
+	}{
+		"fast":       {[]time.Duration{0, 0}, 0, 0},
+		"slow first": {[]time.Duration{time.Second, 0}, 1, 1},
+		"all slow":   {[]time.Duration{300 * time.Millisecond, time.Second, time.Second}, 0, 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				h         = New(Config{Delay: 20 * time.Millisecond, MaxReplicas: len(c.latencies)})
+				cancelled atomic.Int32
+			)
+
+			start := time.Now()
+			v, replica, err := Do(context.Background(), h, func(ctx context.Context, replica int) (string, error) {
+				if err := sleep(ctx, c.latencies[replica]); err != nil {
+					cancelled.Add(1)
+					return "", err
+				}
+				return fmt.Sprint("replica ", replica), nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replica != c.winner || v != fmt.Sprint("replica ", c.winner) {
+				t.Errorf("Do() = %q, %d", v, replica)
+			}
+			if took := time.Since(start); took > c.latencies[c.winner]+200*time.Millisecond {
+				t.Errorf("Do() took %s", took)
+			}
+			if s := h.Stats(); s.Requests != 1 || s.Hedges != c.hedges {
+				t.Errorf("stats %+v", s)
+			}
+
+			// The losers are cancelled
+			for deadline := time.Now().Add(time.Second); cancelled.Load() != int32(c.hedges) && time.Now().Before(deadline); {
+				time.Sleep(time.Millisecond)
+			}
+			if n := cancelled.Load(); n != int32(c.hedges) {
+				t.Errorf("%d replicas cancelled", n)
+			}
+		})
+	}
+}
+
+func TestDoErrors(t *testing.T) {
+	h := New(Config{Delay: time.Hour, MaxReplicas: 3})
+
+	// The failed replica is replaced at once
+	v, replica, err := Do(context.Background(), h, func(ctx context.Context, replica int) (int, error) {
+		if replica == 0 {
+			return 0, errors.New("connection reset")
+		}
+		return replica, nil
+	})
+	if err != nil || v != 1 || replica != 1 {
+		t.Errorf("Do() = %d, %d, %v", v, replica, err)
+	}
+
+	errs := []error{errors.New("first"), errors.New("second"), errors.New("third")}
+	_, _, err = Do(context.Background(), h, func(ctx context.Context, replica int) (int, error) {
+		return 0, errs[replica]
+	})
+	for _, e := range errs {
+		if !errors.Is(err, e) {
+			t.Errorf("Do() error %v doesn't contain %v", err, e)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = Do(ctx, h, func(ctx context.Context, replica int) (int, error) {
+		time.Sleep(time.Second)
+		return 0, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do() ignoring the context error %v", err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	h := New(Config{Delay: time.Millisecond, Budget: 0.25, BudgetBurst: 2})
+
+	for range 30 {
+		_, _, err := Do(context.Background(), h, func(ctx context.Context, replica int) (int, error) {
+			return replica, sleep(ctx, 5*time.Millisecond)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The burst of 2 and one backup per 4 requests
+	if s := h.Stats(); s.Hedges != 9 || s.Denied != 21 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	h := New(Config{Delay: time.Second, Percentile: 0.95})
+
+	for i := range minSamples - 1 {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := h.Delay(); d != time.Second {
+		t.Errorf("the delay with too few latencies %s", d)
+	}
+
+	// 95% of the latencies are up to 10ms
+	h = New(Config{Delay: time.Second, Percentile: 0.95})
+	for i := range 2 * window {
+		latency := time.Duration(i%10+1) * time.Millisecond
+		if i%25 == 0 {
+			latency = time.Second
+		}
+		h.observe(latency)
+	}
+	if d := h.Delay(); d != 10*time.Millisecond {
+		t.Errorf("the 95th percentile %s", d)
+	}
+}
+
+/*
+latencyServer answers "ok" to the requests after the latency drawn for the request number, it returns early when the
+request is cancelled.
+*/
+func latencyServer(t *testing.T, latency func(n int64) time.Duration) (*httptest.Server, *atomic.Int64) {
+	var (
+		requests atomic.Int64
+		srv      = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if sleep(r.Context(), latency(requests.Add(1))) != nil {
+				return
+			}
+			io.WriteString(w, "ok")
+		}))
+	)
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestTransport(t *testing.T) {
+	// Every tenth request is a hundred times slower
+	srv, _ := latencyServer(t, func(n int64) time.Duration {
+		if n%10 == 1 {
+			return time.Second
+		}
+		return 5 * time.Millisecond
+	})
+
+	var (
+		h       = New(Config{Delay: 50 * time.Millisecond, Percentile: 0.95, Budget: 0.2})
+		client  = &http.Client{Transport: &Transport{Hedger: h}}
+		slowest time.Duration
+	)
+	for i := range 50 {
+		start := time.Now()
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != "ok" {
+			t.Fatalf("request %d: %q, %v", i, body, err)
+		}
+		slowest = max(slowest, time.Since(start))
+
+		if i == 0 && ReplicaOf(resp) != 1 {
+			t.Errorf("the first request was won by the replica %d", ReplicaOf(resp))
+		}
+	}
+
+	if slowest > 500*time.Millisecond {
+		t.Errorf("the slowest request took %s", slowest)
+	}
+	if s := h.Stats(); s.HedgeWins < 4 || s.Hedges > 20 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestTransportNotReplayable(t *testing.T) {
+	srv, requests := latencyServer(t, func(int64) time.Duration { return 100 * time.Millisecond })
+	client := &http.Client{Transport: &Transport{Hedger: New(Config{Delay: time.Millisecond})}}
+
+	for _, req := range []func() *http.Request{
+		func() *http.Request {
+			r, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("body"))
+			return r
+		},
+		func() *http.Request {
+			r, _ := http.NewRequest(http.MethodGet, srv.URL, io.NopCloser(strings.NewReader("body")))
+			return r
+		},
+	} {
+		requests.Store(0)
+		resp, err := client.Do(req())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if n := requests.Load(); n != 1 {
+			t.Errorf("%d requests sent", n)
+		}
+	}
+
+	// The POST with an Idempotency-Key is hedged, the replicas send the body again
+	requests.Store(0)
+	r, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("body"))
+	r.Header.Set("Idempotency-Key", "42")
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests sent with Idempotency-Key", n)
+	}
+}
@@ -0,0 +1,86 @@
+package hedge
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+type replicaKey struct{}
+
+/*
+Transport is the http.RoundTripper hedging the requests that are safe to send twice: the GET, HEAD, OPTIONS and TRACE
+requests and the ones with an Idempotency-Key header, whose body can be read again. The other requests go to Base
+once.
+*/
+type Transport struct {
+	// Base sends the replicas, http.DefaultTransport if nil
+	Base   http.RoundTripper
+	Hedger *Hedger
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !replayable(req) {
+		return base.RoundTrip(req)
+	}
+
+	send := func(ctx context.Context, replica int) (*http.Response, error) {
+		r := req.Clone(context.WithValue(ctx, replicaKey{}, replica))
+		if replica > 0 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+		return base.RoundTrip(r)
+	}
+	discard := func(resp *http.Response) {
+		resp.Body.Close()
+	}
+
+	resp, _, cancel, err := race(req.Context(), t.Hedger, send, discard)
+	if err != nil {
+		return nil, err
+	}
+	// The body is read after RoundTrip returns, so the context of the winner lives until the body is closed
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// ReplicaOf returns the number of the replica that won the request of the response, zero for the first one
+func ReplicaOf(resp *http.Response) int {
+	if resp.Request == nil {
+		return 0
+	}
+	replica, _ := resp.Request.Context().Value(replicaKey{}).(int)
+	return replica
+}
+
+// replayable reports whether the request can be sent twice, the rules of net/http retrying a request
+func replayable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	return ok
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
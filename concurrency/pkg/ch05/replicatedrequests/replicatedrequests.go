@@ -1,6 +1,8 @@
 package replicatedrequests
 
 import (
+	"concurrency/pkg/ch05/replicatedrequests/hedge"
+	"context"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -24,6 +26,11 @@ altogether.
 Although this is can be expensive to set up and maintain, if speed is our goal, this is a valuable technique. In addition this naturally provides fault tolerance and scalability.
 */
 
+/*
+Replicating every request multiplies the load. The hedge package replicates only the requests that are slower than
+usual, within a budget of the extra load, see HedgedRequestsUsing.
+*/
+
 func RequestsReplicationUsing() {
 	const (
 		timePaddingInSec = 1
@@ -85,3 +92,31 @@ func RequestsReplicationUsing() {
 
 	fmt.Printf("Received an answer form %#v\n", firstReturned)
 }
+
+// HedgedRequestsUsing is RequestsReplicationUsing with a backup request sent only after the p95 of the latencies.
+func HedgedRequestsUsing() {
+	const timePaddingInSec = 1
+
+	h := hedge.New(hedge.Config{Delay: 2 * time.Second, Percentile: 0.95, MaxReplicas: 10, Budget: 0.1})
+
+	doWork := func(ctx context.Context, id int) (int, error) {
+		simulatedLoadTime := time.Duration(timePaddingInSec+rand.Intn(5)) * time.Second
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(simulatedLoadTime):
+		}
+
+		fmt.Printf("%v took %v\n", id, simulatedLoadTime)
+		return id, nil
+	}
+
+	firstReturned, _, err := hedge.Do(context.Background(), h, doWork)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Received an answer form %#v, %+v\n", firstReturned, h.Stats())
+}
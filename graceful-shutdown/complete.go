@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"graceful-shutdown/lifecycle"
 	"log"
 	"net"
 	"net/http"
@@ -22,6 +24,13 @@ var (
 var isShuttingDown atomic.Bool
 
 func main() {
+	useLifecycle := flag.Bool("lifecycle", false, "shut down with the lifecycle package")
+	flag.Parse()
+	if *useLifecycle {
+		mainWithLifecycle()
+		return
+	}
+
 	// Setup signal context
 	rootCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -74,3 +83,38 @@ func main() {
 	log.Println("Server shut down gracefully")
 
 }
+
+/*
+The lifecycle package runs the same sequence for any number of components: they register their shutdown hooks
+with priorities and timeouts, the readiness and liveness probes flip by themselves, and a second signal exits at
+once. Run with -lifecycle.
+*/
+func mainWithLifecycle() {
+	m := lifecycle.New(lifecycle.Config{})
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", m.ReadinessHandler())
+	mux.Handle("/livez", m.LivenessHandler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+			fmt.Fprintln(w, "Hello!")
+		case <-r.Context().Done():
+			http.Error(w, "Req canceled.", http.StatusRequestTimeout)
+		}
+	})
+
+	server := &http.Server{Addr: ":8080", Handler: mux, BaseContext: m.BaseContext}
+	m.Register(lifecycle.Hook{Name: "http", Priority: lifecycle.Ingress, Stop: lifecycle.HTTPServer(server)})
+
+	go func() {
+		log.Println("Server starting on :8080.")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	}()
+
+	if err := m.Run(context.Background()); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,85 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+/*
+ReadinessHandler answers 200 while the manager runs and 503 once the shutdown begins, so the load balancers stop
+sending the traffic during the drain delay. It's 503 before Run too: the process isn't serving yet.
+*/
+func (m *Manager) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probe(w, m.ready.Load(), "shutting down")
+	})
+}
+
+/*
+LivenessHandler answers 200 until the shutdown runs out of the budget with the hooks still running. The process that
+is shutting down is alive, restarting it would only cut the shutdown short.
+*/
+func (m *Manager) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probe(w, m.live.Load(), "stuck")
+	})
+}
+
+func probe(w http.ResponseWriter, ok bool, reason string) {
+	if !ok {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// HTTPServer shuts the server down, it waits for the requests until ctx is done
+func HTTPServer(srv *http.Server) StopFunc {
+	return srv.Shutdown
+}
+
+// Closer closes c, e.g. a *sql.DB pool. A Close that ignores ctx is abandoned when ctx is done
+func Closer(c io.Closer) StopFunc {
+	return func(ctx context.Context) error {
+		errc := make(chan error, 1)
+		go func() {
+			errc <- c.Close()
+		}()
+
+		select {
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+/*
+Go runs the worker until its hook of the priority cancels its context, the hook waits for the worker to return. The
+worker that returns on its own isn't restarted, its error is logged.
+*/
+func (m *Manager) Go(name string, priority int, run func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if err := run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			m.cfg.Logger.Printf("lifecycle: %s: %v", name, err)
+		}
+	}()
+
+	m.Register(Hook{Name: name, Priority: priority, Stop: func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	}})
+}
@@ -0,0 +1,262 @@
+/*
+Package lifecycle is the shutdown sequence of complete.go as a reusable manager.
+
+On the first signal the readiness probe fails, the manager waits for the change to propagate to the load balancers,
+then runs the shutdown hooks of the components in the order of their priorities. The total budget is the grace
+period of the orchestrator, e.g. the 30 seconds of k8s, and 20% of it is kept as the safety margin. The rest is split
+into the drain delay, the graceful period and the hard period: when the graceful period is over, the BaseContext of
+the servers is cancelled, so the handlers still running are told to give up, and the hooks have the hard period to
+return. A second signal exits at once.
+*/
+package lifecycle
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The priorities of the usual components, the hooks of the lower priority run first
+const (
+	// Ingress is the priority of the HTTP servers and the other entry points: no new work comes in
+	Ingress = 0
+	// Workers is the priority of the background workers and the consumers: the work in flight is finished
+	Workers = 100
+	// Resources is the priority of the DB pools, the producers flushing their buffers and the like: they are used
+	// by the components above until those are stopped
+	Resources = 200
+)
+
+// ErrAbandoned is the error of the hook that didn't return within the budget
+var ErrAbandoned = errors.New("lifecycle: hook abandoned")
+
+// StopFunc stops a component, it must return when ctx is done
+type StopFunc func(ctx context.Context) error
+
+// Hook is the shutdown hook of a component
+type Hook struct {
+	Name string
+	// The hooks run in the ascending order of the priorities, the hooks of the same priority run concurrently
+	Priority int
+	// The timeout of the hook, the end of the budget if zero or longer
+	Timeout time.Duration
+	Stop    StopFunc
+}
+
+// Config is the shutdown budget of a Manager and where its signals come from
+type Config struct {
+	// The time the process has after the first signal, the 30 seconds of k8s terminationGracePeriodSeconds by
+	// default
+	Budget time.Duration
+	// The share of the budget kept as the safety margin, 0.2 by default
+	SafetyMargin float64
+	// How long the readiness probe fails before the hooks run, 5 seconds by default
+	DrainDelay time.Duration
+	// The last part of the budget, when the BaseContext is cancelled and the hooks are waited for, 3 seconds by
+	// default
+	HardPeriod time.Duration
+
+	// The source of the signals, SIGINT and SIGTERM by default. The tests send their signals here
+	Signals <-chan os.Signal
+	// Exit is called on the second signal, os.Exit by default
+	Exit   func(code int)
+	Logger *log.Logger
+}
+
+// Manager runs the shutdown sequence, its methods are safe for concurrent use
+type Manager struct {
+	cfg Config
+
+	mu    sync.Mutex
+	hooks []Hook
+
+	ready, live  atomic.Bool
+	shuttingDown chan struct{}
+
+	ongoing       context.Context
+	cancelOngoing context.CancelFunc
+}
+
+func New(cfg Config) *Manager {
+	if cfg.Budget <= 0 {
+		cfg.Budget = 30 * time.Second
+	}
+	if cfg.SafetyMargin <= 0 {
+		cfg.SafetyMargin = 0.2
+	}
+	if cfg.DrainDelay <= 0 {
+		cfg.DrainDelay = 5 * time.Second
+	}
+	if cfg.HardPeriod <= 0 {
+		cfg.HardPeriod = 3 * time.Second
+	}
+	if cfg.Exit == nil {
+		cfg.Exit = os.Exit
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+
+	m := &Manager{cfg: cfg, shuttingDown: make(chan struct{})}
+	m.live.Store(true)
+	m.ongoing, m.cancelOngoing = context.WithCancel(context.Background())
+	return m
+}
+
+// Register adds the hook, the hooks registered after the shutdown began don't run
+func (m *Manager) Register(h Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks = append(m.hooks, h)
+}
+
+/*
+BaseContext is the http.Server BaseContext: the context of the requests, cancelled when the graceful period is over or
+when all the hooks have returned.
+*/
+func (m *Manager) BaseContext(net.Listener) context.Context {
+	return m.ongoing
+}
+
+// ShuttingDown returns the channel closed when the shutdown begins
+func (m *Manager) ShuttingDown() <-chan struct{} {
+	return m.shuttingDown
+}
+
+/*
+Run waits for a signal or for ctx to be done, and runs the shutdown sequence. It returns the errors of the hooks
+joined, ErrAbandoned for the hooks that didn't return within the budget.
+*/
+func (m *Manager) Run(ctx context.Context) error {
+	signals := m.cfg.Signals
+	if signals == nil {
+		c := make(chan os.Signal, 2)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(c)
+		signals = c
+	}
+
+	m.ready.Store(true)
+	select {
+	case sig := <-signals:
+		m.cfg.Logger.Printf("lifecycle: received %v, shutting down", sig)
+	case <-ctx.Done():
+		m.cfg.Logger.Printf("lifecycle: %v, shutting down", context.Cause(ctx))
+	}
+
+	var (
+		start    = time.Now()
+		deadline = start.Add(time.Duration(float64(m.cfg.Budget) * (1 - m.cfg.SafetyMargin)))
+		graceful = deadline.Add(-m.cfg.HardPeriod)
+	)
+
+	m.ready.Store(false)
+	close(m.shuttingDown)
+
+	// The second signal doesn't wait for the sequence
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-signals:
+			m.cfg.Logger.Printf("lifecycle: received %v again, exiting", sig)
+			m.cfg.Exit(1)
+		case <-done:
+		}
+	}()
+
+	// Give time for readiness check to propagate
+	time.Sleep(min(m.cfg.DrainDelay, time.Until(graceful)))
+
+	stopOngoing := time.AfterFunc(time.Until(graceful), func() {
+		m.cfg.Logger.Print("lifecycle: the graceful period is over, cancelling the ongoing requests")
+		m.cancelOngoing()
+	})
+	defer stopOngoing.Stop()
+	defer m.cancelOngoing()
+
+	return m.stop(deadline)
+}
+
+// stop runs the groups of the hooks of the same priority one by one until the deadline
+func (m *Manager) stop(deadline time.Time) error {
+	m.mu.Lock()
+	hooks := slices.Clone(m.hooks)
+	m.mu.Unlock()
+
+	slices.SortStableFunc(hooks, func(a, b Hook) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	var errs []error
+	for group := range chunkBy(hooks) {
+		results := make(chan error, len(group))
+		for _, h := range group {
+			go func() {
+				results <- m.run(ctx, h)
+			}()
+		}
+
+		for pending := len(group); pending > 0; pending-- {
+			select {
+			case err := <-results:
+				errs = append(errs, err)
+			case <-ctx.Done():
+				// The process is about to be killed, the hooks left are abandoned
+				m.live.Store(false)
+				errs = append(errs, fmt.Errorf("%w: %d hooks of priority %d", ErrAbandoned, pending, group[0].Priority))
+				return errors.Join(errs...)
+			}
+		}
+	}
+
+	m.cfg.Logger.Print("lifecycle: shut down gracefully")
+	return errors.Join(errs...)
+}
+
+// run runs the hook within its timeout
+func (m *Manager) run(ctx context.Context, h Hook) error {
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	if err := h.Stop(ctx); err != nil {
+		m.cfg.Logger.Printf("lifecycle: %s failed after %s: %v", h.Name, time.Since(start), err)
+		return fmt.Errorf("%s: %w", h.Name, err)
+	}
+	m.cfg.Logger.Printf("lifecycle: %s stopped in %s", h.Name, time.Since(start))
+	return nil
+}
+
+// chunkBy yields the runs of the sorted hooks of the same priority
+func chunkBy(hooks []Hook) func(yield func([]Hook) bool) {
+	return func(yield func([]Hook) bool) {
+		for len(hooks) > 0 {
+			n := 1
+			for n < len(hooks) && hooks[n].Priority == hooks[0].Priority {
+				n++
+			}
+			if !yield(hooks[:n]) {
+				return
+			}
+			hooks = hooks[n:]
+		}
+	}
+}
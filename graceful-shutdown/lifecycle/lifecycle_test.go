@@ -0,0 +1,286 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// harness is the manager with the fake signals and exit
+type harness struct {
+	*Manager
+	signals chan os.Signal
+	exits   chan int
+}
+
+func newHarness(cfg Config) *harness {
+	h := &harness{signals: make(chan os.Signal, 2), exits: make(chan int, 1)}
+	if cfg.Budget == 0 {
+		cfg.Budget = time.Second
+	}
+	if cfg.DrainDelay == 0 {
+		cfg.DrainDelay = time.Millisecond
+	}
+	if cfg.HardPeriod == 0 {
+		cfg.HardPeriod = 100 * time.Millisecond
+	}
+	cfg.Signals = h.signals
+	cfg.Exit = func(code int) { h.exits <- code }
+	cfg.Logger = log.New(io.Discard, "", 0)
+	h.Manager = New(cfg)
+	return h
+}
+
+// run runs the manager and returns the channel of its result
+func (h *harness) run(ctx context.Context) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.Run(ctx)
+	}()
+	return errc
+}
+
+// status returns the status code of the probe
+func status(h http.Handler) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w.Code
+}
+
+// eventually waits for the condition for a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	var (
+		h     = newHarness(Config{})
+		mu    sync.Mutex
+		order []string
+		// The hooks of the same priority wait for each other, they only return if they run concurrently
+		ingress sync.WaitGroup
+	)
+	ingress.Add(2)
+
+	hook := func(name string, priority int) {
+		h.Register(Hook{Name: name, Priority: priority, Stop: func(ctx context.Context) error {
+			if priority == Ingress {
+				ingress.Done()
+				ingress.Wait()
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}})
+	}
+	hook("db", Resources)
+	hook("http", Ingress)
+	hook("consumer", Workers)
+	hook("grpc", Ingress)
+
+	if code := status(h.ReadinessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness before Run %d", code)
+	}
+	errc := h.run(context.Background())
+	eventually(t, "not ready", func() bool { return status(h.ReadinessHandler()) == http.StatusOK })
+
+	h.signals <- syscall.SIGTERM
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(order[:2])
+	if want := []string{"grpc", "http", "consumer", "db"}; !slices.Equal(order, want) {
+		t.Errorf("order %v, want %v", order, want)
+	}
+	if code := status(h.ReadinessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness after the shutdown %d", code)
+	}
+	if code := status(h.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("liveness after the shutdown %d", code)
+	}
+	select {
+	case <-h.ShuttingDown():
+	default:
+		t.Error("ShuttingDown() isn't closed")
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	// 800ms of the budget: the drain delay of 100ms, the graceful period up to 600ms, the hard period
+	h := newHarness(Config{DrainDelay: 100 * time.Millisecond, HardPeriod: 200 * time.Millisecond})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		started = make(chan struct{})
+		srv     = &http.Server{
+			BaseContext: h.BaseContext,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				// The request outlives the graceful period, the BaseContext tells it to give up
+				<-r.Context().Done()
+				http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			}),
+		}
+	)
+	go srv.Serve(ln)
+	h.Register(Hook{Name: "http", Priority: Ingress, Stop: HTTPServer(srv)})
+
+	start := time.Now()
+	errc := h.run(context.Background())
+	resp := make(chan *http.Response, 1)
+	go func() {
+		r, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Error(err)
+			close(resp)
+			return
+		}
+		r.Body.Close()
+		resp <- r
+	}()
+	<-started
+
+	h.signals <- syscall.SIGTERM
+	eventually(t, "ready while draining", func() bool {
+		return status(h.ReadinessHandler()) == http.StatusServiceUnavailable
+	})
+
+	if err := <-errc; err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+	if took := time.Since(start); took < 600*time.Millisecond || took > 800*time.Millisecond {
+		t.Errorf("the shutdown took %s", took)
+	}
+	if r := <-resp; r != nil && r.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("the request ended with %d", r.StatusCode)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	var (
+		h      = newHarness(Config{})
+		closed bool
+		worked = make(chan struct{})
+	)
+
+	h.Register(Hook{Name: "slow", Priority: Workers, Timeout: 20 * time.Millisecond, Stop: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	h.Go("worker", Workers, func(ctx context.Context) error {
+		close(worked)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	h.Register(Hook{Name: "db", Priority: Resources, Stop: Closer(closerFunc(func() error {
+		closed = true
+		return nil
+	}))})
+
+	<-worked
+	ctx, cancel := context.WithCancelCause(context.Background())
+	errc := h.run(ctx)
+	cancel(errors.New("test is over"))
+
+	// The hook that timed out doesn't stop the sequence
+	err := <-errc
+	if !errors.Is(err, context.DeadlineExceeded) || err.Error() != "slow: context deadline exceeded" {
+		t.Errorf("Run() = %v", err)
+	}
+	if !closed {
+		t.Error("the db wasn't closed")
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func TestAbandoned(t *testing.T) {
+	var (
+		h       = newHarness(Config{Budget: 250 * time.Millisecond, HardPeriod: 50 * time.Millisecond})
+		release = make(chan struct{})
+		ran     bool
+	)
+	defer close(release)
+
+	h.Register(Hook{Name: "stuck", Priority: Workers, Stop: func(context.Context) error {
+		<-release
+		return nil
+	}})
+	h.Register(Hook{Name: "db", Priority: Resources, Stop: func(context.Context) error {
+		ran = true
+		return nil
+	}})
+
+	start := time.Now()
+	errc := h.run(context.Background())
+	h.signals <- syscall.SIGINT
+
+	if err := <-errc; !errors.Is(err, ErrAbandoned) {
+		t.Errorf("Run() = %v", err)
+	}
+	// 80% of the budget
+	if took := time.Since(start); took < 200*time.Millisecond || took > 300*time.Millisecond {
+		t.Errorf("the shutdown took %s", took)
+	}
+	if ran {
+		t.Error("the hook after the abandoned one ran")
+	}
+	if code := status(h.LivenessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("liveness of the stuck shutdown %d", code)
+	}
+	if h.BaseContext(nil).Err() == nil {
+		t.Error("BaseContext isn't cancelled")
+	}
+}
+
+func TestSecondSignal(t *testing.T) {
+	var (
+		h       = newHarness(Config{Budget: time.Minute})
+		release = make(chan struct{})
+	)
+	defer close(release)
+
+	h.Register(Hook{Name: "stuck", Stop: func(context.Context) error {
+		<-release
+		return nil
+	}})
+
+	h.run(context.Background())
+	h.signals <- syscall.SIGTERM
+	<-h.ShuttingDown()
+	h.signals <- syscall.SIGINT
+
+	select {
+	case code := <-h.exits:
+		if code != 1 {
+			t.Errorf("exit code %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Error("the second signal didn't exit")
+	}
+}
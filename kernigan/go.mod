@@ -1,6 +1,6 @@
 module golang
 
-go 1.22.0
+go 1.23.0
 
 require (
 	github.com/jlaffaye/ftp v0.2.0
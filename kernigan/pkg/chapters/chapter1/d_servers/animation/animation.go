@@ -0,0 +1,277 @@
+/*
+Package animation renders the animated curves of the Lissajous server: Lissajous figures, spirographs and
+harmonographs. The frames differ in the phase of the curve, the lines are antialiased and the animation is encoded
+as GIF or APNG.
+*/
+package animation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// The kinds of the curves
+const (
+	Lissajous    = "lissajous"
+	Spirograph   = "spirograph"
+	Harmonograph = "harmonograph"
+)
+
+// The number of the shades between the background and the line color, the coverage of a pixel is rounded to them
+const levels = 16
+
+// Curve is the point of the curve at the parameter t for the phase of a frame, both x and y are within [-1, 1]
+type Curve func(t, phase float64) (x, y float64)
+
+// Options are the parameters of an animation
+type Options struct {
+	// One of Lissajous, Spirograph and Harmonograph
+	Kind string
+	// The frequencies of the two oscillations of the curve, see Options.Curve
+	FreqX, FreqY float64
+	// The phase of the first frame and its change from frame to frame in radians
+	Phase, PhaseStep float64
+	// The decay of the harmonograph swing per radian of t
+	Damping float64
+	// The curve is drawn for t from 0 to 2π*Cycles
+	Cycles float64
+	// The number of the line segments the curve is drawn with
+	Steps int
+	// The number of the frames and the delay between them in 100ths of a second
+	Frames, Delay int
+	// The width and the height of the frames in pixels
+	Size    int
+	Palette *Palette
+}
+
+// DefaultOptions returns the parameters of a green Lissajous figure on black like the original one
+func DefaultOptions() Options {
+	return Options{
+		Kind:      Lissajous,
+		FreqX:     1,
+		FreqY:     1.5,
+		PhaseStep: 0.1,
+		Damping:   0.02,
+		Cycles:    4,
+		Steps:     4096,
+		Frames:    64,
+		Delay:     4,
+		Size:      256,
+		Palette:   Palettes["green"],
+	}
+}
+
+// Validate reports the parameters the animation can't be rendered with
+func (o *Options) Validate() error {
+	switch {
+	case o.Kind != Lissajous && o.Kind != Spirograph && o.Kind != Harmonograph:
+		return fmt.Errorf("unknown kind %q", o.Kind)
+	case !finite(o.FreqX) || !finite(o.FreqY):
+		return fmt.Errorf("invalid frequencies %g, %g", o.FreqX, o.FreqY)
+	case !finite(o.Phase) || !finite(o.PhaseStep):
+		return fmt.Errorf("invalid phase %g, %g", o.Phase, o.PhaseStep)
+	case !(o.Damping >= 0) || !finite(o.Damping):
+		return fmt.Errorf("invalid damping %g", o.Damping)
+	case !(o.Cycles > 0) || !finite(o.Cycles):
+		return fmt.Errorf("invalid number of cycles %g", o.Cycles)
+	case o.Steps <= 0:
+		return fmt.Errorf("invalid number of steps %d", o.Steps)
+	case o.Frames <= 0:
+		return fmt.Errorf("invalid number of frames %d", o.Frames)
+	case o.Delay < 0 || o.Delay > math.MaxUint16:
+		return fmt.Errorf("invalid delay %d", o.Delay)
+	case o.Size <= 0:
+		return fmt.Errorf("invalid size %d", o.Size)
+	case o.Palette == nil:
+		return errors.New("no palette")
+	}
+	return nil
+}
+
+// Cost is the upper bound of the pixels the rendering touches: every frame is cleared and quantized, and every
+// segment covers at most Size pixels
+func (o *Options) Cost() int64 {
+	size := int64(o.Size)
+	return int64(o.Frames) * (size*size + int64(o.Steps)*size)
+}
+
+// Pixels is the number of the pixels of all the frames, the bytes they take in memory
+func (o *Options) Pixels() int64 {
+	return int64(o.Frames) * int64(o.Size) * int64(o.Size)
+}
+
+/*
+Curve returns the curve of the kind:
+
+	lissajous     x = sin(fx*t), y = sin(fy*t + phase)
+	spirograph    the hypotrochoid, the sum of the rotations with the frequencies fx and -fy, the second one has
+	              the half radius and is turned by phase
+	harmonograph  two damped pendulums per axis, the first one in x is shifted by phase
+*/
+func (o *Options) Curve() Curve {
+	fx, fy, damping := o.FreqX, o.FreqY, o.Damping
+
+	switch o.Kind {
+	case Spirograph:
+		return func(t, phase float64) (float64, float64) {
+			const pen = 0.5
+			a, b := fx*t, fy*t-phase
+			return (math.Cos(a) + pen*math.Cos(b)) / (1 + pen), (math.Sin(a) - pen*math.Sin(b)) / (1 + pen)
+		}
+	case Harmonograph:
+		return func(t, phase float64) (float64, float64) {
+			decay := math.Exp(-damping * t)
+			return decay * (math.Sin(fx*t+phase) + math.Sin(fy*t)) / 2, decay * (math.Sin(fx*t) + math.Cos(fy*t)) / 2
+		}
+	default:
+		return func(t, phase float64) (float64, float64) {
+			return math.Sin(fx * t), math.Sin(fy*t + phase)
+		}
+	}
+}
+
+// Render draws the frames of the animation, it gives up with the error of the context once the context is done
+func Render(ctx context.Context, opts Options) ([]*image.Paletted, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		curve   = opts.Curve()
+		palette = opts.Palette.shades()
+		cover   = make([]float32, opts.Size*opts.Size)
+		frames  = make([]*image.Paletted, 0, opts.Frames)
+
+		// The curve is mapped to the frame leaving a pixel of margin
+		center = float64(opts.Size-1) / 2
+		radius = math.Max(center-1, 0)
+		step   = 2 * math.Pi * opts.Cycles / float64(opts.Steps)
+	)
+
+	for i := 0; i < opts.Frames; i++ {
+		clear(cover)
+		phase := opts.Phase + float64(i)*opts.PhaseStep
+
+		x, y := curve(0, phase)
+		x0, y0 := center+x*radius, center-y*radius
+		for s := 1; s <= opts.Steps; s++ {
+			if s%1024 == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+
+			x, y := curve(float64(s)*step, phase)
+			x1, y1 := center+x*radius, center-y*radius
+			line(cover, opts.Size, x0, y0, x1, y1)
+			x0, y0 = x1, y1
+		}
+
+		img := image.NewPaletted(image.Rect(0, 0, opts.Size, opts.Size), palette)
+		for p, c := range cover {
+			img.Pix[p] = uint8(math.Round(float64(c) * (levels - 1)))
+		}
+		frames = append(frames, img)
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return frames, nil
+}
+
+/*
+line draws the antialiased line with the Xiaolin Wu algorithm: every step along the major axis covers the two
+pixels around the line in proportion to their distance from it. The coverages are composited, so the crossings of
+the curve get brighter rather than overwritten.
+*/
+func line(cover []float32, size int, x0, y0, x1, y1 float64) {
+	plot := func(x, y int, a float64) {
+		if x < 0 || y < 0 || x >= size || y >= size || a <= 0 {
+			return
+		}
+		c := &cover[y*size+x]
+		*c = 1 - (1-*c)*float32(1-a)
+	}
+
+	steep := math.Abs(y1-y0) > math.Abs(x1-x0)
+	if steep {
+		x0, y0, x1, y1 = y0, x0, y1, x1
+		plot0 := plot
+		plot = func(x, y int, a float64) { plot0(y, x, a) }
+	}
+	if x0 > x1 {
+		x0, x1, y0, y1 = x1, x0, y1, y0
+	}
+
+	gradient := 1.0
+	if dx := x1 - x0; dx > 0 {
+		gradient = (y1 - y0) / dx
+	}
+
+	// The end points cover their pixels in proportion to the part of the pixel the segment reaches into
+	xend := math.Round(x0)
+	yend := y0 + gradient*(xend-x0)
+	xgap := 1 - frac(x0+0.5)
+	xstart := int(xend)
+	plot(xstart, int(math.Floor(yend)), (1-frac(yend))*xgap)
+	plot(xstart, int(math.Floor(yend))+1, frac(yend)*xgap)
+	intery := yend + gradient
+
+	xend = math.Round(x1)
+	yend = y1 + gradient*(xend-x1)
+	xgap = frac(x1 + 0.5)
+	xstop := int(xend)
+	plot(xstop, int(math.Floor(yend)), (1-frac(yend))*xgap)
+	plot(xstop, int(math.Floor(yend))+1, frac(yend)*xgap)
+
+	for x := xstart + 1; x < xstop; x++ {
+		y := int(math.Floor(intery))
+		plot(x, y, 1-frac(intery))
+		plot(x, y+1, frac(intery))
+		intery += gradient
+	}
+}
+
+func frac(x float64) float64 {
+	return x - math.Floor(x)
+}
+
+func finite(x float64) bool {
+	return !math.IsNaN(x) && !math.IsInf(x, 0)
+}
+
+// Palette are the colors of an animation
+type Palette struct {
+	Background, Line color.RGBA
+}
+
+// Palettes are the predefined palettes
+var Palettes = map[string]*Palette{
+	"green": {Background: color.RGBA{0, 0, 0, 255}, Line: color.RGBA{0, 255, 0, 255}},
+	"amber": {Background: color.RGBA{20, 10, 0, 255}, Line: color.RGBA{255, 176, 0, 255}},
+	"paper": {Background: color.RGBA{255, 255, 255, 255}, Line: color.RGBA{20, 20, 60, 255}},
+	"neon":  {Background: color.RGBA{10, 0, 30, 255}, Line: color.RGBA{255, 60, 200, 255}},
+}
+
+// shades returns the colors from the background to the line color, the index of a shade is its coverage
+func (pl *Palette) shades() color.Palette {
+	shades := make(color.Palette, levels)
+	for i := range shades {
+		t := float64(i) / (levels - 1)
+		lerp := func(a, b uint8) uint8 {
+			return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+		}
+		shades[i] = color.RGBA{
+			lerp(pl.Background.R, pl.Line.R),
+			lerp(pl.Background.G, pl.Line.G),
+			lerp(pl.Background.B, pl.Line.B),
+			255,
+		}
+	}
+	return shades
+}
@@ -0,0 +1,298 @@
+package animation
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func small(kind string) Options {
+	opts := DefaultOptions()
+	opts.Kind = kind
+	opts.Frames = 4
+	opts.Size = 64
+	opts.Steps = 512
+	return opts
+}
+
+func TestRender(t *testing.T) {
+	for _, kind := range []string{Lissajous, Spirograph, Harmonograph} {
+		frames, err := Render(context.Background(), small(kind))
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if len(frames) != 4 {
+			t.Fatalf("%s: %d frames", kind, len(frames))
+		}
+
+		// The antialiased line has the shades between the background and the line color
+		used := make(map[uint8]bool)
+		for _, p := range frames[0].Pix {
+			used[p] = true
+		}
+		if !used[0] || len(used) < levels/2 {
+			t.Errorf("%s: %d shades used", kind, len(used))
+		}
+
+		if bytes.Equal(frames[0].Pix, frames[1].Pix) {
+			t.Errorf("%s: the frames don't change", kind)
+		}
+	}
+}
+
+func TestLine(t *testing.T) {
+	const size = 8
+	cover := make([]float32, size*size)
+
+	// The horizontal line at the pixel centers covers its pixels fully and nothing else
+	line(cover, size, 1, 3, 6, 3)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			want := float32(0)
+			if y == 3 && x >= 1 && x <= 6 {
+				want = 0.5
+				if x > 1 && x < 6 {
+					want = 1
+				}
+			}
+			if got := cover[y*size+x]; got != want {
+				t.Errorf("cover(%d, %d) = %g, want %g", x, y, got, want)
+			}
+		}
+	}
+
+	// The line between the rows is split between them
+	clear(cover)
+	line(cover, size, 1, 3.5, 6, 3.5)
+	if a, b := cover[3*size+3], cover[4*size+3]; a != 0.5 || b != 0.5 {
+		t.Errorf("the line between the rows covers %g and %g", a, b)
+	}
+
+	// The lines off the canvas are clipped
+	line(cover, size, -20, -20, 40, 50)
+}
+
+func TestCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := Render(ctx, DefaultOptions()); err != context.Canceled {
+		t.Errorf("Render() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestEncodeCanceled(t *testing.T) {
+	frames, err := Render(context.Background(), small(Harmonograph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The encoders give up on the rendered frames too
+	var buf bytes.Buffer
+	if err := encodeAPNG(ctx, &buf, frames, 4); !errors.Is(err, context.Canceled) {
+		t.Errorf("encodeAPNG() error = %v, want %v", err, context.Canceled)
+	}
+	if err := gif.EncodeAll(ctxWriter{ctx, &buf}, &gif.GIF{Image: frames, Delay: make([]int, len(frames))}); !errors.Is(err, context.Canceled) {
+		t.Errorf("EncodeAll() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestGIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GIF(context.Background(), &buf, small(Lissajous)); err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 4 || anim.Delay[0] != 4 || anim.LoopCount != 0 {
+		t.Errorf("%d frames, delay %d, loop count %d", len(anim.Image), anim.Delay[0], anim.LoopCount)
+	}
+	if b := anim.Image[0].Bounds(); b.Dx() != 64 || b.Dy() != 64 {
+		t.Errorf("frame is %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestAPNG(t *testing.T) {
+	opts := small(Spirograph)
+	frames, err := Render(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := APNG(context.Background(), &buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// A plain PNG decoder checks the chunk CRCs and sees the first frame
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < opts.Size; y++ {
+		for x := 0; x < opts.Size; x++ {
+			if r, g, b, _ := img.At(x, y).RGBA(); [3]uint32{r, g, b} != rgb(frames[0].At(x, y).RGBA()) {
+				t.Fatalf("pixel (%d, %d) differs from the first frame", x, y)
+			}
+		}
+	}
+
+	// The animation chunks are in order with the consecutive sequence numbers
+	var (
+		types []string
+		seqs  []uint32
+	)
+	for p := 8; p < len(data); {
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		typ := string(data[p+4 : p+8])
+		types = append(types, typ)
+		if typ == "fcTL" || typ == "fdAT" {
+			seqs = append(seqs, binary.BigEndian.Uint32(data[p+8:]))
+		}
+		if typ == "acTL" {
+			if frames := binary.BigEndian.Uint32(data[p+8:]); frames != 4 {
+				t.Errorf("acTL has %d frames", frames)
+			}
+		}
+		p += 12 + n
+	}
+
+	want := []string{"IHDR", "PLTE", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "fcTL", "fdAT", "fcTL", "fdAT", "IEND"}
+	if len(types) != len(want) {
+		t.Fatalf("chunks %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("chunks %v, want %v", types, want)
+		}
+	}
+	for i, seq := range seqs {
+		if seq != uint32(i) {
+			t.Errorf("sequence numbers %v", seqs)
+			break
+		}
+	}
+}
+
+func rgb(r, g, b, _ uint32) [3]uint32 {
+	return [3]uint32{r, g, b}
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, err := ParseOptions(url.Values{"kind": {"harmonograph"}, "fx": {"2.01"}, "frames": {"10"}, "palette": {"paper"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Kind != Harmonograph || opts.FreqX != 2.01 || opts.Frames != 10 || opts.Palette != Palettes["paper"] {
+		t.Errorf("ParseOptions() = %+v", opts)
+	}
+
+	for _, bad := range []url.Values{
+		{"kind": {"rose"}},
+		{"size": {"0"}},
+		{"frames": {"100000"}},
+		{"fy": {"Inf"}},
+		{"cycles": {"-1"}},
+		{"damping": {"-0.1"}},
+		{"palette": {"plaid"}},
+	} {
+		if _, err := ParseOptions(bad); err == nil {
+			t.Errorf("ParseOptions(%v) succeeded", bad)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	srv := httptest.NewServer(Handler(DefaultLimits()))
+	defer srv.Close()
+
+	tests := []struct {
+		query       string
+		status      int
+		contentType string
+	}{
+		{"size=64&frames=3", http.StatusOK, "image/gif"},
+		{"size=64&frames=3&format=apng&kind=spirograph", http.StatusOK, "image/apng"},
+		{"format=webp", http.StatusBadRequest, ""},
+		{"size=2048&frames=500&steps=1000000", http.StatusBadRequest, ""},
+		// Cheap to draw, but 240MB of frames
+		{"size=2048&frames=60&steps=64", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(srv.URL + "/?" + test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.query, resp.StatusCode, test.status)
+		}
+		if test.contentType != "" && resp.Header.Get("Content-Type") != test.contentType {
+			t.Errorf("%s: content type %q", test.query, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	h := Handler(Limits{Timeout: time.Millisecond})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?size=1024&frames=500&steps=100000", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandlerMaxRenders(t *testing.T) {
+	srv := httptest.NewServer(Handler(Limits{MaxRenders: 1, Timeout: 5 * time.Second}))
+	defer srv.Close()
+
+	// The slow rendering holds the only slot until it's cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/?size=1024&frames=500&steps=100000", nil)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	defer wg.Wait()
+	defer cancel()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		resp, err := http.Get(srv.URL + "/?size=8&frames=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			if resp.Header.Get("Retry-After") == "" {
+				t.Error("no Retry-After")
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status %d while the slot is taken, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package animation
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"io"
+)
+
+// GIF renders the animation and writes it as an endlessly looping GIF
+func GIF(ctx context.Context, w io.Writer, opts Options) error {
+	frames, err := Render(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	anim := gif.GIF{Image: frames, Delay: make([]int, len(frames))}
+	for i := range anim.Delay {
+		anim.Delay[i] = opts.Delay
+	}
+	// EncodeAll can't be interrupted between the frames, its writes can
+	return gif.EncodeAll(ctxWriter{ctx, w}, &anim)
+}
+
+// ctxWriter fails the writes once the context is done
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw ctxWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+// APNG renders the animation and writes it as an endlessly looping animated PNG
+func APNG(ctx context.Context, w io.Writer, opts Options) error {
+	frames, err := Render(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return encodeAPNG(ctx, w, frames, opts.Delay)
+}
+
+/*
+encodeAPNG writes the frames as the indexed color APNG: the PNG whose default image is the first frame, followed by
+the frame control and frame data chunks of the rest. The decoders unaware of APNG show the first frame. All the
+frames share the palette of the first one. It gives up between the frames once ctx is done.
+*/
+func encodeAPNG(ctx context.Context, w io.Writer, frames []*image.Paletted, delay int) error {
+	if len(frames) == 0 {
+		return errors.New("no frames")
+	}
+
+	var (
+		e      = &apngEncoder{w: w}
+		first  = frames[0]
+		bounds = first.Bounds()
+		buf    []byte
+	)
+
+	e.write([]byte("\x89PNG\r\n\x1a\n"))
+
+	// Bit depth 8, color type 3 for the palette, the default compression, filter and no interlace
+	buf = binary.BigEndian.AppendUint32(buf[:0], uint32(bounds.Dx()))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(bounds.Dy()))
+	e.chunk("IHDR", append(buf, 8, 3, 0, 0, 0))
+
+	buf = buf[:0]
+	for _, c := range first.Palette {
+		r, g, b, _ := c.RGBA()
+		buf = append(buf, uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	}
+	e.chunk("PLTE", buf)
+
+	// The number of the frames and of the plays, zero for the endless loop
+	buf = binary.BigEndian.AppendUint32(buf[:0], uint32(len(frames)))
+	e.chunk("acTL", binary.BigEndian.AppendUint32(buf, 0))
+
+	// The frame control and the frame data chunks share the sequence numbers
+	var seq uint32
+	for i, frame := range frames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b := frame.Bounds()
+		buf = binary.BigEndian.AppendUint32(buf[:0], seq)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(b.Dx()))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(b.Dy()))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(b.Min.X-bounds.Min.X))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(b.Min.Y-bounds.Min.Y))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(delay))
+		buf = binary.BigEndian.AppendUint16(buf, 100)
+		// No disposal, the frame replaces the area
+		e.chunk("fcTL", append(buf, 0, 0))
+		seq++
+
+		data, err := compress(frame)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			e.chunk("IDAT", data)
+		} else {
+			e.chunk("fdAT", append(binary.BigEndian.AppendUint32(nil, seq), data...))
+			seq++
+		}
+	}
+
+	e.chunk("IEND", nil)
+	return e.err
+}
+
+// compress returns the zlib stream of the rows of the frame, each one prefixed with the filter type none
+func compress(frame *image.Paletted) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		zw  = zlib.NewWriter(&buf)
+		b   = frame.Bounds()
+	)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		offset := frame.PixOffset(b.Min.X, y)
+		if _, err := zw.Write([]byte{0}); err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(frame.Pix[offset : offset+b.Dx()]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// apngEncoder writes the chunks keeping the first error, so the encoding doesn't check every write
+type apngEncoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *apngEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+// chunk writes the length, the type, the data and the CRC of the type and the data
+func (e *apngEncoder) chunk(typ string, data []byte) {
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	header = append(header, typ...)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	e.write(header)
+	e.write(data)
+	e.write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+}
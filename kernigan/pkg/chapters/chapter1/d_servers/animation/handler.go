@@ -0,0 +1,195 @@
+package animation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// The bounds of the single request parameters, Limits bound their combination
+const (
+	maxSize   = 2048
+	maxFrames = 500
+	maxSteps  = 1 << 20
+)
+
+// Limits bound the rendering of a request, so hostile parameters can't keep the server busy
+type Limits struct {
+	// The largest Options.Cost of a request
+	MaxCost int64
+	// The largest number of the pixels of all the frames of a request, the frames are kept in memory a byte per
+	// pixel until they are encoded
+	MaxPixels int64
+	// The number of the renderings at the same time, the requests beyond it are rejected with 503
+	MaxRenders int
+	// The longest rendering of a request
+	Timeout time.Duration
+}
+
+// DefaultLimits allow twice the work and four times the memory of the default animation, a rendering per CPU and 10
+// seconds of rendering
+func DefaultLimits() Limits {
+	return Limits{MaxCost: 1 << 27, MaxPixels: 1 << 24, MaxRenders: runtime.NumCPU(), Timeout: 10 * time.Second}
+}
+
+/*
+ParseOptions reads the animation parameters from the query on top of the default ones:
+
+	kind            lissajous, spirograph or harmonograph
+	fx, fy          frequencies of the oscillations
+	phase, step     phase of the first frame and its change per frame in radians
+	damping         decay of the harmonograph per radian
+	cycles          number of the 2π periods of the parameter
+	steps           number of the line segments of the curve
+	frames, delay   number of the frames and the delay between them in 100ths of a second
+	size            width and height in pixels
+	palette         name of the palette, see Palettes
+*/
+func ParseOptions(query url.Values) (Options, error) {
+	opts := DefaultOptions()
+
+	if kind := query.Get("kind"); kind != "" {
+		opts.Kind = kind
+	}
+
+	ints := []struct {
+		name     string
+		dst      *int
+		min, max int
+	}{
+		{"steps", &opts.Steps, 1, maxSteps},
+		{"frames", &opts.Frames, 1, maxFrames},
+		{"delay", &opts.Delay, 0, 6000},
+		{"size", &opts.Size, 1, maxSize},
+	}
+	for _, p := range ints {
+		s := query.Get(p.name)
+		if s == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(s)
+		if err != nil || v < p.min || v > p.max {
+			return opts, fmt.Errorf("bad %s %q, want an integer from %d to %d", p.name, s, p.min, p.max)
+		}
+		*p.dst = v
+	}
+
+	floats := []struct {
+		name string
+		dst  *float64
+	}{
+		{"fx", &opts.FreqX},
+		{"fy", &opts.FreqY},
+		{"phase", &opts.Phase},
+		{"step", &opts.PhaseStep},
+		{"damping", &opts.Damping},
+		{"cycles", &opts.Cycles},
+	}
+	for _, p := range floats {
+		s := query.Get(p.name)
+		if s == "" {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || !finite(v) {
+			return opts, fmt.Errorf("bad %s %q", p.name, s)
+		}
+		*p.dst = v
+	}
+
+	if name := query.Get("palette"); name != "" {
+		palette, ok := Palettes[name]
+		if !ok {
+			return opts, fmt.Errorf("unknown palette %q", name)
+		}
+		opts.Palette = palette
+	}
+
+	return opts, opts.Validate()
+}
+
+/*
+Handler serves the animation described by the query, see ParseOptions. It's an APNG if the "format" parameter is
+"apng", GIF otherwise. The requests costing more than the limits allow are rejected, so are the ones coming while
+MaxRenders renderings are in progress, and the renderings taking longer than the timeout are abandoned with 503.
+*/
+func Handler(limits Limits) http.Handler {
+	var renders chan struct{}
+	if limits.MaxRenders > 0 {
+		renders = make(chan struct{}, limits.MaxRenders)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		opts, err := ParseOptions(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cost := opts.Cost(); limits.MaxCost > 0 && cost > limits.MaxCost {
+			http.Error(w, fmt.Sprintf("the animation is too large: cost %d, at most %d", cost, limits.MaxCost),
+				http.StatusBadRequest)
+			return
+		}
+		if pixels := opts.Pixels(); limits.MaxPixels > 0 && pixels > limits.MaxPixels {
+			http.Error(w, fmt.Sprintf("the animation is too large: %d pixels, at most %d", pixels, limits.MaxPixels),
+				http.StatusBadRequest)
+			return
+		}
+
+		if renders != nil {
+			select {
+			case renders <- struct{}{}:
+				defer func() { <-renders }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many renderings in progress", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		ctx := r.Context()
+		if limits.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+			defer cancel()
+		}
+
+		// Render into the buffer first, so an error can still be reported with its status
+		var (
+			buf         bytes.Buffer
+			contentType string
+		)
+		switch format := query.Get("format"); format {
+		case "apng":
+			contentType = "image/apng"
+			err = APNG(ctx, &buf, opts)
+		case "", "gif":
+			contentType = "image/gif"
+			err = GIF(ctx, &buf, opts)
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, "the rendering took too long", http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		buf.WriteTo(w)
+	})
+}
@@ -6,23 +6,24 @@ import (
 	"golang/pkg/chapters/chapter10/archivereader"
 	_ "golang/pkg/chapters/chapter10/archivereader/tarreader"
 	_ "golang/pkg/chapters/chapter10/archivereader/zipreader"
+	"slices"
 )
 
 func PrintArchiveNames() error {
 	var (
 		archPath = flag.String("path", "", "path to archive")
-		format   = flag.String("f", "zip", "available: zip/tar")
+		format   = flag.String("f", "", "available: zip/tar/tar.gz/tar.bz2, detected from the file if empty")
+		extract  = flag.String("x", "", "directory to extract the archive into")
 	)
 
 	flag.Parse()
 
-	switch *format {
-	case "zip":
-		return archivereader.ReadArchive("zip", *archPath)
-	case "tar":
-		return archivereader.ReadArchive("tar", *archPath)
-	default:
+	if *format != "" && !slices.Contains(archivereader.Formats(), *format) {
 		return fmt.Errorf("unsupported format")
 	}
 
+	if *extract != "" {
+		return archivereader.Extract(*archPath, *extract, nil)
+	}
+	return archivereader.ReadArchive(*format, *archPath)
 }
@@ -1,48 +1,277 @@
+/*
+Package archivereader lists, extracts and creates archives of the formats registered by the format packages,
+the same way image.Decode works with the formats registered by image/png or image/jpeg:
+
+	import (
+		"golang/pkg/chapters/chapter10/archivereader"
+		_ "golang/pkg/chapters/chapter10/archivereader/tarreader"
+		_ "golang/pkg/chapters/chapter10/archivereader/zipreader"
+	)
+
+	entries, err := archivereader.List("backup.tar.gz")
+
+The format of a file is detected from its magic bytes, so the file name extension doesn't matter.
+*/
 package archivereader
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Entry describes a file, a directory or a link stored in an archive
+type Entry struct {
+	// The slash-separated path of the entry inside the archive, directories may have a trailing slash
+	Name    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+	// The target of a symbolic link
+	Linkname string
+}
+
+func (e *Entry) IsDir() bool {
+	return e.Mode.IsDir() || strings.HasSuffix(e.Name, "/")
+}
+
+// ArchiveReader walks the entries of a single archive, a new reader is created for every archive being read
 type ArchiveReader interface {
 	Open(path string) error
-	Read(writer io.Writer) error
+	// Next advances to the next entry and returns its description, it returns io.EOF at the end of the archive
+	Next() (*Entry, error)
+	// Read reads the contents of the current entry
+	Read(p []byte) (int, error)
 	Close() error
 }
 
+// ArchiveWriter creates a single archive, a new writer is created for every archive being written
+type ArchiveWriter interface {
+	Create(path string) error
+	// WriteEntry adds an entry with the contents read from r, r is nil for directories and links
+	WriteEntry(entry *Entry, r io.Reader) error
+	Close() error
+}
+
+// Format describes an archive format registered by a format package
+type Format struct {
+	Name string
+	// The signature found at Offset from the beginning of the archives, the empty one disables the detection
+	Magic  string
+	Offset int
+	// The factories create a new reader and writer for every archive, NewWriter is nil for the read-only formats
+	NewReader func() ArchiveReader
+	NewWriter func() ArchiveWriter
+}
+
 const (
-	tableHeaderFormat = "%-*s%-*s\n"
-	tableRowFormat    = "%-*s%-*d\n"
-	width             = 15
+	tableHeaderFormat = "%-*s %-*s\n"
+	tableRowFormat    = "%-*s %-*d\n"
+	width             = 40
+
+	// The number of bytes read from the beginning of a file to detect its format
+	sniffLen = 512
 )
 
 var (
-	archiveReaders = make(map[string]ArchiveReader)
+	ErrUnknownFormat = errors.New("unknown archive format")
+
+	formatsMu sync.RWMutex
+	// The formats in the order of the registration, which is the order the magic bytes are checked in
+	formats []Format
 )
 
+// RegisterFormat registers an archive format, it's usually called from the init function of a format package
+func RegisterFormat(f Format) {
+	if f.Name == "" || f.NewReader == nil {
+		panic("archivereader: RegisterFormat with an empty name or a nil reader factory")
+	}
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	for i := range formats {
+		if formats[i].Name == f.Name {
+			formats[i] = f
+			return
+		}
+	}
+	formats = append(formats, f)
+}
+
+// Register registers a reader of the format that isn't detected from the magic bytes
 func Register(format string, newReader func() ArchiveReader) {
-	archiveReaders[format] = newReader()
+	RegisterFormat(Format{Name: format, NewReader: newReader})
+}
+
+// Formats returns the names of the registered formats
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for _, f := range formats {
+		names = append(names, f.Name)
+	}
+	return names
 }
 
-func ReadArchive(format string, path string) error {
-	reader, ok := archiveReaders[format]
+func lookupFormat(name string) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	for _, f := range formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// Sniff returns the name of the format whose magic bytes the header starts with
+func Sniff(header []byte) (string, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	for _, f := range formats {
+		if f.Magic == "" || len(header) < f.Offset+len(f.Magic) {
+			continue
+		}
+		if string(header[f.Offset:f.Offset+len(f.Magic)]) == f.Magic {
+			return f.Name, nil
+		}
+	}
+	return "", ErrUnknownFormat
+}
+
+// Detect returns the name of the format of the archive at path
+func Detect(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header, err := bufio.NewReaderSize(file, sniffLen).Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading %s: %s", path, err)
+	}
+
+	format, err := Sniff(header)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return format, nil
+}
+
+/*
+Open opens the archive at path with a reader of the given format, the empty format is detected from the magic bytes.
+The caller must close the reader.
+*/
+func Open(format, path string) (ArchiveReader, error) {
+	if format == "" {
+		detected, err := Detect(path)
+		if err != nil {
+			return nil, err
+		}
+		format = detected
+	}
+
+	f, ok := lookupFormat(format)
 	if !ok {
-		return fmt.Errorf("unsupported format")
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
 	}
 
+	reader := f.NewReader()
 	if err := reader.Open(path); err != nil {
-		return fmt.Errorf("opening %s: %s", path, err)
+		return nil, fmt.Errorf("opening %s: %s", path, err)
+	}
+	return reader, nil
+}
+
+// Walk calls fn for every entry of the archive at path, fn may read the contents of the entry from r
+func Walk(path string, fn func(entry *Entry, r io.Reader) error) (err error) {
+	reader, err := Open("", path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing %s: %s", path, closeErr)
+		}
+	}()
+
+	for {
+		entry, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %s", path, err)
+		}
+
+		if err := fn(entry, reader); err != nil {
+			return err
+		}
+	}
+}
+
+// List returns the entries of the archive at path
+func List(path string) ([]Entry, error) {
+	var entries []Entry
+
+	err := Walk(path, func(entry *Entry, _ io.Reader) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return entries, nil
+}
 
-	if err := reader.Read(os.Stdout); err != nil {
-		return fmt.Errorf("reading %s: %s", path, err)
+// ReadArchive prints the names and the sizes of the entries of the archive at path, the empty format is detected
+func ReadArchive(format string, path string) (err error) {
+	reader, err := Open(format, path)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing %s: %s", path, closeErr)
+		}
+	}()
+
+	fmt.Fprintf(os.Stdout, tableHeaderFormat, width, "Name", width, "Size")
+
+	for {
+		entry, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %s", path, err)
+		}
 
-	if err := reader.Close(); err != nil {
-		return fmt.Errorf("closing %s: %s", path, err)
+		fmt.Fprintf(os.Stdout, tableRowFormat, width, entry.Name, width, entry.Size)
 	}
+}
 
-	return nil
+// cleanName returns the entry name as a clean relative slash-separated path, or an error if it leaves the archive
+func cleanName(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(name, "./"))
+	if cleaned == "." {
+		return cleaned, nil
+	}
+	if !fs.ValidPath(cleaned) || strings.Contains(cleaned, `\`) {
+		return "", fmt.Errorf("entry %q: %w", name, ErrInsecurePath)
+	}
+	return cleaned, nil
 }
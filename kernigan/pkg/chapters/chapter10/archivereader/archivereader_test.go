@@ -0,0 +1,283 @@
+package archivereader_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"golang/pkg/chapters/chapter10/archivereader"
+	_ "golang/pkg/chapters/chapter10/archivereader/tarreader"
+	_ "golang/pkg/chapters/chapter10/archivereader/zipreader"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeTree creates the test directory and returns its path
+func writeTree(t *testing.T) string {
+	t.Helper()
+
+	src := t.TempDir()
+	files := map[string]string{
+		"README":         "archive toolkit\n",
+		"docs/guide.txt": strings.Repeat("guide ", 100),
+		"docs/empty.txt": "",
+	}
+	for name, contents := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("docs/guide.txt", filepath.Join(src, "guide")); err != nil {
+		t.Fatal(err)
+	}
+
+	return src
+}
+
+func TestCreateListExtract(t *testing.T) {
+	src := writeTree(t)
+
+	for _, format := range []string{"tar", "tar.gz", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			// The extension is misleading on purpose, the format is detected from the contents
+			archive := filepath.Join(t.TempDir(), "archive.bin")
+			if err := archivereader.Create(format, archive, src); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			if got, err := archivereader.Detect(archive); err != nil || got != format {
+				t.Errorf("Detect = %q, %v, want %q", got, err, format)
+			}
+
+			entries, err := archivereader.List(archive)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name)
+			}
+			want := []string{"README", "docs/", "docs/empty.txt", "docs/guide.txt", "guide"}
+			if !reflect.DeepEqual(names, want) {
+				t.Errorf("List = %q, want %q", names, want)
+			}
+
+			dst := t.TempDir()
+			if err := archivereader.Extract(archive, dst, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			for _, name := range []string{"README", "docs/guide.txt", "docs/empty.txt", "guide"} {
+				got, err := os.ReadFile(filepath.Join(dst, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				orig, _ := os.ReadFile(filepath.Join(src, name))
+				if string(got) != string(orig) {
+					t.Errorf("%s = %q, want %q", name, got, orig)
+				}
+			}
+			if target, err := os.Readlink(filepath.Join(dst, "guide")); err != nil || target != "docs/guide.txt" {
+				t.Errorf("guide links to %q, %v", target, err)
+			}
+		})
+	}
+}
+
+func TestFS(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := archivereader.Create("tar.gz", archive, writeTree(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := archivereader.FS(archive, nil)
+	if err != nil {
+		t.Fatalf("FS: %v", err)
+	}
+
+	var walked []string
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	if want := []string{".", "README", "docs", "docs/empty.txt", "docs/guide.txt", "guide"}; !reflect.DeepEqual(walked, want) {
+		t.Errorf("WalkDir = %q, want %q", walked, want)
+	}
+
+	data, err := fs.ReadFile(fsys, "README")
+	if err != nil || string(data) != "archive toolkit\n" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	if _, err := fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(missing) = %v, want ErrNotExist", err)
+	}
+}
+
+func TestFSLimits(t *testing.T) {
+	archive := writeZip(t, map[string]string{"big": strings.Repeat("x", 1000), "small": "x"})
+
+	// The memory bounds the files kept by FS, Extract isn't bound by it
+	limits := archivereader.Limits{MaxMemory: 1000}
+	if _, err := archivereader.FS(archive, &limits); !errors.Is(err, archivereader.ErrLimitExceeded) {
+		t.Errorf("FS with %+v = %v", limits, err)
+	}
+	if err := archivereader.Extract(archive, t.TempDir(), &limits); err != nil {
+		t.Errorf("Extract with %+v = %v", limits, err)
+	}
+	limits.MaxMemory = 1001
+	if _, err := archivereader.FS(archive, &limits); err != nil {
+		t.Errorf("FS with %+v = %v", limits, err)
+	}
+}
+
+func TestFSFileInTheWay(t *testing.T) {
+	for name, headers := range map[string][]*tar.Header{
+		"file under a file":          {{Name: "a", Size: 1}, {Name: "a/b", Size: 1}},
+		"directory in place of file": {{Name: "a", Size: 1}, {Name: "a/", Typeflag: tar.TypeDir}},
+		"file in place of directory": {{Name: "a/", Typeflag: tar.TypeDir}, {Name: "a", Size: 1}},
+	} {
+		archive := filepath.Join(t.TempDir(), "conflict.tar")
+		file, err := os.Create(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := tar.NewWriter(file)
+		for _, h := range headers {
+			h.Mode = 0o644
+			w.WriteHeader(h)
+			if h.Size > 0 {
+				w.Write([]byte("x"))
+			}
+		}
+		w.Close()
+		file.Close()
+
+		if _, err := archivereader.FS(archive, nil); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("%s: FS = %v, want ErrExist", name, err)
+		}
+	}
+}
+
+// writeZip creates a zip archive with the given entries bypassing Create, so the names aren't checked
+func writeZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	archive := filepath.Join(t.TempDir(), "evil.zip")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(file)
+	for name, contents := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(contents))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	return archive
+}
+
+func TestExtractZipSlip(t *testing.T) {
+	for _, name := range []string{"../evil", "a/../../evil", "/etc/evil"} {
+		archive := writeZip(t, map[string]string{name: "pwned"})
+
+		dst := filepath.Join(t.TempDir(), "dst")
+		err := archivereader.Extract(archive, dst, nil)
+		if !errors.Is(err, archivereader.ErrInsecurePath) {
+			t.Errorf("Extract(%q) = %v, want ErrInsecurePath", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "evil")); err == nil {
+			t.Errorf("Extract(%q) wrote outside the destination", name)
+		}
+	}
+}
+
+func TestExtractSymlinkEscape(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "links.tar")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(file)
+	w.WriteHeader(&tar.Header{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0o777})
+	w.WriteHeader(&tar.Header{Name: "up/evil", Typeflag: tar.TypeReg, Size: 5, Mode: 0o644})
+	w.Write([]byte("pwned"))
+	w.Close()
+	file.Close()
+
+	err = archivereader.Extract(archive, t.TempDir(), nil)
+	if !errors.Is(err, archivereader.ErrInsecurePath) {
+		t.Errorf("Extract = %v, want ErrInsecurePath", err)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	archive := writeZip(t, map[string]string{"big": strings.Repeat("x", 1000), "small": "x"})
+
+	tests := []struct {
+		limits archivereader.Limits
+		ok     bool
+	}{
+		{archivereader.Limits{MaxFileSize: 1000}, true},
+		{archivereader.Limits{MaxFileSize: 999}, false},
+		{archivereader.Limits{MaxTotalSize: 1000}, false},
+		{archivereader.Limits{MaxEntries: 1}, false},
+		{archivereader.Limits{}, true},
+	}
+
+	for _, tt := range tests {
+		err := archivereader.Extract(archive, t.TempDir(), &tt.limits)
+		if tt.ok && err != nil || !tt.ok && !errors.Is(err, archivereader.ErrLimitExceeded) {
+			t.Errorf("Extract with %+v = %v", tt.limits, err)
+		}
+	}
+}
+
+func TestCreateUnsupported(t *testing.T) {
+	err := archivereader.Create("tar.bz2", filepath.Join(t.TempDir(), "a.tbz"), t.TempDir())
+	if !errors.Is(err, archivereader.ErrCreateUnsupported) {
+		t.Errorf("Create(tar.bz2) = %v, want ErrCreateUnsupported", err)
+	}
+
+	if _, err := archivereader.Open("", os.Args[0]); !errors.Is(err, archivereader.ErrUnknownFormat) {
+		t.Errorf("Open(binary) = %v, want ErrUnknownFormat", err)
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "archive.zip")
+	if err := archivereader.Create("zip", archive, writeTree(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if entries, err := archivereader.List(archive); err != nil || len(entries) != 5 {
+				t.Errorf("List = %d entries, %v", len(entries), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
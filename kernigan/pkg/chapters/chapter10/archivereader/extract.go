@@ -0,0 +1,293 @@
+package archivereader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInsecurePath is returned for an entry that would be written outside the destination directory
+	ErrInsecurePath = errors.New("insecure path")
+	// ErrLimitExceeded is returned when an archive exceeds the Limits
+	ErrLimitExceeded = errors.New("archive limit exceeded")
+	// ErrCreateUnsupported is returned by Create for the read-only formats
+	ErrCreateUnsupported = errors.New("format doesn't support creation")
+)
+
+// Limits protect Extract and FS against the decompression bombs, the zero field means no limit
+type Limits struct {
+	// The size of a single file
+	MaxFileSize int64
+	// The total size of all the files
+	MaxTotalSize int64
+	// The number of entries
+	MaxEntries int
+	// The total size of the files FS keeps in memory, it bounds FS on top of MaxTotalSize
+	MaxMemory int64
+}
+
+// DefaultLimits are used when nil limits are passed
+var DefaultLimits = Limits{
+	MaxFileSize:  1 << 30,
+	MaxTotalSize: 4 << 30,
+	MaxEntries:   100000,
+	MaxMemory:    256 << 20,
+}
+
+// budget counts the bytes and the entries against the limits
+type budget struct {
+	limits  Limits
+	total   int64
+	entries int
+}
+
+func newBudget(limits *Limits) *budget {
+	if limits == nil {
+		limits = &DefaultLimits
+	}
+	return &budget{limits: *limits}
+}
+
+func (b *budget) addEntry() error {
+	b.entries++
+	if b.limits.MaxEntries > 0 && b.entries > b.limits.MaxEntries {
+		return fmt.Errorf("more than %d entries: %w", b.limits.MaxEntries, ErrLimitExceeded)
+	}
+	return nil
+}
+
+/*
+copy copies the contents of an entry counting the bytes actually read, since the sizes in the headers can't be
+trusted.
+*/
+func (b *budget) copy(dst io.Writer, src io.Reader, name string) error {
+	limit := int64(-1)
+	if b.limits.MaxFileSize > 0 {
+		limit = b.limits.MaxFileSize
+	}
+	if rest := b.limits.MaxTotalSize - b.total; b.limits.MaxTotalSize > 0 && (limit < 0 || rest < limit) {
+		limit = rest
+	}
+
+	if limit < 0 {
+		n, err := io.Copy(dst, src)
+		b.total += n
+		return err
+	}
+
+	n, err := io.Copy(dst, io.LimitReader(src, limit+1))
+	b.total += n
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return fmt.Errorf("entry %q: %w", name, ErrLimitExceeded)
+	}
+	return nil
+}
+
+/*
+Extract writes the entries of the archive into the directory dst, creating it if necessary. The limits
+may be nil to use DefaultLimits.
+
+The entries whose names leave dst, e.g. "../../etc/passwd" or "/etc/passwd", fail with ErrInsecurePath, and so
+do the symbolic links pointing outside dst and the entries that would be written through a symbolic link. Nothing is
+written for the insecure entry, but the entries extracted before it are kept.
+*/
+func Extract(archive, dst string, limits *Limits) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+
+	b := newBudget(limits)
+
+	return Walk(archive, func(entry *Entry, r io.Reader) error {
+		if err := b.addEntry(); err != nil {
+			return err
+		}
+
+		name, err := cleanName(entry.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		target := filepath.Join(dst, filepath.FromSlash(name))
+		if err := checkParents(dst, name); err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, dirMode(entry.Mode))
+		case entry.Mode&fs.ModeSymlink != 0:
+			return extractSymlink(target, name, entry.Linkname)
+		case !entry.Mode.IsRegular():
+			// Devices, pipes and the other special files are skipped
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return extractFile(b, target, entry, r)
+	})
+}
+
+// checkParents returns an error if an existing parent directory of the entry is a symbolic link
+func checkParents(dst, name string) error {
+	dir := dst
+	for _, elem := range strings.Split(path.Dir(name), "/") {
+		if elem == "." {
+			break
+		}
+
+		dir = filepath.Join(dir, elem)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("entry %q is written through a symbolic link: %w", name, ErrInsecurePath)
+		}
+	}
+	return nil
+}
+
+func extractSymlink(target, name, linkname string) error {
+	if path.IsAbs(linkname) || filepath.IsAbs(linkname) {
+		return fmt.Errorf("link %q to %q: %w", name, linkname, ErrInsecurePath)
+	}
+	if _, err := cleanName(path.Join(path.Dir(name), linkname)); err != nil {
+		return fmt.Errorf("link %q to %q: %w", name, linkname, ErrInsecurePath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(linkname), target)
+}
+
+func extractFile(b *budget, target string, entry *Entry, r io.Reader) (err error) {
+	mode := entry.Mode.Perm()
+	if mode == 0 {
+		mode = 0o644
+	}
+
+	// O_EXCL refuses to follow a symbolic link left in place of the file by an earlier entry
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	if err := b.copy(file, r, entry.Name); err != nil {
+		return err
+	}
+
+	if !entry.ModTime.IsZero() {
+		return os.Chtimes(target, entry.ModTime, entry.ModTime)
+	}
+	return nil
+}
+
+func dirMode(mode fs.FileMode) fs.FileMode {
+	if mode.Perm() == 0 {
+		return 0o755
+	}
+	return mode.Perm() | 0o700
+}
+
+/*
+Create writes the contents of the directory src into a new archive of the format. The names of the entries are
+the paths relative to src. The symbolic links are stored as links, the special files are skipped.
+*/
+func Create(format, archive, src string) (err error) {
+	f, ok := lookupFormat(format)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+	if f.NewWriter == nil {
+		return fmt.Errorf("%s: %w", format, ErrCreateUnsupported)
+	}
+
+	writer := f.NewWriter()
+	if err := writer.Create(archive); err != nil {
+		return fmt.Errorf("creating %s: %s", archive, err)
+	}
+	defer func() {
+		if closeErr := writer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing %s: %s", archive, closeErr)
+		}
+	}()
+
+	return filepath.WalkDir(src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := &Entry{
+			Name:    filepath.ToSlash(rel),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+
+		switch {
+		case d.IsDir():
+			entry.Name += "/"
+			return writer.WriteEntry(entry, nil)
+		case info.Mode()&fs.ModeSymlink != 0:
+			if entry.Linkname, err = os.Readlink(name); err != nil {
+				return err
+			}
+			entry.Linkname = filepath.ToSlash(entry.Linkname)
+			return writer.WriteEntry(entry, nil)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		entry.Size = info.Size()
+		return writeFile(writer, entry, name)
+	})
+}
+
+func writeFile(writer ArchiveWriter, entry *Entry, name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return writer.WriteEntry(entry, file)
+}
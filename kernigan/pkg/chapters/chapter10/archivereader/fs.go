@@ -0,0 +1,241 @@
+package archivereader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// memNode is a file or a directory of an archive loaded into memory
+type memNode struct {
+	entry    Entry
+	data     []byte
+	children map[string]*memNode
+}
+
+func (n *memNode) Name() string               { return path.Base(n.entry.Name) }
+func (n *memNode) Size() int64                { return int64(len(n.data)) }
+func (n *memNode) Mode() fs.FileMode          { return n.entry.Mode }
+func (n *memNode) ModTime() time.Time         { return n.entry.ModTime }
+func (n *memNode) IsDir() bool                { return n.entry.Mode.IsDir() }
+func (n *memNode) Sys() any                   { return nil }
+func (n *memNode) Type() fs.FileMode          { return n.entry.Mode.Type() }
+func (n *memNode) Info() (fs.FileInfo, error) { return n, nil }
+
+// archiveFS is a read-only view of an archive, it implements fs.FS, fs.ReadDirFS, fs.ReadFileFS and fs.StatFS
+type archiveFS struct {
+	root *memNode
+}
+
+/*
+FS loads the archive into memory and returns it as a file system, so it can be walked with fs.WalkDir or served
+with http.FS. The limits may be nil to use DefaultLimits, the archive must fit into them, and its files into
+MaxMemory.
+
+The names are cleaned, the entries leaving the archive root fail with ErrInsecurePath. The directories missing in
+the archive are created implicitly. The symbolic links are kept as links and aren't followed. The entry whose path
+goes through a file, or a file in place of a directory, fails with fs.ErrExist.
+*/
+func FS(archive string, limits *Limits) (fs.FS, error) {
+	if limits == nil {
+		limits = &DefaultLimits
+	}
+	inMemory := *limits
+	if m := inMemory.MaxMemory; m > 0 && (inMemory.MaxTotalSize <= 0 || m < inMemory.MaxTotalSize) {
+		inMemory.MaxTotalSize = m
+	}
+
+	var (
+		b    = newBudget(&inMemory)
+		root = newDir(".")
+	)
+
+	err := Walk(archive, func(entry *Entry, r io.Reader) error {
+		if err := b.addEntry(); err != nil {
+			return err
+		}
+
+		name, err := cleanName(entry.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		if entry.IsDir() {
+			dir, err := root.mkdirAll(name)
+			if err != nil {
+				return fmt.Errorf("entry %q: %w", entry.Name, err)
+			}
+			dir.entry.Mode = fs.ModeDir | entry.Mode.Perm()
+			dir.entry.ModTime = entry.ModTime
+			return nil
+		}
+
+		parent, err := root.mkdirAll(path.Dir(name))
+		if err != nil {
+			return fmt.Errorf("entry %q: %w", entry.Name, err)
+		}
+		if old, ok := parent.children[path.Base(name)]; ok && old.IsDir() {
+			return fmt.Errorf("entry %q: %s is a directory: %w", entry.Name, name, fs.ErrExist)
+		}
+
+		var buf bytes.Buffer
+		if entry.Mode.IsRegular() {
+			if err := b.copy(&buf, r, entry.Name); err != nil {
+				return err
+			}
+		}
+
+		node := &memNode{entry: *entry, data: buf.Bytes()}
+		node.entry.Name = name
+		parent.children[path.Base(name)] = node
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &archiveFS{root: root}, nil
+}
+
+func newDir(name string) *memNode {
+	return &memNode{
+		entry:    Entry{Name: name, Mode: fs.ModeDir | 0o755},
+		children: make(map[string]*memNode),
+	}
+}
+
+// mkdirAll returns the directory at the slash-separated path relative to n, creating the missing ones. A file in the
+// way fails it with fs.ErrExist
+func (n *memNode) mkdirAll(name string) (*memNode, error) {
+	if name == "." {
+		return n, nil
+	}
+
+	dir := n
+	for _, elem := range strings.Split(name, "/") {
+		child, ok := dir.children[elem]
+		switch {
+		case !ok:
+			child = newDir(path.Join(dir.entry.Name, elem))
+			dir.children[elem] = child
+		case !child.IsDir():
+			return nil, fmt.Errorf("%s is not a directory: %w", child.entry.Name, fs.ErrExist)
+		}
+		dir = child
+	}
+	return dir, nil
+}
+
+func (afs *archiveFS) lookup(op, name string) (*memNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node := afs.root
+	if name == "." {
+		return node, nil
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		child, ok := node.children[elem]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+func (afs *archiveFS) Open(name string) (fs.File, error) {
+	node, err := afs.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.IsDir() {
+		return &memDir{node: node, entries: node.sortedChildren()}, nil
+	}
+	return &memFile{node: node, Reader: bytes.NewReader(node.data)}, nil
+}
+
+func (afs *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	return afs.lookup("stat", name)
+}
+
+func (afs *archiveFS) ReadFile(name string) ([]byte, error) {
+	node, err := afs.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return bytes.Clone(node.data), nil
+}
+
+func (afs *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := afs.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.sortedChildren(), nil
+}
+
+func (n *memNode) sortedChildren() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(n.children))
+	for _, child := range n.children {
+		entries = append(entries, child)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+type memFile struct {
+	node *memNode
+	*bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.node, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memDir struct {
+	node    *memNode
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.node, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.entry.Name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	count = min(count, len(d.entries))
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
@@ -2,19 +2,57 @@ package tarreader
 
 import (
 	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
 	"errors"
-	"fmt"
 	"golang/pkg/chapters/chapter10/archivereader"
 	"io"
+	"io/fs"
 	"os"
 )
 
+// The names of the formats and the magic bytes they are detected with
+const (
+	tarFormat    = "tar"
+	tarGzFormat  = "tar.gz"
+	tarBz2Format = "tar.bz2"
+
+	tarMagic       = "ustar"
+	tarMagicOffset = 257
+	gzipMagic      = "\x1f\x8b"
+	bzip2Magic     = "BZh"
+)
+
 func init() {
-	archivereader.Register("tar", NewTarReader)
+	archivereader.RegisterFormat(archivereader.Format{
+		Name:      tarFormat,
+		Magic:     tarMagic,
+		Offset:    tarMagicOffset,
+		NewReader: NewTarReader,
+		NewWriter: NewTarWriter,
+	})
+	archivereader.RegisterFormat(archivereader.Format{
+		Name:      tarGzFormat,
+		Magic:     gzipMagic,
+		NewReader: NewTarGzReader,
+		NewWriter: NewTarGzWriter,
+	})
+	// The standard library can't compress with bzip2, so the format is read-only
+	archivereader.RegisterFormat(archivereader.Format{
+		Name:      tarBz2Format,
+		Magic:     bzip2Magic,
+		NewReader: NewTarBz2Reader,
+	})
 }
 
+// decompressor wraps the file with the decompression reader of the format
+type decompressor func(file io.Reader) (io.Reader, error)
+
 type TarReader struct {
-	file   *os.File
+	file       *os.File
+	decompress decompressor
+	// The decompression reader that has to be closed, if any
+	closer io.Closer
 	reader *tar.Reader
 }
 
@@ -23,39 +61,74 @@ func (tr *TarReader) Open(path string) error {
 	if err != nil {
 		return err
 	}
-	tr.file = file
 
-	tr.reader = tar.NewReader(file)
+	var r io.Reader = file
+	if tr.decompress != nil {
+		if r, err = tr.decompress(file); err != nil {
+			file.Close()
+			return err
+		}
+		if closer, ok := r.(io.Closer); ok {
+			tr.closer = closer
+		}
+	}
+
+	tr.file = file
+	tr.reader = tar.NewReader(r)
 	return nil
 }
 
-func (tr *TarReader) Read(writer io.Writer) error {
-	const (
-		tableHeaderFormat = "%-*s %-*s\n"
-		tableRowFormat    = "%-*s %-*d\n"
-		width             = 40
-	)
+func (tr *TarReader) Next() (*archivereader.Entry, error) {
+	header, err := tr.reader.Next()
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Fprintf(writer, tableHeaderFormat, width, "Name", width, "Size")
+	entry := &archivereader.Entry{
+		Name:     header.Name,
+		Size:     header.Size,
+		Mode:     header.FileInfo().Mode(),
+		ModTime:  header.ModTime,
+		Linkname: header.Linkname,
+	}
 
-	for {
-		header, err := tr.reader.Next()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil
-			} else {
-				return err
-			}
-		}
-		fmt.Fprintf(writer, tableRowFormat, width, header.Name, width, header.Size)
+	// The hard links have no contents of their own, they are reported as irregular files
+	if header.Typeflag == tar.TypeLink {
+		entry.Mode = fs.ModeIrregular | entry.Mode.Perm()
 	}
+
+	return entry, nil
+}
+
+func (tr *TarReader) Read(p []byte) (int, error) {
+	return tr.reader.Read(p)
 }
 
 func (tr *TarReader) Close() error {
-	return tr.file.Close()
+	var err error
+	if tr.closer != nil {
+		err = tr.closer.Close()
+	}
+	return errors.Join(err, tr.file.Close())
 }
 
 func NewTarReader() archivereader.ArchiveReader {
 	tarReader := &TarReader{}
 	return tarReader
 }
+
+func NewTarGzReader() archivereader.ArchiveReader {
+	return &TarReader{
+		decompress: func(file io.Reader) (io.Reader, error) {
+			return gzip.NewReader(file)
+		},
+	}
+}
+
+func NewTarBz2Reader() archivereader.ArchiveReader {
+	return &TarReader{
+		decompress: func(file io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(file), nil
+		},
+	}
+}
@@ -0,0 +1,90 @@
+package tarreader
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"golang/pkg/chapters/chapter10/archivereader"
+	"io"
+	"io/fs"
+	"os"
+)
+
+type TarWriter struct {
+	file *os.File
+	// The compression writer between the tar writer and the file, if any
+	compressor io.WriteCloser
+	compress   func(file io.Writer) io.WriteCloser
+	writer     *tar.Writer
+}
+
+func (tw *TarWriter) Create(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	tw.file = file
+
+	var w io.Writer = file
+	if tw.compress != nil {
+		tw.compressor = tw.compress(file)
+		w = tw.compressor
+	}
+
+	tw.writer = tar.NewWriter(w)
+	return nil
+}
+
+func (tw *TarWriter) WriteEntry(entry *archivereader.Entry, r io.Reader) error {
+	header := &tar.Header{
+		Name:     entry.Name,
+		Size:     entry.Size,
+		Mode:     int64(entry.Mode.Perm()),
+		ModTime:  entry.ModTime,
+		Linkname: entry.Linkname,
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatPAX,
+	}
+
+	switch {
+	case entry.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Size = 0
+	case entry.Mode&fs.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+		header.Size = 0
+	}
+
+	if err := tw.writer.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if r == nil || header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	// The file may have changed since it has been stated, the header size wins
+	_, err := io.CopyN(tw.writer, r, header.Size)
+	return err
+}
+
+// Close flushes the tar writer and the compression writer and closes the file, all the errors are reported
+func (tw *TarWriter) Close() error {
+	err := tw.writer.Close()
+	if tw.compressor != nil {
+		err = errors.Join(err, tw.compressor.Close())
+	}
+	return errors.Join(err, tw.file.Close())
+}
+
+func NewTarWriter() archivereader.ArchiveWriter {
+	return &TarWriter{}
+}
+
+func NewTarGzWriter() archivereader.ArchiveWriter {
+	return &TarWriter{
+		compress: func(file io.Writer) io.WriteCloser {
+			return gzip.NewWriter(file)
+		},
+	}
+}
@@ -2,17 +2,36 @@ package zipreader
 
 import (
 	"archive/zip"
-	"fmt"
+	"errors"
 	"golang/pkg/chapters/chapter10/archivereader"
 	"io"
+	"io/fs"
+)
+
+const (
+	zipFormat = "zip"
+	zipMagic  = "PK\x03\x04"
+
+	// The longest symbolic link target read from an entry
+	maxLinkLen = 4096
 )
 
 func init() {
-	archivereader.Register("zip", NewZipReader)
+	archivereader.RegisterFormat(archivereader.Format{
+		Name:      zipFormat,
+		Magic:     zipMagic,
+		NewReader: NewZipReader,
+		NewWriter: NewZipWriter,
+	})
 }
 
 type ZipReader struct {
 	reader *zip.ReadCloser
+	// The index of the next file
+	next int
+	// The current file and its contents, which are opened on the first Read
+	file    *zip.File
+	current io.ReadCloser
 }
 
 func (zr *ZipReader) Open(path string) error {
@@ -25,40 +44,70 @@ func (zr *ZipReader) Open(path string) error {
 	return nil
 }
 
-func (zr *ZipReader) Read(writer io.Writer) error {
-	const (
-		tableHeaderFormat = "%-*s %-*s\n"
-		tableRowFormat    = "%-*s %-*d\n"
-		width             = 40
-	)
+func (zr *ZipReader) Next() (*archivereader.Entry, error) {
+	if err := zr.closeCurrent(); err != nil {
+		return nil, err
+	}
 
-	fmt.Fprintf(writer, tableHeaderFormat, width, "Name", width, "Size")
+	if zr.next == len(zr.reader.File) {
+		return nil, io.EOF
+	}
+
+	file := zr.reader.File[zr.next]
+	zr.next++
 
-	for _, file := range zr.reader.File {
-		fmt.Fprintf(writer, tableRowFormat, width, file.Name, width, file.UncompressedSize64)
+	entry := &archivereader.Entry{
+		Name:    file.Name,
+		Size:    int64(file.UncompressedSize64),
+		Mode:    file.Mode(),
+		ModTime: file.Modified,
 	}
-	return nil
+
+	zr.file = file
+
+	if entry.Mode&fs.ModeSymlink != 0 {
+		target, err := io.ReadAll(io.LimitReader(zr, maxLinkLen))
+		if err != nil {
+			return nil, err
+		}
+		entry.Linkname = string(target)
+	}
+
+	return entry, nil
+}
+
+// Read decompresses the current file, the files are opened lazily, so listing an archive doesn't decompress it
+func (zr *ZipReader) Read(p []byte) (int, error) {
+	if zr.file == nil || zr.file.FileInfo().IsDir() {
+		return 0, io.EOF
+	}
+
+	if zr.current == nil {
+		current, err := zr.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		zr.current = current
+	}
+	return zr.current.Read(p)
+}
+
+func (zr *ZipReader) closeCurrent() error {
+	if zr.current == nil {
+		zr.file = nil
+		return nil
+	}
+
+	err := zr.current.Close()
+	zr.file, zr.current = nil, nil
+	return err
 }
 
 func (zr *ZipReader) Close() error {
-	return zr.reader.Close()
+	return errors.Join(zr.closeCurrent(), zr.reader.Close())
 }
 
 func NewZipReader() archivereader.ArchiveReader {
 	zipReader := &ZipReader{}
 	return zipReader
 }
-
-/*
-	for {
-		header, err := tr.reader.Next()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil
-			} else {
-				return err
-			}
-		}
-		fmt.Fprintf(writer, tableRowFormat, width, header.Name, width, header.Size)
-	}
-*/
@@ -0,0 +1,67 @@
+package zipreader
+
+import (
+	"archive/zip"
+	"errors"
+	"golang/pkg/chapters/chapter10/archivereader"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+type ZipWriter struct {
+	file   *os.File
+	writer *zip.Writer
+}
+
+func (zw *ZipWriter) Create(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	zw.file = file
+	zw.writer = zip.NewWriter(file)
+	return nil
+}
+
+func (zw *ZipWriter) WriteEntry(entry *archivereader.Entry, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Modified: entry.ModTime,
+		Method:   zip.Deflate,
+	}
+	header.SetMode(entry.Mode)
+
+	switch {
+	case entry.IsDir():
+		// The zip format tells the directories by the trailing slash
+		if !strings.HasSuffix(header.Name, "/") {
+			header.Name += "/"
+		}
+		header.Method = zip.Store
+	case entry.Mode&fs.ModeSymlink != 0:
+		// The target of a link is stored as its contents
+		r = strings.NewReader(entry.Linkname)
+	}
+
+	w, err := zw.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if r == nil || entry.IsDir() {
+		return nil
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (zw *ZipWriter) Close() error {
+	return errors.Join(zw.writer.Close(), zw.file.Close())
+}
+
+func NewZipWriter() archivereader.ArchiveWriter {
+	return &ZipWriter{}
+}
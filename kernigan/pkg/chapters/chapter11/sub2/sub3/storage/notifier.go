@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+)
+
+// Notifier delivers the quota warnings
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface
+type NotifierFunc func(ctx context.Context, n Notification) error
+
+func (f NotifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+/*
+SMTPNotifier mails the warnings to the users. The credentials are passed in by the caller, e.g. from the
+environment: never put passwords in source code.
+*/
+type SMTPNotifier struct {
+	// The host:port of the mail server
+	Addr string
+	From string
+	// nil for the servers that don't need authentication
+	Auth smtp.Auth
+	// Address returns the mail address of the user, the user names are the addresses if nil
+	Address func(user string) string
+}
+
+const subject = "Storage quota warning"
+
+// Notify sends the mail, smtp.SendMail doesn't take a context, so ctx is only checked before sending
+func (sn *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	to := n.User
+	if sn.Address != nil {
+		to = sn.Address(n.User)
+	}
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid mail address %q", to)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", sn.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "\r\n%s\r\n", n.Message())
+
+	if err := smtp.SendMail(sn.Addr, sn.Auth, sn.From, []string{to}, msg.Bytes()); err != nil {
+		return fmt.Errorf("smtp.SendMail(%s): %w", to, err)
+	}
+	return nil
+}
+
+// WebhookNotifier posts the notifications as JSON to a URL
+type WebhookNotifier struct {
+	URL string
+	// http.DefaultClient if nil
+	Client *http.Client
+}
+
+func (wn *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(struct {
+		Notification
+		Message string `json:"message"`
+	}{n, n.Message()})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := wn.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting to webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// LogNotifier writes the notifications to a log, it never fails
+type LogNotifier struct {
+	// log.Default() if nil
+	Logger *log.Logger
+}
+
+func (ln *LogNotifier) Notify(_ context.Context, n Notification) error {
+	logger := ln.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	logger.Printf("quota warning for %s: %d of %d bytes used (%d%%), threshold %d%%",
+		n.User, n.Used, n.Quota, n.Percent, n.Threshold)
+	return nil
+}
@@ -1,63 +1,335 @@
+/*
+Package storage keeps track of the storage used by the users and warns them when they approach their quotas.
+
+The QuotaService compares the usage with the quota of a user and sends a Notification through every Notifier when
+the usage crosses a threshold, e.g. 80% or 95%. A user is notified once per threshold: the next notification is sent
+when the usage crosses a higher threshold, or the same one again after having fallen below it.
+*/
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"net/smtp"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 )
 
-// Email sender configuration.
-// NOTE: never put passwords in source code
 const (
-	sender   = "notifications@example.com"
-	password = "correcthorsebatterystaple"
-	hostname = "smtp.example.com"
+	hundredPercents = 100
+
+	// DefaultQuota is used for the users without a quota of their own
+	DefaultQuota = 10e9
+	// DefaultThreshold is used if no thresholds are configured
+	DefaultThreshold = 90
 
 	template = `Warning: you're using %d bytes of storage,
 	%d%% of your quota`
 )
 
-var (
-	usage = make(map[string]int64)
+// Notification describes a crossed threshold
+type Notification struct {
+	User  string `json:"user"`
+	Used  int64  `json:"used"`
+	Quota int64  `json:"quota"`
+	// The usage in percents of the quota
+	Percent int64 `json:"percent"`
+	// The highest threshold the usage has reached
+	Threshold int `json:"threshold"`
+}
+
+// Message returns the text of the warning sent to the user
+func (n Notification) Message() string {
+	return fmt.Sprintf(template, n.Used, n.Percent)
+}
 
-	// default realization, that will be replaced in test
-	notifyUser = func(username, msg string) {
-		auth := smtp.PlainAuth("", sender, password, hostname)
-		err := smtp.SendMail(hostname+"587", auth, sender, []string{username}, []byte(msg))
-		if err != nil {
-			log.Printf("smtp.SendMail(%s) failed: %s", username, err)
+// Config configures a QuotaService, the zero fields get the defaults
+type Config struct {
+	// The quota of the users missing in Quotas, DefaultQuota if zero
+	DefaultQuota int64
+	// The quotas of the users, the non-positive ones stand for DefaultQuota
+	Quotas map[string]int64
+	// The thresholds in percents of the quota, {DefaultThreshold} if empty
+	Thresholds []int
+	// The usage accounting, a MemoryStore if nil
+	Store     Store
+	Notifiers []Notifier
+}
+
+// QuotaService checks the usage of the users against their quotas, it's safe for concurrent use
+type QuotaService struct {
+	// mu guards the quotas, the users and the store, the notifiers are called without it
+	mu sync.Mutex
+	// The locks of the users being updated
+	users        map[string]*userLock
+	defaultQuota int64
+	quotas       map[string]int64
+	// Sorted in ascending order
+	thresholds []int
+	store      Store
+	notifiers  []Notifier
+}
+
+func NewQuotaService(cfg Config) *QuotaService {
+	s := &QuotaService{
+		users:        make(map[string]*userLock),
+		defaultQuota: cfg.DefaultQuota,
+		quotas:       make(map[string]int64, len(cfg.Quotas)),
+		thresholds:   append([]int(nil), cfg.Thresholds...),
+		store:        cfg.Store,
+		notifiers:    cfg.Notifiers,
+	}
+
+	if s.defaultQuota <= 0 {
+		s.defaultQuota = DefaultQuota
+	}
+	for user, quota := range cfg.Quotas {
+		// The non-positive quota is the default one, as in SetQuota
+		if quota > 0 {
+			s.quotas[user] = quota
 		}
+	}
+	if len(s.thresholds) == 0 {
+		s.thresholds = []int{DefaultThreshold}
+	}
+	sort.Ints(s.thresholds)
+	if s.store == nil {
+		s.store = NewMemoryStore()
+	}
 
+	return s
+}
+
+// SetQuota sets the quota of the user, the non-positive quota restores the default one
+func (s *QuotaService) SetQuota(user string, quota int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if quota <= 0 {
+		delete(s.quotas, user)
+		return
 	}
-)
+	s.quotas[user] = quota
+}
 
-func bytesInUse(username string) int64 {
-	return usage[username]
+// Quota returns the quota of the user
+func (s *QuotaService) Quota(user string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.quota(user)
 }
 
-func CheckQuota(username string) {
+func (s *QuotaService) quota(user string) int64 {
+	if quota, ok := s.quotas[user]; ok {
+		return quota
+	}
+	return s.defaultQuota
+}
 
-	const (
-		hundredPercents = 100
-		quota           = 10e9
+// Usage returns the bytes in use by the user
+func (s *QuotaService) Usage(user string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		quotaLimitInPercents = 90
-	)
+	record, err := s.store.Load(user)
+	return record.Used, err
+}
 
-	var (
-		percent int64
+// SetUsage records the bytes in use by the user and checks the quota
+func (s *QuotaService) SetUsage(ctx context.Context, user string, used int64) error {
+	return s.update(ctx, user, func(record *Record) {
+		record.Used = used
+	})
+}
+
+// AddUsage adds delta, which may be negative, to the bytes in use by the user and checks the quota
+func (s *QuotaService) AddUsage(ctx context.Context, user string, delta int64) error {
+	return s.update(ctx, user, func(record *Record) {
+		record.Used = max(record.Used+delta, 0)
+	})
+}
+
+// Check checks the quota of the user, it's needed only after the quota or the thresholds have changed
+func (s *QuotaService) Check(ctx context.Context, user string) error {
+	return s.update(ctx, user, func(*Record) {})
+}
+
+// userLock serializes the updates of a user, refs counts the updates holding or waiting for it
+type userLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockUser locks the updates of the user and returns the unlocking function
+func (s *QuotaService) lockUser(user string) (unlock func()) {
+	s.mu.Lock()
+	l := s.users[user]
+	if l == nil {
+		l = &userLock{}
+		s.users[user] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(s.users, user)
+		}
+	}
+}
+
+/*
+update changes the record of the user and checks the quota:
 
-		msg string
+1) Find the highest threshold the usage has reached, 0 if none
+2) If it's above the one the user has been notified of, notify the user
+3) Save the reached threshold if the user has been notified or the usage has fallen below the saved one
+
+The notifiers are called under the lock of the user only, so the concurrent updates of a user don't send the same
+notification twice, and a slow notifier doesn't hold up the other users.
+*/
+func (s *QuotaService) update(ctx context.Context, user string, change func(record *Record)) error {
+	unlock := s.lockUser(user)
+	defer unlock()
+
+	s.mu.Lock()
+	record, err := s.store.Load(user)
+	quota := s.quota(user)
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("loading usage of %s: %w", user, err)
+	}
+	change(&record)
+
+	var (
+		percent   = hundredPercents * record.Used / quota
+		threshold = s.reached(percent)
+		notifyErr error
 	)
 
-	used := bytesInUse(username)
-	percent = hundredPercents * used / quota
+	if threshold > record.Threshold {
+		notifyErr = s.notify(ctx, Notification{
+			User:      user,
+			Used:      record.Used,
+			Quota:     quota,
+			Percent:   percent,
+			Threshold: threshold,
+		})
 
-	if percent < quotaLimitInPercents {
-		// OK
-		return
+		// The threshold is kept unchanged, so the notification is retried on the next update
+		if notifyErr == nil {
+			record.Threshold = threshold
+		}
+	} else {
+		record.Threshold = threshold
+	}
+
+	s.mu.Lock()
+	err = s.store.Save(user, record)
+	s.mu.Unlock()
+	if err != nil {
+		return errors.Join(notifyErr, fmt.Errorf("saving usage of %s: %w", user, err))
+	}
+	return notifyErr
+}
+
+// Returns the highest threshold not above percent, or 0
+func (s *QuotaService) reached(percent int64) int {
+	threshold := 0
+	for _, t := range s.thresholds {
+		if int64(t) > percent {
+			break
+		}
+		threshold = t
+	}
+	return threshold
+}
+
+// notify sends the notification through all the notifiers, it fails only if none of them succeeds
+func (s *QuotaService) notify(ctx context.Context, n Notification) error {
+	if len(s.notifiers) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, notifier := range s.notifiers {
+		if err := notifier.Notify(ctx, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == len(s.notifiers) {
+		return fmt.Errorf("notifying %s: %w", n.User, errors.Join(errs...))
 	}
+	return nil
+}
+
+// Scan sets the usage of the user to the total size of the regular files in dir and checks the quota
+func (s *QuotaService) Scan(ctx context.Context, user, dir string) error {
+	used, err := DirSize(ctx, dir)
+	if err != nil {
+		return err
+	}
+	return s.SetUsage(ctx, user, used)
+}
+
+/*
+ScanAll treats every subdirectory of root as the home directory of the user with the same name, e.g. /home/joe,
+and scans them all. It scans as many directories as it can and returns all the errors.
+*/
+func (s *QuotaService) ScanAll(ctx context.Context, root string) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if err := s.Scan(ctx, entry.Name(), filepath.Join(root, entry.Name())); err != nil {
+			errs = append(errs, fmt.Errorf("scanning %s: %w", entry.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DirSize returns the total size of the regular files in the directory tree, the symbolic links aren't followed
+func DirSize(ctx context.Context, dir string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// The file has been removed during the scan
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
 
-	msg = fmt.Sprintf(template, used, percent)
-	notifyUser(username, msg)
+	return size, err
 }
@@ -1,49 +1,409 @@
 package storage
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 )
 
-func TestCheckQuouta(t *testing.T) {
+// mail is a message received by the fake SMTP server
+type mail struct {
+	from string
+	to   []string
+	data string
+}
+
+/*
+fakeSMTP is a local SMTP server speaking just enough of the protocol for smtp.SendMail: it doesn't advertise any
+extensions, so the client sends the mail in plain text without authentication.
+*/
+type fakeSMTP struct {
+	listener net.Listener
+	mu       sync.Mutex
+	mails    []mail
+	wg       sync.WaitGroup
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &fakeSMTP{listener: listener}
+	srv.wg.Add(1)
+	go srv.serve()
+
+	t.Cleanup(func() {
+		listener.Close()
+		srv.wg.Wait()
+	})
+	return srv
+}
+
+func (srv *fakeSMTP) addr() string {
+	return srv.listener.Addr().String()
+}
+
+func (srv *fakeSMTP) received() []mail {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return append([]mail(nil), srv.mails...)
+}
+
+func (srv *fakeSMTP) serve() {
+	defer srv.wg.Done()
+
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			srv.handle(conn)
+		}()
+	}
+}
+
+func (srv *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		r = bufio.NewReader(conn)
+		m mail
+	)
+
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost fake SMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			m.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			m.to = append(m.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			m.data = data.String()
+
+			srv.mu.Lock()
+			srv.mails = append(srv.mails, m)
+			srv.mu.Unlock()
+
+			m = mail{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
 	const (
 		user = "joe@example.org"
 
 		wantSubstring = "98% of your quota"
 	)
+
 	var (
-		notifiedUser string
-		notifiedMsg  string
+		srv = newFakeSMTP(t)
+		ctx = context.Background()
 
-		// Save the original function value of "notifyUser()" so that it'll be restored after test function has returned.
-		notifyUserOriginal = notifyUser
+		service = NewQuotaService(Config{
+			Notifiers: []Notifier{&SMTPNotifier{Addr: srv.addr(), From: "notifications@example.com"}},
+		})
 	)
 
-	defer func() {
-		notifyUser = notifyUserOriginal
+	// Simulate a 9.8GB-used condition
+	if err := service.SetUsage(ctx, user, 98e8); err != nil {
+		t.Fatalf("SetUsage: %v", err)
+	}
+
+	mails := srv.received()
+	if len(mails) != 1 {
+		t.Fatalf("%d mails sent, want 1", len(mails))
+	}
+
+	if got := mails[0].to; len(got) != 1 || got[0] != user {
+		t.Errorf("wrong user (%s) notified, want %s", got, user)
+	}
+	if !strings.Contains(mails[0].data, wantSubstring) {
+		t.Errorf("unexpected notification message <<%s>>, want substring %q", mails[0].data, wantSubstring)
+	}
+
+	// The same level isn't reported twice
+	if err := service.AddUsage(ctx, user, 1e6); err != nil {
+		t.Fatalf("AddUsage: %v", err)
+	}
+	if n := len(srv.received()); n != 1 {
+		t.Errorf("%d mails sent after the repeated check, want 1", n)
+	}
+}
+
+// recorder is a Notifier remembering the thresholds it has been notified of
+type recorder struct {
+	mu         sync.Mutex
+	thresholds []int
+	err        error
+}
+
+func (r *recorder) Notify(_ context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.thresholds = append(r.thresholds, n.Threshold)
+	return nil
+}
+
+func TestThresholds(t *testing.T) {
+	var (
+		rec     = &recorder{}
+		ctx     = context.Background()
+		service = NewQuotaService(Config{
+			DefaultQuota: 1000,
+			Quotas:       map[string]int64{"ann": 100},
+			Thresholds:   []int{95, 80},
+			Notifiers:    []Notifier{rec},
+		})
+	)
+
+	steps := []struct {
+		used int64
+		want []int
+	}{
+		{700, nil},
+		{800, []int{80}},
+		{900, []int{80}},
+		{960, []int{80, 95}},
+		{990, []int{80, 95}},
+		// The usage falls below both thresholds and crosses them again
+		{100, []int{80, 95}},
+		{850, []int{80, 95, 80}},
+	}
+
+	for _, step := range steps {
+		if err := service.SetUsage(ctx, "joe", step.used); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.thresholds; !slices.Equal(got, step.want) {
+			t.Errorf("after %d bytes notified of %v, want %v", step.used, got, step.want)
+		}
+	}
+
+	// The per-user quota
+	if err := service.SetUsage(ctx, "ann", 96); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.thresholds[len(rec.thresholds)-1]; got != 95 {
+		t.Errorf("ann notified of %d%%, want 95%%", got)
+	}
+}
+
+func TestNonPositiveQuotas(t *testing.T) {
+	var (
+		rec     = &recorder{}
+		ctx     = context.Background()
+		service = NewQuotaService(Config{
+			DefaultQuota: 100,
+			Quotas:       map[string]int64{"zero": 0, "negative": -5},
+			Notifiers:    []Notifier{rec},
+		})
+	)
+
+	// The non-positive quotas are the default one, not a division by zero
+	for _, user := range []string{"zero", "negative"} {
+		if quota := service.Quota(user); quota != 100 {
+			t.Errorf("Quota(%s) = %d, want 100", user, quota)
+		}
+		if err := service.SetUsage(ctx, user, 95); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(rec.thresholds, []int{90, 90}) {
+		t.Errorf("notified of %v, want [90 90]", rec.thresholds)
+	}
+}
+
+func TestNotificationRetried(t *testing.T) {
+	var (
+		rec     = &recorder{err: errors.New("mail server is down")}
+		ctx     = context.Background()
+		service = NewQuotaService(Config{DefaultQuota: 100, Notifiers: []Notifier{rec}})
+	)
+
+	if err := service.SetUsage(ctx, "joe", 95); err == nil {
+		t.Fatal("SetUsage succeeded with a failing notifier")
+	}
+
+	rec.err = nil
+	if err := service.Check(ctx, "joe"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rec.thresholds, []int{90}) {
+		t.Errorf("notified of %v, want [90]", rec.thresholds)
+	}
+}
+
+func TestSlowNotifier(t *testing.T) {
+	var (
+		notifying = make(chan struct{})
+		release   = make(chan struct{})
+		ctx       = context.Background()
+
+		// The notification of joe hangs until released
+		service = NewQuotaService(Config{DefaultQuota: 100, Notifiers: []Notifier{
+			NotifierFunc(func(_ context.Context, n Notification) error {
+				if n.User == "joe" {
+					close(notifying)
+					<-release
+				}
+				return nil
+			}),
+		}})
+
+		done = make(chan error)
+	)
+
+	go func() {
+		done <- service.SetUsage(ctx, "joe", 95)
 	}()
+	<-notifying
 
-	// Reinitialization of unexported package function variable
-	notifyUser = func(user, msg string) {
-		notifiedUser, notifiedMsg = user, msg
+	// The other users aren't held up
+	if err := service.AddUsage(ctx, "ann", 10); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := service.Usage("ann"); err != nil || used != 10 {
+		t.Errorf("Usage(ann) = %d, %v; want 10", used, err)
 	}
 
-	// Simulate a 980Mb-used condition
-	usage[user] = 98e8
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got map[string]any
 
-	// ops
-	CheckQuota(user)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
 
-	if notifiedUser == "" && notifiedMsg == "" {
-		t.Fatalf("notifyUser not called")
+	n := Notification{User: "joe", Used: 95, Quota: 100, Percent: 95, Threshold: 90}
+	if err := (&WebhookNotifier{URL: srv.URL}).Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify: %v", err)
 	}
 
-	if notifiedUser != user {
-		t.Errorf("wrong user (%s) notified, want %s", notifiedMsg, user)
+	if got["user"] != "joe" || got["threshold"] != 90.0 || !strings.Contains(got["message"].(string), "95%") {
+		t.Errorf("webhook got %v", got)
 	}
 
-	if !strings.Contains(notifiedMsg, wantSubstring) {
-		t.Errorf("unexpected notification message <<%s>>, want substring %q", notifiedMsg, wantSubstring)
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+	if err := (&WebhookNotifier{URL: failing.URL}).Notify(context.Background(), n); err == nil {
+		t.Error("Notify succeeded with a 404 response")
 	}
+}
+
+func TestScanAndFileStore(t *testing.T) {
+	var (
+		root = t.TempDir()
+		path = filepath.Join(t.TempDir(), "usage.json")
+		ctx  = context.Background()
+	)
 
+	for name, size := range map[string]int{"joe/a": 60, "joe/docs/b": 35, "ann/c": 10} {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{}
+	service := NewQuotaService(Config{DefaultQuota: 100, Store: store, Notifiers: []Notifier{rec}})
+	if err := service.ScanAll(ctx, root); err != nil {
+		t.Fatalf("ScanAll: %v", err)
+	}
+	if used, _ := service.Usage("joe"); used != 95 {
+		t.Errorf("joe uses %d bytes, want 95", used)
+	}
+	if !slices.Equal(rec.thresholds, []int{90}) {
+		t.Errorf("notified of %v, want [90]", rec.thresholds)
+	}
+
+	// The restarted service remembers the usage and the sent notification
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = &recorder{}
+	service = NewQuotaService(Config{DefaultQuota: 100, Store: store, Notifiers: []Notifier{rec}})
+	if err := service.Scan(ctx, "joe", filepath.Join(root, "joe")); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := service.Usage("ann"); used != 10 {
+		t.Errorf("ann uses %d bytes after restart, want 10", used)
+	}
+	if len(rec.thresholds) != 0 {
+		t.Errorf("notified again after restart: %v", rec.thresholds)
+	}
 }
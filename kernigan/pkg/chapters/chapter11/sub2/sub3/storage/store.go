@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Record is the usage accounting of a user
+type Record struct {
+	Used int64 `json:"used"`
+	// The highest threshold the user has been notified of
+	Threshold int `json:"threshold"`
+}
+
+// Store keeps the records of the users, Load returns the zero record for an unknown user
+type Store interface {
+	Load(user string) (Record, error)
+	Save(user string, record Record) error
+}
+
+// MemoryStore keeps the records in memory, it's safe for concurrent use
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (ms *MemoryStore) Load(user string) (Record, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.records[user], nil
+}
+
+func (ms *MemoryStore) Save(user string, record Record) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.records[user] = record
+	return nil
+}
+
+/*
+FileStore keeps the records in a JSON file, so the usage and the sent notifications survive restarts. Every Save
+rewrites the file through a temporary one, so a crash never leaves it half-written.
+*/
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]Record
+}
+
+// OpenFileStore loads the records from the file at path, the missing file is created on the first Save
+func OpenFileStore(path string) (*FileStore, error) {
+	fst := &FileStore{path: path, records: make(map[string]Record)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fst, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &fst.records); err != nil {
+		return nil, err
+	}
+	return fst, nil
+}
+
+func (fst *FileStore) Load(user string) (Record, error) {
+	fst.mu.Lock()
+	defer fst.mu.Unlock()
+
+	return fst.records[user], nil
+}
+
+func (fst *FileStore) Save(user string, record Record) error {
+	fst.mu.Lock()
+	defer fst.mu.Unlock()
+
+	previous, existed := fst.records[user]
+	fst.records[user] = record
+
+	if err := fst.write(); err != nil {
+		// Keep the memory consistent with the file
+		if existed {
+			fst.records[user] = previous
+		} else {
+			delete(fst.records, user)
+		}
+		return err
+	}
+	return nil
+}
+
+func (fst *FileStore) write() error {
+	data, err := json.MarshalIndent(fst.records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fst.path), filepath.Base(fst.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fst.path)
+}
@@ -3,6 +3,7 @@ package intset_test
 import (
 	"fmt"
 	"golang/pkg/chapters/chapter11/sub4/intset/mapbasedintset"
+	"golang/pkg/chapters/chapter11/sub4/intset/roaring"
 	"golang/pkg/chapters/chapter6"
 	"log"
 	"math"
@@ -55,6 +56,7 @@ func BenchmarkAdd(b *testing.B) {
 		fillers = []filler{
 			chapter6.New(),
 			mapbasedintset.New(),
+			roaring.New(),
 		}
 	)
 
@@ -0,0 +1,488 @@
+package roaring
+
+import (
+	"math/bits"
+	"slices"
+)
+
+const (
+	// The values are split into 64K chunks, a container holds the low 16 bits of the values of a chunk
+	chunkBits = 16
+	chunkSize = 1 << chunkBits
+
+	// An array container holds at most arrayMax values, a bitmap container of 8KB is smaller beyond it
+	arrayMax    = 4096
+	bitmapWords = chunkSize / 64
+	// A run container holds at most runsMax runs, a bitmap container is smaller beyond it
+	runsMax = 2048
+)
+
+// container is a set of the low 16 bits of the values of a chunk. The mutating methods return the container
+// holding the result, which is a different one when the representation changes.
+type container interface {
+	add(x uint16) container
+	// addRange adds the values from lo to hi inclusive
+	addRange(lo, hi uint16) container
+	remove(x uint16) container
+	has(x uint16) bool
+	card() int
+	// rank returns the number of the values less than or equal to x
+	rank(x uint16) int
+	// selectAt returns the i-th smallest value, 0 <= i < card()
+	selectAt(i int) uint16
+	// each calls yield for the values in ascending order until it returns false, it reports whether all the values
+	// have been visited
+	each(yield func(x uint16) bool) bool
+	// asBitmap returns the values as a bitmap, the bitmap container returns itself, so the result mustn't be changed
+	asBitmap() *bitmapContainer
+	clone() container
+	// The size of the serialized container payload in bytes
+	sizeInBytes() int
+}
+
+// arrayContainer keeps the sorted values, it's used for the sparse chunks
+type arrayContainer struct {
+	values []uint16
+}
+
+func (ac *arrayContainer) add(x uint16) container {
+	i, found := slices.BinarySearch(ac.values, x)
+	if found {
+		return ac
+	}
+	if len(ac.values) == arrayMax {
+		return ac.asBitmap().add(x)
+	}
+
+	ac.values = slices.Insert(ac.values, i, x)
+	return ac
+}
+
+func (ac *arrayContainer) addRange(lo, hi uint16) container {
+	if len(ac.values)+int(hi-lo)+1 > arrayMax {
+		// The range can overlap the values, so the result may still fit into an array
+		bc := ac.asBitmap()
+		bc.addRange(lo, hi)
+		return bc.shrink()
+	}
+
+	var (
+		start, _ = slices.BinarySearch(ac.values, lo)
+		end      = start
+	)
+	for end < len(ac.values) && ac.values[end] <= hi {
+		end++
+	}
+
+	fill := make([]uint16, 0, int(hi-lo)+1)
+	for x := int(lo); x <= int(hi); x++ {
+		fill = append(fill, uint16(x))
+	}
+	ac.values = slices.Replace(ac.values, start, end, fill...)
+	return ac
+}
+
+func (ac *arrayContainer) remove(x uint16) container {
+	if i, found := slices.BinarySearch(ac.values, x); found {
+		ac.values = slices.Delete(ac.values, i, i+1)
+	}
+	return ac
+}
+
+func (ac *arrayContainer) has(x uint16) bool {
+	_, found := slices.BinarySearch(ac.values, x)
+	return found
+}
+
+func (ac *arrayContainer) card() int {
+	return len(ac.values)
+}
+
+func (ac *arrayContainer) rank(x uint16) int {
+	i, found := slices.BinarySearch(ac.values, x)
+	if found {
+		return i + 1
+	}
+	return i
+}
+
+func (ac *arrayContainer) selectAt(i int) uint16 {
+	return ac.values[i]
+}
+
+func (ac *arrayContainer) each(yield func(x uint16) bool) bool {
+	for _, x := range ac.values {
+		if !yield(x) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ac *arrayContainer) asBitmap() *bitmapContainer {
+	bc := newBitmapContainer()
+	for _, x := range ac.values {
+		bc.words[x>>6] |= 1 << (x & 63)
+	}
+	bc.n = len(ac.values)
+	return bc
+}
+
+func (ac *arrayContainer) clone() container {
+	return &arrayContainer{values: slices.Clone(ac.values)}
+}
+
+func (ac *arrayContainer) sizeInBytes() int {
+	return 2 * len(ac.values)
+}
+
+// bitmapContainer keeps a bit per value of the chunk, it's used for the dense chunks
+type bitmapContainer struct {
+	words []uint64
+	// The number of the set bits
+	n int
+}
+
+func newBitmapContainer() *bitmapContainer {
+	return &bitmapContainer{words: make([]uint64, bitmapWords)}
+}
+
+func (bc *bitmapContainer) add(x uint16) container {
+	word, mask := x>>6, uint64(1)<<(x&63)
+	if bc.words[word]&mask == 0 {
+		bc.words[word] |= mask
+		bc.n++
+	}
+	return bc
+}
+
+func (bc *bitmapContainer) addRange(lo, hi uint16) container {
+	first, last := int(lo>>6), int(hi>>6)
+
+	before := 0
+	for _, w := range bc.words[first : last+1] {
+		before += bits.OnesCount64(w)
+	}
+
+	for i := first; i <= last; i++ {
+		mask := ^uint64(0)
+		if i == first {
+			mask &= ^uint64(0) << (lo & 63)
+		}
+		if i == last {
+			mask &= ^uint64(0) >> (63 - hi&63)
+		}
+		bc.words[i] |= mask
+	}
+
+	after := 0
+	for _, w := range bc.words[first : last+1] {
+		after += bits.OnesCount64(w)
+	}
+
+	bc.n += after - before
+	return bc
+}
+
+func (bc *bitmapContainer) remove(x uint16) container {
+	word, mask := x>>6, uint64(1)<<(x&63)
+	if bc.words[word]&mask == 0 {
+		return bc
+	}
+
+	bc.words[word] &^= mask
+	bc.n--
+	if bc.n <= arrayMax {
+		return bc.toArray()
+	}
+	return bc
+}
+
+func (bc *bitmapContainer) has(x uint16) bool {
+	return bc.words[x>>6]&(1<<(x&63)) != 0
+}
+
+func (bc *bitmapContainer) card() int {
+	return bc.n
+}
+
+func (bc *bitmapContainer) rank(x uint16) int {
+	word := int(x >> 6)
+
+	rank := 0
+	for _, w := range bc.words[:word] {
+		rank += bits.OnesCount64(w)
+	}
+
+	// The mask of the bits from 0 to x&63 inclusive, it wraps around to all ones for 63
+	mask := uint64(2)<<(x&63) - 1
+	return rank + bits.OnesCount64(bc.words[word]&mask)
+}
+
+func (bc *bitmapContainer) selectAt(i int) uint16 {
+	for word, w := range bc.words {
+		n := bits.OnesCount64(w)
+		if i >= n {
+			i -= n
+			continue
+		}
+
+		// Clear the i lowest set bits, the lowest remaining one is the result
+		for ; i > 0; i-- {
+			w &= w - 1
+		}
+		return uint16(word<<6 + bits.TrailingZeros64(w))
+	}
+
+	panic("roaring: select out of range")
+}
+
+func (bc *bitmapContainer) each(yield func(x uint16) bool) bool {
+	for word, w := range bc.words {
+		for w != 0 {
+			if !yield(uint16(word<<6 + bits.TrailingZeros64(w))) {
+				return false
+			}
+			w &= w - 1
+		}
+	}
+	return true
+}
+
+func (bc *bitmapContainer) asBitmap() *bitmapContainer {
+	return bc
+}
+
+func (bc *bitmapContainer) clone() container {
+	return &bitmapContainer{words: slices.Clone(bc.words), n: bc.n}
+}
+
+func (bc *bitmapContainer) sizeInBytes() int {
+	return 8 * bitmapWords
+}
+
+func (bc *bitmapContainer) toArray() *arrayContainer {
+	ac := &arrayContainer{values: make([]uint16, 0, bc.n)}
+	bc.each(func(x uint16) bool {
+		ac.values = append(ac.values, x)
+		return true
+	})
+	return ac
+}
+
+// recount recomputes the number of the set bits after the words have been changed directly
+func (bc *bitmapContainer) recount() {
+	bc.n = 0
+	for _, w := range bc.words {
+		bc.n += bits.OnesCount64(w)
+	}
+}
+
+// shrink returns the array container if the values fit into it, or the bitmap itself
+func (bc *bitmapContainer) shrink() container {
+	if bc.n <= arrayMax {
+		return bc.toArray()
+	}
+	return bc
+}
+
+// interval is a run of consecutive values from start to last inclusive
+type interval struct {
+	start, last uint16
+}
+
+func (iv interval) len() int {
+	return int(iv.last-iv.start) + 1
+}
+
+// runContainer keeps the sorted runs of consecutive values, neither overlapping nor adjacent
+type runContainer struct {
+	runs []interval
+}
+
+// find returns the index of the last run starting at or before x, or -1
+func (rc *runContainer) find(x uint16) int {
+	i, found := slices.BinarySearchFunc(rc.runs, x, func(iv interval, x uint16) int {
+		return int(iv.start) - int(x)
+	})
+	if found {
+		return i
+	}
+	return i - 1
+}
+
+func (rc *runContainer) add(x uint16) container {
+	return rc.addRange(x, x)
+}
+
+func (rc *runContainer) addRange(lo, hi uint16) container {
+	// The runs from i to j-1 overlap or touch [lo, hi] and are merged with it
+	i := rc.find(lo)
+	if i < 0 || int(rc.runs[i].last)+1 < int(lo) {
+		i++
+	}
+	j := i
+	for j < len(rc.runs) && int(rc.runs[j].start) <= int(hi)+1 {
+		j++
+	}
+
+	merged := interval{start: lo, last: hi}
+	if i < j {
+		merged.start = min(merged.start, rc.runs[i].start)
+		merged.last = max(merged.last, rc.runs[j-1].last)
+	}
+
+	rc.runs = slices.Replace(rc.runs, i, j, merged)
+	if len(rc.runs) > runsMax {
+		return rc.asBitmap().shrink()
+	}
+	return rc
+}
+
+func (rc *runContainer) remove(x uint16) container {
+	i := rc.find(x)
+	if i < 0 || x > rc.runs[i].last {
+		return rc
+	}
+
+	switch iv := rc.runs[i]; {
+	case iv.start == iv.last:
+		rc.runs = slices.Delete(rc.runs, i, i+1)
+	case x == iv.start:
+		rc.runs[i].start++
+	case x == iv.last:
+		rc.runs[i].last--
+	default:
+		rc.runs[i].last = x - 1
+		rc.runs = slices.Insert(rc.runs, i+1, interval{start: x + 1, last: iv.last})
+	}
+
+	if len(rc.runs) > runsMax {
+		return rc.asBitmap().shrink()
+	}
+	return rc
+}
+
+func (rc *runContainer) has(x uint16) bool {
+	i := rc.find(x)
+	return i >= 0 && x <= rc.runs[i].last
+}
+
+func (rc *runContainer) card() int {
+	n := 0
+	for _, iv := range rc.runs {
+		n += iv.len()
+	}
+	return n
+}
+
+func (rc *runContainer) rank(x uint16) int {
+	i := rc.find(x)
+	if i < 0 {
+		return 0
+	}
+
+	rank := 0
+	for _, iv := range rc.runs[:i] {
+		rank += iv.len()
+	}
+	return rank + int(min(x, rc.runs[i].last)-rc.runs[i].start) + 1
+}
+
+func (rc *runContainer) selectAt(i int) uint16 {
+	for _, iv := range rc.runs {
+		if i < iv.len() {
+			return iv.start + uint16(i)
+		}
+		i -= iv.len()
+	}
+
+	panic("roaring: select out of range")
+}
+
+func (rc *runContainer) each(yield func(x uint16) bool) bool {
+	for _, iv := range rc.runs {
+		for x := int(iv.start); x <= int(iv.last); x++ {
+			if !yield(uint16(x)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rc *runContainer) asBitmap() *bitmapContainer {
+	bc := newBitmapContainer()
+	for _, iv := range rc.runs {
+		bc.addRange(iv.start, iv.last)
+	}
+	return bc
+}
+
+func (rc *runContainer) clone() container {
+	return &runContainer{runs: slices.Clone(rc.runs)}
+}
+
+func (rc *runContainer) sizeInBytes() int {
+	return 4 * len(rc.runs)
+}
+
+// countRuns returns the number of the runs of consecutive values in the container
+func countRuns(c container) int {
+	var (
+		runs = 0
+		prev = -2
+	)
+	c.each(func(x uint16) bool {
+		if int(x) != prev+1 {
+			runs++
+		}
+		prev = int(x)
+		return true
+	})
+	return runs
+}
+
+// toRuns returns the values of the container as a run container
+func toRuns(c container) *runContainer {
+	rc := &runContainer{}
+	c.each(func(x uint16) bool {
+		if n := len(rc.runs); n > 0 && int(rc.runs[n-1].last)+1 == int(x) {
+			rc.runs[n-1].last = x
+		} else {
+			rc.runs = append(rc.runs, interval{start: x, last: x})
+		}
+		return true
+	})
+	return rc
+}
+
+// optimize returns the values of the container in the smallest of the three representations
+func optimize(c container) container {
+	var (
+		n    = c.card()
+		runs = countRuns(c)
+
+		arraySize  = 2 * n
+		bitmapSize = 8 * bitmapWords
+		runSize    = 4 * runs
+	)
+
+	switch {
+	case runSize < arraySize && runSize < bitmapSize:
+		if _, ok := c.(*runContainer); ok {
+			return c
+		}
+		return toRuns(c)
+	case n <= arrayMax:
+		if _, ok := c.(*arrayContainer); ok {
+			return c
+		}
+		return c.asBitmap().toArray()
+	default:
+		if _, ok := c.(*bitmapContainer); ok {
+			return c
+		}
+		return c.asBitmap()
+	}
+}
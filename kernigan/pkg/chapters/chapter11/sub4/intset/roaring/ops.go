@@ -0,0 +1,342 @@
+package roaring
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// The container operations never change their operands, the result is always a new container
+
+func and(a, b container) container {
+	if _, ok := b.(*arrayContainer); ok {
+		a, b = b, a
+	}
+
+	switch a := a.(type) {
+	case *arrayContainer:
+		if b, ok := b.(*arrayContainer); ok {
+			return andArrays(a, b)
+		}
+		return filter(a, b, true)
+	case *runContainer:
+		if b, ok := b.(*runContainer); ok {
+			return andRuns(a, b)
+		}
+	}
+
+	var (
+		x, y = a.asBitmap(), b.asBitmap()
+		bc   = newBitmapContainer()
+	)
+	for i := range bc.words {
+		bc.words[i] = x.words[i] & y.words[i]
+		bc.n += bits.OnesCount64(bc.words[i])
+	}
+	return bc.shrink()
+}
+
+func or(a, b container) container {
+	aa, aok := a.(*arrayContainer)
+	ba, bok := b.(*arrayContainer)
+	if aok && bok {
+		return orArrays(aa, ba)
+	}
+	if a, ok := a.(*runContainer); ok {
+		if b, ok := b.(*runContainer); ok {
+			return orRuns(a, b)
+		}
+	}
+
+	var (
+		x, y = a.asBitmap(), b.asBitmap()
+		bc   = newBitmapContainer()
+	)
+	for i := range bc.words {
+		bc.words[i] = x.words[i] | y.words[i]
+		bc.n += bits.OnesCount64(bc.words[i])
+	}
+	// The union of an array and a run container can be small
+	return bc.shrink()
+}
+
+func andNot(a, b container) container {
+	if a, ok := a.(*arrayContainer); ok {
+		return filter(a, b, false)
+	}
+
+	var (
+		x, y = a.asBitmap(), b.asBitmap()
+		bc   = newBitmapContainer()
+	)
+	for i := range bc.words {
+		bc.words[i] = x.words[i] &^ y.words[i]
+		bc.n += bits.OnesCount64(bc.words[i])
+	}
+	return bc.shrink()
+}
+
+func xor(a, b container) container {
+	aa, aok := a.(*arrayContainer)
+	ba, bok := b.(*arrayContainer)
+	if aok && bok {
+		return xorArrays(aa, ba)
+	}
+
+	var (
+		x, y = a.asBitmap(), b.asBitmap()
+		bc   = newBitmapContainer()
+	)
+	for i := range bc.words {
+		bc.words[i] = x.words[i] ^ y.words[i]
+		bc.n += bits.OnesCount64(bc.words[i])
+	}
+	return bc.shrink()
+}
+
+// andCard returns the number of the values in both containers without building the intersection
+func andCard(a, b container) int {
+	if _, ok := b.(*arrayContainer); ok {
+		a, b = b, a
+	}
+
+	if a, ok := a.(*arrayContainer); ok {
+		n := 0
+		for _, x := range a.values {
+			if b.has(x) {
+				n++
+			}
+		}
+		return n
+	}
+
+	var (
+		x, y = a.asBitmap(), b.asBitmap()
+		n    = 0
+	)
+	for i := range x.words {
+		n += bits.OnesCount64(x.words[i] & y.words[i])
+	}
+	return n
+}
+
+// filter returns the values of the array that are in the container if keep is true, or that aren't otherwise
+func filter(a *arrayContainer, c container, keep bool) *arrayContainer {
+	ac := &arrayContainer{}
+	for _, x := range a.values {
+		if c.has(x) == keep {
+			ac.values = append(ac.values, x)
+		}
+	}
+	return ac
+}
+
+func andArrays(a, b *arrayContainer) *arrayContainer {
+	ac := &arrayContainer{values: make([]uint16, 0, min(len(a.values), len(b.values)))}
+	for i, j := 0, 0; i < len(a.values) && j < len(b.values); {
+		switch x, y := a.values[i], b.values[j]; {
+		case x < y:
+			i++
+		case x > y:
+			j++
+		default:
+			ac.values = append(ac.values, x)
+			i++
+			j++
+		}
+	}
+	return ac
+}
+
+// orArrays merges the arrays, the result is a bitmap if it doesn't fit into an array
+func orArrays(a, b *arrayContainer) container {
+	if len(a.values)+len(b.values) > arrayMax {
+		bc := a.asBitmap()
+		for _, x := range b.values {
+			bc.add(x)
+		}
+		return bc.shrink()
+	}
+
+	ac := &arrayContainer{values: make([]uint16, 0, len(a.values)+len(b.values))}
+	i, j := 0, 0
+	for i < len(a.values) && j < len(b.values) {
+		switch x, y := a.values[i], b.values[j]; {
+		case x < y:
+			ac.values = append(ac.values, x)
+			i++
+		case x > y:
+			ac.values = append(ac.values, y)
+			j++
+		default:
+			ac.values = append(ac.values, x)
+			i++
+			j++
+		}
+	}
+	ac.values = append(ac.values, a.values[i:]...)
+	ac.values = append(ac.values, b.values[j:]...)
+	return ac
+}
+
+func xorArrays(a, b *arrayContainer) container {
+	if len(a.values)+len(b.values) > arrayMax {
+		bc := a.asBitmap()
+		for _, x := range b.values {
+			word, mask := x>>6, uint64(1)<<(x&63)
+			bc.words[word] ^= mask
+		}
+		bc.recount()
+		return bc.shrink()
+	}
+
+	ac := &arrayContainer{}
+	i, j := 0, 0
+	for i < len(a.values) && j < len(b.values) {
+		switch x, y := a.values[i], b.values[j]; {
+		case x < y:
+			ac.values = append(ac.values, x)
+			i++
+		case x > y:
+			ac.values = append(ac.values, y)
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	ac.values = append(ac.values, a.values[i:]...)
+	ac.values = append(ac.values, b.values[j:]...)
+	return ac
+}
+
+func andRuns(a, b *runContainer) container {
+	rc := &runContainer{}
+	for i, j := 0, 0; i < len(a.runs) && j < len(b.runs); {
+		x, y := a.runs[i], b.runs[j]
+		if start, last := max(x.start, y.start), min(x.last, y.last); start <= last {
+			rc.runs = append(rc.runs, interval{start: start, last: last})
+		}
+		if x.last < y.last {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	if len(rc.runs) > runsMax {
+		return rc.asBitmap()
+	}
+	return rc
+}
+
+func orRuns(a, b *runContainer) container {
+	// The runs of both containers in the order of their starts, merged with the overlapping and adjacent ones
+	all := append(slices.Clone(a.runs), b.runs...)
+	slices.SortFunc(all, func(x, y interval) int {
+		return int(x.start) - int(y.start)
+	})
+
+	rc := &runContainer{}
+	for _, iv := range all {
+		if n := len(rc.runs); n > 0 && int(iv.start) <= int(rc.runs[n-1].last)+1 {
+			rc.runs[n-1].last = max(rc.runs[n-1].last, iv.last)
+			continue
+		}
+		rc.runs = append(rc.runs, iv)
+	}
+
+	if len(rc.runs) > runsMax {
+		return rc.asBitmap()
+	}
+	return rc
+}
+
+// combine applies op to the containers of the same keys. The containers of the keys in s only are copied if
+// keepS is true, the same is for t and keepT. The empty results are dropped.
+func combine(s, t *IntSet, op func(a, b container) container, keepS, keepT bool) *IntSet {
+	var (
+		r    = &IntSet{}
+		i, j = 0, 0
+	)
+
+	push := func(key uint16, c container) {
+		if c.card() > 0 {
+			r.keys = append(r.keys, key)
+			r.containers = append(r.containers, c)
+		}
+	}
+
+	for i < len(s.keys) && j < len(t.keys) {
+		switch x, y := s.keys[i], t.keys[j]; {
+		case x < y:
+			if keepS {
+				push(x, s.containers[i].clone())
+			}
+			i++
+		case x > y:
+			if keepT {
+				push(y, t.containers[j].clone())
+			}
+			j++
+		default:
+			push(x, op(s.containers[i], t.containers[j]))
+			i++
+			j++
+		}
+	}
+
+	for ; keepS && i < len(s.keys); i++ {
+		push(s.keys[i], s.containers[i].clone())
+	}
+	for ; keepT && j < len(t.keys); j++ {
+		push(t.keys[j], t.containers[j].clone())
+	}
+
+	return r
+}
+
+// And returns the intersection of s and t
+func (s *IntSet) And(t *IntSet) *IntSet {
+	return combine(s, t, and, false, false)
+}
+
+// Or returns the union of s and t
+func (s *IntSet) Or(t *IntSet) *IntSet {
+	return combine(s, t, or, true, true)
+}
+
+// AndNot returns the values of s that aren't in t
+func (s *IntSet) AndNot(t *IntSet) *IntSet {
+	return combine(s, t, andNot, true, false)
+}
+
+// Xor returns the values that are in either s or t but not in both
+func (s *IntSet) Xor(t *IntSet) *IntSet {
+	return combine(s, t, xor, true, true)
+}
+
+// AndLen returns the number of the values in both s and t, it's cheaper than s.And(t).Len()
+func (s *IntSet) AndLen(t *IntSet) int {
+	n := 0
+	for i, key := range s.keys {
+		if j, found := t.index(key); found {
+			n += andCard(s.containers[i], t.containers[j])
+		}
+	}
+	return n
+}
+
+// UnionWith sets s to the union of s and t
+func (s *IntSet) UnionWith(t *IntSet) {
+	*s = *s.Or(t)
+}
+
+// IntersectWith sets s to the intersection of s and t
+func (s *IntSet) IntersectWith(t *IntSet) {
+	*s = *s.And(t)
+}
+
+// DifferenceWith removes the values of t from s
+func (s *IntSet) DifferenceWith(t *IntSet) {
+	*s = *s.AndNot(t)
+}
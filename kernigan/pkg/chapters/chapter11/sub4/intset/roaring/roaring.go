@@ -0,0 +1,286 @@
+/*
+Package roaring implements a compressed set of non-negative integers after the roaring bitmaps.
+
+The values are split into 64K chunks by their high 16 bits, and every chunk is kept in the container that suits its
+density: a sorted array for the sparse chunks, a bitmap for the dense ones and a list of runs for the consecutive
+values. Unlike the chapter6 IntSet, the memory depends on the number of the values rather than on the largest one,
+and the set operations work a chunk at a time, counting the bits with popcount.
+*/
+package roaring
+
+import (
+	"bytes"
+	"fmt"
+	"iter"
+	"slices"
+)
+
+// MaxValue is the largest value a set can hold
+const MaxValue = 1<<32 - 1
+
+// An IntSet is a set of the integers from 0 to MaxValue. Its zero value represents the empty set.
+type IntSet struct {
+	// The high 16 bits of the values in ascending order and the containers of their low 16 bits
+	keys       []uint16
+	containers []container
+}
+
+// New returns an empty set, it's the same as new(IntSet)
+func New() *IntSet {
+	return &IntSet{}
+}
+
+// split returns the high and the low 16 bits of x, it panics if x is out of the range
+func split(x int) (uint16, uint16) {
+	if x < 0 || uint64(x) > MaxValue {
+		panic(fmt.Sprintf("roaring: value %d out of range [0, %d]", x, MaxValue))
+	}
+	return uint16(x >> chunkBits), uint16(x)
+}
+
+func join(key, low uint16) int {
+	return int(key)<<chunkBits | int(low)
+}
+
+func (s *IntSet) index(key uint16) (int, bool) {
+	return slices.BinarySearch(s.keys, key)
+}
+
+// set stores the container of the key, removing it if it's empty
+func (s *IntSet) set(i int, c container) {
+	if c.card() == 0 {
+		s.keys = slices.Delete(s.keys, i, i+1)
+		s.containers = slices.Delete(s.containers, i, i+1)
+		return
+	}
+	s.containers[i] = c
+}
+
+// Add adds the values to the set, it panics if a value is out of the range
+func (s *IntSet) Add(values ...int) {
+	for _, x := range values {
+		key, low := split(x)
+
+		i, found := s.index(key)
+		if !found {
+			s.keys = slices.Insert(s.keys, i, key)
+			s.containers = slices.Insert(s.containers, i, container(&arrayContainer{}))
+		}
+		s.containers[i] = s.containers[i].add(low)
+	}
+}
+
+// AddRange adds the values from lo to hi-1, the full chunks are stored as single runs
+func (s *IntSet) AddRange(lo, hi int) {
+	if lo >= hi {
+		return
+	}
+	split(lo)
+	split(hi - 1)
+
+	for start := lo; start < hi; {
+		var (
+			key, low = split(start)
+			end      = min(hi-1, join(key, chunkSize-1))
+			_, last  = split(end)
+		)
+
+		i, found := s.index(key)
+		if !found {
+			s.keys = slices.Insert(s.keys, i, key)
+			s.containers = slices.Insert(s.containers, i, container(&runContainer{}))
+		}
+		s.containers[i] = s.containers[i].addRange(low, last)
+
+		start = end + 1
+	}
+}
+
+// Remove removes the values from the set, the values out of the range are ignored
+func (s *IntSet) Remove(values ...int) {
+	for _, x := range values {
+		if x < 0 || uint64(x) > MaxValue {
+			continue
+		}
+		key, low := split(x)
+
+		if i, found := s.index(key); found {
+			s.set(i, s.containers[i].remove(low))
+		}
+	}
+}
+
+// Has reports whether the set contains x
+func (s *IntSet) Has(x int) bool {
+	if x < 0 || uint64(x) > MaxValue {
+		return false
+	}
+	key, low := split(x)
+
+	i, found := s.index(key)
+	return found && s.containers[i].has(low)
+}
+
+// Len returns the number of the values
+func (s *IntSet) Len() int {
+	n := 0
+	for _, c := range s.containers {
+		n += c.card()
+	}
+	return n
+}
+
+// Clear makes the set empty, it never fails
+func (s *IntSet) Clear() error {
+	s.keys, s.containers = nil, nil
+	return nil
+}
+
+// Copy returns a deep copy of the set
+func (s *IntSet) Copy() *IntSet {
+	t := &IntSet{
+		keys:       slices.Clone(s.keys),
+		containers: make([]container, len(s.containers)),
+	}
+	for i, c := range s.containers {
+		t.containers[i] = c.clone()
+	}
+	return t
+}
+
+// All returns an iterator over the values in ascending order
+func (s *IntSet) All() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i, c := range s.containers {
+			key := s.keys[i]
+			if !c.each(func(low uint16) bool { return yield(join(key, low)) }) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the values in descending order
+func (s *IntSet) Backward() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := len(s.containers) - 1; i >= 0; i-- {
+			c := s.containers[i]
+			for j := c.card() - 1; j >= 0; j-- {
+				if !yield(join(s.keys[i], c.selectAt(j))) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Elements returns the values in ascending order
+func (s *IntSet) Elements() []int {
+	elements := make([]int, 0, s.Len())
+	for x := range s.All() {
+		elements = append(elements, x)
+	}
+	return elements
+}
+
+// String returns the values in the format of the chapter6 IntSet, e.g. "{ 1 2 3 }"
+func (s *IntSet) String() string {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for x := range s.All() {
+		fmt.Fprintf(&buf, " %d", x)
+	}
+	buf.WriteString(" }")
+
+	return buf.String()
+}
+
+// Rank returns the number of the values less than or equal to x
+func (s *IntSet) Rank(x int) int {
+	if x < 0 {
+		return 0
+	}
+	if uint64(x) > MaxValue {
+		return s.Len()
+	}
+	key, low := split(x)
+
+	rank := 0
+	for i, k := range s.keys {
+		switch {
+		case k < key:
+			rank += s.containers[i].card()
+		case k == key:
+			return rank + s.containers[i].rank(low)
+		default:
+			return rank
+		}
+	}
+	return rank
+}
+
+// Select returns the i-th smallest value counting from 0, ok is false if i is out of the range
+func (s *IntSet) Select(i int) (x int, ok bool) {
+	if i < 0 {
+		return 0, false
+	}
+
+	for j, c := range s.containers {
+		if n := c.card(); i >= n {
+			i -= n
+			continue
+		}
+		return join(s.keys[j], c.selectAt(i)), true
+	}
+	return 0, false
+}
+
+// Min returns the smallest value, ok is false for the empty set
+func (s *IntSet) Min() (x int, ok bool) {
+	return s.Select(0)
+}
+
+// Max returns the largest value, ok is false for the empty set
+func (s *IntSet) Max() (x int, ok bool) {
+	if len(s.containers) == 0 {
+		return 0, false
+	}
+
+	last := len(s.containers) - 1
+	c := s.containers[last]
+	return join(s.keys[last], c.selectAt(c.card()-1)), true
+}
+
+/*
+RunOptimize converts every container to the smallest of the array, bitmap and run representations. The sets built
+by Add keep the runs of consecutive values in arrays and bitmaps, it's worth calling before MarshalBinary.
+*/
+func (s *IntSet) RunOptimize() {
+	for i, c := range s.containers {
+		s.containers[i] = optimize(c)
+	}
+}
+
+// Stats describes the containers of a set
+type Stats struct {
+	Arrays, Bitmaps, Runs int
+	// The size of the values in the containers in bytes
+	Bytes int
+}
+
+func (s *IntSet) Stats() Stats {
+	var stats Stats
+	for _, c := range s.containers {
+		switch c.(type) {
+		case *arrayContainer:
+			stats.Arrays++
+		case *bitmapContainer:
+			stats.Bitmaps++
+		case *runContainer:
+			stats.Runs++
+		}
+		stats.Bytes += c.sizeInBytes()
+	}
+	return stats
+}
@@ -0,0 +1,374 @@
+package roaring
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+
+	"golang/pkg/chapters/chapter6"
+)
+
+var addTests = []struct {
+	name     string
+	elems    []int
+	expected string
+}{
+	{"Basic", []int{1, 2, 3}, "{ 1 2 3 }"},
+	{"Same elements", []int{7, 7, 7}, "{ 7 }"},
+	{"Empty list", []int{}, "{ }"},
+	{"Zero value", []int{0}, "{ 0 }"},
+	{"Repeated elements in different order", []int{3, 2, 1, 1, 2, 3}, "{ 1 2 3 }"},
+	{"Different chunks", []int{MaxValue, 1 << 16, 65535}, "{ 65535 65536 4294967295 }"},
+}
+
+func TestAdd(t *testing.T) {
+	const templ = "%s: Add(%#v) = %s, want %s"
+
+	for _, test := range addTests {
+		set := New()
+		set.Add(test.elems...)
+
+		if got := set.String(); got != test.expected {
+			t.Errorf(templ, test.name, test.elems, got, test.expected)
+		}
+	}
+}
+
+// model is the reference set the roaring one is compared with
+type model map[int]bool
+
+func (m model) elements() []int {
+	elements := make([]int, 0, len(m))
+	for x := range m {
+		elements = append(elements, x)
+	}
+	sort.Ints(elements)
+	return elements
+}
+
+// randomSet fills the chunks with the values of the different densities, so all kinds of containers are used
+func randomSet(rnd *rand.Rand) (*IntSet, model) {
+	var (
+		set = New()
+		m   = make(model)
+	)
+
+	for chunk := 0; chunk < 6; chunk++ {
+		base := rnd.Intn(8) << chunkBits
+
+		switch rnd.Intn(3) {
+		case 0: // Sparse
+			for i := 0; i < 100; i++ {
+				x := base + rnd.Intn(chunkSize)
+				set.Add(x)
+				m[x] = true
+			}
+		case 1: // Dense
+			for i := 0; i < 10000; i++ {
+				x := base + rnd.Intn(chunkSize)
+				set.Add(x)
+				m[x] = true
+			}
+		case 2: // Runs
+			for i := 0; i < 10; i++ {
+				lo := base + rnd.Intn(chunkSize)
+				hi := min(lo+rnd.Intn(3000), base+chunkSize)
+				set.AddRange(lo, hi)
+				for x := lo; x < hi; x++ {
+					m[x] = true
+				}
+			}
+		}
+	}
+
+	return set, m
+}
+
+func check(t *testing.T, name string, set *IntSet, m model) {
+	t.Helper()
+
+	want := m.elements()
+	if got := set.Elements(); !slices.Equal(got, want) {
+		t.Errorf("%s: %d elements, want %d", name, len(got), len(want))
+		return
+	}
+	if got := set.Len(); got != len(want) {
+		t.Errorf("%s: Len() = %d, want %d", name, got, len(want))
+	}
+	for i, c := range set.containers {
+		if c.card() == 0 {
+			t.Errorf("%s: empty container of key %d", name, set.keys[i])
+		}
+		if bc, ok := c.(*bitmapContainer); ok && bc.n <= arrayMax {
+			t.Errorf("%s: bitmap container of %d values", name, bc.n)
+		}
+	}
+}
+
+func TestOperations(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20; i++ {
+		s, ms := randomSet(rnd)
+		u, mu := randomSet(rnd)
+		if i%2 == 0 {
+			s.RunOptimize()
+		}
+
+		and, or, andNot, xor := make(model), make(model), make(model), make(model)
+		for x := range ms {
+			or[x] = true
+			if mu[x] {
+				and[x] = true
+			} else {
+				andNot[x] = true
+				xor[x] = true
+			}
+		}
+		for x := range mu {
+			or[x] = true
+			if !ms[x] {
+				xor[x] = true
+			}
+		}
+
+		check(t, "And", s.And(u), and)
+		check(t, "Or", s.Or(u), or)
+		check(t, "AndNot", s.AndNot(u), andNot)
+		check(t, "Xor", s.Xor(u), xor)
+		if got := s.AndLen(u); got != len(and) {
+			t.Errorf("AndLen() = %d, want %d", got, len(and))
+		}
+
+		// The operands are left intact
+		check(t, "operand", s, ms)
+
+		s.UnionWith(u)
+		check(t, "UnionWith", s, or)
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	var (
+		rnd = rand.New(rand.NewSource(2))
+		set = New()
+		m   = make(model)
+	)
+
+	// Grow a chunk past the array and run limits, then shrink it back
+	for i := 0; i < 20000; i++ {
+		x := rnd.Intn(2 * chunkSize)
+		set.Add(x)
+		m[x] = true
+	}
+	check(t, "Add", set, m)
+
+	set.AddRange(1000, 70000)
+	for x := 1000; x < 70000; x++ {
+		m[x] = true
+	}
+	set.RunOptimize()
+	check(t, "AddRange", set, m)
+
+	for x := range m {
+		if rnd.Intn(10) > 0 {
+			set.Remove(x)
+			delete(m, x)
+		}
+	}
+	check(t, "Remove", set, m)
+
+	for x := range m {
+		set.Remove(x)
+	}
+	if set.Len() != 0 || len(set.containers) != 0 {
+		t.Errorf("%d values in %d containers after removing all", set.Len(), len(set.containers))
+	}
+
+	set.Remove(-1, MaxValue+1)
+	if set.Has(-1) || set.Has(MaxValue+1) {
+		t.Error("out of range values found")
+	}
+}
+
+func TestRankSelect(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	set, m := randomSet(rnd)
+	set.RunOptimize()
+	elements := m.elements()
+
+	for i, x := range elements {
+		if got := set.Rank(x); got != i+1 {
+			t.Fatalf("Rank(%d) = %d, want %d", x, got, i+1)
+		}
+		if got, ok := set.Select(i); !ok || got != x {
+			t.Fatalf("Select(%d) = %d, %t, want %d", i, got, ok, x)
+		}
+	}
+
+	if _, ok := set.Select(len(elements)); ok {
+		t.Error("Select beyond the set succeeded")
+	}
+	if got := set.Rank(MaxValue); got != len(elements) {
+		t.Errorf("Rank(MaxValue) = %d, want %d", got, len(elements))
+	}
+	if x, _ := set.Min(); x != elements[0] {
+		t.Errorf("Min() = %d, want %d", x, elements[0])
+	}
+	if x, _ := set.Max(); x != elements[len(elements)-1] {
+		t.Errorf("Max() = %d, want %d", x, elements[len(elements)-1])
+	}
+}
+
+func TestIterators(t *testing.T) {
+	set := New()
+	set.Add(5, 1, 1<<20, 3)
+	set.AddRange(100, 103)
+
+	var got []int
+	for x := range set.All() {
+		if x > 100 {
+			break
+		}
+		got = append(got, x)
+	}
+	if want := []int{1, 3, 5, 100}; !slices.Equal(got, want) {
+		t.Errorf("All() = %v, want %v", got, want)
+	}
+
+	got = slices.Collect(set.Backward())
+	if want := []int{1 << 20, 102, 101, 100, 5, 3, 1}; !slices.Equal(got, want) {
+		t.Errorf("Backward() = %v, want %v", got, want)
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	rnd := rand.New(rand.NewSource(4))
+	set, m := randomSet(rnd)
+
+	for _, optimize := range []bool{false, true} {
+		if optimize {
+			set.RunOptimize()
+		}
+
+		data, err := set.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := New()
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary: %v", err)
+		}
+		check(t, "UnmarshalBinary", got, m)
+		if got.Stats() != set.Stats() {
+			t.Errorf("Stats() = %+v, want %+v", got.Stats(), set.Stats())
+		}
+
+		// Every truncation is detected, and the set is left intact
+		for n := 0; n < len(data); n += 1 + n/8 {
+			if err := got.UnmarshalBinary(data[:n]); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("UnmarshalBinary of %d bytes out of %d: %v", n, len(data), err)
+			}
+		}
+		check(t, "failed UnmarshalBinary", got, m)
+	}
+
+	corrupt := []struct {
+		name string
+		data []byte
+	}{
+		{"bad magic", []byte{1, 2, 3, 4, 0, 0, 0, 0}},
+		{"huge count", []byte{'I', 'N', 'R', '1', 0xff, 0xff, 0xff, 0xff}},
+		{"unknown kind", []byte{'I', 'N', 'R', '1', 1, 0, 0, 0, 0, 0, 9, 1, 0, 0, 0, 0, 0}},
+		{"unsorted array", []byte{'I', 'N', 'R', '1', 1, 0, 0, 0, 0, 0, 1, 2, 0, 0, 0, 5, 0, 4, 0}},
+		{"overlapping runs", []byte{'I', 'N', 'R', '1', 1, 0, 0, 0, 0, 0, 3, 2, 0, 0, 0, 1, 0, 5, 0, 6, 0, 7, 0}},
+		{"trailing bytes", []byte{'I', 'N', 'R', '1', 0, 0, 0, 0, 0}},
+	}
+	for _, test := range corrupt {
+		if err := New().UnmarshalBinary(test.data); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: UnmarshalBinary() = %v, want ErrCorrupt", test.name, err)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	set := New()
+	set.AddRange(0, 3*chunkSize)
+	set.Add(5*chunkSize, 5*chunkSize+7)
+
+	if got, want := set.Stats(), (Stats{Arrays: 1, Runs: 3, Bytes: 16}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+const benchSize = 1e5
+
+func benchValues(limit int) []int {
+	rnd := rand.New(rand.NewSource(5))
+	values := make([]int, benchSize)
+	for i := range values {
+		values[i] = rnd.Intn(limit)
+	}
+	return values
+}
+
+func BenchmarkAddRoaring(b *testing.B) {
+	values := benchValues(1 << 24)
+	for i := 0; i < b.N; i++ {
+		New().Add(values...)
+	}
+}
+
+func BenchmarkAddBitVector(b *testing.B) {
+	values := benchValues(1 << 24)
+	for i := 0; i < b.N; i++ {
+		chapter6.New().Add(values...)
+	}
+}
+
+func BenchmarkUnionRoaring(b *testing.B) {
+	s, t := New(), New()
+	s.Add(benchValues(1 << 24)...)
+	t.AddRange(1<<20, 1<<23)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Copy().UnionWith(t)
+	}
+}
+
+func BenchmarkUnionBitVector(b *testing.B) {
+	s, t := chapter6.New(), chapter6.New()
+	s.Add(benchValues(1 << 24)...)
+	for x := 1 << 20; x < 1<<23; x++ {
+		t.Add(x)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Copy().UnionWith(t)
+	}
+}
+
+func BenchmarkElementsRoaring(b *testing.B) {
+	s := New()
+	s.Add(benchValues(1 << 24)...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Elements()
+	}
+}
+
+func BenchmarkElementsBitVector(b *testing.B) {
+	s := chapter6.New()
+	s.Add(benchValues(1 << 24)...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Elements()
+	}
+}
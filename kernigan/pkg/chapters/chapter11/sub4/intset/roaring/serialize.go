@@ -0,0 +1,233 @@
+package roaring
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+/*
+The binary format is little endian:
+
+	magic      uint32
+	containers uint32
+	for every container in ascending order of the keys:
+		key   uint16
+		kind  uint8
+		count uint32 the number of the values of an array, the set bits of a bitmap or the runs of a run container
+		the count values as uint16, the 1024 words of a bitmap as uint64 or the count runs as start and last uint16
+*/
+
+const magic = 0x31524e49 // "INR1"
+
+const (
+	kindArray byte = iota + 1
+	kindBitmap
+	kindRun
+)
+
+// ErrCorrupt is returned by UnmarshalBinary for the data not produced by MarshalBinary
+var ErrCorrupt = errors.New("roaring: corrupt data")
+
+// MarshalBinary implements encoding.BinaryMarshaler, RunOptimize makes the result smaller for the sets with runs
+func (s *IntSet) MarshalBinary() ([]byte, error) {
+	size := 8
+	for _, c := range s.containers {
+		size += 7 + c.sizeInBytes()
+	}
+
+	data := make([]byte, 0, size)
+	data = binary.LittleEndian.AppendUint32(data, magic)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(s.containers)))
+
+	for i, c := range s.containers {
+		data = binary.LittleEndian.AppendUint16(data, s.keys[i])
+
+		switch c := c.(type) {
+		case *arrayContainer:
+			data = append(data, kindArray)
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(c.values)))
+			for _, x := range c.values {
+				data = binary.LittleEndian.AppendUint16(data, x)
+			}
+		case *bitmapContainer:
+			data = append(data, kindBitmap)
+			data = binary.LittleEndian.AppendUint32(data, uint32(c.n))
+			for _, w := range c.words {
+				data = binary.LittleEndian.AppendUint64(data, w)
+			}
+		case *runContainer:
+			data = append(data, kindRun)
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(c.runs)))
+			for _, iv := range c.runs {
+				data = binary.LittleEndian.AppendUint16(data, iv.start)
+				data = binary.LittleEndian.AppendUint16(data, iv.last)
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// decoder reads the little endian values, it stops at the first error
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.data) < n {
+		d.err = fmt.Errorf("%w: unexpected end of data", ErrCorrupt)
+		return nil
+	}
+
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, args...))
+	}
+}
+
+/*
+UnmarshalBinary implements encoding.BinaryUnmarshaler, it replaces the values of the set. The data is checked
+thoroughly, so a set is never left in an inconsistent state: the set isn't changed if an error is returned.
+*/
+func (s *IntSet) UnmarshalBinary(data []byte) error {
+	d := &decoder{data: data}
+
+	if d.uint32() != magic && d.err == nil {
+		return fmt.Errorf("%w: bad magic number", ErrCorrupt)
+	}
+
+	n := d.uint32()
+	// Every container takes at least 9 bytes, so the count can't make us allocate much more than the data size
+	if d.err == nil && uint64(n)*9 > uint64(len(d.data)) {
+		return fmt.Errorf("%w: %d containers in %d bytes", ErrCorrupt, n, len(d.data))
+	}
+
+	t := &IntSet{
+		keys:       make([]uint16, 0, n),
+		containers: make([]container, 0, n),
+	}
+	for i := 0; i < int(n) && d.err == nil; i++ {
+		key := d.uint16()
+		if len(t.keys) > 0 && key <= t.keys[len(t.keys)-1] {
+			d.fail("key %d out of order", key)
+		}
+
+		c := d.container()
+		if d.err == nil {
+			t.keys = append(t.keys, key)
+			t.containers = append(t.containers, c)
+		}
+	}
+
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.data) > 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrCorrupt, len(d.data))
+	}
+
+	*s = *t
+	return nil
+}
+
+func (d *decoder) container() container {
+	var (
+		kind  = d.uint8()
+		count = d.uint32()
+	)
+	if d.err != nil {
+		return nil
+	}
+
+	switch kind {
+	case kindArray:
+		if count == 0 || count > arrayMax {
+			d.fail("array of %d values", count)
+			return nil
+		}
+
+		ac := &arrayContainer{values: make([]uint16, 0, count)}
+		for i := 0; i < int(count) && d.err == nil; i++ {
+			x := d.uint16()
+			if i > 0 && x <= ac.values[i-1] {
+				d.fail("array value %d out of order", x)
+			}
+			ac.values = append(ac.values, x)
+		}
+		return ac
+
+	case kindBitmap:
+		bc := newBitmapContainer()
+		for i := range bc.words {
+			bc.words[i] = d.uint64()
+			bc.n += bits.OnesCount64(bc.words[i])
+		}
+		if d.err == nil && (bc.n != int(count) || bc.n == 0) {
+			d.fail("bitmap of %d values, %d expected", bc.n, count)
+		}
+		return bc.shrink()
+
+	case kindRun:
+		if count == 0 || count > runsMax {
+			d.fail("%d runs", count)
+			return nil
+		}
+
+		rc := &runContainer{runs: make([]interval, 0, count)}
+		for i := 0; i < int(count) && d.err == nil; i++ {
+			iv := interval{start: d.uint16(), last: d.uint16()}
+			if iv.start > iv.last {
+				d.fail("run from %d to %d", iv.start, iv.last)
+			}
+			// The runs are neither overlapping nor adjacent
+			if i > 0 && int(iv.start) <= int(rc.runs[i-1].last)+1 {
+				d.fail("run from %d out of order", iv.start)
+			}
+			rc.runs = append(rc.runs, iv)
+		}
+		return rc
+
+	default:
+		d.fail("unknown container kind %d", kind)
+		return nil
+	}
+}
@@ -141,6 +141,8 @@ variable, "notifyUser()". Now we can write a test that substitutes a simple fake
 	1) There's one problem. After "TestCheckQuouta()" function has returned, "CheckQuota()" no longer works it should because it's still using the test's fake implemetation of
 	"notifyUser()" (There's always the risk of this kind when updating global variables). We must modify the test to restore the previous value so that subsequent tests observe no
 	effect, and we must do this on all execution paths, including test failures and panics. This naturally suggests "defer"
+	2) The "storage" package has since replaced "CheckQuota()" and the global "notifyUser()" with "QuotaService", which gets its "Notifier"s in its "Config". So
+	"TestCheckQuota()" passes a fake SMTP server to the service it creates, and there's no global to restore.
 This pattern can be used to temporarily save and restore all kinds of global variables including:
 	1) command-line Flags
 	2) debugging options
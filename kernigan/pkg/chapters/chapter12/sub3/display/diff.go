@@ -0,0 +1,412 @@
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ChangeKind tells how a value at a path differs
+type ChangeKind string
+
+const (
+	// Changed means the path holds different values in both sides
+	Changed ChangeKind = "changed"
+	// Added means the path exists in the second value only, e.g. a map key or an extra slice item
+	Added ChangeKind = "added"
+	// Removed means the path exists in the first value only
+	Removed ChangeKind = "removed"
+)
+
+// Change is a single difference found by Diff. From and To hold the text form of the values, the one missing
+// on its side is empty.
+type Change struct {
+	// The path in the Display notation relative to the compared values, e.g. .Actors["Dr. Strangelove"] or [2].Year,
+	// the empty path stands for the values themselves
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	From string     `json:"from,omitempty"`
+	To   string     `json:"to,omitempty"`
+}
+
+func (c Change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "<root>"
+	}
+
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("%s: added %s", path, c.To)
+	case Removed:
+		return fmt.Sprintf("%s: removed %s", path, c.From)
+	default:
+		return fmt.Sprintf("%s: %s -> %s", path, c.From, c.To)
+	}
+}
+
+// Text renders the changes a line per change, the empty string means there are no differences
+func Text(changes []Change) string {
+	var b strings.Builder
+	for _, c := range changes {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// JSON renders the changes as a JSON array, it's never null
+func JSON(changes []Change) ([]byte, error) {
+	if changes == nil {
+		changes = []Change{}
+	}
+	return json.MarshalIndent(changes, "", "  ")
+}
+
+// Option configures Diff
+type Option func(o *options)
+
+type options struct {
+	ignore         map[string]bool
+	tolerance      float64
+	nilEqualsEmpty bool
+}
+
+// IgnoreFields skips the struct fields with the given names, e.g. "UpdatedAt", or at the given paths, e.g. ".Owner.ID"
+func IgnoreFields(names ...string) Option {
+	return func(o *options) {
+		for _, name := range names {
+			o.ignore[name] = true
+		}
+	}
+}
+
+// FloatTolerance treats the floating point and complex numbers as equal if they differ by at most tolerance
+func FloatTolerance(tolerance float64) Option {
+	return func(o *options) {
+		o.tolerance = tolerance
+	}
+}
+
+// NilEqualsEmpty treats the nil slices and maps as equal to the empty ones
+func NilEqualsEmpty() Option {
+	return func(o *options) {
+		o.nilEqualsEmpty = true
+	}
+}
+
+// comparison identifies a pair of references being compared, see chapter13 equal
+type comparison struct {
+	x, y visit
+}
+
+// differ keeps the state of a single Diff call
+type differ struct {
+	options
+	changes []Change
+	// The pairs of references on the path from the roots to the current values
+	seen map[comparison]empty
+}
+
+/*
+Diff reports the differences between a and b path by path, in the order of the struct fields, the slice indices
+and the sorted map keys. The nil result means a and b are deeply equal.
+
+The pointers are followed transparently, so they don't appear in the paths. A pair of references that is met again
+while it's being compared closes a cycle in both values and is considered equal, which makes Diff terminate on
+cyclic data.
+*/
+func Diff(a, b any, opts ...Option) []Change {
+	d := differ{
+		options: options{ignore: make(map[string]bool)},
+		seen:    make(map[comparison]empty),
+	}
+	for _, opt := range opts {
+		opt(&d.options)
+	}
+
+	d.diff("", reflect.ValueOf(a), reflect.ValueOf(b))
+	return d.changes
+}
+
+func (d *differ) report(path string, kind ChangeKind, x, y reflect.Value) {
+	c := Change{Path: path, Kind: kind}
+	if kind != Added {
+		c.From = formatValue(x)
+	}
+	if kind != Removed {
+		c.To = formatValue(y)
+	}
+	d.changes = append(d.changes, c)
+}
+
+func (d *differ) diff(path string, x, y reflect.Value) {
+	if !x.IsValid() || !y.IsValid() {
+		if x.IsValid() != y.IsValid() {
+			d.report(path, Changed, x, y)
+		}
+		return
+	}
+
+	// The values of different types are written with their types, e.g. int(1) -> int64(1)
+	if x.Type() != y.Type() {
+		d.changes = append(d.changes, Change{
+			Path: path,
+			Kind: Changed,
+			From: fmt.Sprintf("%s(%s)", x.Type(), formatValue(x)),
+			To:   fmt.Sprintf("%s(%s)", y.Type(), formatValue(y)),
+		})
+		return
+	}
+
+	switch x.Kind() {
+	case reflect.Bool:
+		if x.Bool() != y.Bool() {
+			d.report(path, Changed, x, y)
+		}
+	case reflect.String:
+		if x.String() != y.String() {
+			d.report(path, Changed, x, y)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if x.Int() != y.Int() {
+			d.report(path, Changed, x, y)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if x.Uint() != y.Uint() {
+			d.report(path, Changed, x, y)
+		}
+	case reflect.Float32, reflect.Float64:
+		if !d.floatsEqual(x.Float(), y.Float()) {
+			d.report(path, Changed, x, y)
+		}
+	case reflect.Complex64, reflect.Complex128:
+		if !d.complexEqual(x.Complex(), y.Complex()) {
+			d.report(path, Changed, x, y)
+		}
+	case reflect.Chan, reflect.UnsafePointer, reflect.Func:
+		if x.Pointer() != y.Pointer() {
+			d.report(path, Changed, x, y)
+		}
+	case reflect.Interface:
+		d.diff(path, x.Elem(), y.Elem())
+	case reflect.Pointer:
+		if x.IsNil() || y.IsNil() {
+			if x.IsNil() != y.IsNil() {
+				d.report(path, Changed, x, y)
+			}
+			return
+		}
+		if d.enter(x, y) {
+			defer d.leave(x, y)
+			d.diff(path, x.Elem(), y.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < x.NumField(); i++ {
+			name := x.Type().Field(i).Name
+			fieldPath := path + "." + name
+			if d.ignore[name] || d.ignore[fieldPath] {
+				continue
+			}
+			d.diff(fieldPath, x.Field(i), y.Field(i))
+		}
+	case reflect.Array:
+		d.diffItems(path, x, y)
+	case reflect.Slice:
+		if !d.sameNilness(path, x, y) {
+			return
+		}
+		if d.enter(x, y) {
+			defer d.leave(x, y)
+			d.diffItems(path, x, y)
+		}
+	case reflect.Map:
+		if !d.sameNilness(path, x, y) {
+			return
+		}
+		if d.enter(x, y) {
+			defer d.leave(x, y)
+			d.diffMaps(path, x, y)
+		}
+	}
+}
+
+func (d *differ) diffItems(path string, x, y reflect.Value) {
+	for i := 0; i < max(x.Len(), y.Len()); i++ {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= y.Len():
+			d.report(itemPath, Removed, x.Index(i), reflect.Value{})
+		case i >= x.Len():
+			d.report(itemPath, Added, reflect.Value{}, y.Index(i))
+		default:
+			d.diff(itemPath, x.Index(i), y.Index(i))
+		}
+	}
+}
+
+func (d *differ) diffMaps(path string, x, y reflect.Value) {
+	keys := x.MapKeys()
+	for _, key := range y.MapKeys() {
+		if !x.MapIndex(key).IsValid() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return formatKey(keys[i]) < formatKey(keys[j])
+	})
+
+	for _, key := range keys {
+		var (
+			keyPath = fmt.Sprintf("%s[%s]", path, formatKey(key))
+			xv      = x.MapIndex(key)
+			yv      = y.MapIndex(key)
+		)
+
+		switch {
+		case !yv.IsValid():
+			d.report(keyPath, Removed, xv, yv)
+		case !xv.IsValid():
+			d.report(keyPath, Added, xv, yv)
+		default:
+			d.diff(keyPath, xv, yv)
+		}
+	}
+}
+
+// Reports whether both slices or maps are nil or both aren't, and the difference otherwise
+func (d *differ) sameNilness(path string, x, y reflect.Value) bool {
+	if x.IsNil() == y.IsNil() {
+		return true
+	}
+
+	if d.nilEqualsEmpty && x.Len() == 0 && y.Len() == 0 {
+		return false
+	}
+
+	d.report(path, Changed, x, y)
+	return false
+}
+
+// enter marks the pair of references as being compared, it returns false if the pair closes a cycle
+func (d *differ) enter(x, y reflect.Value) bool {
+	key := comparison{visitOf(x), visitOf(y)}
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+
+	d.seen[key] = empty{}
+	return true
+}
+
+func (d *differ) leave(x, y reflect.Value) {
+	delete(d.seen, comparison{visitOf(x), visitOf(y)})
+}
+
+func (d *differ) floatsEqual(x, y float64) bool {
+	if x == y || math.IsNaN(x) && math.IsNaN(y) {
+		return true
+	}
+	return math.Abs(x-y) <= d.tolerance
+}
+
+func (d *differ) complexEqual(x, y complex128) bool {
+	if x == y || cmplx.IsNaN(x) && cmplx.IsNaN(y) {
+		return true
+	}
+	return cmplx.Abs(x-y) <= d.tolerance
+}
+
+// The nesting level formatValue stops at, it keeps the text short and bounds the walk over cyclic values
+const maxFormatDepth = 3
+
+// formatValue returns the text form of a value for a Change, with the values nested too deep replaced by "..."
+func formatValue(v reflect.Value) string {
+	var b strings.Builder
+	writeValue(&b, v, 0)
+	return b.String()
+}
+
+func writeValue(b *strings.Builder, v reflect.Value, depth int) {
+	if !v.IsValid() {
+		b.WriteString("nil")
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b.WriteString(strconv.FormatBool(v.Bool()))
+		return
+	case reflect.String:
+		b.WriteString(strconv.Quote(v.String()))
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteString(strconv.FormatInt(v.Int(), 10))
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		b.WriteString(strconv.FormatUint(v.Uint(), 10))
+		return
+	case reflect.Float32, reflect.Float64:
+		b.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+		return
+	case reflect.Complex64, reflect.Complex128:
+		b.WriteString(strconv.FormatComplex(v.Complex(), 'g', -1, v.Type().Bits()))
+		return
+	case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+	}
+
+	if depth == maxFormatDepth {
+		b.WriteString("...")
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		b.WriteByte('&')
+		writeValue(b, v.Elem(), depth+1)
+	case reflect.Interface:
+		writeValue(b, v.Elem(), depth)
+	case reflect.Slice, reflect.Array:
+		b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeValue(b, v.Index(i), depth+1)
+		}
+		b.WriteByte(']')
+	case reflect.Map:
+		b.WriteString("map[")
+		for i, key := range sortedKeys(v) {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeValue(b, key, depth+1)
+			b.WriteByte(':')
+			writeValue(b, v.MapIndex(key), depth+1)
+		}
+		b.WriteByte(']')
+	case reflect.Struct:
+		b.WriteByte('{')
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(v.Type().Field(i).Name)
+			b.WriteByte(':')
+			writeValue(b, v.Field(i), depth+1)
+		}
+		b.WriteByte('}')
+	default:
+		b.WriteString(v.Type().String())
+	}
+}
@@ -3,7 +3,10 @@ package display
 import (
 	"fmt"
 	"golang/pkg/chapters/chapter12/sub2/format"
+	"io"
+	"os"
 	"reflect"
+	"sort"
 )
 
 func DisplayUsing() {
@@ -38,107 +41,143 @@ func DisplayUsing() {
 }
 
 func Display(name string, x interface{}) {
-	fmt.Printf("Display %s (%T):\n", name, x)
-	display(name, reflect.ValueOf(x))
+	Fdisplay(os.Stdout, name, x)
+}
+
+// Fdisplay writes the path and the value of every atom reachable from x to w. A reference that leads back to
+// a value being displayed is written as CYCLE instead of being followed.
+func Fdisplay(w io.Writer, name string, x interface{}) {
+	fmt.Fprintf(w, "Display %s (%T):\n", name, x)
 
+	d := displayer{w: w, seen: make(map[visit]empty)}
+	d.display(name, reflect.ValueOf(x))
 }
 
 type empty struct{}
 
-var (
-	repetitions map[reflect.Value]empty
-)
+// visit identifies a reference value: maps and pointers by their address, slices by their address and length
+type visit struct {
+	ptr uintptr
+	len int
+	t   reflect.Type
+}
 
-func init() {
-	repetitions = make(map[reflect.Value]empty)
+// displayer keeps the state of a single Display call
+type displayer struct {
+	w io.Writer
+	// The references on the path from the root to the current value
+	seen map[visit]empty
 }
 
-func display(path string, v reflect.Value) {
+func (d *displayer) display(path string, v reflect.Value) {
 	switch v.Kind() {
 	case reflect.Invalid:
-		fmt.Printf("%s = invalid\n", path)
+		fmt.Fprintf(d.w, "%s = invalid\n", path)
 	case reflect.Slice, reflect.Array:
 		if v.Kind() == reflect.Slice {
-			if isCycle(v) {
+			if v.IsNil() {
+				fmt.Fprintf(d.w, "%s = nil\n", path)
 				return
 			}
-
-			repetitions[v] = empty{}
+			if !d.enter(path, v) {
+				return
+			}
+			defer d.leave(v)
 		}
 
 		for i := 0; i < v.Len(); i++ {
-			display(fmt.Sprintf("%s[%d]", path, i), v.Index(i))
+			d.display(fmt.Sprintf("%s[%d]", path, i), v.Index(i))
 		}
 
 	case reflect.Struct:
 		for i := 0; i < v.NumField(); i++ {
 			fieldPath := fmt.Sprintf("%s.%s", path, v.Type().Field(i).Name)
-			display(fieldPath, v.Field(i))
+			d.display(fieldPath, v.Field(i))
 		}
 	case reflect.Map:
-		if isCycle(v) {
+		if v.IsNil() {
+			fmt.Fprintf(d.w, "%s = nil\n", path)
 			return
 		}
-
-		repetitions[v] = empty{}
-
-		if keys := v.MapKeys(); len(keys) != 0 {
-			firstKey := keys[0]
-
-			// If a internal value isn't comparable we'll break the case statement
-			switch firstKey.Kind() {
-			case reflect.Array:
-				for _, key := range keys {
-					strKey := fmt.Sprintf("%s[%v]", path, key)
-					display(fmt.Sprintf("%s[%s]", path, strKey), v.MapIndex(key))
-				}
-			case reflect.Struct:
-				for _, key := range keys {
-					strKey := fmt.Sprintf("%s[%v]", path, key)
-					display(fmt.Sprintf("%s[%s]", path, strKey), v.MapIndex(key))
-				}
-			default:
-				for _, key := range keys {
-					display(fmt.Sprintf("%s[%s]", path, format.FormatAtom(key)), v.MapIndex(key))
-				}
-			}
+		if v.Len() == 0 {
+			fmt.Fprintf(d.w, "%s = map[]\n", path)
 			return
 		}
-
-		fmt.Printf("map has 0 length")
-
-	case reflect.Ptr:
-		if isCycle(v) {
+		if !d.enter(path, v) {
 			return
 		}
+		defer d.leave(v)
 
-		repetitions[v] = empty{}
+		for _, key := range sortedKeys(v) {
+			d.display(fmt.Sprintf("%s[%s]", path, formatKey(key)), v.MapIndex(key))
+		}
 
+	case reflect.Ptr:
 		if v.IsNil() {
-			fmt.Printf("%s = nil\n", path)
+			fmt.Fprintf(d.w, "%s = nil\n", path)
 			return
 		}
-		display(fmt.Sprintf("(*%s)", path), v.Elem())
+		if !d.enter(path, v) {
+			return
+		}
+		defer d.leave(v)
+
+		d.display(fmt.Sprintf("(*%s)", path), v.Elem())
 	case reflect.Interface:
 		if v.IsNil() {
-			fmt.Printf("%s = nil\n", path)
+			fmt.Fprintf(d.w, "%s = nil\n", path)
 			return
 		}
-		fmt.Printf("%s.type = %s\n", path, v.Elem().Type())
-		display(path+".value", v.Elem())
+		fmt.Fprintf(d.w, "%s.type = %s\n", path, v.Elem().Type())
+		d.display(path+".value", v.Elem())
 	default:
-		fmt.Printf("%s = %s\n", path, format.FormatAtom(v))
+		fmt.Fprintf(d.w, "%s = %s\n", path, format.FormatAtom(v))
+	}
+
+}
+
+// enter marks the reference as being displayed, or reports the cycle and returns false if it's already been marked
+func (d *displayer) enter(path string, v reflect.Value) bool {
+	key := visitOf(v)
+	if _, ok := d.seen[key]; ok {
+		fmt.Fprintf(d.w, "%s = CYCLE\n", path)
+		return false
 	}
 
+	d.seen[key] = empty{}
+	return true
 }
 
-func isCycle(val reflect.Value) bool {
-	if _, ok := repetitions[val]; ok {
-		fmt.Printf("CYCLE\n")
-		delete(repetitions, val)
-		return true
+func (d *displayer) leave(v reflect.Value) {
+	delete(d.seen, visitOf(v))
+}
+
+func visitOf(v reflect.Value) visit {
+	key := visit{ptr: v.Pointer(), t: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	return key
+}
+
+// Returns the map keys in the order of their text form, so the output is stable
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return formatKey(keys[i]) < formatKey(keys[j])
+	})
+	return keys
+}
+
+// Formats a map key, the composite keys are written with their fields
+func formatKey(key reflect.Value) string {
+	switch key.Kind() {
+	case reflect.Array, reflect.Struct, reflect.Interface:
+		if key.CanInterface() {
+			return fmt.Sprintf("%+v", key.Interface())
+		}
 	}
-	return false
+	return format.FormatAtom(key)
 }
 
 func DifferencePtrAndNonPtrDisplaying() {
@@ -0,0 +1,164 @@
+package display
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type movie struct {
+	Title  string
+	Year   int
+	Rating float64
+	Actors map[string]string
+	Oscars []string
+	Sequel *movie
+	secret int
+}
+
+func strangelove() *movie {
+	return &movie{
+		Title:  "Dr. Strangelove",
+		Year:   1964,
+		Rating: 8.4,
+		Actors: map[string]string{
+			"Dr. Strangelove":     "Peter Sellers",
+			"Gen. Buck Turgidson": "George C. Scott",
+		},
+		Oscars: []string{"Best Actor (Nomin.)", "Best Adapted Screenplay (Nomin.)"},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, b := strangelove(), strangelove()
+	b.Year = 1965
+	b.Actors["Maj. T.J. \"King\" Kong"] = "Slim Pickens"
+	delete(b.Actors, "Gen. Buck Turgidson")
+	b.Oscars = append(b.Oscars, "Best Director (Nomin.)")
+	b.Sequel = &movie{Title: "Son of Strangelove"}
+	b.secret = 1
+
+	got := Text(Diff(a, b))
+	want := `.Year: 1964 -> 1965
+.Actors["Gen. Buck Turgidson"]: removed "George C. Scott"
+.Actors["Maj. T.J. \"King\" Kong"]: added "Slim Pickens"
+.Oscars[2]: added "Best Director (Nomin.)"
+.Sequel: nil -> &{Title:"Son of Strangelove" Year:0 Rating:0 Actors:nil Oscars:nil Sequel:nil secret:0}
+.secret: 0 -> 1
+`
+	if got != want {
+		t.Errorf("Diff\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	if changes := Diff(a, strangelove()); changes != nil {
+		t.Errorf("Diff of equal values = %v, want nil", changes)
+	}
+}
+
+func TestDiffOptions(t *testing.T) {
+	a, b := strangelove(), strangelove()
+	b.Title = "Dr. Strangelove or: How I Learned to Stop Worrying and Love the Bomb"
+	b.Year = 1965
+	b.Rating = 8.4000001
+	a.Oscars = nil
+	b.Oscars = []string{}
+
+	got := Diff(a, b)
+	if len(got) != 4 {
+		t.Fatalf("Diff without options = %v, want 4 changes", got)
+	}
+
+	got = Diff(a, b, IgnoreFields("Title", ".Year"), FloatTolerance(1e-6), NilEqualsEmpty())
+	if got != nil {
+		t.Errorf("Diff with options = %v, want nil", got)
+	}
+
+	// The paths ignore the fields at the given path only
+	a.Sequel, b.Sequel = &movie{Year: 1}, &movie{Year: 2}
+	got = Diff(a, b, IgnoreFields("Title", ".Year"), FloatTolerance(1e-6), NilEqualsEmpty())
+	if want := []Change{{Path: ".Sequel.Year", Kind: Changed, From: "1", To: "2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %v, want %v", got, want)
+	}
+}
+
+func TestDiffCycles(t *testing.T) {
+	a, b := strangelove(), strangelove()
+	a.Sequel, b.Sequel = a, b
+
+	if got := Diff(a, b); got != nil {
+		t.Errorf("Diff of equal cycles = %v, want nil", got)
+	}
+
+	b.Year = 2000
+	got := Text(Diff(a, b))
+	if want := ".Year: 1964 -> 2000\n"; got != want {
+		t.Errorf("Diff of cycles = %q, want %q", got, want)
+	}
+
+	type list []any
+	x, y := list{1, nil}, list{1, nil}
+	x[1], y[1] = x, y
+	y = append(y, 2)
+
+	got = Text(Diff(x, y))
+	if want := "[2]: added 2\n"; got != want {
+		t.Errorf("Diff of cyclic slices = %q, want %q", got, want)
+	}
+}
+
+func TestDiffTypes(t *testing.T) {
+	got := Diff([]any{1, "a", nil}, []any{int64(1), "a", 2.5})
+	want := []Change{
+		{Path: "[0]", Kind: Changed, From: "int(1)", To: "int64(1)"},
+		{Path: "[2]", Kind: Changed, From: "nil", To: "2.5"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %v, want %v", got, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	data, err := JSON(Diff(map[string]int{"a": 1}, map[string]int{"b": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Change
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshaling %s: %v", data, err)
+	}
+	want := []Change{
+		{Path: `["a"]`, Kind: Removed, From: "1"},
+		{Path: `["b"]`, Kind: Added, To: "1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON = %s", data)
+	}
+
+	if data, _ := JSON(nil); string(data) != "[]" {
+		t.Errorf("JSON(nil) = %s, want []", data)
+	}
+}
+
+func TestFdisplayCycle(t *testing.T) {
+	m := strangelove()
+	m.Sequel = m
+
+	var buf bytes.Buffer
+	Fdisplay(&buf, "m", m)
+
+	out := buf.String()
+	if !strings.Contains(out, "(*m).Sequel = CYCLE\n") {
+		t.Errorf("Fdisplay doesn't report the cycle:\n%s", out)
+	}
+
+	// The state isn't shared between the calls
+	var again bytes.Buffer
+	Fdisplay(&again, "m", m)
+	if again.String() != out {
+		t.Errorf("second Fdisplay differs:\n%s\nfirst:\n%s", again.String(), out)
+	}
+}
@@ -0,0 +1,645 @@
+package sexpr
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strconv"
+	"text/scanner"
+)
+
+// ---- lexer ----
+
+// lexer is used to read a sequence of []byte data in order to decode this byte sequence.
+// It scans a token only when it's asked for, so a decoder doesn't scan the token following a complete value.
+type lexer struct {
+	scan scanner.Scanner
+	src  *errReader
+
+	// the current lookahead token and its position, valid if peeked is true
+	token  rune
+	pos    scanner.Position
+	peeked bool
+
+	// the number of the consumed tokens
+	consumed int
+
+	// the number of the lists being read or written, see enter
+	depth int
+
+	// the first error reported by the scanner
+	err error
+}
+
+// errReader keeps the first non-EOF error of r, because the scanner reports read errors only as messages
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && err != io.EOF && er.err == nil {
+		er.err = err
+	}
+	return n, err
+}
+
+func newLexer(r io.Reader) *lexer {
+	lex := &lexer{src: &errReader{r: r}}
+
+	lex.scan.Init(lex.src)
+	lex.scan.Mode = scanner.GoTokens
+	lex.scan.Error = func(s *scanner.Scanner, msg string) {
+		if lex.err == nil {
+			lex.err = &SyntaxError{Msg: msg, Pos: s.Pos()}
+		}
+	}
+
+	return lex
+}
+
+// peek scans the next token, if it hasn't been scanned yet, and returns it without consuming
+func (lex *lexer) peek() rune {
+	if lex.peeked {
+		return lex.token
+	}
+
+	lex.token = lex.scan.Scan()
+	lex.pos = lex.scan.Position
+	lex.peeked = true
+
+	// The end of input has no token position, so report the position after the last character
+	if lex.token == scanner.EOF {
+		lex.pos = lex.scan.Pos()
+	}
+
+	if lex.src.err != nil {
+		panic(lex.src.err)
+	}
+	if lex.err != nil {
+		panic(lex.err)
+	}
+
+	return lex.token
+}
+
+// maxDepth bounds the nesting of the lists read and written recursively, as encoding/json does
+const maxDepth = 10000
+
+// enter opens a list, the nesting beyond maxDepth fails with a syntax error rather than overflows the stack
+func (lex *lexer) enter() {
+	if lex.depth++; lex.depth > maxDepth {
+		lex.fail("exceeded max depth %d", maxDepth)
+	}
+}
+
+// leave closes the list opened by enter
+func (lex *lexer) leave() {
+	lex.depth--
+}
+
+// next consumes the current token
+func (lex *lexer) next() {
+	lex.peek()
+	lex.peeked = false
+	lex.consumed++
+}
+
+// text returns the text of the current token
+func (lex *lexer) text() string {
+	return lex.scan.TokenText()
+}
+
+// consume checks whether the current token is the wanted one,
+// if so, it calls lex.next(), otherwise it panics with a syntax error
+func (lex *lexer) consume(want rune) {
+	if lex.peek() != want {
+		lex.fail("got %s, want %q", lex.describe(), want)
+	}
+
+	lex.next()
+}
+
+// describe returns a string describing the current token, for use in errors
+func (lex *lexer) describe() string {
+	switch lex.peek() {
+	case scanner.EOF:
+		return "end of input"
+	case scanner.Ident:
+		return fmt.Sprintf("symbol %s", lex.text())
+	case scanner.String, scanner.RawString:
+		return fmt.Sprintf("string %s", lex.text())
+	case scanner.Int, scanner.Float:
+		return fmt.Sprintf("number %s", lex.text())
+	case scanner.Char:
+		return fmt.Sprintf("character %s", lex.text())
+	}
+	return fmt.Sprintf("%q", lex.token)
+}
+
+// fail panics with a syntax error at the current token
+func (lex *lexer) fail(format string, args ...any) {
+	lex.peek()
+	panic(&SyntaxError{Msg: fmt.Sprintf(format, args...), Pos: lex.pos})
+}
+
+func (lex *lexer) typeError(value string, t reflect.Type) {
+	lex.peek()
+	panic(&UnmarshalTypeError{Value: value, Type: t, Pos: lex.pos})
+}
+
+// Converts the errors the decoding panics with into the returned error, runtime errors are resumed
+func catchError(err *error) {
+	x := recover()
+	if x == nil {
+		return
+	}
+
+	if e, ok := x.(error); ok {
+		if _, isRuntime := e.(runtime.Error); !isRuntime {
+			*err = e
+			return
+		}
+	}
+	panic(x)
+}
+
+// ---- decoder ----
+
+// Unmarshal parses S-expression data and populates the variable
+// whose address is in the non-nil pointer out.
+func Unmarshal(data []byte, out interface{}) error {
+	dec := NewDecoder(bytes.NewReader(data))
+
+	err := dec.Decode(out)
+	if errors.Is(err, io.EOF) {
+		return &SyntaxError{Msg: "unexpected end of input", Pos: dec.lex.pos}
+	}
+	if err != nil {
+		return err
+	}
+
+	return dec.expectEOF()
+}
+
+// Decoder reads S-expression values from an input stream one after another
+type Decoder struct {
+	lex *lexer
+	err error
+
+	// the number of the lists opened by Token and not closed yet
+	depth int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{lex: newLexer(r)}
+}
+
+// Decode reads the next value from the input and stores it in the value pointed to by v.
+// It returns io.EOF when there are no more values. After any other error the decoder is unusable.
+func (dec *Decoder) Decode(v any) (err error) {
+	if dec.err != nil {
+		return dec.err
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("sexpr: Decode(non-pointer %T)", v)
+	}
+
+	defer func() {
+		if err != nil && !errors.Is(err, io.EOF) {
+			dec.err = err
+		}
+	}()
+	defer catchError(&err)
+
+	if dec.lex.peek() == scanner.EOF {
+		return io.EOF
+	}
+
+	read(dec.lex, rv.Elem())
+	return nil
+}
+
+// More reports whether there is another value in the input or in the list being read with Token
+func (dec *Decoder) More() (more bool) {
+	if dec.err != nil {
+		return false
+	}
+
+	// The error will be reported by the next call of Decode
+	defer func() {
+		if recover() != nil {
+			more = true
+		}
+	}()
+
+	token := dec.lex.peek()
+	return token != scanner.EOF && token != ')'
+}
+
+func (dec *Decoder) expectEOF() (err error) {
+	defer catchError(&err)
+
+	if dec.lex.peek() != scanner.EOF {
+		dec.lex.fail("unexpected %s after top-level value", dec.lex.describe())
+	}
+	return nil
+}
+
+/*
+Reads a single value into v.
+
+1) Let the Unmarshaler or TextUnmarshaler of v decode the value, if v implements one of them
+2) Set nil to pointers, slices, maps and interfaces, false to booleans, and the zero value to the rest
+3) Otherwise decode the value depending on its first token
+*/
+func read(lex *lexer, v reflect.Value) {
+	const (
+		nilStr  = "nil"
+		trueStr = "t"
+	)
+
+	isNil := lex.peek() == scanner.Ident && lex.text() == nilStr
+
+	u, tu, v := indirect(v, isNil)
+	if u != nil {
+		var buf bytes.Buffer
+		writeValue(lex, &buf)
+		if err := u.UnmarshalSExpr(buf.Bytes()); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if isNil {
+		v.Set(reflect.Zero(v.Type()))
+		lex.next()
+		return
+	}
+
+	if tu != nil {
+		if token := lex.peek(); token != scanner.String && token != scanner.RawString {
+			lex.typeError(lex.describe(), reflect.TypeOf(tu))
+		}
+		s, _ := strconv.Unquote(lex.text())
+		if err := tu.UnmarshalText([]byte(s)); err != nil {
+			panic(err)
+		}
+		lex.next()
+		return
+	}
+
+	switch lex.peek() {
+	case scanner.Ident:
+		// The only valid identifiers besides "nil" are "t"
+		// and struct field names, that are read by readList.
+		if lex.text() != trueStr {
+			lex.fail("unexpected %s", lex.describe())
+		}
+		if v.Kind() != reflect.Bool {
+			lex.typeError(lex.describe(), v.Type())
+		}
+		v.SetBool(true)
+		lex.next()
+	case scanner.String, scanner.RawString:
+		if v.Kind() != reflect.String {
+			lex.typeError("string", v.Type())
+		}
+		// Returns the natural string value without any quotes
+		s, err := strconv.Unquote(lex.text())
+		if err != nil {
+			lex.fail("%s", err)
+		}
+		v.SetString(s)
+		lex.next()
+	case scanner.Int, scanner.Float, '-':
+		readNumber(lex, v)
+	// #C(real imag)
+	case '#':
+		lex.next()
+		if lex.peek() != scanner.Ident || lex.text() != "C" {
+			lex.fail("got %s after '#', want C", lex.describe())
+		}
+		if v.Kind() != reflect.Complex64 && v.Kind() != reflect.Complex128 {
+			lex.typeError("complex", v.Type())
+		}
+		lex.next()
+		lex.consume('(')
+		re, _ := readFloat(lex, v.Type().Bits()/2)
+		im, _ := readFloat(lex, v.Type().Bits()/2)
+		lex.consume(')')
+		v.SetComplex(complex(re, im))
+	case '(':
+		lex.enter()
+		lex.next()
+		// Examines the list items
+		readList(lex, v)
+		lex.consume(')')
+		lex.leave()
+	default:
+		lex.fail("unexpected %s", lex.describe())
+	}
+}
+
+/*
+Walks down v allocating the nil pointers until it gets to a non-pointer value. If it encounters an Unmarshaler or
+a TextUnmarshaler, it stops and returns it. If decodingNil is true, it stops at the last settable pointer, so that
+the pointer can be set to nil.
+*/
+func indirect(v reflect.Value, decodingNil bool) (Unmarshaler, encoding.TextUnmarshaler, reflect.Value) {
+	var (
+		v0       = v
+		haveAddr bool
+	)
+
+	// Start with the address of a named value, so that the methods with pointer receivers are found
+	if v.Kind() != reflect.Pointer && v.Type().Name() != "" && v.CanAddr() {
+		haveAddr = true
+		v = v.Addr()
+	}
+
+	for v.Kind() == reflect.Pointer {
+		if decodingNil && v.CanSet() {
+			break
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		if v.Type().NumMethod() > 0 && v.CanInterface() {
+			if u, ok := v.Interface().(Unmarshaler); ok {
+				return u, nil, reflect.Value{}
+			}
+			if tu, ok := v.Interface().(encoding.TextUnmarshaler); ok && !decodingNil {
+				return nil, tu, reflect.Value{}
+			}
+		}
+
+		if haveAddr {
+			v, haveAddr = v0, false
+		} else {
+			v = v.Elem()
+		}
+	}
+
+	return nil, nil, v
+}
+
+// Reads and consumes a possibly negative number into an integer or a floating point v
+func readNumber(lex *lexer, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		text := readNumberText(lex)
+		i, err := strconv.ParseInt(text, 0, 64)
+		if err != nil || v.OverflowInt(i) {
+			lex.typeError("number "+text, v.Type())
+		}
+		v.SetInt(i)
+		lex.next()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		text := readNumberText(lex)
+		u, err := strconv.ParseUint(text, 0, 64)
+		if err != nil || v.OverflowUint(u) {
+			lex.typeError("number "+text, v.Type())
+		}
+		v.SetUint(u)
+		lex.next()
+	case reflect.Float32, reflect.Float64:
+		f, text := readFloat(lex, v.Type().Bits())
+		if v.OverflowFloat(f) {
+			lex.typeError("number "+text, v.Type())
+		}
+		v.SetFloat(f)
+	default:
+		lex.typeError("number", v.Type())
+	}
+}
+
+// Returns the text of the current number token with its sign, leaving the token unconsumed
+func readNumberText(lex *lexer) string {
+	var sign string
+	if lex.peek() == '-' {
+		sign = "-"
+		lex.next()
+	}
+
+	if token := lex.peek(); token != scanner.Int && token != scanner.Float {
+		lex.fail("got %s, want number", lex.describe())
+	}
+
+	return sign + lex.text()
+}
+
+// Reads and consumes a floating point number
+func readFloat(lex *lexer, bitSize int) (float64, string) {
+	text := readNumberText(lex)
+
+	f, err := strconv.ParseFloat(text, bitSize)
+	if err != nil {
+		lex.fail("invalid number %s", text)
+	}
+	lex.next()
+
+	return f, text
+}
+
+// readList reads the list items into v, the opening parenthesis is already consumed
+func readList(lex *lexer, v reflect.Value) {
+	switch v.Kind() {
+	// (item ...)
+	case reflect.Array:
+		var i int
+		for ; !endList(lex); i++ {
+			if i < v.Len() {
+				read(lex, v.Index(i))
+				continue
+			}
+			skipValue(lex)
+		}
+		// Zero the rest of the array
+		for ; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+	// (item ...)
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), 0, 0)
+		for !endList(lex) {
+			// Get an addressable zero value of the element type
+			item := reflect.New(v.Type().Elem()).Elem()
+			read(lex, item)
+			slice = reflect.Append(slice, item)
+		}
+		v.Set(slice)
+	// ((name value) ...)
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		for !endList(lex) {
+			lex.consume('(')
+			name := readFieldName(lex)
+			// Unknown fields are skipped
+			if i, ok := fields.byName[name]; ok {
+				read(lex, v.Field(fields.list[i].index))
+			} else {
+				skipValue(lex)
+			}
+			lex.consume(')')
+		}
+	// ((key value) ...)
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for !endList(lex) {
+			lex.consume('(')
+			// Get the addressable key and value
+			key := reflect.New(v.Type().Key()).Elem()
+			read(lex, key)
+			value := reflect.New(v.Type().Elem()).Elem()
+			read(lex, value)
+			// Put the pair key/value into the map
+			v.SetMapIndex(key, value)
+			lex.consume(')')
+		}
+	// ("type" value)
+	case reflect.Interface:
+		if lex.peek() != scanner.String {
+			lex.fail("got %s, want type name", lex.describe())
+		}
+		name, _ := strconv.Unquote(lex.text())
+		t, ok := typeByName(name)
+		if !ok {
+			lex.fail("unregistered type %q", name)
+		}
+		if !t.AssignableTo(v.Type()) {
+			lex.typeError("value of type "+name, v.Type())
+		}
+		lex.next()
+
+		value := reflect.New(t).Elem()
+		read(lex, value)
+		v.Set(value)
+	default:
+		lex.typeError("list", v.Type())
+	}
+}
+
+// Struct field names are written as symbols, or as strings if they aren't identifiers
+func readFieldName(lex *lexer) string {
+	var name string
+
+	switch lex.peek() {
+	case scanner.Ident:
+		name = lex.text()
+	case scanner.String:
+		name, _ = strconv.Unquote(lex.text())
+	default:
+		lex.fail("got %s, want field name", lex.describe())
+	}
+	lex.next()
+
+	return name
+}
+
+// endList reports whether the current token closes the list
+// or panics if the input has ended
+func endList(lex *lexer) bool {
+	switch lex.peek() {
+	case scanner.EOF:
+		lex.fail("unexpected end of input, want ')'")
+	case ')':
+		return true
+	}
+	return false
+}
+
+// skipValue consumes a single value keeping only the depth of its lists, so a value of any size is skipped in constant
+// memory. The tokens are checked as writeValue checks them, their text isn't taken
+func skipValue(lex *lexer) {
+	for depth := 0; ; {
+		switch token := lex.peek(); {
+		case token == '(':
+			depth++
+			lex.next()
+			continue
+		case token == ')' && depth > 0:
+			depth--
+		case token == scanner.EOF && depth > 0:
+			lex.fail("unexpected end of input, want ')'")
+		case token == '-':
+			lex.next()
+			if token := lex.peek(); token != scanner.Int && token != scanner.Float {
+				lex.fail("got %s, want number", lex.describe())
+			}
+		case token == '#':
+			lex.next()
+			if lex.peek() != scanner.Ident || lex.text() != "C" {
+				lex.fail("got %s after '#', want C", lex.describe())
+			}
+			// The list of the parts follows
+			lex.next()
+			continue
+		case token == scanner.String, token == scanner.RawString,
+			token == scanner.Ident, token == scanner.Int, token == scanner.Float:
+		default:
+			lex.fail("unexpected %s", lex.describe())
+		}
+
+		lex.next()
+		if depth == 0 {
+			return
+		}
+	}
+}
+
+/*
+Consumes a single value and writes its compact form to buf: the list items are separated by single spaces and
+the strings are normalized to the double-quoted form.
+*/
+func writeValue(lex *lexer, buf *bytes.Buffer) {
+	switch lex.peek() {
+	case '(':
+		lex.enter()
+		buf.WriteByte('(')
+		lex.next()
+		for first := true; !endList(lex); first = false {
+			if !first {
+				buf.WriteByte(' ')
+			}
+			writeValue(lex, buf)
+		}
+		lex.next()
+		buf.WriteByte(')')
+		lex.leave()
+	case '-':
+		buf.WriteString(readNumberText(lex))
+		lex.next()
+	case '#':
+		lex.next()
+		if lex.peek() != scanner.Ident || lex.text() != "C" {
+			lex.fail("got %s after '#', want C", lex.describe())
+		}
+		lex.next()
+		buf.WriteString("#C")
+		writeValue(lex, buf)
+	case scanner.String, scanner.RawString:
+		s, err := strconv.Unquote(lex.text())
+		if err != nil {
+			lex.fail("%s", err)
+		}
+		buf.WriteString(strconv.Quote(s))
+		lex.next()
+	case scanner.Ident, scanner.Int, scanner.Float:
+		buf.WriteString(lex.text())
+		lex.next()
+	default:
+		lex.fail("unexpected %s", lex.describe())
+	}
+}
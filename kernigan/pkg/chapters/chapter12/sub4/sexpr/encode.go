@@ -0,0 +1,387 @@
+package sexpr
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode"
+	"unsafe"
+)
+
+type empty struct{}
+
+// encodeState is the state of a single Marshal call, so concurrent calls don't share anything
+type encodeState struct {
+	bytes.Buffer
+
+	// The pointers, maps and slices on the current encoding path, used to detect cycles
+	ptrSeen map[ptrKey]empty
+}
+
+/*
+Identifies a pointer, map or slice value. The type is a part of the key, because a struct and its first field share
+the address, and the length is, because slices of different lengths may share the array.
+*/
+type ptrKey struct {
+	ptr unsafe.Pointer
+	typ reflect.Type
+	len int
+}
+
+func newEncodeState() *encodeState {
+	return &encodeState{ptrSeen: make(map[ptrKey]empty)}
+}
+
+// Marshal returns the compact S-expression encoding of v
+func Marshal(v interface{}) ([]byte, error) {
+	e := newEncodeState()
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// MarshalIndent is like Marshal but puts the nested lists on separate lines, see Indent
+func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	b, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := Indent(&buf, b, prefix, indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encoder writes S-expression values to an output stream, one value per line
+type Encoder struct {
+	w              io.Writer
+	prefix, indent string
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetIndent makes the encoder format every value as if it was indented by Indent
+func (enc *Encoder) SetIndent(prefix, indent string) {
+	enc.prefix, enc.indent = prefix, indent
+}
+
+// Encode writes the S-expression encoding of v followed by a newline
+func (enc *Encoder) Encode(v any) error {
+	var (
+		b   []byte
+		err error
+	)
+
+	if enc.prefix == "" && enc.indent == "" {
+		b, err = Marshal(v)
+	} else {
+		b, err = MarshalIndent(v, enc.prefix, enc.indent)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = enc.w.Write(append(b, '\n'))
+	return err
+}
+
+func (e *encodeState) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.WriteString("nil")
+		return nil
+	}
+
+	if handled, err := e.encodeMarshaler(v); handled {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.WriteString(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return e.encodeFloat(v.Float(), v.Type().Bits())
+	// #C(real imag)
+	case reflect.Complex64, reflect.Complex128:
+		var (
+			c    = v.Complex()
+			bits = v.Type().Bits() / 2
+		)
+		e.WriteString("#C(")
+		if err := e.encodeFloat(real(c), bits); err != nil {
+			return err
+		}
+		e.WriteByte(' ')
+		if err := e.encodeFloat(imag(c), bits); err != nil {
+			return err
+		}
+		e.WriteByte(')')
+	case reflect.String:
+		e.WriteString(strconv.Quote(v.String()))
+	case reflect.Bool:
+		if v.Bool() {
+			e.WriteString("t")
+			break
+		}
+		e.WriteString("nil")
+	case reflect.Pointer:
+		if v.IsNil() {
+			e.WriteString("nil")
+			break
+		}
+		return e.withCycleCheck(v, func() error {
+			return e.encode(v.Elem())
+		})
+	// ("type" value)
+	case reflect.Interface:
+		if v.IsNil() {
+			e.WriteString("nil")
+			break
+		}
+		e.WriteByte('(')
+		e.WriteString(strconv.Quote(typeName(v.Elem().Type())))
+		e.WriteByte(' ')
+		if err := e.encode(v.Elem()); err != nil {
+			return err
+		}
+		e.WriteByte(')')
+	// (value ...)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.WriteString("nil")
+			break
+		}
+		return e.withCycleCheck(v, func() error {
+			return e.encodeArray(v)
+		})
+	case reflect.Array:
+		return e.encodeArray(v)
+	// ((name value) ...)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	// ((key value) ...)
+	case reflect.Map:
+		if v.IsNil() {
+			e.WriteString("nil")
+			break
+		}
+		return e.withCycleCheck(v, func() error {
+			return e.encodeMap(v)
+		})
+	default:
+		return fmt.Errorf("sexpr: unsupported type: %s", v.Type())
+	}
+
+	return nil
+}
+
+/*
+Encodes v with its MarshalSExpr or MarshalText method, if any, and reports whether it did.
+
+1) Take the pointer receiver methods into account when v is addressable
+2) Encode nil pointers as nil without calling their methods
+3) Validate and compact the output of MarshalSExpr, quote the output of MarshalText
+*/
+func (e *encodeState) encodeMarshaler(v reflect.Value) (bool, error) {
+	t := v.Type()
+	if t.Kind() != reflect.Pointer && v.CanAddr() &&
+		(reflect.PointerTo(t).Implements(marshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)) {
+		v = v.Addr()
+		t = v.Type()
+	}
+
+	var (
+		isMarshaler     = t.Implements(marshalerType)
+		isTextMarshaler = t.Implements(textMarshalerType)
+	)
+	if !isMarshaler && !isTextMarshaler {
+		return false, nil
+	}
+
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		e.WriteString("nil")
+		return true, nil
+	}
+
+	if isMarshaler {
+		b, err := v.Interface().(Marshaler).MarshalSExpr()
+		if err != nil {
+			return true, fmt.Errorf("sexpr: error calling MarshalSExpr for type %s: %w", t, err)
+		}
+		if err := compact(&e.Buffer, b); err != nil {
+			return true, fmt.Errorf("sexpr: error calling MarshalSExpr for type %s: %w", t, err)
+		}
+		return true, nil
+	}
+
+	text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return true, fmt.Errorf("sexpr: error calling MarshalText for type %s: %w", t, err)
+	}
+	e.WriteString(strconv.Quote(string(text)))
+
+	return true, nil
+}
+
+// Encodes v with encodeFn unless v is already being encoded higher on the current path
+func (e *encodeState) withCycleCheck(v reflect.Value, encodeFn func() error) error {
+	key := ptrKey{ptr: v.UnsafePointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+
+	if _, ok := e.ptrSeen[key]; ok {
+		return fmt.Errorf("sexpr: encountered a cycle via %s", v.Type())
+	}
+
+	e.ptrSeen[key] = empty{}
+	defer delete(e.ptrSeen, key)
+
+	return encodeFn()
+}
+
+func (e *encodeState) encodeFloat(f float64, bits int) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("sexpr: unsupported value: %s", strconv.FormatFloat(f, 'g', -1, bits))
+	}
+
+	e.WriteString(strconv.FormatFloat(f, 'g', -1, bits))
+	return nil
+}
+
+func (e *encodeState) encodeArray(v reflect.Value) error {
+	e.WriteByte('(')
+	for i := 0; i < v.Len(); i++ {
+		if i > 0 {
+			e.WriteByte(' ')
+		}
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	e.WriteByte(')')
+
+	return nil
+}
+
+func (e *encodeState) encodeStruct(v reflect.Value) error {
+	var written int
+
+	e.WriteByte('(')
+	for _, f := range cachedFields(v.Type()).list {
+		fv := v.Field(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+
+		if written > 0 {
+			e.WriteByte(' ')
+		}
+		written++
+
+		e.WriteByte('(')
+		e.writeSymbol(f.name)
+		e.WriteByte(' ')
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+		e.WriteByte(')')
+	}
+	e.WriteByte(')')
+
+	return nil
+}
+
+// Encodes the map pairs sorted by the encoded keys, so the output is deterministic
+func (e *encodeState) encodeMap(v reflect.Value) error {
+	type pair struct {
+		key, value []byte
+	}
+
+	var (
+		pairs = make([]pair, 0, v.Len())
+		sub   = &encodeState{ptrSeen: e.ptrSeen}
+	)
+
+	iter := v.MapRange()
+	for iter.Next() {
+		if err := sub.encode(iter.Key()); err != nil {
+			return err
+		}
+		keyLen := sub.Len()
+		if err := sub.encode(iter.Value()); err != nil {
+			return err
+		}
+
+		b := bytes.Clone(sub.Bytes())
+		pairs = append(pairs, pair{key: b[:keyLen], value: b[keyLen:]})
+		sub.Reset()
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return bytes.Compare(pairs[i].key, pairs[j].key) < 0
+	})
+
+	e.WriteByte('(')
+	for i, p := range pairs {
+		if i > 0 {
+			e.WriteByte(' ')
+		}
+		e.WriteByte('(')
+		e.Write(p.key)
+		e.WriteByte(' ')
+		e.Write(p.value)
+		e.WriteByte(')')
+	}
+	e.WriteByte(')')
+
+	return nil
+}
+
+// Writes the field name as a symbol if it's an identifier, otherwise as a string
+func (e *encodeState) writeSymbol(name string) {
+	if isIdent(name) {
+		e.WriteString(name)
+		return
+	}
+	e.WriteString(strconv.Quote(name))
+}
+
+func isIdent(s string) bool {
+	if s == "" || s == "nil" || s == "t" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+
+	return false
+}
@@ -0,0 +1,129 @@
+package sexpr
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/scanner"
+)
+
+// node is a parsed S-expression: either an atom with its compact text or a list
+type node struct {
+	atom   string
+	list   []node
+	isList bool
+}
+
+// Parses a single value, the atoms are kept in their compact form
+func parseNode(lex *lexer) node {
+	if lex.peek() != '(' {
+		var buf bytes.Buffer
+		writeValue(lex, &buf)
+		return node{atom: buf.String()}
+	}
+
+	lex.next()
+	n := node{isList: true}
+	for !endList(lex) {
+		n.list = append(n.list, parseNode(lex))
+	}
+	lex.next()
+
+	return n
+}
+
+// Reports whether the list contains a non-empty sublist
+func (n node) hasSublists() bool {
+	for _, item := range n.list {
+		if item.isList && len(item.list) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compact appends the compact form of the single S-expression value in src to dst
+func compact(dst *bytes.Buffer, src []byte) (err error) {
+	defer catchError(&err)
+
+	lex := newLexer(bytes.NewReader(src))
+	if lex.peek() == scanner.EOF {
+		lex.fail("unexpected end of input")
+	}
+
+	var buf bytes.Buffer
+	writeValue(lex, &buf)
+	if lex.peek() != scanner.EOF {
+		lex.fail("unexpected %s after top-level value", lex.describe())
+	}
+
+	dst.Write(buf.Bytes())
+	return nil
+}
+
+/*
+Indent appends to dst an indented form of the S-expression value in src.
+
+The items of a list that contains other lists are put on separate lines, each line begins with prefix followed by
+one copy of indent per nesting level. The only exception is the second item of a list starting with an atom, e.g. a
+struct field or a typed value, it stays on the line of the atom. The lists of atoms are kept on a single line:
+
+	((Title "Dr. Strangelove")
+	  (Year 1964)
+	  (Oscars ("Best Actor (Nomin.)" "Best Adapted Screenplay (Nomin.)")))
+*/
+func Indent(dst *bytes.Buffer, src []byte, prefix, indent string) (err error) {
+	defer catchError(&err)
+
+	lex := newLexer(bytes.NewReader(src))
+	if lex.peek() == scanner.EOF {
+		lex.fail("unexpected end of input")
+	}
+
+	root := parseNode(lex)
+	if lex.peek() != scanner.EOF {
+		lex.fail("unexpected %s after top-level value", lex.describe())
+	}
+
+	writeIndented(dst, root, prefix, indent, 0)
+	return nil
+}
+
+func writeIndented(dst *bytes.Buffer, n node, prefix, indent string, depth int) {
+	if !n.isList {
+		dst.WriteString(n.atom)
+		return
+	}
+
+	breakLines := n.hasSublists()
+
+	dst.WriteByte('(')
+	for i, item := range n.list {
+		switch {
+		case i == 0:
+		case breakLines && !(i == 1 && !n.list[0].isList):
+			dst.WriteByte('\n')
+			dst.WriteString(prefix)
+			dst.WriteString(strings.Repeat(indent, depth+1))
+		default:
+			dst.WriteByte(' ')
+		}
+		writeIndented(dst, item, prefix, indent, depth+1)
+	}
+	dst.WriteByte(')')
+}
+
+// Print prints the indented S-expression p to the standard output
+func Print(p []byte) {
+	const (
+		indent = "  "
+	)
+
+	var buf bytes.Buffer
+	if err := Indent(&buf, p, "", indent); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(buf.String())
+}
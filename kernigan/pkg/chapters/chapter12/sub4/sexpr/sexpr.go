@@ -1,401 +1,213 @@
+// Package sexpr encodes and decodes Go values as S-expressions.
+//
+// The mapping between Go values and S-expressions:
+//
+//	nil pointer, slice, map, interface  nil
+//	bool                                t or nil
+//	integer, float                      42, -1.5, 1e+06
+//	complex                             #C(1 -2)
+//	string                              "text"
+//	array, slice                        (item ...)
+//	struct                              ((Name value) ...)
+//	map                                 ((key value) ...)
+//	interface                           ("type" value)
+//
+// Struct fields are named after the Go field name unless the field has a tag `sexpr:"name,omitempty"`. The "-" tag
+// name skips the field, omitempty skips it when it holds a zero value. Types implementing Marshaler/Unmarshaler or
+// encoding.TextMarshaler/TextUnmarshaler (e.g. time.Time) encode themselves.
+//
+// The dynamic types of interface values are looked up by name in a registry, see Register.
 package sexpr
 
 import (
-	"bytes"
+	"encoding"
 	"fmt"
-	"io"
 	"reflect"
-	"strconv"
+	"strings"
+	"sync"
 	"text/scanner"
 )
 
-type empty struct{}
+// Marshaler is implemented by types that can marshal themselves into a valid S-expression.
+type Marshaler interface {
+	MarshalSExpr() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that can unmarshal an S-expression of themselves.
+// The input is a compact encoding of a single value.
+type Unmarshaler interface {
+	UnmarshalSExpr([]byte) error
+}
 
 var (
-	repetitions map[reflect.Value]empty
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 )
 
-func init() {
-	repetitions = make(map[reflect.Value]empty)
+// SyntaxError describes malformed S-expression input
+type SyntaxError struct {
+	Msg string
+	// The position of the offending token
+	Pos scanner.Position
 }
 
-func isPresented(val reflect.Value) bool {
-	if _, ok := repetitions[val]; ok {
-		return true
-	}
-	return false
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("sexpr: syntax error at %s: %s", positionString(e.Pos), e.Msg)
 }
 
-func encode(buf *bytes.Buffer, v reflect.Value) error {
-	var (
-		kind = v.Kind()
-	)
-
-	switch kind {
-	// Invalid
-	case reflect.Invalid:
-		buf.WriteString("nil")
-		// Ints
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		fmt.Fprintf(buf, "%d", v.Int())
-		// Uints
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		fmt.Fprintf(buf, "%d", v.Uint())
-		// Float
-	case reflect.Float32, reflect.Float64:
-		fmt.Fprintf(buf, "%.5f", v.Float())
-		// Complex
-	case reflect.Complex64, reflect.Complex128:
-		compl := v.Complex()
-		fmt.Fprintf(buf, "#C (%.5f %.5f)", real(compl), imag(compl))
-	case reflect.String:
-		// String
-		fmt.Fprintf(buf, "%q", v.String())
-		// Bool
-	case reflect.Bool:
-		val := v.Bool()
-		if val {
-			fmt.Fprint(buf, "t")
-			break
-		}
-		fmt.Fprint(buf, "nil")
-		// Pointer
-	case reflect.Ptr:
-		if isPresented(v) {
-			delete(repetitions, v)
-			return nil
-		}
-		repetitions[v] = empty{}
-		return encode(buf, v.Elem())
-	case reflect.Interface:
-		if isPresented(v) {
-			delete(repetitions, v)
-			return nil
-		}
-		repetitions[v] = empty{}
-
-		if v.IsNil() {
-			return fmt.Errorf("nil interface value")
-		}
-		buf.WriteByte('(')
-		fmt.Fprintf(buf, "%q ", v.Type().Name())
-		if err := encode(buf, v.Elem()); err != nil {
-			return err
-		}
-		buf.WriteByte(')')
-		// Array/Slice (value ...)
-	case reflect.Array, reflect.Slice:
-		if v.Kind() == reflect.Slice {
-			if isPresented(v) {
-				delete(repetitions, v)
-				return nil
-			}
-			repetitions[v] = empty{}
-		}
-
-		buf.WriteByte('(')
-		for i := 0; i < v.Len(); i++ {
-			if i > 0 {
-				buf.WriteByte(' ')
-			}
-			if err := encode(buf, v.Index(i)); err != nil {
-				return err
-			}
-		}
-		buf.WriteByte(')')
-		// Struct ((name value) ...)
-	case reflect.Struct:
-		buf.WriteByte('(')
-		for i := 0; i < v.NumField(); i++ {
-			if i > 0 {
-				buf.WriteByte(' ')
-			}
-			fmt.Fprintf(buf, "(%s ", v.Type().Field(i).Name)
-			if err := encode(buf, v.Field(i)); err != nil {
-				return err
-			}
-			buf.WriteByte(')')
-
-		}
-		buf.WriteByte(')')
-
-		// Map ((key value) ...)
-	case reflect.Map:
-
-		buf.WriteByte('(')
-		for i, key := range v.MapKeys() {
-			if i > 0 {
-				buf.WriteByte(' ')
-			}
-			buf.WriteByte('(')
-
-			if err := encode(buf, key); err != nil {
-				return err
-			}
-			buf.WriteByte(' ')
-			if err := encode(buf, v.MapIndex(key)); err != nil {
-				return err
-			}
+// UnmarshalTypeError describes an S-expression value that can't be stored into a Go value of the given type
+type UnmarshalTypeError struct {
+	// The description of the S-expression value, e.g. "string" or "list"
+	Value string
+	Type  reflect.Type
+	Pos   scanner.Position
+}
 
-			buf.WriteByte(')')
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("sexpr: cannot unmarshal %s into Go value of type %s at %s", e.Value, e.Type, positionString(e.Pos))
+}
 
-		}
-		buf.WriteByte(')')
-	default:
-		return fmt.Errorf("unsupported type: %s", v.Type().String())
-	}
-	return nil
+// Returns "line:column", scanner.Position.String prepends "<input>" to it for the unnamed inputs
+func positionString(pos scanner.Position) string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
 }
 
-func Marshal(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	if err := encode(&buf, reflect.ValueOf(v)); err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+// ---- type registry ----
+
+var registry = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
 }
 
-func Print(p []byte) {
-	const (
-		scale                     = 2
-		doubleOpenBrackets        = "(("
-		intendeddifferentBrackets = ")\n"
-		doubleCloseBrackets       = "))"
-
-		closeBracket = ")"
-		openBracket  = "("
-	)
-	var (
-		pair string
-
-		depth = 0
-	)
-
-	p = bytes.ReplaceAll(p, []byte(") ("), []byte(")\n("))
-
-	for i := 0; i < len(p)-1; i++ {
-		pair = string(p[i]) + string(p[i+1])
-
-		switch pair {
-		case doubleOpenBrackets:
-			fmt.Print(doubleOpenBrackets)
-			depth++
-			i++
-		case intendeddifferentBrackets:
-			fmt.Println(closeBracket)
-			fmt.Printf("%*s", scale*depth, "")
-			i++
-		case doubleCloseBrackets:
-			fmt.Printf("%s\n", doubleCloseBrackets)
-			depth--
-			i++
-		default:
-			fmt.Print(string(p[i]))
-		}
+func init() {
+	for _, value := range []any{
+		false, "",
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+		float32(0), float64(0), complex64(0), complex128(0),
+	} {
+		Register(value)
 	}
 }
 
-// lexer is used to read a sequence of []byte data in order to decode this byte sequence
-// it stores a current token has read
-type lexer struct {
-	scan scanner.Scanner
-	// the current token
-	token rune
+// Register records the dynamic type of value under its type string (e.g. "main.Movie" or "[]int"), so that interface
+// values holding this type can be decoded. The basic types are registered by default.
+func Register(value any) {
+	RegisterName(reflect.TypeOf(value).String(), value)
 }
 
-// next advances the scanner and puts a new token into lex.token field
-func (lex *lexer) next() {
-	lex.token = lex.scan.Scan()
-}
+// RegisterName is like Register but uses the given name for the type. It panics if the name or the type have
+// already been registered differently.
+func RegisterName(name string, value any) {
+	if name == "" {
+		panic("sexpr: attempt to register empty name")
+	}
+	t := reflect.TypeOf(value)
 
-// text returns current text in the scanner
-func (lex *lexer) text() string {
-	return lex.scan.TokenText()
-}
+	registry.Lock()
+	defer registry.Unlock()
 
-// consume checks whether the scanner includes the wanted token
-// if so, it calls lex.next(), otherswise it panics
-func (lex *lexer) consume(want rune) {
-	// NOTE: Not an example of good error handling
-	if lex.token != want {
-		panic(fmt.Sprintf("got %q, want %q", lex.text(), want))
+	if registered, ok := registry.byName[name]; ok && registered != t {
+		panic(fmt.Sprintf("sexpr: registering duplicate types for %q: %s != %s", name, registered, t))
+	}
+	if registered, ok := registry.byType[t]; ok && registered != name {
+		panic(fmt.Sprintf("sexpr: registering duplicate names for %s: %q != %q", t, registered, name))
 	}
 
-	lex.next()
+	registry.byName[name] = t
+	registry.byType[t] = name
 }
 
-func read(lex *lexer, v reflect.Value) {
-
-	const (
-		nilStr = "nil"
-	)
-
-	switch lex.token {
-	case scanner.Ident:
-		// The only valid identifiers are "nil"
-		// and struct field names.
-		// Sets the corresponding underlying value to v ("reflect.Value")
-		if lex.text() == nilStr {
-			v.Set(reflect.Zero(v.Type()))
-			lex.next()
-			return
-		}
-	case scanner.String:
-		// NOTE: ignoring errors
-		// Returns the natural string value without any quotes
-		s, _ := strconv.Unquote(lex.text())
-		// Sets the given above unquoted value to the v ("reflect.Value")
-		v.SetString(s)
-		lex.next()
-		return
-
-	case scanner.Int:
-		// NOTE: ignoring errors
-		i, _ := strconv.Atoi(lex.text())
-		// Sets the given parsed above value to the v ("reflect.Value")
-		v.SetInt(int64(i))
-		lex.next()
-		return
-	case '(':
-		lex.next()
-		// Examines the given byte sequence
-		readList(lex, v)
-		// consume ')'
-		lex.next()
-		return
+// Returns the registered name of t or its type string if t isn't registered
+func typeName(t reflect.Type) string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	if name, ok := registry.byType[t]; ok {
+		return name
 	}
-	panic(fmt.Sprintf("unexpected token %q", lex.text()))
+	return t.String()
 }
 
-// readList
-func readList(lex *lexer, v reflect.Value) {
-	switch v.Kind() {
-	// (item ...)
-	case reflect.Array:
-		for i := 0; !endList(lex); i++ {
-			read(lex, v.Index(i))
-		}
-	// (item ...)
-	case reflect.Slice:
-		var item reflect.Value
-
-		for !endList(lex) {
-			// 1) Get the type of v (reflect.Value)
-			// 2) Based on the type above get the element type
-			// 3) Get a value that represents a pointer to a new zero value
-			// 4) Get an addressable reflect.Value
-			item = reflect.New(v.Type().Elem()).Elem()
-			read(lex, item)
-			v.Set(reflect.Append(v, item))
-		}
-	// ((name value) ...)
-	case reflect.Struct:
-		var name string
-		for !endList(lex) {
-			lex.consume('(')
-			if lex.token != scanner.Ident {
-				panic(fmt.Sprintf("got token %q, want field name", lex.text()))
-			}
-			name = lex.text()
-			lex.next()
-			read(lex, v.FieldByName(name))
-			lex.consume(')')
-		}
-	// ((key value) ...)
-	case reflect.Map:
-		var (
-			key   reflect.Value
-			value reflect.Value
-		)
-
-		// Create a map
-		v.Set(reflect.MakeMap(v.Type()))
-		for !endList(lex) {
-			lex.consume('(')
-		}
-		// Get the addressable key
-		key = reflect.New(v.Type().Key()).Elem()
-		read(lex, key)
+func typeByName(name string) (reflect.Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
 
-		// Get the addressable value
-		value = reflect.New(v.Type().Elem()).Elem()
-		read(lex, value)
+	t, ok := registry.byName[name]
+	return t, ok
+}
 
-		// Put the pair key/value into the created map
-		v.SetMapIndex(key, value)
+// ---- struct fields ----
 
-		lex.consume(')')
-	default:
-		panic(fmt.Sprintf("cannot decode list into %v", v.Type()))
-	}
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
 }
 
-// endList reads the current stored lex.token
-// processes some checks and returns the corresponding values
-// or panics if the lex.token is EOF
-func endList(lex *lexer) bool {
-	switch lex.token {
-	case scanner.EOF:
-		panic("EOF")
-	case ')':
-		return true
-	}
-	return false
+type structFields struct {
+	list   []field
+	byName map[string]int
 }
 
-// Unmarshall parses S-expression data and populates the variable
-// whose address is in the non-nil pointer out.
-func Unmarshal(data []byte, out interface{}) (err error) {
-	var lex *lexer
-	lex = &lexer{scan: scanner.Scanner{Mode: scanner.GoTokens}}
-	lex.scan.Init(bytes.NewReader(data))
-	// get the first token
-	lex.next()
-
-	// NOTE: this isn't an example of ideal error handling
-	defer func() {
-		if x := recover(); x != nil {
-			err = fmt.Errorf("error at %s: %v", lex.scan.Position, x)
-		}
-	}()
+// Caches the fields of struct types, reflect.Type -> *structFields
+var fieldCache sync.Map
 
-	read(lex, reflect.ValueOf(out).Elem())
-	return nil
-}
+/*
+Returns the encoded fields of the struct type t.
 
-type Decoder struct {
-	r    io.Reader
-	data []byte
-}
+1) Skip the unexported fields and the fields tagged with "-"
+2) Take the name from the tag, if it's set, otherwise from the field
+*/
+func cachedFields(t reflect.Type) *structFields {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(*structFields)
+	}
 
-func NewDecoder(r io.Reader) *Decoder {
-	const (
-		initialBufSize = 2048
-	)
+	fields := &structFields{byName: make(map[string]int)}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
 
-	return &Decoder{r: r, data: make([]byte, initialBufSize)}
-}
+		tag := sf.Tag.Get("sexpr")
+		if tag == "-" {
+			continue
+		}
 
-func (dec *Decoder) initData() error {
-	const (
-		emptyDataSize = 0
-	)
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
 
-	n, err := dec.r.Read(dec.data)
-	if err != nil {
-		return fmt.Errorf("reading data from r: %s", err)
-	}
-	if n == emptyDataSize {
-		return fmt.Errorf("reader's data is empty")
+		fields.byName[name] = len(fields.list)
+		fields.list = append(fields.list, field{
+			name:      name,
+			index:     i,
+			omitEmpty: hasOption(options, "omitempty"),
+		})
 	}
 
-	return nil
+	actual, _ := fieldCache.LoadOrStore(t, fields)
+	return actual.(*structFields)
 }
 
-func (dec *Decoder) Decode(v any) error {
-	if err := dec.initData(); err != nil {
-		return err
+// Reports whether the comma-separated tag options contain the option
+func hasOption(options, option string) bool {
+	for options != "" {
+		var current string
+		current, options, _ = strings.Cut(options, ",")
+		if current == option {
+			return true
+		}
 	}
 
-	return Unmarshal(dec.data, v)
+	return false
 }
@@ -0,0 +1,550 @@
+package sexpr_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang/pkg/chapters/chapter12/sub4/sexpr"
+)
+
+type Movie struct {
+	Title    string            `sexpr:"title"`
+	Subtitle string            `sexpr:"subtitle,omitempty"`
+	Year     int               `sexpr:"year"`
+	Color    bool              `sexpr:"color"`
+	Actors   map[string]string `sexpr:"actors"`
+	Oscars   []string          `sexpr:"oscars,omitempty"`
+	Rating   float64           `sexpr:"rating"`
+	Released time.Time         `sexpr:"released"`
+	Sequel   *Movie            `sexpr:"sequel"`
+	Budget   complex128        `sexpr:"-"`
+	secret   string
+}
+
+// Celsius encodes itself as (celsius value)
+type Celsius float64
+
+func (c Celsius) MarshalSExpr() ([]byte, error) {
+	return []byte(fmt.Sprintf("(celsius   %g)", float64(c))), nil
+}
+
+func (c *Celsius) UnmarshalSExpr(data []byte) error {
+	var value float64
+	if _, err := fmt.Sscanf(string(data), "(celsius %g)", &value); err != nil {
+		return err
+	}
+	*c = Celsius(value)
+	return nil
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Square struct{ Side float64 }
+
+func (s Square) Area() float64 { return s.Side * s.Side }
+
+type Circle struct{ R float64 }
+
+func (c *Circle) Area() float64 { return 3 * c.R * c.R }
+
+func init() {
+	sexpr.Register(Square{})
+	sexpr.RegisterName("circle", &Circle{})
+}
+
+func newMovie() Movie {
+	return Movie{
+		Title: "Dr. Strangelove",
+		Year:  1964,
+		Color: false,
+		Actors: map[string]string{
+			"Pres. Merkin Muffley": "Peter Sellers",
+			"Gen. Buck Turgidson":  "George C. Scott",
+		},
+		Oscars:   []string{"Best Actor (Nomin.)"},
+		Rating:   -8.4,
+		Released: time.Date(1964, time.January, 29, 0, 0, 0, 0, time.UTC),
+		Sequel:   &Movie{Title: "None", Year: -1},
+		Budget:   complex(1.8e6, 0),
+		secret:   "unexported",
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	const want = `((title "Dr. Strangelove") (year 1964) (color nil) ` +
+		`(actors (("Gen. Buck Turgidson" "George C. Scott") ("Pres. Merkin Muffley" "Peter Sellers"))) ` +
+		`(oscars ("Best Actor (Nomin.)")) (rating -8.4) (released "1964-01-29T00:00:00Z") ` +
+		`(sequel ((title "None") (year -1) (color nil) (actors nil) (rating 0) (released "0001-01-01T00:00:00Z") (sequel nil))))`
+
+	got, err := sexpr.Marshal(newMovie())
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	if string(got) != want {
+		t.Errorf("Marshal:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	type Values struct {
+		Ints    []int8
+		Uint    uint16
+		Complex complex64
+		Array   [3]string
+		Temp    Celsius
+		TempPtr *Celsius
+		Shapes  []Shape
+		Any     any
+		Nested  map[string][]*int
+		Bytes   []byte
+		Quoted  map[string]int `sexpr:"quoted name"`
+	}
+
+	var (
+		temp  = Celsius(-3.5)
+		seven = 7
+
+		movie = newMovie()
+		in    = Values{
+			Ints:    []int8{-128, 0, 127},
+			Uint:    65535,
+			Complex: complex(1.5, -2),
+			Array:   [3]string{"a", "b\n\"c\""},
+			Temp:    36.6,
+			TempPtr: &temp,
+			Shapes:  []Shape{Square{2}, &Circle{1}, nil},
+			Any:     42,
+			Nested:  map[string][]*int{"x": {&seven, nil}},
+			Bytes:   []byte("hi"),
+			Quoted:  map[string]int{},
+		}
+	)
+
+	movie.Budget = 0
+
+	for _, value := range []any{movie, in} {
+		data, err := sexpr.Marshal(value)
+		if err != nil {
+			t.Fatalf("Marshal(%T): %s", value, err)
+		}
+
+		out := reflect.New(reflect.TypeOf(value))
+		if err := sexpr.Unmarshal(data, out.Interface()); err != nil {
+			t.Fatalf("Unmarshal(%s): %s", data, err)
+		}
+
+		// The unexported field isn't encoded
+		if m, ok := value.(Movie); ok {
+			m.secret = ""
+			value = m
+		}
+		if !reflect.DeepEqual(out.Elem().Interface(), value) {
+			t.Errorf("round trip of %T:\ngot  %#v\nwant %#v", value, out.Elem().Interface(), value)
+		}
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	type Node struct {
+		Next *Node
+	}
+
+	var (
+		cyclic = &Node{}
+		tests  = []struct {
+			value any
+			want  string
+		}{
+			{cyclic, "sexpr: encountered a cycle via *sexpr_test.Node"},
+			{make(chan int), "sexpr: unsupported type: chan int"},
+			{[]float64{1, 0 / func() float64 { return 0 }()}, "sexpr: unsupported value: NaN"},
+		}
+	)
+
+	cyclic.Next = cyclic
+
+	for _, test := range tests {
+		if _, err := sexpr.Marshal(test.value); err == nil || err.Error() != test.want {
+			t.Errorf("Marshal(%T) error = %v, want %q", test.value, err, test.want)
+		}
+	}
+
+	// The same pointer twice on different paths isn't a cycle
+	shared := &Node{}
+	if _, err := sexpr.Marshal([]*Node{shared, shared}); err != nil {
+		t.Errorf("Marshal of a shared pointer: %s", err)
+	}
+}
+
+// Tree is a list of lists of any depth
+type Tree []Tree
+
+func TestUnmarshalErrors(t *testing.T) {
+	var tests = []struct {
+		input string
+		into  any
+		want  string
+	}{
+		{`((title "x")`, new(Movie), "sexpr: syntax error at 1:13: unexpected end of input, want ')'"},
+		{"((year\n  \"x\"))", new(Movie), "sexpr: cannot unmarshal string into Go value of type int at 2:3"},
+		{`(1 2) 3`, new([]int), "sexpr: syntax error at 1:7: unexpected number 3 after top-level value"},
+		{`(300)`, new([]int8), "sexpr: cannot unmarshal number 300 into Go value of type int8 at 1:2"},
+		{`("unknown" 1)`, new(any), `sexpr: syntax error at 1:2: unregistered type "unknown"`},
+		{`("string" "x")`, new(Shape), "sexpr: cannot unmarshal value of type string into Go value of type sexpr_test.Shape at 1:2"},
+		{`"unterminated`, new(string), "sexpr: syntax error at 1:14: literal not terminated"},
+		{``, new(int), "sexpr: syntax error at 1:1: unexpected end of input"},
+	}
+
+	for _, test := range tests {
+		if err := sexpr.Unmarshal([]byte(test.input), test.into); err == nil || err.Error() != test.want {
+			t.Errorf("Unmarshal(%q) error = %v, want %q", test.input, err, test.want)
+		}
+	}
+
+	// The nesting is bounded both for the values read and for the ones passed to an Unmarshaler
+	deep := strings.Repeat("(", 10001) + strings.Repeat(")", 10001)
+	for _, into := range []any{new(Tree), new(Celsius)} {
+		if err := sexpr.Unmarshal([]byte(deep), into); err == nil || !strings.Contains(err.Error(), "exceeded max depth") {
+			t.Errorf("Unmarshal of 10001 lists into %T error = %v", into, err)
+		}
+	}
+	if err := sexpr.Unmarshal([]byte(deep[1:len(deep)-1]), new(Tree)); err != nil {
+		t.Errorf("Unmarshal of 10000 lists: %s", err)
+	}
+
+	var syntaxErr *sexpr.SyntaxError
+	if err := sexpr.Unmarshal([]byte("(\n\n  )x"), new([]int)); !errors.As(err, &syntaxErr) || syntaxErr.Pos.Line != 3 {
+		t.Errorf("Unmarshal error = %#v, want *SyntaxError on line 3", err)
+	}
+}
+
+func TestEncoderDecoderStream(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		values = []Movie{{Title: "A", Year: 1}, {Title: "B", Year: 2}, {Title: "C", Year: 3}}
+	)
+
+	enc := sexpr.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	for _, value := range values {
+		if err := enc.Encode(value); err != nil {
+			t.Fatalf("Encode: %s", err)
+		}
+	}
+
+	dec := sexpr.NewDecoder(&buf)
+	var got []Movie
+	for dec.More() {
+		var movie Movie
+		if err := dec.Decode(&movie); err != nil {
+			t.Fatalf("Decode: %s", err)
+		}
+		got = append(got, movie)
+	}
+	if err := dec.Decode(new(Movie)); err != io.EOF {
+		t.Errorf("Decode after the last value = %v, want io.EOF", err)
+	}
+
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("decoded %v, want %v", got, values)
+	}
+}
+
+func TestMarshalIndent(t *testing.T) {
+	const want = `((title "Dr. Strangelove")
+  (year 1964)
+  (color nil)
+  (actors (("Gen. Buck Turgidson" "George C. Scott")
+      ("Pres. Merkin Muffley" "Peter Sellers")))
+  (oscars ("Best Actor (Nomin.)"))
+  (rating -8.4)
+  (released "1964-01-29T00:00:00Z")
+  (sequel nil))`
+
+	movie := newMovie()
+	movie.Sequel = nil
+
+	got, err := sexpr.MarshalIndent(movie, "", "  ")
+	if err != nil {
+		t.Fatalf("MarshalIndent: %s", err)
+	}
+	if string(got) != want {
+		t.Errorf("MarshalIndent:\ngot\n%s\nwant\n%s", got, want)
+	}
+}
+
+// Must be run with -race
+func TestConcurrentMarshal(t *testing.T) {
+	var (
+		wg    sync.WaitGroup
+		movie = newMovie()
+	)
+
+	want, err := sexpr.Marshal(movie)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if got, err := sexpr.Marshal(movie); err != nil || !bytes.Equal(got, want) {
+					t.Errorf("concurrent Marshal = %s, %v", got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !strings.Contains(string(want), "sequel") {
+		t.Errorf("unexpected encoding %s", want)
+	}
+}
+
+func TestToken(t *testing.T) {
+	const input = `((name "x") (n -12) (f 1.5e3) (c #C(1 -2)) (list nil t))`
+
+	var (
+		want = []sexpr.Token{
+			sexpr.StartList{},
+			sexpr.StartList{}, sexpr.Symbol("name"), sexpr.String("x"), sexpr.EndList{},
+			sexpr.StartList{}, sexpr.Symbol("n"), sexpr.Int(-12), sexpr.EndList{},
+			sexpr.StartList{}, sexpr.Symbol("f"), sexpr.Float(1500), sexpr.EndList{},
+			sexpr.StartList{}, sexpr.Symbol("c"), sexpr.Symbol("#C"),
+			sexpr.StartList{}, sexpr.Int(1), sexpr.Int(-2), sexpr.EndList{}, sexpr.EndList{},
+			sexpr.StartList{}, sexpr.Symbol("list"), sexpr.Symbol("nil"), sexpr.Symbol("t"), sexpr.EndList{},
+			sexpr.EndList{},
+		}
+		got []sexpr.Token
+	)
+
+	dec := sexpr.NewDecoder(strings.NewReader(input))
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Token: %s", err)
+		}
+		got = append(got, token)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens:\ngot  %v\nwant %v", got, want)
+	}
+
+	for input, wantErr := range map[string]string{
+		`(1`:  "sexpr: syntax error at 1:3: unexpected end of input, want ')'",
+		`1)`:  "sexpr: syntax error at 1:2: unexpected ')'",
+		`'c'`: "sexpr: syntax error at 1:1: unexpected character 'c'",
+	} {
+		dec := sexpr.NewDecoder(strings.NewReader(input))
+
+		var err error
+		for err == nil {
+			_, err = dec.Token()
+		}
+		if err.Error() != wantErr {
+			t.Errorf("Token error for %q = %v, want %q", input, err, wantErr)
+		}
+	}
+}
+
+func TestTokenWithDecode(t *testing.T) {
+	dec := sexpr.NewDecoder(strings.NewReader(`(((title "A")) ((title "B"))) ((title "C"))`))
+
+	if token, err := dec.Token(); err != nil || token != (sexpr.StartList{}) {
+		t.Fatalf("Token = %v, %v; want StartList", token, err)
+	}
+
+	var titles []string
+	for dec.More() {
+		var movie Movie
+		if err := dec.Decode(&movie); err != nil {
+			t.Fatalf("Decode: %s", err)
+		}
+		titles = append(titles, movie.Title)
+	}
+
+	if token, err := dec.Token(); err != nil || token != (sexpr.EndList{}) {
+		t.Fatalf("Token = %v, %v; want EndList", token, err)
+	}
+
+	var movie Movie
+	if err := dec.Decode(&movie); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	titles = append(titles, movie.Title)
+
+	if want := []string{"A", "B", "C"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("titles = %v, want %v", titles, want)
+	}
+}
+
+// moviesReader generates an endless-looking stream of movies without holding it in memory
+type moviesReader struct {
+	n, count int
+	buf      bytes.Buffer
+}
+
+func (mr *moviesReader) Read(p []byte) (int, error) {
+	for mr.buf.Len() < len(p) && mr.n < mr.count {
+		fmt.Fprintf(&mr.buf, `((title "M%d") (year %d) (actors (("a%d" "b") ("c" "d"))) (sequel ((title "S%d"))))`+"\n",
+			mr.n, 1900+mr.n%100, mr.n, mr.n)
+		mr.n++
+	}
+	if mr.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return mr.buf.Read(p)
+}
+
+func TestSelect(t *testing.T) {
+	const count = 10000
+
+	var tests = []struct {
+		path  string
+		fn    func(dec *sexpr.Decoder, got *[]string) error
+		first []string
+		total int
+	}{
+		{
+			path: "title",
+			fn: func(dec *sexpr.Decoder, got *[]string) error {
+				var title string
+				err := dec.Decode(&title)
+				*got = append(*got, title)
+				return err
+			},
+			first: []string{"M0", "M1"},
+			total: count,
+		},
+		{
+			path: "sequel/title",
+			fn: func(dec *sexpr.Decoder, got *[]string) error {
+				token, err := dec.Token()
+				*got = append(*got, fmt.Sprint(token))
+				return err
+			},
+			first: []string{"S0", "S1"},
+			total: count,
+		},
+		{
+			// Only the first token is read, the rest is skipped
+			path: "actors",
+			fn: func(dec *sexpr.Decoder, got *[]string) error {
+				token, err := dec.Token()
+				*got = append(*got, fmt.Sprintf("%T", token))
+				return err
+			},
+			first: []string{"sexpr.StartList", "sexpr.StartList"},
+			total: count,
+		},
+		{
+			// Nothing is read, the value is skipped
+			path: "actors/*",
+			fn: func(dec *sexpr.Decoder, got *[]string) error {
+				*got = append(*got, "")
+				return nil
+			},
+			first: []string{"", ""},
+			total: 2 * count,
+		},
+		{
+			path: "year/nope",
+			fn: func(dec *sexpr.Decoder, got *[]string) error {
+				*got = append(*got, "")
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var got []string
+
+		dec := sexpr.NewDecoder(&moviesReader{count: count})
+		err := dec.Select(test.path, func(dec *sexpr.Decoder) error {
+			return test.fn(dec, &got)
+		})
+		if err != nil {
+			t.Fatalf("Select(%q): %s", test.path, err)
+		}
+
+		if len(got) != test.total {
+			t.Errorf("Select(%q) selected %d values, want %d", test.path, len(got), test.total)
+		}
+		if len(got) >= 2 && !reflect.DeepEqual(got[:2], test.first) {
+			t.Errorf("Select(%q) selected %q first, want %q", test.path, got[:2], test.first)
+		}
+	}
+
+	// The errors of fn are returned as is
+	errStop := errors.New("stop")
+	dec := sexpr.NewDecoder(&moviesReader{count: count})
+	if err := dec.Select("title", func(*sexpr.Decoder) error { return errStop }); err != errStop {
+		t.Errorf("Select error = %v, want %v", err, errStop)
+	}
+}
+
+// listReader generates a single list of size bytes without holding it in memory
+type listReader struct {
+	size, n int
+}
+
+func (lr *listReader) Read(p []byte) (int, error) {
+	const item = `((a 1) "b" -2.5 #C(1 2)) `
+
+	if lr.n >= lr.size {
+		return 0, io.EOF
+	}
+
+	var n int
+	for n+len(item)+1 < len(p) && lr.n < lr.size {
+		switch {
+		case lr.n == 0:
+			p[n] = '('
+			n++
+			lr.n++
+		case lr.n+len(item) >= lr.size:
+			p[n] = ')'
+			n++
+			lr.n = lr.size
+		default:
+			n += copy(p[n:], item)
+			lr.n += len(item)
+		}
+	}
+	return n, nil
+}
+
+func TestSelectSkipMemory(t *testing.T) {
+	const size = 16 << 20
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	dec := sexpr.NewDecoder(&listReader{size: size})
+	if err := dec.Select("", func(*sexpr.Decoder) error { return nil }); err != nil {
+		t.Fatalf("Select: %s", err)
+	}
+
+	// The skipped list isn't buffered
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/16 {
+		t.Errorf("skipping %d bytes allocated %d bytes", size, allocated)
+	}
+}
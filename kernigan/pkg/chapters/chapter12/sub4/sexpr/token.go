@@ -0,0 +1,286 @@
+package sexpr
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// Token holds a value of one of these types: StartList, EndList, Symbol, String, Int or Float.
+//
+// A complex number #C(1 2) is returned as Symbol("#C") followed by the tokens of the list (1 2).
+type Token any
+
+// StartList is the opening parenthesis of a list
+type StartList struct{}
+
+// EndList is the closing parenthesis of a list
+type EndList struct{}
+
+// Symbol is an identifier, e.g. nil, t or a struct field name
+type Symbol string
+
+// String is an unquoted string literal
+type String string
+
+// Int is an integer literal
+type Int int64
+
+// Float is a floating point literal
+type Float float64
+
+/*
+Token returns the next token of the input or io.EOF at its end. It lets the callers walk arbitrarily large inputs
+in constant memory. It can be mixed with Decode, e.g. to decode the items of a list one by one:
+
+	dec.Token() // StartList
+	for dec.More() {
+		dec.Decode(&item)
+	}
+	dec.Token() // EndList
+*/
+func (dec *Decoder) Token() (token Token, err error) {
+	if dec.err != nil {
+		return nil, dec.err
+	}
+
+	defer func() {
+		if err != nil && !errors.Is(err, io.EOF) {
+			dec.err = err
+		}
+	}()
+	defer catchError(&err)
+
+	lex := dec.lex
+	switch lex.peek() {
+	case scanner.EOF:
+		if dec.depth > 0 {
+			lex.fail("unexpected end of input, want ')'")
+		}
+		return nil, io.EOF
+	case '(':
+		dec.depth++
+		token = StartList{}
+	case ')':
+		if dec.depth == 0 {
+			lex.fail("unexpected ')'")
+		}
+		dec.depth--
+		token = EndList{}
+	case scanner.Ident:
+		token = Symbol(lex.text())
+	case scanner.String, scanner.RawString:
+		s, err := strconv.Unquote(lex.text())
+		if err != nil {
+			lex.fail("%s", err)
+		}
+		token = String(s)
+	case '-', scanner.Int, scanner.Float:
+		token = readNumberToken(lex)
+	case '#':
+		lex.next()
+		if lex.peek() != scanner.Ident || lex.text() != "C" {
+			lex.fail("got %s after '#', want C", lex.describe())
+		}
+		token = Symbol("#C")
+	default:
+		lex.fail("unexpected %s", lex.describe())
+	}
+	lex.next()
+
+	return token, nil
+}
+
+// Returns the current possibly negative number token as Int or Float, leaving the token unconsumed
+func readNumberToken(lex *lexer) Token {
+	text := readNumberText(lex)
+
+	if lex.peek() == scanner.Int {
+		i, err := strconv.ParseInt(text, 0, 64)
+		if err != nil {
+			lex.fail("invalid number %s: %s", text, errors.Unwrap(err))
+		}
+		return Int(i)
+	}
+
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		lex.fail("invalid number %s: %s", text, errors.Unwrap(err))
+	}
+	return Float(f)
+}
+
+/*
+Select streams the input and calls fn for every value found at path, the decoder is positioned at the value.
+fn may decode the value with Decode, walk it with Token, or leave it alone, the rest of the value is skipped anyway.
+Only the enclosing lists are kept in memory, so the input can be arbitrarily large.
+
+A list is named after its first item if this item is an atom, so struct fields (name value) and map pairs (key value)
+are named after the field names and the keys. The path is a slash-separated list of names that must match the names
+of the lists enclosing a value, from the outermost one, the unnamed lists are skipped. "*" matches any name. E.g. for
+the movies
+
+	((title "Dr. Strangelove") (actors (("Gen. Buck Turgidson" "George C. Scott"))))
+
+the path "title" selects the titles, "actors" selects the maps of actors and "actors/*" selects every actor.
+The empty path selects the top-level values. If Select is called inside a list, it stops at the end of this list.
+*/
+func (dec *Decoder) Select(path string, fn func(dec *Decoder) error) (err error) {
+	type frame struct {
+		name  string
+		named bool
+	}
+
+	var (
+		want  []string
+		stack []frame
+		names []string
+	)
+
+	if path != "" {
+		want = strings.Split(path, "/")
+	}
+
+	for {
+		if dec.err != nil {
+			return dec.err
+		}
+
+		token, isValue, err := dec.peekKind()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// The end of the current list, or of the list Select has been called in
+		if !isValue {
+			if len(stack) == 0 {
+				return nil
+			}
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+			if frame := stack[len(stack)-1]; frame.named {
+				names = names[:len(names)-1]
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if matchPath(names, want) {
+			if err := dec.deliver(fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if token != '(' {
+			if err := dec.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Descend into the list, taking its first atom as the name
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+
+		var f frame
+		if head, isValue, err := dec.peekKind(); err == nil && isValue && head != '(' && head != '#' {
+			token, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			f = frame{name: tokenText(token), named: true}
+			names = append(names, f.name)
+		}
+		stack = append(stack, f)
+	}
+}
+
+// Returns the lookahead token and whether it starts a value, that is it isn't a closing parenthesis
+func (dec *Decoder) peekKind() (token rune, isValue bool, err error) {
+	defer catchError(&err)
+
+	switch token = dec.lex.peek(); token {
+	case scanner.EOF:
+		if dec.depth > 0 {
+			dec.lex.fail("unexpected end of input, want ')'")
+		}
+		return token, false, io.EOF
+	case ')':
+		return token, dec.depth == 0, nil
+	}
+
+	return token, true, nil
+}
+
+// Calls fn for the value at the current position and skips whatever fn has left of it
+func (dec *Decoder) deliver(fn func(dec *Decoder) error) error {
+	var (
+		depth    = dec.depth
+		consumed = dec.lex.consumed
+	)
+
+	if err := fn(dec); err != nil {
+		return err
+	}
+
+	if dec.lex.consumed == consumed {
+		return dec.skip()
+	}
+	for dec.depth > depth {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Skips the value at the current position
+func (dec *Decoder) skip() (err error) {
+	defer func() {
+		if err != nil {
+			dec.err = err
+		}
+	}()
+	defer catchError(&err)
+
+	skipValue(dec.lex)
+	return nil
+}
+
+func matchPath(names, want []string) bool {
+	if len(names) != len(want) {
+		return false
+	}
+
+	for i := range want {
+		if want[i] != "*" && want[i] != names[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func tokenText(token Token) string {
+	switch token := token.(type) {
+	case Symbol:
+		return string(token)
+	case String:
+		return string(token)
+	case Int:
+		return strconv.FormatInt(int64(token), 10)
+	case Float:
+		return strconv.FormatFloat(float64(token), 'g', -1, 64)
+	}
+
+	return ""
+}
@@ -0,0 +1,182 @@
+package params
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+/*
+Pack returns a link built from base and the fields of the struct v, which is the reverse of Unpack:
+
+1) The path fields replace their {name} wildcards in base, the values are path-escaped
+2) The http and query fields are added to the query of base, a slice adds a parameter per item
+3) The form, header and body fields, the nil pointers and the zero omitempty fields are left out
+
+base may be an absolute URL or a path, e.g. "http://localhost:8080/search" or "/items/{id}".
+*/
+func Pack(base string, v any) (string, error) {
+	addrValue := reflect.ValueOf(v)
+	for addrValue.Kind() == reflect.Pointer && !addrValue.IsNil() {
+		addrValue = addrValue.Elem()
+	}
+	if addrValue.Kind() != reflect.Struct {
+		return "", fmt.Errorf("unsupported type %T, want a struct", v)
+	}
+
+	p := packer{query: url.Values{}, path: make(map[string]string)}
+	if err := p.packStruct(addrValue, ""); err != nil {
+		return "", err
+	}
+
+	for name, value := range p.path {
+		base = strings.ReplaceAll(base, "{"+name+"}", url.PathEscape(value))
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("parsing base url: %s", err)
+	}
+
+	query := u.Query()
+	for name, values := range p.query {
+		query[name] = append(query[name], values...)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// packer collects the parameters of a single Pack call
+type packer struct {
+	query url.Values
+	path  map[string]string
+}
+
+func (p *packer) packStruct(v reflect.Value, prefix string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if isHidden(field) {
+			continue
+		}
+
+		tag := getFieldTag(field)
+		if tag.name == skipTagValue {
+			continue
+		}
+
+		var (
+			fv   = v.Field(i)
+			name = joinName(prefix, tag.name)
+		)
+
+		if tag.omitEmpty && fv.IsZero() {
+			continue
+		}
+
+		switch tag.source {
+		case httpTagKey, queryTagKey, pathTagKey:
+		default:
+			continue
+		}
+
+		if isNestedStruct(field.Type) {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+
+			nestedPrefix := prefix
+			if tag.tagged {
+				nestedPrefix = name
+			}
+			if err := p.packStruct(fv, nestedPrefix); err != nil {
+				return err
+			}
+			continue
+		}
+
+		values, err := formatValues(fv, field.Tag.Get(layoutTagKey))
+		if err != nil {
+			return fmt.Errorf("field %s: %s", name, err)
+		}
+
+		if tag.source == pathTagKey {
+			if len(values) > 0 {
+				p.path[tag.name] = values[len(values)-1]
+			}
+			continue
+		}
+		p.query[name] = append(p.query[name], values...)
+	}
+
+	return nil
+}
+
+// Returns the text form of the field, a value per slice item, none for a nil pointer
+func formatValues(v reflect.Value, layout string) ([]string, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Slice && !v.Type().Implements(textMarshalerType) {
+		values := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, err := paramValue(v.Index(i), layout)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %s", i, err)
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	value, err := paramValue(v, layout)
+	if err != nil {
+		return nil, err
+	}
+	return []string{value}, nil
+}
+
+// paramValue returns a string representation of the field value
+// in the form populate parses it back from
+func paramValue(v reflect.Value, layout string) (string, error) {
+	switch {
+	case v.Type() == timeType:
+		if layout == emptyTagValue {
+			layout = time.RFC3339
+		}
+		return v.Interface().(time.Time).Format(layout), nil
+	case v.Type() == durationType:
+		return v.Interface().(time.Duration).String(), nil
+	case v.Type().Implements(textMarshalerType):
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	default:
+		return "", fmt.Errorf("unsupported kind %s", v.Type())
+	}
+}
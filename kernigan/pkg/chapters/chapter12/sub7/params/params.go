@@ -1,259 +1,420 @@
+/*
+Package params binds HTTP request data to struct fields and validates them, the sources of the values are set
+with the field tags:
+
+	http:"name"    the query and the form body, the default for untagged fields named in lowercase
+	query:"name"   the URL query only
+	form:"name"    the form body only
+	path:"name"    the path wildcard of a http.ServeMux pattern, e.g. /items/{name}
+	header:"Name"  the request header
+	body:"json"    the JSON request body, the field may be of any type encoding/json supports
+
+The supported field types are strings, booleans, numbers, time.Time (RFC 3339 or the layout set with the tag
+layout:"2006-01-02"), time.Duration, encoding.TextUnmarshaler implementations, slices of them and pointers to them.
+The nested structs are bound with the name of the struct field as a prefix, e.g. "addr.city", the untagged ones
+are flattened. Pointer fields are optional: they stay nil if there is no value for them in the request. A struct
+nested in itself, e.g. the parent of a tree node, is bound at its first level only.
+
+The validation rules are set with the valid tag, e.g. valid:"required,min=3,max=10", see RegisterValidator.
+*/
 package params
 
 import (
-	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// The tag keys of the value sources
 const (
-	httpTagKey    = "http"
-	validTagKey   = "valid"
+	httpTagKey   = "http"
+	queryTagKey  = "query"
+	formTagKey   = "form"
+	pathTagKey   = "path"
+	headerTagKey = "header"
+	bodyTagKey   = "body"
+
+	validTagKey  = "valid"
+	layoutTagKey = "layout"
+
 	emptyTagValue = ""
+	skipTagValue  = "-"
+	nameSeparator = "."
+
+	maxMemory = 32 << 20
 )
 
-// search implements the /search url endpoint
-func search(resp http.ResponseWriter, req *http.Request) {
-	const (
-		maxResultsNumber = 10
-	)
+// The order the tag keys are looked up in, the first one found sets the source of a field
+var sourceTagKeys = []string{bodyTagKey, pathTagKey, headerTagKey, queryTagKey, formTagKey, httpTagKey}
 
-	var data struct {
-		Labels     []string `http:"1"`
-		MaxResults int      `http:"max"`
-		Exact      bool     `http:"x"`
-		Weight     int      `http:"w"`
-		Height     float64  `http:"h"`
-	}
-
-	data.MaxResults = maxResultsNumber
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
 
-	if err := Unpack(req, &data); err != nil {
-		http.Error(resp, err.Error(), http.StatusBadRequest)
-		return
-	}
+// FieldError describes a request value that can't be bound to a field or doesn't pass a validation rule
+type FieldError struct {
+	// The source tag key, e.g. "query" or "header"
+	Source string
+	// The parameter name, prefixed with the names of the enclosing structs
+	Field string
+	// The failed validation rule, or "type" if the value can't be converted to the field type
+	Rule string
+	Err  error
+}
 
-	// ...
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %q: %s", e.Source, e.Field, e.Err)
+}
 
-	fmt.Fprintf(resp, "Search: %+v\n", data)
+func (e *FieldError) Unwrap() error {
+	return e.Err
 }
 
-// Pack return a link based on the struct fields
-func Pack(v any) (string, error) {
+// Errors aggregates the errors of all the fields that have failed
+type Errors []*FieldError
 
-	const (
-		maxBufferSize = 2048
-		urlBody       = "localhost:8080/search?"
-		equivalence   = '='
-		urlAnd        = '&'
-	)
+func (errs Errors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
 
-	var (
-		addrValue              = reflect.ValueOf(v)
-		urlBuf    bytes.Buffer = *bytes.NewBuffer(make([]byte, 0, maxBufferSize))
+	return strings.Join(messages, "; ")
+}
 
-		tagValue  string
-		fieldKind reflect.Kind
+// fieldTag is the parsed source tag of a struct field
+type fieldTag struct {
+	source    string
+	name      string
+	omitEmpty bool
+	// Whether the tag has been set explicitly
+	tagged bool
+}
 
-		paramVal string
-		err      error
+/*
+getFieldTag returns the first source tag of the field. If there is none, it returns the http source and the field's
+name in lowercase.
+*/
+func getFieldTag(field reflect.StructField) fieldTag {
+	for _, key := range sourceTagKeys {
+		tagValue, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
 
-		writeParam = func(paramVal string) {
-			urlBuf.WriteString(tagValue)
-			urlBuf.WriteByte(equivalence)
-			urlBuf.WriteString(paramVal)
-			urlBuf.WriteByte(urlAnd)
+		name, options, _ := strings.Cut(tagValue, ",")
+		if name == emptyTagValue {
+			name = strings.ToLower(field.Name)
 		}
-	)
 
-	if reflect.TypeOf(v).Kind() != reflect.Struct {
-		return "", fmt.Errorf("unsupported type")
+		return fieldTag{
+			source:    key,
+			name:      name,
+			omitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+			tagged:    true,
+		}
 	}
 
-	urlBuf.WriteString(urlBody)
+	return fieldTag{source: httpTagKey, name: strings.ToLower(field.Name)}
+}
 
-	for i := 0; i < addrValue.NumField(); i++ {
+// Reports whether the values of type t are bound field by field rather than from a single parameter
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
 
-		tagValue = getTagValue(addrValue.Type().Field(i), httpTagKey)
-		fieldKind = addrValue.Field(i).Kind()
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
 
-		switch fieldKind {
-		case reflect.Slice:
-			for j := 0; j < addrValue.Field(i).Len(); j++ {
-				paramVal, err = paramValue(addrValue.Field(i).Index(j))
-				if err != nil {
-					return "", fmt.Errorf("getting field str representation: %s", err)
-				}
-				writeParam(paramVal)
-			}
+// Reports whether the field can't be bound: it's unexported and isn't an embedded struct whose fields are promoted
+func isHidden(field reflect.StructField) bool {
+	return !field.IsExported() && !(field.Anonymous && field.Type.Kind() == reflect.Struct)
+}
 
-		default:
-			paramVal, err = paramValue(addrValue.Field(i))
-			if err != nil {
-				return "", fmt.Errorf("getting field str representation: %s", err)
-			}
-			writeParam(paramVal)
-		}
+func joinName(prefix, name string) string {
+	if prefix == "" {
+		return name
 	}
+	return prefix + nameSeparator + name
+}
 
-	if urlBuf.Bytes()[urlBuf.Len()-1] == urlAnd {
-		urlBuf.Truncate(urlBuf.Len() - 1)
-	}
+// binder keeps the state of a single Unpack call
+type binder struct {
+	req  *http.Request
+	errs Errors
+	// The number of the fields that have got a value, used to allocate the optional nested structs
+	bound int
+	// The struct types being bound, a type nested in itself would be bound forever
+	active map[reflect.Type]bool
+}
 
-	return urlBuf.String(), nil
+func (b *binder) fail(source, field, rule string, err error) {
+	b.errs = append(b.errs, &FieldError{Source: source, Field: field, Rule: rule, Err: err})
 }
 
-// getTagValue parses field's tag value and if it's presented
-// returns this value in lowercase, otherwise returns the field'S
-// name in lowercase
-func getTagValue(field reflect.StructField, tagKey string) string {
-	tag := field.Tag
-	tagValue := tag.Get(tagKey)
-	if tagValue == emptyTagValue {
-		tagValue = strings.ToLower(field.Name)
+/*
+Unpack populates the fields of the struct pointed to by ptr from the request and validates them.
+
+1) Parse the query and the form body
+2) Bind every field from its source, the fields without a value in the request keep their values
+3) Validate every field with the rules of its valid tag
+4) Return all the failures at once as Errors
+*/
+func Unpack(req *http.Request, ptr any) error {
+	addrVal := reflect.ValueOf(ptr)
+	if addrVal.Kind() != reflect.Pointer || addrVal.IsNil() || addrVal.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported type %T, want a pointer to a struct", ptr)
 	}
 
-	return tagValue
-}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := req.ParseMultipartForm(maxMemory); err != nil {
+			return fmt.Errorf("parsing multipart form: %s", err)
+		}
+	} else if err := req.ParseForm(); err != nil {
+		return fmt.Errorf("parsing form: %s", err)
+	}
 
-// paramValue returns a string representation of the field value
-// and the nil error value in none ones encountered,
-// otherwise returns empty string and the corresponding non-nil error
-func paramValue(reflectValue reflect.Value) (string, error) {
+	b := &binder{req: req, active: make(map[reflect.Type]bool)}
+	b.bindStruct(addrVal.Elem(), "")
 
-	var (
-		strValue string
-	)
+	if len(b.errs) > 0 {
+		return b.errs
+	}
+	return nil
+}
 
-	switch reflectValue.Kind() {
-	case reflect.Invalid:
-		return "", fmt.Errorf("invalid type")
+func (b *binder) bindStruct(v reflect.Value, prefix string) {
+	b.active[v.Type()] = true
+	defer delete(b.active, v.Type())
 
-	case reflect.String:
-		if strValue := reflectValue.Interface().(string); strValue == "" {
-			return "", fmt.Errorf("empty field value")
-		} else {
-			return strValue, nil
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if isHidden(field) {
+			continue
 		}
 
-	case reflect.Bool:
-		value := reflectValue.Interface().(bool)
-		if value {
-			return "true", nil
+		tag := getFieldTag(field)
+		if tag.name == skipTagValue {
+			continue
 		}
-		return "false", nil
 
-	case reflect.Int:
-		value := reflectValue.Interface().(int)
-		strValue = strconv.Itoa(value)
-		return strValue, nil
+		var (
+			fv   = v.Field(i)
+			name = joinName(prefix, tag.name)
+		)
 
-	case reflect.Float64:
-		value := reflectValue.Interface().(float64)
-		strValue = strconv.FormatFloat(value, 'f', -1, 64)
-		return strValue, nil
+		// The path wildcards and the headers aren't prefixed
+		if tag.source == pathTagKey || tag.source == headerTagKey {
+			name = tag.name
+		}
 
-	default:
-		return "", fmt.Errorf("unexpected reflection kind")
+		switch {
+		case tag.source == bodyTagKey:
+			b.bindBody(fv, tag.name)
+		case isNestedStruct(field.Type):
+			// The untagged nested structs are flattened
+			nestedPrefix := prefix
+			if tag.tagged {
+				nestedPrefix = name
+			}
+			b.bindNested(fv, nestedPrefix)
+		default:
+			if values := b.values(tag.source, name); len(values) > 0 {
+				if err := setValues(fv, values, field.Tag.Get(layoutTagKey)); err != nil {
+					b.fail(tag.source, name, "type", err)
+				}
+				b.bound++
+			}
+		}
+
+		b.validate(fv, field, tag.source, name)
 	}
 }
 
-// Unpack populates the fields of the struct pointed to by ptr
-func Unpack(req *http.Request, ptr any) error {
-
-	var (
-		err     error
-		addrVal reflect.Value
-
-		fields map[string]reflect.Value
+/*
+bindNested binds a nested struct unless its type is being bound already. A nil pointer to a struct is allocated only
+if the request has a parameter with its prefix and any of its fields has got a value, otherwise the struct isn't
+validated either.
+*/
+func (b *binder) bindNested(v reflect.Value, prefix string) {
+	if v.Kind() != reflect.Pointer {
+		b.bindStruct(v, prefix)
+		return
+	}
 
-		httpTagValue string
+	if b.active[v.Type().Elem()] {
+		return
+	}
+	if !v.IsNil() {
+		b.bindStruct(v.Elem(), prefix)
+		return
+	}
+	if !b.hasPrefix(prefix) {
+		return
+	}
 
-		validTagValue    string
-		queryLastElement string
+	var (
+		bound  = b.bound
+		failed = len(b.errs)
+		nested = reflect.New(v.Type().Elem())
 	)
 
-	if err = req.ParseForm(); err != nil {
-		return err
+	b.bindStruct(nested.Elem(), prefix)
+	if b.bound > bound {
+		v.Set(nested)
+		return
 	}
-	fmt.Println(req.URL.Query())
-
-	// Build a map of fields keyed by effective name.
-	fields = make(map[string]reflect.Value)
-
-	// Gets the struct addressable value
-	addrVal = reflect.ValueOf(ptr).Elem()
+	b.errs = b.errs[:failed]
+}
 
-	for i := 0; i < addrVal.NumField(); i++ {
-		httpTagValue = getTagValue(addrVal.Type().Field(i), httpTagKey)
-		validTagValue = getTagValue(addrVal.Type().Field(i), validTagKey)
+// Reports whether the query or the form has a parameter with the prefix, any parameter does for the empty one
+func (b *binder) hasPrefix(prefix string) bool {
+	if prefix == "" {
+		return true
+	}
 
-		if _, ok := req.URL.Query()[validTagValue]; !ok {
-			return fmt.Errorf("query doesn't have arguments for the parameter: %s", validTagValue)
+	for name := range b.req.Form {
+		if strings.HasPrefix(name, prefix+nameSeparator) {
+			return true
 		}
+	}
+	return false
+}
 
-		queryLastElement = req.URL.Query()[validTagValue][len(req.URL.Query()[validTagValue])-1]
-		if err = validateParamValue(validTagValue, queryLastElement); err != nil {
-			return fmt.Errorf("validating query value: %s", err)
-		}
+// Decodes the JSON body into the field, the empty body leaves the field as is
+func (b *binder) bindBody(v reflect.Value, name string) {
+	if b.req.Body == nil || b.req.Body == http.NoBody {
+		return
+	}
 
-		fields[httpTagValue] = addrVal.Field(i)
+	err := json.NewDecoder(b.req.Body).Decode(v.Addr().Interface())
+	if errors.Is(err, io.EOF) {
+		return
+	}
+	if err != nil {
+		b.fail(bodyTagKey, name, "type", err)
+		return
+	}
+	b.bound++
+}
 
+// Returns the raw values of the parameter from the source
+func (b *binder) values(source, name string) []string {
+	switch source {
+	case queryTagKey:
+		return b.req.URL.Query()[name]
+	case formTagKey:
+		if b.req.MultipartForm != nil {
+			return b.req.MultipartForm.Value[name]
+		}
+		return b.req.PostForm[name]
+	case pathTagKey:
+		if value := b.req.PathValue(name); value != "" {
+			return []string{value}
+		}
+		return nil
+	case headerTagKey:
+		return b.req.Header.Values(name)
+	default:
+		return b.req.Form[name]
 	}
+}
 
-	// Update struct field for each parameter in the request
-	for name, values := range req.Form {
-		f := fields[name]
-		// Ignore unrecognized parameters
-		if !f.IsValid() {
-			continue
+/*
+setValues takes a field of a struct and the values to be assigned to it. A slice gets all the values, any other
+field gets the last one. A nil pointer is allocated.
+*/
+func setValues(v reflect.Value, values []string, layout string) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
 		}
+		return setValues(v.Elem(), values, layout)
+	}
 
+	if v.Kind() == reflect.Slice && !v.Addr().Type().Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(v.Type(), 0, len(values))
 		for _, value := range values {
-			switch f.Kind() {
-			case reflect.Slice:
-				elem := reflect.New(f.Type().Elem()).Elem()
-				if err = populate(elem, value); err != nil {
-					return fmt.Errorf("%s %v", name, err)
-				}
-				f.Set(reflect.Append(f, elem))
-
-			default:
-				if err = populate(f, value); err != nil {
-					return fmt.Errorf("%s %v", name, err)
-				}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := populate(elem, value, layout); err != nil {
+				return err
 			}
+			slice = reflect.Append(slice, elem)
 		}
+		v.Set(slice)
+		return nil
 	}
-	return nil
+
+	return populate(v, values[len(values)-1], layout)
 }
 
-// populate takes field of a struct and value to be assigned to the field
-// checks the validity of the value and if it's passed sets the value, returns the nil error,
-// otherwise returns non-nil value
-func populate(v reflect.Value, value string) error {
+// populate takes a field of a struct and the value to be assigned to the field,
+// converts the value to the field type and sets it, or returns the conversion error
+func populate(v reflect.Value, value, layout string) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return populate(v.Elem(), value, layout)
+	}
+
+	switch {
+	case v.Type() == timeType:
+		if layout == emptyTagValue {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return fmt.Errorf("invalid time %q, want layout %q", value, layout)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case v.Addr().Type().Implements(textUnmarshalerType):
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
 	switch v.Kind() {
 	case reflect.String:
 		v.SetString(value)
-	case reflect.Int:
-		i, err := strconv.ParseInt(value, 10, 64)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid integer %q", value)
 		}
 		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", value)
+		}
+		v.SetUint(u)
 	case reflect.Bool:
 		b, err := strconv.ParseBool(value)
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid boolean %q", value)
 		}
 		v.SetBool(b)
-	case reflect.Float64:
-		f, err := strconv.ParseFloat(value, 64)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid number %q", value)
 		}
 		v.SetFloat(f)
 	default:
@@ -261,74 +422,3 @@ func populate(v reflect.Value, value string) error {
 	}
 	return nil
 }
-
-// validateParamValue takes the value to be assigned to the corresponding field, validation tag value if any,
-// checks whether the passed value is correct and if everything is correct, returns nil error, otherwise returns
-// non-nil value
-func validateParamValue(validateTag, valueToValidate string) error {
-	switch validateTag {
-	case "email":
-		const (
-			invalidEmailLength = 0
-			emailSeparator     = "@"
-
-			leftSideInd         = 0
-			validLeftSideLength = 5
-
-			rightSideInd = 1
-			validDomain  = "google.com"
-		)
-
-		email := valueToValidate
-
-		if len(email) == invalidEmailLength {
-			return fmt.Errorf("invalid email length: %d", len(email))
-		}
-
-		if !strings.Contains(email, emailSeparator) {
-			return fmt.Errorf("password doesn't include %q: %d", '@', len(email))
-		}
-
-		emailPortions := strings.Split(email, emailSeparator)
-		if len(emailPortions[leftSideInd]) < validLeftSideLength {
-			return fmt.Errorf("email left side has invalid length: %d", len(emailPortions[leftSideInd]))
-		}
-		if emailPortions[rightSideInd] != validDomain {
-			return fmt.Errorf("email domain isn't valid: %s", validDomain)
-		}
-
-		return nil
-	case "zip":
-		const (
-			validZipLength = 6
-			validZipPrefix = "100"
-		)
-
-		zip := valueToValidate
-
-		if len(zip) < validZipLength {
-			return fmt.Errorf("invalid zip length: %d", len(zip))
-		}
-
-		if !strings.HasPrefix(zip, validZipPrefix) {
-			return fmt.Errorf("ivalid zip prefix: %s", zip)
-		}
-
-		return nil
-
-	case "password":
-		const (
-			minPasswordLength = 8
-			maxPasswordLength = 32
-		)
-
-		password := valueToValidate
-		if !(len(password) >= minPasswordLength && len(password) <= maxPasswordLength) {
-			return fmt.Errorf("invalid password length: %d", len(password))
-		}
-
-		return nil
-	default:
-		return fmt.Errorf("unsupported validity tag")
-	}
-}
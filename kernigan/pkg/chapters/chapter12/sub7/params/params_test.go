@@ -0,0 +1,307 @@
+package params
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type address struct {
+	City string `query:"city" valid:"required"`
+	Zip  string `query:"zip" valid:"zip"`
+}
+
+type paging struct {
+	Page  int `query:"page" valid:"min=1"`
+	Limit int `query:"limit" valid:"max=50"`
+}
+
+type payload struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+type order struct {
+	ID      string        `path:"id" valid:"len=4"`
+	Token   string        `header:"X-Token" valid:"required"`
+	Status  string        `query:"status" valid:"oneof=new paid shipped"`
+	Note    *string       `form:"note" valid:"max=10"`
+	Count   *int          `query:"count"`
+	Since   time.Time     `query:"since" layout:"2006-01-02"`
+	Timeout time.Duration `query:"timeout" valid:"max=1m"`
+	Labels  []string      `query:"label" valid:"max=2,regex=^[a-z]+$"`
+	Addr    *address      `query:"addr"`
+	paging
+	Body payload `body:"json"`
+}
+
+func serve(t *testing.T, req *http.Request, ptr any) error {
+	t.Helper()
+
+	var err error
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		err = Unpack(r, ptr)
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	return err
+}
+
+func TestUnpack(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost,
+		"/orders/a1b2?status=paid&count=3&since=2024-05-01&timeout=30s&label=x&label=y"+
+			"&addr.city=Paris&addr.zip=100200&page=2&limit=20",
+		strings.NewReader(`{"name":"box","tags":["a"]}`))
+	req.Header.Set("X-Token", "secret")
+
+	var got order
+	if err := serve(t, req, &got); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+
+	count := 3
+	want := order{
+		ID:      "a1b2",
+		Token:   "secret",
+		Status:  "paid",
+		Count:   &count,
+		Since:   time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Timeout: 30 * time.Second,
+		Labels:  []string{"x", "y"},
+		Addr:    &address{City: "Paris", Zip: "100200"},
+		paging:  paging{Page: 2, Limit: 20},
+		Body:    payload{Name: "box", Tags: []string{"a"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unpack\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestUnpackOptional(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/orders/a1b2?status=new", nil)
+	req.Header.Set("X-Token", "secret")
+
+	got := order{Labels: []string{"keep"}, paging: paging{Page: 1}}
+	if err := serve(t, req, &got); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+
+	if got.Count != nil || got.Note != nil || got.Addr != nil {
+		t.Errorf("absent optional fields have been set: %+v", got)
+	}
+	if !reflect.DeepEqual(got.Labels, []string{"keep"}) || got.Page != 1 {
+		t.Errorf("absent fields lost their defaults: %+v", got)
+	}
+}
+
+type category struct {
+	Name   string    `query:"name"`
+	Parent *category `query:"parent"`
+	Tree   struct {
+		Root *category `query:"root"`
+	} `query:"tree"`
+}
+
+func TestUnpackSelfReferential(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/orders/a1b2?name=tea&parent.name=drinks&tree.root.name=all", nil)
+
+	var got category
+	if err := serve(t, req, &got); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	if got.Name != "tea" || got.Parent != nil || got.Tree.Root != nil {
+		t.Errorf("Unpack = %+v, want only the first level bound", got)
+	}
+
+	// The cycle of the values stops as well
+	got = category{}
+	got.Parent = &got
+	if err := serve(t, req, &got); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+}
+
+func TestUnpackForm(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/orders/a1b2?status=new&note=query",
+		strings.NewReader("note=form&page=5"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Token", "secret")
+
+	var got order
+	got.Page = 1
+	if err := serve(t, req, &got); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+
+	// The form source reads the body only and the query source the URL only
+	if got.Note == nil || *got.Note != "form" {
+		t.Errorf("Note = %v, want form", got.Note)
+	}
+	if got.Page != 1 {
+		t.Errorf("Page = %d, want 1", got.Page)
+	}
+}
+
+func TestUnpackErrors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet,
+		"/orders/abc?status=lost&count=many&timeout=2m&label=a&label=B&addr.zip=200000&page=0&limit=51", nil)
+
+	err := serve(t, req, &order{})
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Unpack error = %v, want Errors", err)
+	}
+
+	var got []string
+	for _, e := range errs {
+		got = append(got, fmt.Sprintf("%s %s %s", e.Source, e.Field, e.Rule))
+	}
+	want := []string{
+		"path id len",
+		"header X-Token required",
+		"query status oneof",
+		"query count type",
+		"query timeout max",
+		"query label regex",
+		"query addr.city required",
+		"query addr.zip zip",
+		"query page min",
+		"query limit max",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors\ngot  %q\nwant %q", got, want)
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, `query "limit": value must be at most 50; `) &&
+		!strings.HasSuffix(msg, `query "limit": value must be at most 50`) {
+		t.Errorf("Error() = %q", msg)
+	}
+}
+
+func TestUnpackUnsupported(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	var s struct{ A int }
+	if err := Unpack(req, s); err == nil {
+		t.Error("Unpack(struct) succeeded, want an error")
+	}
+}
+
+func TestRegisterValidator(t *testing.T) {
+	RegisterValidator("even", func(v reflect.Value, _ string) error {
+		if v.Int()%2 != 0 {
+			return fmt.Errorf("%d is odd", v.Int())
+		}
+		return nil
+	})
+
+	var data struct {
+		N  int   `http:"n" valid:"even"`
+		Ns []int `http:"ns" valid:"even,min=2"`
+		U  int   `http:"u" valid:"unknown"`
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?n=4&ns=2&ns=3", nil)
+	err := Unpack(req, &data)
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Unpack error = %v, want 2 errors", err)
+	}
+	if e := errs[0]; e.Field != "ns" || e.Rule != "even" || e.Err.Error() != "item 1: 3 is odd" {
+		t.Errorf("errs[0] = %v", e)
+	}
+	if e := errs[1]; e.Field != "u" || e.Rule != "unknown" {
+		t.Errorf("errs[1] = %v", e)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	got := parseRules("required, min=1,regex=^(a|b){1,2}$")
+	want := []rule{{name: "required"}, {name: "min", param: "1"}, {name: "regex", param: "^(a|b){1,2}$"}}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRules = %+v, want %+v", got, want)
+	}
+}
+
+func TestPack(t *testing.T) {
+	count := 0
+	v := order{
+		ID:      "a/b c",
+		Token:   "secret",
+		Status:  "new & paid",
+		Count:   &count,
+		Since:   time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Timeout: 90 * time.Second,
+		Labels:  []string{"x", "y"},
+		Addr:    &address{City: "São Paulo"},
+		paging:  paging{Page: 2},
+		Body:    payload{Name: "box"},
+	}
+
+	got, err := Pack("http://localhost:8080/orders/{id}?lang=en", &v)
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	want := "http://localhost:8080/orders/a%2Fb%20c?" +
+		"addr.city=S%C3%A3o+Paulo&addr.zip=&count=0&label=x&label=y&lang=en&limit=0&page=2" +
+		"&since=2024-05-01&status=new+%26+paid&timeout=1m30s"
+	if got != want {
+		t.Errorf("Pack\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	type search struct {
+		Labels []string `http:"l"`
+		Max    int      `http:"max"`
+		Exact  bool     `http:"x,omitempty"`
+		Height float64  `http:"h"`
+	}
+
+	in := search{Labels: []string{"golang", "go programming"}, Max: 10, Height: 1.5}
+	link, err := Pack("/search", in)
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	if want := "/search?h=1.5&l=golang&l=go+programming&max=10"; link != want {
+		t.Errorf("Pack = %s, want %s", link, want)
+	}
+
+	var out search
+	if err := Unpack(httptest.NewRequest(http.MethodGet, link, nil), &out); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip: got %+v, want %+v", out, in)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		query, want string
+		code        int
+	}{
+		{"?1=golang&1=go&x=true", "Search: {Labels:[golang go] MaxResults:10 Exact:true Weight:0 Height:0}\n", http.StatusOK},
+		{"?max=0&1=Go", "http \"1\": item 0: \"Go\" doesn't match ^[a-z]+$; http \"max\": value must be at least 1\n",
+			http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		search(rec, httptest.NewRequest(http.MethodGet, "/search"+tt.query, nil))
+
+		if rec.Code != tt.code || rec.Body.String() != tt.want {
+			t.Errorf("search(%s) = %d %q, want %d %q", tt.query, rec.Code, rec.Body.String(), tt.code, tt.want)
+		}
+	}
+}
@@ -1,10 +1,37 @@
 package params
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 )
 
+// search implements the /search url endpoint
+func search(resp http.ResponseWriter, req *http.Request) {
+	const (
+		maxResultsNumber = 10
+	)
+
+	var data struct {
+		Labels     []string `http:"1" valid:"max=5,regex=^[a-z]+$"`
+		MaxResults int      `http:"max" valid:"min=1,max=100"`
+		Exact      bool     `http:"x"`
+		Weight     int      `http:"w"`
+		Height     float64  `http:"h"`
+	}
+
+	data.MaxResults = maxResultsNumber
+
+	if err := Unpack(req, &data); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// ...
+
+	fmt.Fprintf(resp, "Search: %+v\n", data)
+}
+
 func StartServer() {
 
 	http.HandleFunc("/search", search)
@@ -0,0 +1,345 @@
+package params
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	requiredRule = "required"
+	minRule      = "min"
+	maxRule      = "max"
+	lenRule      = "len"
+	regexRule    = "regex"
+	oneOfRule    = "oneof"
+
+	ruleSeparator  = ","
+	paramSeparator = "="
+)
+
+/*
+ValidatorFunc checks the value of a field against the rule parameter, e.g. "10" for the rule max=10, and returns
+a non-nil error describing the failure. The value is never a nil pointer: the optional fields that haven't been set
+are checked by the required rule only.
+*/
+type ValidatorFunc func(v reflect.Value, param string) error
+
+var (
+	validatorsMu sync.RWMutex
+	validators   = map[string]ValidatorFunc{
+		minRule:    validateMin,
+		maxRule:    validateMax,
+		lenRule:    validateLen,
+		regexRule:  validateRegex,
+		oneOfRule:  validateOneOf,
+		"email":    validateEmail,
+		"zip":      validateZip,
+		"password": validatePassword,
+	}
+
+	// The rules checking the slices and the maps as a whole, the rest ones are applied to every element
+	collectionRules = map[string]bool{minRule: true, maxRule: true, lenRule: true}
+
+	// The compiled regex rule parameters
+	regexCache sync.Map
+)
+
+/*
+RegisterValidator makes the validator available under name in the valid tags, replacing the one registered under
+the same name before. Validators of the rules other than min, max and len are applied to every element of a slice.
+*/
+func RegisterValidator(name string, fn ValidatorFunc) {
+	if name == "" || name == requiredRule || strings.ContainsAny(name, ruleSeparator+paramSeparator) {
+		panic(fmt.Sprintf("params: invalid validator name %q", name))
+	}
+	if fn == nil {
+		panic("params: nil validator " + name)
+	}
+
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+
+	validators[name] = fn
+}
+
+func lookupValidator(name string) (ValidatorFunc, bool) {
+	validatorsMu.RLock()
+	defer validatorsMu.RUnlock()
+
+	fn, ok := validators[name]
+	return fn, ok
+}
+
+type rule struct {
+	name  string
+	param string
+}
+
+// parseRules splits the valid tag into rules. The regex rule takes the rest of the tag, so it must be the last one.
+func parseRules(tag string) []rule {
+	var rules []rule
+
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, regexRule+paramSeparator) {
+			item, tag = tag, ""
+		} else {
+			item, tag, _ = strings.Cut(tag, ruleSeparator)
+		}
+
+		name, param, _ := strings.Cut(strings.TrimSpace(item), paramSeparator)
+		if name != "" {
+			rules = append(rules, rule{name: name, param: param})
+		}
+	}
+
+	return rules
+}
+
+/*
+validate checks the field against the rules of its valid tag. A nil pointer fails the required rule and skips
+the rest ones, any other value fails the required rule if it's zero.
+*/
+func (b *binder) validate(v reflect.Value, field reflect.StructField, source, name string) {
+	rules := parseRules(field.Tag.Get(validTagKey))
+
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	for _, r := range rules {
+		if r.name == requiredRule {
+			if v.IsZero() {
+				b.fail(source, name, r.name, fmt.Errorf("is required"))
+				return
+			}
+			continue
+		}
+
+		if v.Kind() == reflect.Pointer {
+			return
+		}
+
+		fn, ok := lookupValidator(r.name)
+		if !ok {
+			b.fail(source, name, r.name, fmt.Errorf("unknown validation rule %q", r.name))
+			continue
+		}
+
+		if err := applyValidator(fn, r, v); err != nil {
+			b.fail(source, name, r.name, err)
+		}
+	}
+}
+
+func applyValidator(fn ValidatorFunc, r rule, v reflect.Value) error {
+	isCollection := v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+	if !isCollection || collectionRules[r.name] {
+		return fn(v, r.param)
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		if err := fn(v.Index(i), r.param); err != nil {
+			return fmt.Errorf("item %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+/*
+compare returns the sign of the comparison of the value with the rule parameter. The numbers are compared by
+value, the durations accept the parameter in the time.ParseDuration format, the strings, slices and maps are compared
+by length.
+*/
+func compare(v reflect.Value, param string) (int, error) {
+	var value, limit float64
+
+	switch v.Kind() {
+	case reflect.String:
+		value = float64(utf8.RuneCountInString(v.String()))
+	case reflect.Slice, reflect.Array, reflect.Map:
+		value = float64(v.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = float64(v.Int())
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(param)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration parameter %q", param)
+			}
+			param = strconv.FormatInt(int64(d), 10)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		value = v.Float()
+	default:
+		return 0, fmt.Errorf("can't compare %s", v.Type())
+	}
+
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid parameter %q", param)
+	}
+
+	switch {
+	case value < limit:
+		return -1, nil
+	case value > limit:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Returns the word the limits of the value are described with
+func measure(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return "length"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return "number of items"
+	}
+	return "value"
+}
+
+func validateMin(v reflect.Value, param string) error {
+	sign, err := compare(v, param)
+	if err != nil {
+		return err
+	}
+	if sign < 0 {
+		return fmt.Errorf("%s must be at least %s", measure(v), param)
+	}
+	return nil
+}
+
+func validateMax(v reflect.Value, param string) error {
+	sign, err := compare(v, param)
+	if err != nil {
+		return err
+	}
+	if sign > 0 {
+		return fmt.Errorf("%s must be at most %s", measure(v), param)
+	}
+	return nil
+}
+
+func validateLen(v reflect.Value, param string) error {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+	default:
+		return fmt.Errorf("%s has no length", v.Type())
+	}
+
+	sign, err := compare(v, param)
+	if err != nil {
+		return err
+	}
+	if sign != 0 {
+		return fmt.Errorf("%s must be %s", measure(v), param)
+	}
+	return nil
+}
+
+func validateRegex(v reflect.Value, param string) error {
+	if v.Kind() != reflect.String {
+		return fmt.Errorf("can't match %s", v.Type())
+	}
+
+	re, ok := regexCache.Load(param)
+	if !ok {
+		compiled, err := regexp.Compile(param)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+		re, _ = regexCache.LoadOrStore(param, compiled)
+	}
+
+	if !re.(*regexp.Regexp).MatchString(v.String()) {
+		return fmt.Errorf("%q doesn't match %s", v.String(), param)
+	}
+	return nil
+}
+
+// validateOneOf compares the text form of the value with the space-separated options
+func validateOneOf(v reflect.Value, param string) error {
+	value := fmt.Sprint(v.Interface())
+
+	for _, option := range strings.Fields(param) {
+		if value == option {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q isn't one of %s", value, strings.Join(strings.Fields(param), ", "))
+}
+
+func validateEmail(v reflect.Value, _ string) error {
+	const (
+		invalidEmailLength = 0
+		emailSeparator     = "@"
+
+		leftSideInd         = 0
+		validLeftSideLength = 5
+
+		rightSideInd = 1
+		validDomain  = "google.com"
+	)
+
+	email := v.String()
+
+	if len(email) == invalidEmailLength {
+		return fmt.Errorf("invalid email length: %d", len(email))
+	}
+
+	if !strings.Contains(email, emailSeparator) {
+		return fmt.Errorf("email doesn't include %q", emailSeparator)
+	}
+
+	emailPortions := strings.SplitN(email, emailSeparator, 2)
+	if len(emailPortions[leftSideInd]) < validLeftSideLength {
+		return fmt.Errorf("email left side has invalid length: %d", len(emailPortions[leftSideInd]))
+	}
+	if emailPortions[rightSideInd] != validDomain {
+		return fmt.Errorf("email domain isn't valid: %s", emailPortions[rightSideInd])
+	}
+
+	return nil
+}
+
+func validateZip(v reflect.Value, _ string) error {
+	const (
+		validZipLength = 6
+		validZipPrefix = "100"
+	)
+
+	zip := v.String()
+
+	if len(zip) < validZipLength {
+		return fmt.Errorf("invalid zip length: %d", len(zip))
+	}
+
+	if !strings.HasPrefix(zip, validZipPrefix) {
+		return fmt.Errorf("invalid zip prefix: %s", zip)
+	}
+
+	return nil
+}
+
+func validatePassword(v reflect.Value, _ string) error {
+	const (
+		minPasswordLength = 8
+		maxPasswordLength = 32
+	)
+
+	password := v.String()
+	if !(len(password) >= minPasswordLength && len(password) <= maxPasswordLength) {
+		return fmt.Errorf("invalid password length: %d", len(password))
+	}
+
+	return nil
+}
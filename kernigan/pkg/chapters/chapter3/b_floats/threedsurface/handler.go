@@ -0,0 +1,187 @@
+package threedsurface
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+)
+
+// The bounds of the request parameters, so a request can't make the server render forever or exhaust the memory
+const (
+	maxSize = 4096
+	// The PNG takes 12 bytes per pixel for the image and the z-buffer, about 48MB at most
+	maxPixels = 4 << 20
+	maxCells  = 400
+)
+
+/*
+ParseOptions reads the rendering parameters from the query on top of the default ones:
+
+	width, height   canvas size in pixels
+	cells           number of grid cells along each axis
+	xyrange         width of the x and y ranges
+	zscale          pixels per z unit
+	rotation        rotation around the z axis in degrees
+	elevation       angle of the x, y axes to the horizontal in degrees
+	ramp            name of the color ramp, see Ramps
+	zmin, zmax      heights mapped to the ends of the ramp, "auto" for the range of the surface
+	shading         "off" to disable the lighting
+*/
+func ParseOptions(query url.Values) (Options, error) {
+	opts := DefaultOptions()
+
+	ints := []struct {
+		name     string
+		dst      *int
+		min, max int
+	}{
+		{"width", &opts.Width, 1, maxSize},
+		{"height", &opts.Height, 1, maxSize},
+		{"cells", &opts.Cells, 1, maxCells},
+	}
+	for _, p := range ints {
+		s := query.Get(p.name)
+		if s == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(s)
+		if err != nil || v < p.min || v > p.max {
+			return opts, fmt.Errorf("bad %s %q, want an integer from %d to %d", p.name, s, p.min, p.max)
+		}
+		*p.dst = v
+	}
+	if opts.Width*opts.Height > maxPixels {
+		return opts, fmt.Errorf("the canvas is too large: %dx%d, at most %d pixels", opts.Width, opts.Height, maxPixels)
+	}
+
+	floats := []struct {
+		name string
+		dst  *float64
+		// The factor converting the parameter to the option, e.g. degrees to radians
+		scale float64
+	}{
+		{"xyrange", &opts.XYRange, 1},
+		{"zscale", &opts.ZScale, 1},
+		{"rotation", &opts.Rotation, math.Pi / 180},
+		{"elevation", &opts.Elevation, math.Pi / 180},
+	}
+	for _, p := range floats {
+		s := query.Get(p.name)
+		if s == "" {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || !finite(v) {
+			return opts, fmt.Errorf("bad %s %q", p.name, s)
+		}
+		*p.dst = v * p.scale
+	}
+
+	if name := query.Get("ramp"); name != "" {
+		ramp, ok := Ramps[name]
+		if !ok {
+			return opts, fmt.Errorf("unknown ramp %q", name)
+		}
+		opts.Ramp = ramp
+	}
+
+	if query.Get("zmin") == "auto" || query.Get("zmax") == "auto" {
+		opts.ZMin, opts.ZMax = 0, 0
+	} else {
+		for _, p := range []struct {
+			name string
+			dst  *float64
+		}{{"zmin", &opts.ZMin}, {"zmax", &opts.ZMax}} {
+			s := query.Get(p.name)
+			if s == "" {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || !finite(v) {
+				return opts, fmt.Errorf("bad %s %q", p.name, s)
+			}
+			*p.dst = v
+		}
+	}
+
+	if query.Get("shading") == "off" {
+		opts.Light = [3]float64{}
+	}
+
+	return opts, opts.Validate()
+}
+
+/*
+Handler serves the surface of the formula in the "expr" query parameter, compile turns the formula into the function.
+The image is a PNG if the "format" parameter is "png", SVG otherwise, the rest of the parameters are described in
+ParseOptions. The requests coming while a rendering per CPU is in progress are rejected with 503, so are the ones
+whose client has gone.
+*/
+func Handler(compile func(expr string) (Func, error)) http.Handler {
+	renders := make(chan struct{}, runtime.NumCPU())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		f, err := compile(query.Get("expr"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad expr: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		opts, err := ParseOptions(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case renders <- struct{}{}:
+			defer func() { <-renders }()
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many renderings in progress", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Render into the buffer first, so an error can still be reported with its status
+		var (
+			buf         bytes.Buffer
+			contentType string
+		)
+		switch format := query.Get("format"); format {
+		case "png":
+			contentType = "image/png"
+			err = PNG(r.Context(), &buf, f, opts)
+		case "", "svg":
+			contentType = "image/svg+xml"
+			err = SVG(r.Context(), &buf, f, opts)
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case errors.Is(err, ErrTooSteep):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, context.Canceled):
+			http.Error(w, "the request is canceled", http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		buf.WriteTo(w)
+	})
+}
@@ -0,0 +1,132 @@
+package threedsurface
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// maxRaster bounds the pixels the triangles cover together, the steep cells of a large zscale span the whole canvas
+// each, so their number times the canvas would render for minutes
+const maxRaster = 1 << 26
+
+// ErrTooSteep is returned for the surfaces whose cells cover more than maxRaster pixels together
+var ErrTooSteep = errors.New("the surface is too steep to rasterize, lower zscale or cells")
+
+// Image rasterizes the surface, every cell is filled as two triangles and only the pixels nearest to the viewer
+// are kept, so the hidden cells don't show through whatever the rotation. It stops with ctx.Err() once ctx is done
+func Image(ctx context.Context, f Func, opts Options) (*image.RGBA, error) {
+	s, err := project(ctx, f, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		bounds = image.Rect(0, 0, opts.Width, opts.Height)
+		raster int
+	)
+	for _, c := range s.cells {
+		if raster += 2 * extent(bounds, c.corners[:]...); raster > maxRaster {
+			return nil, ErrTooSteep
+		}
+	}
+
+	var (
+		img  = image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+		zbuf = make([]float64, opts.Width*opts.Height)
+	)
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for i := range zbuf {
+		zbuf[i] = math.Inf(-1)
+	}
+
+	for i, c := range s.cells {
+		if i%256 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		a, b, cc, d := c.corners[0], c.corners[1], c.corners[2], c.corners[3]
+		fillTriangle(img, zbuf, a, b, cc, c.color)
+		fillTriangle(img, zbuf, a, cc, d, c.color)
+	}
+	return img, nil
+}
+
+// PNG writes the rasterized surface as a PNG image
+func PNG(ctx context.Context, w io.Writer, f Func, opts Options) error {
+	img, err := Image(ctx, f, opts)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// fillTriangle paints the pixels whose centers are inside the triangle and nearer than the ones painted before
+func fillTriangle(img *image.RGBA, zbuf []float64, p0, p1, p2 *point, c color.RGBA) {
+	area := edge(p0.sx, p0.sy, p1.sx, p1.sy, p2.sx, p2.sy)
+	if area == 0 {
+		return
+	}
+
+	var (
+		bounds = img.Bounds()
+		minX   = clamp(math.Floor(min(p0.sx, p1.sx, p2.sx)), bounds.Min.X, bounds.Max.X-1)
+		maxX   = clamp(math.Ceil(max(p0.sx, p1.sx, p2.sx)), bounds.Min.X, bounds.Max.X-1)
+		minY   = clamp(math.Floor(min(p0.sy, p1.sy, p2.sy)), bounds.Min.Y, bounds.Max.Y-1)
+		maxY   = clamp(math.Ceil(max(p0.sy, p1.sy, p2.sy)), bounds.Min.Y, bounds.Max.Y-1)
+	)
+
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+
+			// The barycentric coordinates, all of the same sign as the area inside the triangle
+			w0 := edge(p1.sx, p1.sy, p2.sx, p2.sy, px, py) / area
+			w1 := edge(p2.sx, p2.sy, p0.sx, p0.sy, px, py) / area
+			w2 := 1 - w0 - w1
+			if w0 < 0 || w1 < 0 || w2 < 0 {
+				continue
+			}
+
+			i := (y-bounds.Min.Y)*bounds.Dx() + (x - bounds.Min.X)
+			depth := w0*p0.depth + w1*p1.depth + w2*p2.depth
+			if depth <= zbuf[i] {
+				continue
+			}
+			zbuf[i] = depth
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// extent returns the number of the pixels of the bounding box of the points within the bounds
+func extent(bounds image.Rectangle, points ...*point) int {
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		minX, maxX = min(minX, p.sx), max(maxX, p.sx)
+		minY, maxY = min(minY, p.sy), max(maxY, p.sy)
+	}
+
+	var (
+		dx = clamp(math.Ceil(maxX), bounds.Min.X, bounds.Max.X-1) - clamp(math.Floor(minX), bounds.Min.X, bounds.Max.X-1)
+		dy = clamp(math.Ceil(maxY), bounds.Min.Y, bounds.Max.Y-1) - clamp(math.Floor(minY), bounds.Min.Y, bounds.Max.Y-1)
+	)
+	return (dx + 1) * (dy + 1)
+}
+
+// edge returns the doubled signed area of the triangle (a, b, p)
+func edge(ax, ay, bx, by, px, py float64) float64 {
+	return (bx-ax)*(py-ay) - (by-ay)*(px-ax)
+}
+
+// clamp converts v to an int within [lo, hi], the huge coordinates of the steep surfaces would overflow int
+func clamp(v float64, lo, hi int) int {
+	return int(max(float64(lo), min(float64(hi), v)))
+}
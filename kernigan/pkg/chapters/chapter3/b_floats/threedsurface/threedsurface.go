@@ -1,93 +1,298 @@
+/*
+Package threedsurface renders the surface z = f(x, y) as an isometric grid of cells, either as SVG polygons or as
+a PNG image.
+*/
 package threedsurface
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"image/color"
+	"io"
 	"math"
 	"net/http"
+	"sort"
 )
 
-var (
-	width, height = 600, 320                     // canvas size in pixels
-	cells         = 100                          // number of grid
-	xyrange       = 30.0                         // axis ranges (-xyrange...+xyrange)
-	xyscale       = float64(width) / 2 / xyrange // pixels per x or y unit
-	zscale        = float64(height) * 0.4        // pixels per z unit
-	angle         = math.Pi / 6                  // angle of x, y axes (=30*)
-	sin30, cos30  = math.Sin(angle), math.Cos(angle)
-	function      func(x, y float64) float64
-)
+// Func is the surface height at the point (x, y)
+type Func func(x, y float64) float64
 
-func GetSurface(writer http.ResponseWriter, fn func(x, y float64) float64) {
+// Options are the rendering parameters
+type Options struct {
+	// Canvas size in pixels
+	Width, Height int
+	// Number of grid cells along each axis
+	Cells int
+	// Width of the x and y ranges centered at zero
+	XYRange float64
+	// Pixels per z unit, 0.4 of the height if zero
+	ZScale float64
+	// Rotation of the surface around the z axis in radians
+	Rotation float64
+	// Angle of the x, y axes to the horizontal in radians, the higher the angle, the more from above we look
+	Elevation float64
+	// Colors of the cells from the lowest to the highest ones
+	Ramp Ramp
+	// Heights mapped to the ends of the ramp, the range of the surface is used if both are zero
+	ZMin, ZMax float64
+	// Direction to the light source, the cells aren't shaded if it's zero
+	Light [3]float64
+}
 
-	function = fn
+// DefaultOptions returns the parameters of the original 600x320 rendering with the shading added
+func DefaultOptions() Options {
+	return Options{
+		Width:     600,
+		Height:    320,
+		Cells:     100,
+		XYRange:   30,
+		Elevation: math.Pi / 6,
+		Ramp:      Ramps["bluered"],
+		ZMin:      -1,
+		ZMax:      1,
+		Light:     [3]float64{-1, -1, 2},
+	}
+}
 
-	// Write type of content we'll write
-	writer.Header().Set("Content-Type", "image/svg+xml")
-	// Set the scale factor (adjust as needed)
-	scale := 1.0
-
-	// Write the svg/xml top info with centering
-	fmt.Fprintf(writer, "<svg xmlns='http://www.w3.org/2000/svg' "+
-		"style='stroke: red; fill: white; stroke-width: 0.7' "+
-		"width='%d' height='%d' viewBox='0 0 %d %d'>", width, height, width, height)
-
-	// Find and reflect points on 2D canvas
-	for i := 0; i < cells; i++ {
-		for j := 0; j < cells; j++ {
-			ax, ay := corner(i+1, j)
-			bx, by := corner(i, j)
-			cx, cy := corner(i, j+1)
-			dx, dy := corner(i+1, j+1)
-
-			// Apply scaling
-			ax *= scale
-			bx *= scale
-			cx *= scale
-			dx *= scale
-			ay *= scale
-			by *= scale
-			cy *= scale
-			dy *= scale
-
-			// Calculate average height for the polygon
-			h1 := function(ax, ay)
-			h2 := function(bx, by)
-			h3 := function(cx, cy)
-			h4 := function(dx, dy)
-			avgHeight := (h1 + h2 + h3 + h4) / 4
-
-			// Map the height to a color from blue to red
-			color := colorMap(avgHeight)
-
-			// Output polygon with fill color
-			fmt.Fprintf(writer, "<polygon points='%g, %g, %g, %g, %g, %g, %g, %g' fill='%s' />\n", ax, ay, bx, by, cx, cy, dx, dy, color)
+// Validate reports the parameters the surface can't be rendered with
+func (o *Options) Validate() error {
+	switch {
+	case o.Width <= 0 || o.Height <= 0:
+		return fmt.Errorf("invalid canvas size %dx%d", o.Width, o.Height)
+	case o.Cells <= 0:
+		return fmt.Errorf("invalid number of cells %d", o.Cells)
+	case !(o.XYRange > 0) || !finite(o.XYRange):
+		return fmt.Errorf("invalid xyrange %g", o.XYRange)
+	case !finite(o.ZScale):
+		return fmt.Errorf("invalid zscale %g", o.ZScale)
+	case !finite(o.Rotation) || !finite(o.Elevation):
+		return fmt.Errorf("invalid angles %g, %g", o.Rotation, o.Elevation)
+	case len(o.Ramp) == 0:
+		return errors.New("empty color ramp")
+	}
+	return nil
+}
+
+// Ramp is a color gradient, its colors are evenly spaced from 0 to 1
+type Ramp []color.RGBA
+
+// Ramps are the predefined gradients
+var Ramps = map[string]Ramp{
+	"bluered": {{0, 0, 255, 255}, {255, 0, 0, 255}},
+	"terrain": {{0, 64, 160, 255}, {40, 160, 60, 255}, {150, 120, 70, 255}, {255, 255, 255, 255}},
+	"gray":    {{32, 32, 32, 255}, {224, 224, 224, 255}},
+	"heat":    {{0, 0, 0, 255}, {200, 0, 0, 255}, {255, 200, 0, 255}, {255, 255, 255, 255}},
+}
+
+// At returns the color at t, the values out of [0, 1] are clamped
+func (r Ramp) At(t float64) color.RGBA {
+	if len(r) == 1 || !(t > 0) {
+		return r[0]
+	}
+	if t >= 1 {
+		return r[len(r)-1]
+	}
+
+	t *= float64(len(r) - 1)
+	i := int(t)
+	t -= float64(i)
+
+	a, b := r[i], r[i+1]
+	lerp := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 255}
+}
+
+// point is a grid corner, ok is false if the surface isn't finite there
+type point struct {
+	// The screen coordinates and the distance towards the viewer
+	sx, sy, depth float64
+	// The world coordinates, z in the units of x and y
+	x, y, z float64
+	// The surface height
+	h  float64
+	ok bool
+}
+
+// cell is a filled quadrilateral of the grid
+type cell struct {
+	corners [4]*point
+	color   color.RGBA
+	depth   float64
+}
+
+// surface is the projected grid of a function
+type surface struct {
+	opts  Options
+	grid  []point
+	cells []cell
+}
+
+// project evaluates the function at the grid corners and builds the cells with all the corners finite, it stops with
+// ctx.Err() once ctx is done
+func project(ctx context.Context, f Func, opts Options) (*surface, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		n       = opts.Cells + 1
+		xyscale = float64(opts.Width) / 2 / opts.XYRange
+		zscale  = opts.ZScale
+
+		sinR, cosR = math.Sincos(opts.Rotation)
+		sinE, cosE = math.Sincos(opts.Elevation)
+
+		s = &surface{opts: opts, grid: make([]point, n*n)}
+	)
+	if zscale == 0 {
+		zscale = float64(opts.Height) * 0.4
+	}
+
+	zmin, zmax := math.Inf(1), math.Inf(-1)
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
+
+		for j := 0; j < n; j++ {
+			// Find point (x,y) at corner of cell (i,j)
+			x := opts.XYRange * (float64(i)/float64(opts.Cells) - 0.5)
+			y := opts.XYRange * (float64(j)/float64(opts.Cells) - 0.5)
+			z := f(x, y)
+
+			p := &s.grid[i*n+j]
+			if !finite(z) {
+				continue
+			}
+			zmin, zmax = min(zmin, z), max(zmax, z)
+
+			// Rotate (x,y) and project (x,y,z) isometrically onto the 2-D canvas (sx,sy)
+			rx, ry := x*cosR-y*sinR, x*sinR+y*cosR
+			*p = point{
+				sx: float64(opts.Width)/2 + (rx-ry)*cosE*xyscale,
+				sy: float64(opts.Height)/2 + (rx+ry)*sinE*xyscale - z*zscale,
+				// The viewing direction is the normal to the projection plane
+				depth: zscale*(rx+ry) + 2*sinE*xyscale*z,
+				x:     rx,
+				y:     ry,
+				z:     z * zscale / xyscale,
+				h:     z,
+			}
+			// The huge heights overflow the projection
+			p.ok = finite(p.sy) && finite(p.depth)
+		}
+	}
+
+	lo, hi := opts.ZMin, opts.ZMax
+	if lo == 0 && hi == 0 {
+		lo, hi = zmin, zmax
+	}
+	light := normalize(opts.Light)
+
+	for i := 0; i < opts.Cells; i++ {
+		for j := 0; j < opts.Cells; j++ {
+			c := cell{corners: [4]*point{
+				&s.grid[(i+1)*n+j], &s.grid[i*n+j], &s.grid[i*n+j+1], &s.grid[(i+1)*n+j+1],
+			}}
+			if !c.corners[0].ok || !c.corners[1].ok || !c.corners[2].ok || !c.corners[3].ok {
+				continue
+			}
+
+			var height float64
+			for _, p := range c.corners {
+				height += p.h
+				c.depth += p.depth
+			}
+			height /= 4
+			c.depth /= 4
+
+			t := 0.5
+			if hi > lo {
+				t = (height - lo) / (hi - lo)
+			}
+			c.color = shade(opts.Ramp.At(t), c.normal(), light)
+
+			s.cells = append(s.cells, c)
+		}
+	}
+
+	// The painter's algorithm: the farthest cells first
+	sort.SliceStable(s.cells, func(i, j int) bool {
+		return s.cells[i].depth < s.cells[j].depth
+	})
+	return s, nil
+}
+
+// normal returns the unit normal of the cell pointing up, computed from its diagonals
+func (c *cell) normal() [3]float64 {
+	a, b, cc, d := c.corners[0], c.corners[1], c.corners[2], c.corners[3]
+	u := [3]float64{cc.x - a.x, cc.y - a.y, cc.z - a.z}
+	v := [3]float64{d.x - b.x, d.y - b.y, d.z - b.z}
+
+	n := [3]float64{u[1]*v[2] - u[2]*v[1], u[2]*v[0] - u[0]*v[2], u[0]*v[1] - u[1]*v[0]}
+	if n[2] < 0 {
+		n = [3]float64{-n[0], -n[1], -n[2]}
+	}
+	return normalize(n)
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func normalize(v [3]float64) [3]float64 {
+	l := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	if l == 0 {
+		return v
+	}
+	return [3]float64{v[0] / l, v[1] / l, v[2] / l}
+}
+
+// ambient is the share of the light every cell gets regardless of its direction
+const ambient = 0.35
+
+// shade applies the Lambertian lighting to the color, the zero light leaves it as is
+func shade(c color.RGBA, normal, light [3]float64) color.RGBA {
+	if light == [3]float64{} {
+		return c
 	}
-	fmt.Fprintf(writer, "</svg>\n")
+
+	k := ambient + (1-ambient)*max(0, normal[0]*light[0]+normal[1]*light[1]+normal[2]*light[2])
+	scale := func(x uint8) uint8 {
+		return uint8(math.Round(float64(x) * k))
+	}
+	return color.RGBA{scale(c.R), scale(c.G), scale(c.B), c.A}
 }
 
-// Find points to be reflected on 2D canvas
-func corner(i, j int) (a float64, b float64) {
-	// Find point (x,y) at corner of cell (i,j)
-	x := xyrange * (float64(i)/float64(cells) - 0.5)
-	y := xyrange * (float64(j)/float64(cells) - 0.5)
+// SVG writes the surface as SVG polygons, the cells with non-finite heights are left out
+func SVG(ctx context.Context, w io.Writer, f Func, opts Options) error {
+	s, err := project(ctx, f, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "<svg xmlns='http://www.w3.org/2000/svg' "+
+		"style='stroke: grey; fill: white; stroke-width: 0.7' "+
+		"width='%d' height='%d' viewBox='0 0 %d %d'>\n", opts.Width, opts.Height, opts.Width, opts.Height)
 
-	// Compute surface height z
-	z := function(x, y)
+	for _, c := range s.cells {
+		a, b, cc, d := c.corners[0], c.corners[1], c.corners[2], c.corners[3]
+		fmt.Fprintf(w, "<polygon points='%g,%g %g,%g %g,%g %g,%g' fill='#%02x%02x%02x'/>\n",
+			a.sx, a.sy, b.sx, b.sy, cc.sx, cc.sy, d.sx, d.sy, c.color.R, c.color.G, c.color.B)
+	}
 
-	// Project (x,y,z) isometrically onto 2-D SVG canvas (sx, sy)
-	a = float64(width/2) + (x-y)*cos30*xyscale
-	b = float64(height/2) + (x+y)*sin30*xyscale - z*zscale
-	return
+	_, err = fmt.Fprintf(w, "</svg>\n")
+	return err
 }
 
-// Returns the color that depends on the height
-func colorMap(height float64) string {
-	// Map height to a color from blue to red
-	normalizedHeight := (height + 1) / 2   // Normalize height to the range [0, 1]
-	r := int(255 * normalizedHeight)       // Red component
-	b := int(255 * (1 - normalizedHeight)) // Blue component
-	g := 0                                 // Green component (you can customize this)
+// GetSurface writes the SVG of the surface with the default options
+func GetSurface(writer http.ResponseWriter, fn func(x, y float64) float64) {
+	writer.Header().Set("Content-Type", "image/svg+xml")
 
-	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	if err := SVG(context.Background(), writer, fn, DefaultOptions()); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
 }
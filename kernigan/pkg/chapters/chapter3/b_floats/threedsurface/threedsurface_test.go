@@ -0,0 +1,248 @@
+package threedsurface
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sinc(x, y float64) float64 {
+	r := math.Hypot(x, y)
+	return math.Sin(r) / r
+}
+
+func TestSVGSkipsNonFinite(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Cells = 10
+
+	var buf bytes.Buffer
+	if err := SVG(context.Background(), &buf, sinc, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	svg := buf.String()
+	if strings.Contains(svg, "NaN") || strings.Contains(svg, "Inf") {
+		t.Error("non-finite coordinates in the SVG")
+	}
+	// sin(r)/r is NaN at the center corner only, it's shared by 4 cells
+	if n := strings.Count(svg, "<polygon"); n != 10*10-4 {
+		t.Errorf("%d polygons, want %d", n, 10*10-4)
+	}
+}
+
+func TestImage(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Width, opts.Height, opts.Cells = 200, 120, 20
+	opts.Rotation = math.Pi / 4
+
+	var buf bytes.Buffer
+	if err := PNG(context.Background(), &buf, func(x, y float64) float64 { return 0 }, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 120 {
+		t.Fatalf("image is %dx%d, want 200x120", b.Dx(), b.Dy())
+	}
+
+	// The flat surface covers the center and leaves the corners blank
+	white := color.RGBA{255, 255, 255, 255}
+	if got := color.RGBAModel.Convert(img.At(100, 60)); got == white {
+		t.Error("the center of the image is blank")
+	}
+	if got := color.RGBAModel.Convert(img.At(0, 0)); got != white {
+		t.Errorf("the corner of the image is %v, want blank", got)
+	}
+}
+
+func TestZBuffer(t *testing.T) {
+	// Two flat triangles over the same pixels, the nearer one wins whatever the order
+	var (
+		near = [3]point{{sx: 0, sy: 0, depth: 2}, {sx: 10, sy: 0, depth: 2}, {sx: 0, sy: 10, depth: 2}}
+		far  = [3]point{{sx: 0, sy: 0, depth: 1}, {sx: 10, sy: 0, depth: 1}, {sx: 0, sy: 10, depth: 1}}
+		red  = color.RGBA{255, 0, 0, 255}
+		blue = color.RGBA{0, 0, 255, 255}
+	)
+
+	opts := DefaultOptions()
+	opts.Width, opts.Height = 10, 10
+	img, _ := Image(context.Background(), func(x, y float64) float64 { return math.NaN() }, opts)
+	zbuf := make([]float64, 100)
+	for i := range zbuf {
+		zbuf[i] = math.Inf(-1)
+	}
+
+	fillTriangle(img, zbuf, &near[0], &near[1], &near[2], red)
+	fillTriangle(img, zbuf, &far[0], &far[1], &far[2], blue)
+	if got := img.RGBAAt(2, 2); got != red {
+		t.Errorf("pixel is %v, want %v", got, red)
+	}
+}
+
+func TestRamp(t *testing.T) {
+	ramp := Ramp{{0, 0, 0, 255}, {200, 100, 0, 255}}
+
+	tests := []struct {
+		t    float64
+		want color.RGBA
+	}{
+		{-1, color.RGBA{0, 0, 0, 255}},
+		{0.5, color.RGBA{100, 50, 0, 255}},
+		{2, color.RGBA{200, 100, 0, 255}},
+		{math.NaN(), color.RGBA{0, 0, 0, 255}},
+	}
+	for _, test := range tests {
+		if got := ramp.At(test.t); got != test.want {
+			t.Errorf("At(%g) = %v, want %v", test.t, got, test.want)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, err := ParseOptions(url.Values{
+		"width": {"300"}, "rotation": {"90"}, "ramp": {"gray"}, "zmin": {"auto"}, "shading": {"off"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Width != 300 || opts.Height != 320 || math.Abs(opts.Rotation-math.Pi/2) > 1e-12 {
+		t.Errorf("got %+v", opts)
+	}
+	if opts.ZMin != 0 || opts.ZMax != 0 || opts.Light != [3]float64{} || len(opts.Ramp) != 2 {
+		t.Errorf("got %+v", opts)
+	}
+
+	for _, bad := range []url.Values{
+		{"width": {"0"}},
+		{"cells": {"100000"}},
+		{"xyrange": {"NaN"}},
+		{"ramp": {"rainbow"}},
+	} {
+		if _, err := ParseOptions(bad); err == nil {
+			t.Errorf("ParseOptions(%v) succeeded", bad)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	compile := func(expr string) (Func, error) {
+		if expr != "sinc" {
+			return nil, errors.New("unknown formula")
+		}
+		return sinc, nil
+	}
+
+	srv := httptest.NewServer(Handler(compile))
+	defer srv.Close()
+
+	tests := []struct {
+		query       string
+		status      int
+		contentType string
+	}{
+		{"expr=sinc", http.StatusOK, "image/svg+xml"},
+		{"expr=sinc&format=png&cells=30", http.StatusOK, "image/png"},
+		{"expr=cos", http.StatusBadRequest, ""},
+		{"expr=sinc&format=gif", http.StatusBadRequest, ""},
+		{"expr=sinc&width=-1", http.StatusBadRequest, ""},
+		{"expr=sinc&format=png&width=4096&height=4096", http.StatusBadRequest, ""},
+		{"expr=sinc&format=png&width=2048&height=2048&cells=400&zscale=1e7", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(srv.URL + "?" + test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.query, resp.StatusCode, test.status)
+		}
+		if got := resp.Header.Get("Content-Type"); test.contentType != "" && got != test.contentType {
+			t.Errorf("%s: content type %q, want %q", test.query, got, test.contentType)
+		}
+	}
+}
+
+func TestImageCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := Image(ctx, sinc, DefaultOptions()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Image = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestImageTooSteep(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Width, opts.Height, opts.Cells, opts.ZScale = 2048, 2048, 400, 1e7
+
+	start := time.Now()
+	_, err := Image(context.Background(), func(x, y float64) float64 { return math.Sin(x * y) }, opts)
+	if !errors.Is(err, ErrTooSteep) {
+		t.Errorf("Image = %v, want %v", err, ErrTooSteep)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Image took %s", elapsed)
+	}
+}
+
+func TestHandlerRenders(t *testing.T) {
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	compile := func(string) (Func, error) {
+		var once bool
+		return func(x, y float64) float64 {
+			if !once {
+				once = true
+				started <- struct{}{}
+				<-release
+			}
+			return 0
+		}, nil
+	}
+
+	srv := httptest.NewServer(Handler(compile))
+	defer srv.Close()
+
+	// Every rendering slot is taken by a request blocked in the function
+	done := make(chan struct{})
+	for range runtime.NumCPU() {
+		go func() {
+			if resp, err := http.Get(srv.URL + "?cells=1"); err == nil {
+				resp.Body.Close()
+			}
+			done <- struct{}{}
+		}()
+		<-started
+	}
+
+	resp, err := http.Get(srv.URL + "?cells=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("status %d, Retry-After %q; want 503 and the header", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	close(release)
+	for range runtime.NumCPU() {
+		<-done
+	}
+}
@@ -0,0 +1,108 @@
+package fractals
+
+import (
+	"math/big"
+)
+
+// referenceOrbit iterates the center of the tile in big.Float and returns the orbit rounded to float64. It stops at
+// the escape, so the orbit may be shorter than the iteration count.
+func referenceOrbit(t Tile, p Params) []complex128 {
+	var (
+		prec = uint(t.Z + 64)
+
+		// The center is -2 + (2X+1)*2^(1-z) + (2 - (2Y+1)*2^(1-z))i, exact in binary
+		cx = centerCoord(t.X, t.Z, prec, -2, 1)
+		cy = centerCoord(t.Y, t.Z, prec, 2, -1)
+
+		zx, zy = new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec)
+		ax, ay = new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec)
+	)
+
+	// The Mandelbrot orbit starts at 0 with the center as the constant, the Julia one starts at the center
+	if p.Kind == Julia {
+		zx.Set(cx)
+		zy.Set(cy)
+		cx.SetFloat64(real(p.C))
+		cy.SetFloat64(imag(p.C))
+	}
+
+	orbit := make([]complex128, 0, p.MaxIter+1)
+	for n := 0; n <= p.MaxIter; n++ {
+		x, _ := zx.Float64()
+		y, _ := zy.Float64()
+		orbit = append(orbit, complex(x, y))
+		if x*x+y*y > bailout*bailout {
+			break
+		}
+
+		// z = z^2 + c
+		ax.Mul(zx, zx)
+		ay.Mul(zy, zy)
+		zy.Mul(zy, zx)
+		zy.Add(zy, zy)
+		zy.Add(zy, cy)
+		zx.Sub(ax, ay)
+		zx.Add(zx, cx)
+	}
+	return orbit
+}
+
+// centerCoord returns origin + sign*(2i+1)*2^(1-z), the coordinate of the center of the tile column or row i
+func centerCoord(i *big.Int, z int, prec uint, origin float64, sign int) *big.Float {
+	k := new(big.Int).Lsh(i, 1)
+	k.Add(k, big.NewInt(1))
+	if sign < 0 {
+		k.Neg(k)
+	}
+
+	f := new(big.Float).SetPrec(prec).SetInt(k)
+	f.SetMantExp(f, 1-z)
+	return f.Add(f, new(big.Float).SetFloat64(origin))
+}
+
+/*
+perturbed iterates the pixels of the tile as the deltas from the reference orbit Z of its center:
+
+	z = Z + d, d -> 2Zd + d^2 + dc
+
+where dc is the offset of the pixel for the Mandelbrot set and zero for the Julia set. The deltas stay tiny, so
+float64 is enough for them however deep the tile is. When the pixel orbit comes closer to zero than its delta, or
+the reference orbit ends, the delta is rebased onto the start of the reference orbit, which avoids the glitches of
+the plain perturbation.
+*/
+func perturbed(t Tile, p Params) []escape {
+	var (
+		orbit   = referenceOrbit(t, p)
+		span    = t.span()
+		escapes = make([]escape, TileSize*TileSize)
+	)
+
+	for py := 0; py < TileSize; py++ {
+		for px := 0; px < TileSize; px++ {
+			var dz, dc complex128
+			if p.Kind == Julia {
+				dz = offset(px, py, span)
+			} else {
+				dc = offset(px, py, span)
+			}
+
+			e := escape{inside: true}
+			for n, m := 0, 0; n < p.MaxIter; n++ {
+				dz = 2*orbit[m]*dz + dz*dz + dc
+				m++
+
+				z := orbit[m] + dz
+				abs2 := real(z)*real(z) + imag(z)*imag(z)
+				if abs2 > bailout*bailout {
+					e = smooth(n+1, z)
+					break
+				}
+				if abs2 < real(dz)*real(dz)+imag(dz)*imag(dz) || m == len(orbit)-1 {
+					dz, m = z-orbit[0], 0
+				}
+			}
+			escapes[py*TileSize+px] = e
+		}
+	}
+	return escapes
+}
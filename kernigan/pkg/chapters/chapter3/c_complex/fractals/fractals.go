@@ -1,437 +1,289 @@
+/*
+Package fractals serves the Mandelbrot, Julia and Newton fractals as XYZ map tiles.
+
+At zoom z the square from -2-2i to 2+2i is split into 2^z x 2^z tiles of TileSize pixels, the tile (0, 0) is the
+top left one. The deep tiles of the Mandelbrot and Julia sets are computed by perturbation: a single reference orbit
+in big.Float per tile and the float64 deltas of the pixels around it, so the zoom isn't limited by the float64
+precision.
+
+	http://localhost:8000/                                   the viewer
+	http://localhost:8000/tiles/3/2/4.png?kind=julia&c=-0.8,0.156&iter=500&palette=fire
+*/
 package fractals
 
-// http://localhost:8000/create?type=mandelbrot&smoothing=0
 import (
 	"fmt"
 	"image"
 	"image/color"
-	"image/png"
-	"log"
 	"math"
+	"math/big"
 	"math/cmplx"
-	"math/rand"
-	"net/http"
-	"os"
 	"strconv"
-	"sync"
-	"time"
+	"strings"
 )
 
+// TileSize is the width and the height of a tile in pixels
+const TileSize = 256
+
+// The kinds of the fractals
 const (
-	width, height = 1024, 1024
-	iterations    = 200
-	contrast      = 15
+	Mandelbrot = "mandelbrot"
+	Julia      = "julia"
+	Newton     = "newton"
 )
 
-var (
-	img                    *image.RGBA
-	parametersToBeApplied  []string
-	zoom                           = 1.0
-	xmin, ymin, xmax, ymax float64 = -2, -2, 2, 2
+const (
+	// The limits of the iteration count
+	defaultIter = 256
+	maxIter     = 20000
+
+	// The zoom the tiles are computed by perturbation from
+	deepZoom = 32
+	// The deepest zoom, the float64 deltas of the pixels underflow far beyond it
+	maxZoom = 256
+	// The Newton fractal is computed in float64 only
+	maxNewtonZoom = 44
+
+	// The escape radius, the big one makes the smooth coloring precise
+	bailout = 256
 )
 
-func reInit() {
-	img = image.NewRGBA(image.Rect(0, 0, width, height))
-	parametersToBeApplied = make([]string, 0)
+// Params describe a fractal, they're comparable, so they're a part of the tile cache key
+type Params struct {
+	Kind    string
+	MaxIter int
+	Palette string
+	// The constant of the Julia set
+	C complex128
 }
 
-func Server() {
+// ParseParams reads the parameters from the query: kind, iter, palette and c for the Julia set as "re,im"
+func ParseParams(get func(key string) string) (Params, error) {
+	p := Params{Kind: Mandelbrot, MaxIter: defaultIter, Palette: "classic", C: complex(-0.8, 0.156)}
 
-	http.HandleFunc("/create", fractalHandler)
-	log.Fatalf("Error occured: %v", http.ListenAndServe("localhost:8000", nil))
-}
+	switch kind := get("kind"); kind {
+	case "":
+	case Mandelbrot, Julia, Newton:
+		p.Kind = kind
+	default:
+		return p, fmt.Errorf("unknown fractal kind %q", kind)
+	}
 
-func fractalHandler(writer http.ResponseWriter, request *http.Request) {
-	parameters := request.URL.Query()
-	if len(parameters) != 0 {
-		reInit()
-		for key, arrayOfValues := range parameters {
-			switch key {
-			case "type":
-				value := arrayOfValues[0]
-				switch value {
-				case "":
-					fmt.Fprint(os.Stderr, "Error occured: no type parameter\n")
-					os.Exit(1)
-				case "mandelbrot":
-					parametersToBeApplied = append(parametersToBeApplied, "mandelbrot")
-				case "newton":
-					parametersToBeApplied = append(parametersToBeApplied, "newton")
-
-				}
-
-			case "smoothing":
-				value := arrayOfValues[0]
-				switch value {
-				case "":
-					fmt.Fprint(os.Stderr, "Error occured: no smoothing parameter\n")
-					os.Exit(1)
-				case "0":
-					parametersToBeApplied = append(parametersToBeApplied, "0")
-				case "1":
-					parametersToBeApplied = append(parametersToBeApplied, "1")
-				}
-
-			case "zoom":
-				value := arrayOfValues[0]
-				switch value {
-				case "":
-					fmt.Fprint(os.Stderr, "Error occured: no smoothing parameter\n")
-					os.Exit(1)
-				default:
-					if value, err := strconv.ParseFloat(value, 64); err != nil {
-						fmt.Fprintf(os.Stderr, err.Error())
-					} else {
-						zoom = value
-					}
-				}
-			case "x":
-				value := arrayOfValues[0]
-				switch value {
-				case "":
-					fmt.Fprint(os.Stderr, "Error occured: no x parameter\n")
-					os.Exit(1)
-				default:
-					if value, err := strconv.ParseFloat(value, 64); err != nil {
-						fmt.Fprintf(os.Stderr, err.Error())
-					} else {
-						xmin, xmax = -value, value
-					}
-				}
-			case "y":
-				value := arrayOfValues[0]
-				switch value {
-				case "":
-					fmt.Fprint(os.Stderr, "Error occured: no y parameter\n")
-					os.Exit(1)
-				default:
-					if value, err := strconv.ParseFloat(value, 64); err != nil {
-						fmt.Fprintf(os.Stderr, err.Error())
-					} else {
-						ymin, ymax = -value, value
-					}
-				}
-			}
+	if s := get("iter"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxIter {
+			return p, fmt.Errorf("bad iter %q, want an integer from 1 to %d", s, maxIter)
 		}
-
-	} else {
-		fmt.Fprintf(os.Stderr, "Error: no parameters passed")
-		os.Exit(1)
+		p.MaxIter = n
 	}
 
-	parallelMandelbrot3()
-	rotate90Right(img)
-	switch parametersToBeApplied[1] {
-	case "0":
-	case "1":
-		smooth()
+	if name := get("palette"); name != "" {
+		if _, ok := Palettes[name]; !ok {
+			return p, fmt.Errorf("unknown palette %q", name)
+		}
+		p.Palette = name
 	}
 
-	png.Encode(writer, img)
-
-}
-
-func createFractal() {
-	timer := time.Now()
-	for px := 0; px < width; px++ {
-		// Scaling the current y coordinate so that it corresponds to location on complex plane
-		x := (float64(px)/width)*(xmax-xmin)*zoom + xmin
-		for py := 0; py < height; py++ {
-			// Scaling the current x coordinate so that it corresponds to location on complex plane
-			y := (float64(py)/height)*(ymax-ymin)*zoom + ymin
-			z := complex(-x, y)
-			switch parametersToBeApplied[0] {
-			case "mandelbrot":
-				img.Set(px, py, mandelbrot(z))
-			case "newton":
-				img.Set(px, py, newton(z))
-			}
+	if s := get("c"); s != "" {
+		re, im, ok := strings.Cut(s, ",")
+		x, errx := strconv.ParseFloat(re, 64)
+		y, erry := strconv.ParseFloat(im, 64)
+		if !ok || errx != nil || erry != nil || math.IsNaN(x+y) || math.IsInf(x+y, 0) {
+			return p, fmt.Errorf("bad c %q, want re,im", s)
 		}
+		p.C = complex(x, y)
 	}
 
-	log.Println(time.Since(timer))
+	return p, nil
 }
 
-type vector struct {
-	px, py int
-	colour color.RGBA
+// Tile is the position of a tile in the XYZ scheme, the columns and the rows are big as there are 2^z of them
+type Tile struct {
+	Z    int
+	X, Y *big.Int
 }
 
-// UNBUFFERED
-func parallelMandelbrot1() {
-	timer := time.Now()
-
-	colorChan := make(chan vector)
-	var wg sync.WaitGroup
-
-	for px := 0; px < width; px++ {
-		// Scaling the current y coordinate so that it corresponds to location on complex plane
-		x := (float64(px)/width)*(xmax-xmin)*zoom + xmin
-		for py := 0; py < height; py++ {
-			// Scaling the current x coordinate so that it corresponds to location on complex plane
-			y := (float64(py)/height)*(ymax-ymin)*zoom + ymin
-			z := complex(-x, y)
-			wg.Add(1)
-			go func(px, py int) {
-				defer wg.Done()
-				vector := vector{px: px, py: py, colour: mandelbrot(z)}
-				colorChan <- vector
-			}(px, py)
-		}
-	}
-
-	go func() {
-		wg.Wait()
-		close(colorChan)
-	}()
+// ParseTile reads the tile position from the decimal strings
+func ParseTile(z, x, y string) (Tile, error) {
+	var (
+		t   Tile
+		err error
+		ok  bool
+	)
 
-	for vector := range colorChan {
-		img.Set(vector.px, vector.py, vector.colour)
+	if t.Z, err = strconv.Atoi(z); err != nil {
+		return t, fmt.Errorf("bad zoom %q", z)
 	}
-	log.Println(time.Since(timer))
-}
-
-// BUFFERED UNIDIRECTIONAL SEND
-func parallelMandelbrot2() {
-	timer := time.Now()
-
-	colorChan := make(chan vector, width*height)
-	var wg sync.WaitGroup
-
-	for px := 0; px < width; px++ {
-		// Scaling the current y coordinate so that it corresponds to location on complex plane
-		x := (float64(px)/width)*(xmax-xmin)*zoom + xmin
-		for py := 0; py < height; py++ {
-			// Scaling the current x coordinate so that it corresponds to location on complex plane
-			y := (float64(py)/height)*(ymax-ymin)*zoom + ymin
-			z := complex(-x, y)
-			wg.Add(1)
-			go func(px, py int) {
-				defer wg.Done()
-				vector := vector{px: px, py: py, colour: mandelbrot(z)}
-				colorChan <- vector
-			}(px, py)
-		}
+	if t.X, ok = new(big.Int).SetString(x, 10); !ok {
+		return t, fmt.Errorf("bad column %q", x)
 	}
-
-	go func() {
-		wg.Wait()
-		close(colorChan)
-	}()
-
-	for vector := range colorChan {
-		img.Set(vector.px, vector.py, vector.colour)
+	if t.Y, ok = new(big.Int).SetString(y, 10); !ok {
+		return t, fmt.Errorf("bad row %q", y)
 	}
-	log.Println(time.Since(timer))
+	return t, nil
 }
 
-// BUFFERED BIDIRECTIONAL OPs
-func parallelMandelbrot3() {
-	timer := time.Now()
-
-	sendColorChan := make(chan vector, width*height)
-
-	var wg sync.WaitGroup
-
-	for px := 0; px < width; px++ {
-		// Scaling the current y coordinate so that it corresponds to location on complex plane
-		x := (float64(px)/width)*(xmax-xmin)*zoom + xmin
-		for py := 0; py < height; py++ {
-			// Scaling the current x coordinate so that it corresponds to location on complex plane
-			y := (float64(py)/height)*(ymax-ymin)*zoom + ymin
-			z := complex(-x, y)
-			wg.Add(1)
-			go func(px, py int) {
-				defer wg.Done()
-				vector := vector{px: px, py: py, colour: mandelbrot(z)}
-				sendColorChan <- vector
-				// why not set img here?
-			}(px, py)
-		}
-	}
+func (t Tile) String() string {
+	return fmt.Sprintf("%d/%s/%s", t.Z, t.X, t.Y)
+}
 
-	for v := range sendColorChan {
-		wg.Add(1)
-		go func(v vector) {
-			img.Set(v.px, v.py, v.colour)
-		}(v)
+// Validate reports the tiles out of the grid of their zoom or beyond the deepest zoom of the kind
+func (t Tile) Validate(kind string) error {
+	limit := maxZoom
+	if kind == Newton {
+		limit = maxNewtonZoom
+	}
+	if t.Z < 0 || t.Z > limit {
+		return fmt.Errorf("zoom %d out of range [0, %d]", t.Z, limit)
 	}
 
-	// sender
-	go func() {
-		wg.Wait()
-		close(sendColorChan)
-	}()
+	n := new(big.Int).Lsh(big.NewInt(1), uint(t.Z))
+	if t.X == nil || t.Y == nil || t.X.Sign() < 0 || t.X.Cmp(n) >= 0 || t.Y.Sign() < 0 || t.Y.Cmp(n) >= 0 {
+		return fmt.Errorf("tile %s out of range", t)
+	}
+	return nil
+}
 
-	log.Println(time.Since(timer))
+// span returns the width of the tile in the complex plane
+func (t Tile) span() float64 {
+	return math.Ldexp(4, -t.Z)
 }
 
-// WITHOUT CHANNEL
-func parallelMandelbrot4() {
-	timer := time.Now()
-
-	var wg sync.WaitGroup
-
-	for px := 0; px < width; px++ {
-		// Scaling the current y coordinate so that it corresponds to location on complex plane
-		x := (float64(px)/width)*(xmax-xmin)*zoom + xmin
-		for py := 0; py < height; py++ {
-			// Scaling the current x coordinate so that it corresponds to location on complex plane
-			y := (float64(py)/height)*(ymax-ymin)*zoom + ymin
-			z := complex(-x, y)
-			wg.Add(1)
-			go func(px, py int) {
-				//defer wg.Done()
-				img.Set(px, py, mandelbrot(z))
-				wg.Done()
-			}(px, py)
-		}
-	}
+// center returns the center of the tile in float64, it's precise enough for the shallow zooms only
+func (t Tile) center() complex128 {
+	span := t.span()
+	x, _ := new(big.Float).SetInt(t.X).Float64()
+	y, _ := new(big.Float).SetInt(t.Y).Float64()
+	return complex(-2+(x+0.5)*span, 2-(y+0.5)*span)
+}
 
-	wg.Wait()
-	log.Println(time.Since(timer))
+// offset returns the position of the center of the pixel relative to the center of the tile
+func offset(px, py int, span float64) complex128 {
+	return complex(
+		(float64(px)+0.5)/TileSize*span-span/2,
+		span/2-(float64(py)+0.5)/TileSize*span,
+	)
 }
 
-func smooth() {
+// escape is the outcome of iterating a point: the smooth iteration count or inside if it never escaped
+type escape struct {
+	mu     float64
+	inside bool
+}
 
-	for px := 0; px < height; px++ {
-		for py := 0; py < width; py++ {
-			pixelEnvironmentColors := getPixelEnvironmen(px, py)
-			// fmt.Println(pixelEnvironmentColors)
-			setAverageColor(px, py, pixelEnvironmentColors)
-			// fmt.Println(px, py)
-			pixelEnvironmentColors = make([]color.RGBA, 0)
-			// fmt.Println(pixelEnvironmentColors)
-		}
-	}
+// smooth returns the fractional iteration count of the point that escaped with z after n iterations
+func smooth(n int, z complex128) escape {
+	logZ := math.Log(real(z)*real(z)+imag(z)*imag(z)) / 2
+	return escape{mu: float64(n) + 1 - math.Log2(logZ/math.Ln2)}
 }
 
-func getPixelEnvironmen(px, py int) []color.RGBA {
-	var pixels []color.RGBA
-	for i := -1; i < 2; i++ {
-		surroundingXPixel := px - 1*i
-		for j := -1; j < 2; j++ {
-			surroundingYPixel := py - 1*j
-			if (surroundingXPixel >= 0 || surroundingXPixel < width) &&
-				(surroundingYPixel >= 0 || surroundingYPixel < width) {
-				pixels = append(pixels, img.RGBAAt(surroundingXPixel, surroundingYPixel))
-			}
+// iterate iterates z -> z^2 + c from z in float64
+func iterate(z, c complex128, maxIter int) escape {
+	for n := 0; n < maxIter; n++ {
+		z = z*z + c
+		if real(z)*real(z)+imag(z)*imag(z) > bailout*bailout {
+			return smooth(n+1, z)
 		}
 	}
-	return pixels
+	return escape{inside: true}
 }
 
-func setAverageColor(px, py int, pixelEnvironment []color.RGBA) {
-	var r, g, b, a int
-	for _, color := range pixelEnvironment {
-		r += int(color.R)
-		g += int(color.G)
-		b += int(color.B)
-		a += int(color.A)
-	}
+// newtonRoots are the roots of z^4 - 1 the Newton's method converges to
+var newtonRoots = []complex128{1, 1i, -1, -1i}
 
-	newColor := color.RGBA{
-		uint8(r / len(pixelEnvironment)),
-		uint8(g / len(pixelEnvironment)),
-		uint8(b / len(pixelEnvironment)),
-		uint8(a / len(pixelEnvironment)),
-	}
+// newton returns the index of the root the Newton's method for z^4 - 1 converges to from z, or -1, and the number
+// of the iterations it took
+func newton(z complex128, maxIter int) (root, n int) {
+	const epsilon = 1e-6
 
-	img.Set(px, py, newColor)
-}
-func mandelbrot(z complex128) color.RGBA {
-	var v complex128
-	for n := uint8(0); n < iterations; n++ {
-		v = v*v + z
-		var r uint8 = uint8(rand.Intn(256))
-		var g uint8 = uint8(rand.Intn(256))
-		var b uint8 = uint8(rand.Intn(256))
-		//  If absolute value of complex number > 2 (the point is allocated out of circe that has r = 2)
-		// We'll color the pixel with shade of the gray color
-		if cmplx.Abs(v) > 2*math.Pow(zoom, 2) {
-			// 255 is the absolute value of white color.
-			// Subracting values from 255 we get shades of gray color
-			return color.RGBA{r, g, b, 255 - uint8(rand.Intn(256))}
+	for n = 0; n < maxIter; n++ {
+		for i, r := range newtonRoots {
+			if cmplx.Abs(z-r) < epsilon {
+				return i, n
+			}
+		}
+
+		z3 := z * z * z
+		if z3 == 0 {
+			break
 		}
+		z -= (z3*z - 1) / (4 * z3)
 	}
-	// If absolute value of complex number <= 2 (the point is allocated inside the cirlce that has r = 2)
-	// We'll color the point with black
-	return color.RGBA{0, 0, 255, uint8(rand.Intn(256))}
+	return -1, n
 }
 
-func newton(initialGuess complex128) color.RGBA {
-	const epsilon float64 = 1e-5 // Precision of float64
-	const maxIterations = 10000  // Max amount of iterations
-
-	var countOfCycles int64 // We'll count this value
+// Render draws the tile, it's safe for concurrent use
+func Render(t Tile, p Params) (*image.RGBA, error) {
+	if err := t.Validate(p.Kind); err != nil {
+		return nil, err
+	}
+	palette, ok := Palettes[p.Palette]
+	if !ok {
+		return nil, fmt.Errorf("unknown palette %q", p.Palette)
+	}
 
-	z := initialGuess // Initial point on the complex plane
+	img := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
 
-	// Depending of the found approximation we'll match the color
-	rootColors := []color.RGBA{
-		{255, 0, 0, 255},   // Red
-		{0, 255, 0, 255},   // Green
-		{0, 0, 255, 255},   // Blue
-		{255, 255, 0, 255}, // Yellow
+	if p.Kind == Newton {
+		span, center := t.span(), t.center()
+		for py := 0; py < TileSize; py++ {
+			for px := 0; px < TileSize; px++ {
+				root, n := newton(center+offset(px, py, span), p.MaxIter)
+				img.SetRGBA(px, py, palette.root(root, n))
+			}
+		}
+		return img, nil
 	}
 
-	for countOfCycles = 0; cmplx.Abs(f(z)) > epsilon && countOfCycles < maxIterations; countOfCycles++ {
-		z -= f(z) / fpr(z)
+	var escapes []escape
+	if t.Z >= deepZoom {
+		escapes = perturbed(t, p)
+	} else {
+		escapes = direct(t, p)
 	}
-
-	// If the iteration count is below the maximum, find the index of the root that was approached
-	if countOfCycles < maxIterations {
-		rootIndex := findApproachedRoot(z)
-		return rootColors[rootIndex]
+	for i, e := range escapes {
+		img.SetRGBA(i%TileSize, i/TileSize, palette.escape(e))
 	}
-
-	// If the iteration count exceeds the maximum, return a default color (you may adjust this)
-	return color.RGBA{0, 0, 0, 255}
+	return img, nil
 }
 
-func findApproachedRoot(z complex128) int {
-	roots := []complex128{
-		cmplx.Pow(complex(1, 0), 4),
-		cmplx.Pow(complex(0, 1), 4),
-		cmplx.Pow(complex(-1, 0), 4),
-		cmplx.Pow(complex(0, -1), 4),
-	}
-
-	// Find the index of the root that z is closest to
-	minDistance := cmplx.Abs(z - roots[0])
-	minIndex := 0
-	for i := 1; i < len(roots); i++ {
-		distance := cmplx.Abs(z - roots[i])
-		if distance < minDistance {
-			minDistance = distance
-			minIndex = i
+// direct iterates the pixels of the tile in float64
+func direct(t Tile, p Params) []escape {
+	var (
+		span    = t.span()
+		center  = t.center()
+		escapes = make([]escape, TileSize*TileSize)
+	)
+
+	for py := 0; py < TileSize; py++ {
+		for px := 0; px < TileSize; px++ {
+			point := center + offset(px, py, span)
+
+			if p.Kind == Julia {
+				escapes[py*TileSize+px] = iterate(point, p.C, p.MaxIter)
+			} else {
+				escapes[py*TileSize+px] = iterate(0, point, p.MaxIter)
+			}
 		}
 	}
-
-	return minIndex
-}
-
-func f(z complex128) complex128 {
-	return cmplx.Pow(z, 4) - 1
+	return escapes
 }
 
-func fpr(z complex128) complex128 {
-	return 4 * cmplx.Pow(z, 3)
+// escape returns the color of the escape
+func (pl *Palette) escape(e escape) color.RGBA {
+	if e.inside {
+		return pl.Inside
+	}
+	return pl.At(e.mu / pl.Period)
 }
 
-func rotate90Right(original *image.RGBA) {
-	width := original.Bounds().Dx()
-	height := original.Bounds().Dy()
-
-	// Создаем новое изображение с перевернутыми размерами
-	rotated := image.NewRGBA(image.Rect(0, 0, height, width))
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			// Копируем пиксели из оригинального изображения с учетом поворота
-			rotated.Set(y, width-x, original.At(x, y))
-		}
+// root returns the color of the root shaded by the number of the iterations
+func (pl *Palette) root(root, n int) color.RGBA {
+	if root < 0 {
+		return pl.Inside
 	}
 
-	img = rotated
+	c := pl.At(float64(root) / float64(len(newtonRoots)))
+	k := math.Max(0.25, 1-float64(n)/64)
+	return color.RGBA{uint8(float64(c.R) * k), uint8(float64(c.G) * k), uint8(float64(c.B) * k), 255}
 }
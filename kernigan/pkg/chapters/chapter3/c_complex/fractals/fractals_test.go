@@ -0,0 +1,251 @@
+package fractals
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func mustTile(t *testing.T, z int, x, y string) Tile {
+	t.Helper()
+
+	tile, err := ParseTile(strconv.Itoa(z), x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tile
+}
+
+func TestParseParams(t *testing.T) {
+	p, err := ParseParams(url.Values{"kind": {"julia"}, "iter": {"500"}, "c": {"0.285,0.01"}}.Get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Params{Kind: Julia, MaxIter: 500, Palette: "classic", C: complex(0.285, 0.01)}); p != want {
+		t.Errorf("ParseParams() = %+v, want %+v", p, want)
+	}
+
+	for _, bad := range []url.Values{
+		{"kind": {"sierpinski"}},
+		{"iter": {"0"}},
+		{"iter": {"many"}},
+		{"palette": {"pink"}},
+		{"c": {"1"}},
+		{"c": {"NaN,0"}},
+	} {
+		if _, err := ParseParams(bad.Get); err == nil {
+			t.Errorf("ParseParams(%v) succeeded", bad)
+		}
+	}
+}
+
+func TestValidateTile(t *testing.T) {
+	deep := new(big.Int).Lsh(big.NewInt(1), 99).String()
+
+	tests := []struct {
+		tile Tile
+		kind string
+		ok   bool
+	}{
+		{mustTile(t, 0, "0", "0"), Mandelbrot, true},
+		{mustTile(t, 2, "3", "3"), Mandelbrot, true},
+		{mustTile(t, 2, "4", "0"), Mandelbrot, false},
+		{mustTile(t, 2, "-1", "0"), Mandelbrot, false},
+		{mustTile(t, 99, "0", "0"), Newton, false},
+		{mustTile(t, 99, deep, "0"), Mandelbrot, false},
+	}
+	for _, test := range tests {
+		if err := test.tile.Validate(test.kind); (err == nil) != test.ok {
+			t.Errorf("%s %s: Validate() = %v", test.kind, test.tile, err)
+		}
+	}
+
+	if _, err := ParseTile("3", "1.5", "0"); err == nil {
+		t.Error("ParseTile accepted a fractional column")
+	}
+}
+
+// The perturbation agrees with the plain float64 iteration where the latter is precise
+func TestPerturbationAgrees(t *testing.T) {
+	for _, p := range []Params{
+		{Kind: Mandelbrot, MaxIter: 300, Palette: "classic"},
+		{Kind: Julia, MaxIter: 300, Palette: "classic", C: complex(-0.8, 0.156)},
+	} {
+		tile := mustTile(t, 6, "20", "29")
+
+		var (
+			want = direct(tile, p)
+			got  = perturbed(tile, p)
+			bad  = 0
+		)
+		for i := range want {
+			if want[i].inside != got[i].inside || math.Abs(want[i].mu-got[i].mu) > 1e-3 {
+				bad++
+			}
+		}
+		if bad > len(want)/100 {
+			t.Errorf("%s: %d of %d pixels differ", p.Kind, bad, len(want))
+		}
+	}
+}
+
+func distinctColors(t *testing.T, data []byte) int {
+	t.Helper()
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != TileSize || b.Dy() != TileSize {
+		t.Fatalf("tile is %dx%d", b.Dx(), b.Dy())
+	}
+
+	colors := make(map[color.Color]bool)
+	for y := 0; y < TileSize; y++ {
+		for x := 0; x < TileSize; x++ {
+			colors[img.At(x, y)] = true
+		}
+	}
+	return len(colors)
+}
+
+func TestDeepZoom(t *testing.T) {
+	// The tile at zoom 80 with the corner at i, a Misiurewicz point of the boundary with the structure at any scale
+	var (
+		z = 80
+		n = new(big.Int).Lsh(big.NewInt(1), uint(z))
+		// re = -2 + x * 4 / 2^z and im = 2 - y * 4 / 2^z at the top left corner of the tile
+		x = new(big.Int).Rsh(n, 1)
+		y = new(big.Int).Rsh(n, 2)
+	)
+
+	s := NewService(Config{Workers: 2})
+	defer s.Close()
+
+	data, err := s.Tile(context.Background(), Tile{Z: z, X: x, Y: y}, Params{Kind: Mandelbrot, MaxIter: 2000, Palette: "classic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := distinctColors(t, data); n < 10 {
+		t.Errorf("the deep tile has %d colors only", n)
+	}
+}
+
+func TestService(t *testing.T) {
+	s := NewService(Config{Workers: 2, CacheTiles: 4})
+	defer s.Close()
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	get := func(path string) (*http.Response, []byte) {
+		t.Helper()
+
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	for _, path := range []string{"/tiles/1/0/1.png", "/tiles/1/0/1.png", "/tiles/2/1/1.png?kind=newton&palette=rgby"} {
+		resp, body := get(path)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", path, resp.StatusCode, body)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+			t.Errorf("%s: content type %q", path, ct)
+		}
+		if n := distinctColors(t, body); n < 4 {
+			t.Errorf("%s: %d colors", path, n)
+		}
+	}
+	if stats := s.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("Stats() = %+v, want 1 hit and 2 misses", stats)
+	}
+
+	for path, status := range map[string]int{
+		"/tiles/1/0/1.jpg":             http.StatusNotFound,
+		"/tiles/1/2/0.png":             http.StatusNotFound,
+		"/tiles/x/0/0.png":             http.StatusBadRequest,
+		"/tiles/1/0/0.png?kind=cantor": http.StatusBadRequest,
+	} {
+		if resp, _ := get(path); resp.StatusCode != status {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+
+	resp, body := get("/")
+	if resp.StatusCode != http.StatusOK || !bytes.Contains(body, []byte("<title>Fractals</title>")) {
+		t.Errorf("viewer: status %d", resp.StatusCode)
+	}
+}
+
+func TestDisconnectedClient(t *testing.T) {
+	s := NewService(Config{Workers: 1})
+	defer s.Close()
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	const path = "/tiles/3/2/3.png?iter=5000"
+
+	// The client goes away while the tile is rendered
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	for s.Stats().Misses == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// The next request for the tile doesn't get the abandoned rendering
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if n := distinctColors(t, body); n < 4 {
+		t.Errorf("%d colors", n)
+	}
+}
+
+func TestClosedService(t *testing.T) {
+	s := NewService(Config{Workers: 1})
+	s.Close()
+
+	if _, err := s.Tile(context.Background(), mustTile(t, 0, "0", "0"), Params{Kind: Mandelbrot, MaxIter: 10, Palette: "gray"}); err != ErrClosed {
+		t.Errorf("Tile() error = %v, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,59 @@
+package fractals
+
+import (
+	"image/color"
+	"math"
+)
+
+// Palette colors the escape times, the colors are evenly spaced on a cycle
+type Palette struct {
+	Colors []color.RGBA
+	// The color of the points that never escape or converge
+	Inside color.RGBA
+	// The number of the iterations a cycle of the colors takes
+	Period float64
+}
+
+// Palettes are the palettes a tile can be rendered with
+var Palettes = map[string]*Palette{
+	"classic": {
+		Colors: []color.RGBA{
+			{0, 7, 100, 255}, {32, 107, 203, 255}, {237, 255, 255, 255}, {255, 170, 0, 255}, {0, 2, 0, 255},
+		},
+		Inside: color.RGBA{0, 0, 0, 255},
+		Period: 64,
+	},
+	"fire": {
+		Colors: []color.RGBA{{20, 0, 0, 255}, {200, 30, 0, 255}, {255, 200, 40, 255}, {255, 255, 220, 255}},
+		Inside: color.RGBA{0, 0, 0, 255},
+		Period: 48,
+	},
+	"gray": {
+		Colors: []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}},
+		Inside: color.RGBA{0, 0, 0, 255},
+		Period: 32,
+	},
+	"rgby": {
+		Colors: []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 0, 255}},
+		Inside: color.RGBA{0, 0, 0, 255},
+		Period: 16,
+	},
+}
+
+// At returns the color at t, the palette wraps around, so t is taken modulo 1
+func (pl *Palette) At(t float64) color.RGBA {
+	t -= math.Floor(t)
+	if math.IsNaN(t) {
+		t = 0
+	}
+
+	t *= float64(len(pl.Colors))
+	i := int(t) % len(pl.Colors)
+	t -= math.Floor(t)
+
+	a, b := pl.Colors[i], pl.Colors[(i+1)%len(pl.Colors)]
+	lerp := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 255}
+}
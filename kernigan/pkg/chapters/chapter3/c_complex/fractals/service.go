@@ -0,0 +1,232 @@
+package fractals
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"math/big"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang/pkg/chapters/chapter9/sub7/memo"
+)
+
+//go:embed viewer.html
+var viewer []byte
+
+// ErrClosed is returned for the tiles requested after the service has been closed
+var ErrClosed = errors.New("fractals: service closed")
+
+// Config sizes the workers and the tile cache of a Service
+type Config struct {
+	// The number of the tiles rendered at the same time, the number of CPUs by default
+	Workers int
+	// The number of the encoded tiles kept in memory, 1024 by default
+	CacheTiles int
+}
+
+/*
+Service is an http.Handler serving the tiles at /tiles/{z}/{x}/{y}.png and the viewer at /. The tiles are rendered
+by a fixed number of workers, so a burst of requests doesn't start more renderings than there are CPUs, and the
+encoded ones are kept in an LRU cache. The concurrent requests for the same tile share a single rendering.
+*/
+type Service struct {
+	cache *memo.Memo[tileKey, []byte]
+	jobs  chan job
+	quit  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+	mux   *http.ServeMux
+}
+
+// tileKey identifies a rendered tile, the big tile coordinates are kept as decimal strings to be comparable
+type tileKey struct {
+	z      int
+	x, y   string
+	params Params
+}
+
+type job struct {
+	ctx    context.Context
+	key    tileKey
+	result chan<- result
+}
+
+type result struct {
+	data []byte
+	err  error
+}
+
+func NewService(config Config) *Service {
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.CacheTiles <= 0 {
+		config.CacheTiles = 1024
+	}
+
+	s := &Service{
+		jobs: make(chan job),
+		quit: make(chan struct{}),
+		mux:  http.NewServeMux(),
+	}
+	s.cache = memo.New(s.render, memo.MaxEntries(config.CacheTiles))
+
+	s.wg.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go s.worker()
+	}
+
+	s.mux.HandleFunc("GET /tiles/{z}/{x}/{file}", s.serveTile)
+	s.mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(viewer)
+	})
+
+	return s
+}
+
+// Close stops the workers, the renderings in progress are completed
+func (s *Service) Close() {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+	s.wg.Wait()
+}
+
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Tile returns the PNG of the tile from the cache or renders it
+func (s *Service) Tile(ctx context.Context, t Tile, p Params) ([]byte, error) {
+	if err := t.Validate(p.Kind); err != nil {
+		return nil, err
+	}
+	if _, ok := Palettes[p.Palette]; !ok {
+		return nil, fmt.Errorf("unknown palette %q", p.Palette)
+	}
+
+	return s.cache.Get(ctx, tileKey{z: t.Z, x: t.X.String(), y: t.Y.String(), params: p})
+}
+
+func (s *Service) Stats() memo.Stats {
+	return s.cache.Stats()
+}
+
+// render passes the tile to a worker and waits for it, it's the function memoized by the cache
+func (s *Service) render(ctx context.Context, key tileKey) ([]byte, error) {
+	results := make(chan result, 1)
+
+	select {
+	case s.jobs <- job{ctx: ctx, key: key, result: results}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.quit:
+		return nil, ErrClosed
+	}
+
+	select {
+	case res := <-results:
+		return res.data, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Service) worker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case j := <-s.jobs:
+			// Nobody waits for the tile anymore
+			if err := j.ctx.Err(); err != nil {
+				j.result <- result{err: err}
+				continue
+			}
+
+			data, err := encode(j.key)
+			j.result <- result{data, err}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func encode(key tileKey) ([]byte, error) {
+	t := Tile{Z: key.z, X: new(big.Int), Y: new(big.Int)}
+	t.X.SetString(key.x, 10)
+	t.Y.SetString(key.y, 10)
+
+	img, err := Render(t, key.params)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Service) serveTile(w http.ResponseWriter, r *http.Request) {
+	y, ok := strings.CutSuffix(r.PathValue("file"), ".png")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	t, err := ParseTile(r.PathValue("z"), r.PathValue("x"), y)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := ParseParams(r.URL.Query().Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := t.Validate(p.Kind); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	data, err := s.Tile(r.Context(), t, p)
+	switch {
+	case r.Context().Err() != nil:
+		// The client has gone
+		return
+	case errors.Is(err, ErrClosed):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, context.Canceled):
+		// The rendering was abandoned by the clients that have gone, it isn't cached and the retry starts a new one
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	// A tile never changes
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	w.Write(data)
+}
+
+// Server serves the fractals at localhost:8000
+func Server() {
+	service := NewService(Config{})
+	defer service.Close()
+
+	log.Fatalf("Error occured: %v", http.ListenAndServe("localhost:8000", service))
+}
@@ -5,8 +5,8 @@ import (
 	"fmt"
 )
 
-// blockSize defines the max blocksize size
-const blockSize = 64
+// blockSize is the number of the bits in a word: 32 or 64 depending on the platform
+const blockSize = 32 << (^uint(0) >> 63)
 
 // An IntSet is a set of small non-negative integers.
 // Its zero value represents the empty set
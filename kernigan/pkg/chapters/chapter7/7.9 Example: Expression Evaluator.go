@@ -213,6 +213,16 @@ func (fc functionCall) Eval(env Environment) float64 {
 // The map to check an arity of a function
 var paramsNum = map[string]int{"pow": 2, "sin": 1, "sqrt": 1}
 
+// Functions returns the names of the supported functions mapped to their arity
+func Functions() map[string]int {
+	functions := make(map[string]int, len(paramsNum))
+	for name, arity := range paramsNum {
+		functions[name] = arity
+	}
+
+	return functions
+}
+
 /*
 1) Check the presence of the function gievn in the function map
 2) Check whether params count is valid or not
@@ -327,28 +337,28 @@ func parseAndCheck(input string) (Expression, error) {
 	return expr, nil
 }
 
-func plot(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-
+// compileSurface turns the formula of x, y and r, the distance from (0,0), into the surface function
+func compileSurface(input string) (Func, error) {
 	// Get an expression from the user input and perform its validation check
-	expr, err := parseAndCheck(r.Form.Get("expr"))
+	expr, err := parseAndCheck(input)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("bad expr: %s", err), http.StatusBadRequest)
-		return
+		return nil, err
 	}
 
-	// Plot a surface and send to user the image of the plotted surface
-	GetSurface(w,
-		func(x, y float64) float64 {
-			r := math.Hypot(x, y) // distance from (0,0)
-			return expr.Eval(Environment{"x": x, "y": y, "r": r})
-		})
+	return func(x, y float64) float64 {
+		r := math.Hypot(x, y) // distance from (0,0)
+		return expr.Eval(Environment{"x": x, "y": y, "r": r})
+	}, nil
 }
 
+/*
+StartServer serves the surfaces of the formulas, e.g. /plot?expr=sin(r)/r&format=png&rotation=30, see
+threedsurface.ParseOptions for the rendering parameters.
+*/
 func StartServer() {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/plot", plot)
+	mux.Handle("/plot", Handler(compileSurface))
 
 	http.ListenAndServe("localhost:8080", mux)
 }
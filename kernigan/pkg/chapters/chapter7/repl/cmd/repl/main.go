@@ -0,0 +1,23 @@
+// The repl command evaluates the expressions of section 7.9 interactively, see repl.Run
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"golang/pkg/chapters/chapter7/repl"
+)
+
+func main() {
+	var cfg repl.Config
+	flag.StringVar(&cfg.Prompt, "prompt", "", "the prompt, \">> \" by default")
+	flag.StringVar(&cfg.HistoryFile, "history", "", "the history file, ~/.evalrepl_history by default, \"-\" for none")
+	flag.IntVar(&cfg.HistorySize, "history-size", 0, "the number of the history entries kept, 1000 by default")
+	flag.Parse()
+
+	if err := repl.New(cfg).Run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "repl:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,73 @@
+package repl
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	evaluator "golang/pkg/chapters/chapter7"
+)
+
+/*
+Returns the candidates completing the word that ends at pos of line and the start of this word.
+
+1) If the line is a meta-command name, complete the meta-commands
+2) Otherwise complete the functions, adding an opening parenthesis, and the variables
+*/
+func (r *REPL) complete(line []rune, pos int) (candidates []string, start int) {
+	start = pos
+	for start > 0 && isIdentRune(line[start-1]) {
+		start--
+	}
+	prefix := string(line[start:pos])
+
+	if start == 1 && line[0] == ':' {
+		for command := range metaCommands {
+			if strings.HasPrefix(command[1:], prefix) {
+				candidates = append(candidates, command[1:])
+			}
+		}
+		sort.Strings(candidates)
+		return candidates, start
+	}
+
+	if prefix == "" {
+		return nil, start
+	}
+
+	for name := range evaluator.Functions() {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name+"(")
+		}
+	}
+	for name := range r.env {
+		if strings.HasPrefix(string(name), prefix) {
+			candidates = append(candidates, string(name))
+		}
+	}
+	sort.Strings(candidates)
+
+	return candidates, start
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Returns the longest common prefix of the strings
+func commonPrefix(strs []string) string {
+	if len(strs) == 0 {
+		return ""
+	}
+
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+
+	return prefix
+}
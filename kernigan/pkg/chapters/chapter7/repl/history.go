@@ -0,0 +1,75 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// history keeps the entered statements, the oldest first
+type history struct {
+	entries []string
+	size    int
+}
+
+func newHistory(size int) *history {
+	return &history{size: size}
+}
+
+// Appends the statement unless it repeats the last one, drops the oldest entries above the size limit
+func (h *history) add(statement string) {
+	statement = strings.TrimSpace(statement)
+	if statement == "" {
+		return
+	}
+	if len(h.entries) > 0 && h.entries[len(h.entries)-1] == statement {
+		return
+	}
+
+	h.entries = append(h.entries, statement)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+}
+
+// Reads the history file, one statement per line. A missing file isn't an error.
+func (h *history) load(fileName string) error {
+	file, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		h.add(scanner.Text())
+	}
+
+	return scanner.Err()
+}
+
+// Rewrites the history file with the current entries
+func (h *history) save(fileName string) error {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, entry := range h.entries {
+		writer.WriteString(entry)
+		writer.WriteByte('\n')
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
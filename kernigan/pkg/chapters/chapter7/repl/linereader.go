@@ -0,0 +1,311 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// lineReader reads a single line of the user input
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// Returns the line editor if in is a terminal, otherwise the plain line reader
+func newLineReader(in io.Reader, out io.Writer, h *history, complete completeFunc) lineReader {
+	if file, ok := in.(*os.File); ok && isTerminal(int(file.Fd())) {
+		return &editor{
+			fd:       int(file.Fd()),
+			in:       bufio.NewReader(file),
+			out:      out,
+			history:  h,
+			complete: complete,
+		}
+	}
+
+	return &plainReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+// plainReader reads lines without editing, e.g. from a pipe or a file
+type plainReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (pr *plainReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(pr.out, prompt)
+
+	if !pr.scanner.Scan() {
+		if err := pr.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+
+	return pr.scanner.Text(), nil
+}
+
+type completeFunc func(line []rune, pos int) (candidates []string, start int)
+
+// The control keys handled by the editor
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyTab       = 9
+	keyLineFeed  = 10
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+/*
+editor is an emacs-like line editor working in the raw terminal mode. It supports:
+  - cursor movement with arrows, Home/End, Ctrl-A/E/B/F
+  - deletion with Backspace, Delete, Ctrl-D/K/U/W
+  - history navigation with Up/Down and Ctrl-P/N
+  - completion with Tab
+*/
+type editor struct {
+	fd  int
+	in  *bufio.Reader
+	out io.Writer
+
+	history  *history
+	complete completeFunc
+
+	prompt string
+	line   []rune
+	pos    int
+
+	// The position in the history while navigating it and the line being edited before the navigation has started
+	historyPos int
+	draft      []rune
+}
+
+/*
+1) Switch the terminal to the raw mode and restore it on return
+2) Read keys one by one, editing the line and redrawing it
+3) Return the line on Enter, errInterrupted on Ctrl-C and io.EOF on Ctrl-D in an empty line
+*/
+func (e *editor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", fmt.Errorf("switching the terminal to the raw mode; %s", err)
+	}
+	defer restore()
+
+	e.prompt, e.line, e.pos = prompt, nil, 0
+	e.historyPos, e.draft = len(e.history.entries), nil
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case keyEnter, keyLineFeed:
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.line)
+		case keyCtrlB:
+			e.moveLeft()
+		case keyCtrlF:
+			e.moveRight()
+		case keyCtrlK:
+			e.line = e.line[:e.pos]
+		case keyCtrlU:
+			e.line, e.pos = append([]rune(nil), e.line[e.pos:]...), 0
+		case keyCtrlW:
+			e.deleteWord()
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case keyCtrlP:
+			e.historyPrev()
+		case keyCtrlN:
+			e.historyNext()
+		case keyBackspace, '\b':
+			if e.pos > 0 {
+				e.pos--
+				e.deleteAt(e.pos)
+			}
+		case keyTab:
+			e.completeWord()
+		case keyEscape:
+			e.escapeSequence()
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				e.insert(r)
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+// Handles the ANSI escape sequences of the arrows, Home, End and Delete keys
+func (e *editor) escapeSequence() {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return
+	}
+
+	switch r {
+	case 'A':
+		e.historyPrev()
+	case 'B':
+		e.historyNext()
+	case 'C':
+		e.moveRight()
+	case 'D':
+		e.moveLeft()
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.line)
+	case '1', '3', '4', '7', '8':
+		// Sequences like "\x1b[3~" (Delete), "\x1b[1~" (Home) and "\x1b[4~" (End)
+		if next, _, err := e.in.ReadRune(); err != nil || next != '~' {
+			return
+		}
+		switch r {
+		case '3':
+			e.deleteAt(e.pos)
+		case '1', '7':
+			e.pos = 0
+		case '4', '8':
+			e.pos = len(e.line)
+		}
+	}
+}
+
+func (e *editor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.pos+1:], e.line[e.pos:])
+	e.line[e.pos] = r
+	e.pos++
+}
+
+func (e *editor) deleteAt(pos int) {
+	if pos < 0 || pos >= len(e.line) {
+		return
+	}
+	e.line = append(e.line[:pos], e.line[pos+1:]...)
+}
+
+// Deletes the word before the cursor and the spaces after it
+func (e *editor) deleteWord() {
+	start := e.pos
+	for start > 0 && e.line[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && e.line[start-1] != ' ' {
+		start--
+	}
+
+	e.line = append(e.line[:start], e.line[e.pos:]...)
+	e.pos = start
+}
+
+func (e *editor) moveLeft() {
+	if e.pos > 0 {
+		e.pos--
+	}
+}
+
+func (e *editor) moveRight() {
+	if e.pos < len(e.line) {
+		e.pos++
+	}
+}
+
+func (e *editor) historyPrev() {
+	if e.historyPos == 0 {
+		return
+	}
+	if e.historyPos == len(e.history.entries) {
+		e.draft = append([]rune(nil), e.line...)
+	}
+
+	e.historyPos--
+	e.setLine([]rune(e.history.entries[e.historyPos]))
+}
+
+func (e *editor) historyNext() {
+	if e.historyPos == len(e.history.entries) {
+		return
+	}
+
+	e.historyPos++
+	if e.historyPos == len(e.history.entries) {
+		e.setLine(e.draft)
+		return
+	}
+	e.setLine([]rune(e.history.entries[e.historyPos]))
+}
+
+func (e *editor) setLine(line []rune) {
+	e.line = append([]rune(nil), line...)
+	e.pos = len(e.line)
+}
+
+/*
+1) Find the candidates for the word before the cursor
+2) Replace the word with the single candidate or extend it with the common prefix of all the candidates
+3) If the word can't be extended, print all the candidates under the line
+*/
+func (e *editor) completeWord() {
+	candidates, start := e.complete(e.line, e.pos)
+	if len(candidates) == 0 {
+		return
+	}
+
+	completion := []rune(commonPrefix(candidates))
+	if len(completion) > e.pos-start {
+		tail := append([]rune(nil), e.line[e.pos:]...)
+		e.line = append(append(e.line[:start], completion...), tail...)
+		e.pos = start + len(completion)
+		return
+	}
+
+	fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+}
+
+// Redraws the prompt and the line and puts the cursor to its position
+func (e *editor) refresh() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K\r", e.prompt, string(e.line))
+
+	if column := utf8.RuneCountInString(e.prompt) + e.pos; column > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", column)
+	}
+}
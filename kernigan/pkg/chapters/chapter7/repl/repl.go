@@ -0,0 +1,377 @@
+// Package repl is an interactive read-eval-print loop for the chapter7 expression language.
+//
+// Statements are either expressions ("pow(x, 2) + 1") or assignments ("x = sqrt(2)"). Assigned variables persist
+// for the whole session. Lines starting with ':' are meta-commands, see :help.
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	evaluator "golang/pkg/chapters/chapter7"
+)
+
+const (
+	defaultPrompt       = ">> "
+	defaultContinuation = ".. "
+	defaultHistorySize  = 1000
+
+	historyFileName = ".evalrepl_history"
+)
+
+var (
+	assignmentRegexp = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+	// Is returned by a line reader when the user interrupted the current input with Ctrl-C
+	errInterrupted = errors.New("interrupted")
+	// Is returned by the meta-command :quit
+	errQuit = errors.New("quit")
+)
+
+// Config holds the REPL settings. The zero value is usable.
+type Config struct {
+	// The prompt printed before every statement. Defaults to ">> "
+	Prompt string
+	// The prompt printed before every continuation line of a multiline statement. Defaults to ".. "
+	ContinuationPrompt string
+	// The file the history is loaded from and saved to. Defaults to ~/.evalrepl_history, "-" disables persistence
+	HistoryFile string
+	// The number of history entries kept. Defaults to 1000
+	HistorySize int
+}
+
+// REPL evaluates statements against a persistent variable environment
+type REPL struct {
+	cfg Config
+	env evaluator.Environment
+
+	history *history
+}
+
+// New creates a REPL with "pi" and "e" predefined
+func New(cfg Config) *REPL {
+	if cfg.Prompt == "" {
+		cfg.Prompt = defaultPrompt
+	}
+	if cfg.ContinuationPrompt == "" {
+		cfg.ContinuationPrompt = defaultContinuation
+	}
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = defaultHistorySize
+	}
+	if cfg.HistoryFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			cfg.HistoryFile = filepath.Join(home, historyFileName)
+		}
+	}
+	if cfg.HistoryFile == "-" {
+		cfg.HistoryFile = ""
+	}
+
+	return &REPL{
+		cfg:     cfg,
+		env:     evaluator.Environment{"pi": math.Pi, "e": math.E},
+		history: newHistory(cfg.HistorySize),
+	}
+}
+
+// Start runs the REPL on the standard input and output
+func Start() error {
+	return New(Config{}).Run(os.Stdin, os.Stdout)
+}
+
+/*
+Run reads statements from in until EOF or :quit and writes the results to out.
+
+1) Load the history file
+2) If in is a terminal, read lines with the line editor, otherwise read them as is
+3) Evaluate every complete statement and print either its result or its error
+4) Save the history file, unless it failed to load, so the file isn't overwritten
+*/
+func (r *REPL) Run(in io.Reader, out io.Writer) (err error) {
+	if r.cfg.HistoryFile != "" {
+		if err := r.history.load(r.cfg.HistoryFile); err != nil {
+			fmt.Fprintf(out, "history is not loaded and won't be saved; %s\n", err)
+		} else {
+			defer func() {
+				if saveErr := r.history.save(r.cfg.HistoryFile); saveErr != nil && err == nil {
+					err = fmt.Errorf("saving history to %s; %s", r.cfg.HistoryFile, saveErr)
+				}
+			}()
+		}
+	}
+
+	reader := newLineReader(in, out, r.history, r.complete)
+
+	fmt.Fprintln(out, "Expression evaluator. Type :help for help.")
+
+	for {
+		statement, err := r.readStatement(reader)
+		switch {
+		case errors.Is(err, errInterrupted):
+			continue
+		case errors.Is(err, io.EOF):
+			fmt.Fprintln(out)
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		r.history.add(statement)
+
+		result, err := r.Execute(statement)
+		switch {
+		case errors.Is(err, errQuit):
+			return nil
+		case err != nil:
+			fmt.Fprintf(out, "error: %s\n", err)
+		case result != "":
+			fmt.Fprintln(out, result)
+		}
+	}
+}
+
+/*
+Reads lines until the statement is complete. A statement continues on the next line if it has unclosed parentheses
+or its line ends with a backslash.
+*/
+func (r *REPL) readStatement(reader lineReader) (string, error) {
+	var (
+		lines  []string
+		prompt = r.cfg.Prompt
+	)
+
+	for {
+		line, err := reader.readLine(prompt)
+		if err != nil {
+			// An unfinished statement is still evaluated at the end of the input
+			if errors.Is(err, io.EOF) && len(lines) > 0 {
+				return strings.Join(lines, " "), nil
+			}
+			return "", err
+		}
+
+		continued := strings.HasSuffix(strings.TrimRight(line, " \t"), `\`)
+		if continued {
+			line = strings.TrimSuffix(strings.TrimRight(line, " \t"), `\`)
+		}
+		lines = append(lines, line)
+
+		statement := strings.Join(lines, " ")
+		if !continued && parenDepth(statement) <= 0 {
+			return statement, nil
+		}
+
+		prompt = r.cfg.ContinuationPrompt
+	}
+}
+
+// Returns the number of parentheses left open in s
+func parenDepth(s string) int {
+	var depth int
+	for _, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+	}
+
+	return depth
+}
+
+/*
+Execute evaluates a single statement and returns the text to be printed.
+
+1) If the statement starts with ':', run the meta-command
+2) If the statement is an assignment, evaluate its right side and store the result in the environment
+3) Otherwise evaluate the statement as an expression
+*/
+func (r *REPL) Execute(statement string) (string, error) {
+	statement = strings.TrimSpace(statement)
+
+	if strings.HasPrefix(statement, ":") {
+		return r.executeMeta(statement)
+	}
+
+	if match := assignmentRegexp.FindStringSubmatch(statement); match != nil {
+		name, strExpr := evaluator.Variable(match[1]), match[2]
+
+		if _, isFunction := evaluator.Functions()[string(name)]; isFunction {
+			return "", fmt.Errorf("cannot assign to function %s", name)
+		}
+
+		value, err := r.eval(strExpr)
+		if err != nil {
+			return "", err
+		}
+		r.env[name] = value
+
+		return fmt.Sprintf("%s = %g", name, value), nil
+	}
+
+	value, err := r.eval(statement)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%g", value), nil
+}
+
+// Parses, checks and evaluates the string expression in the REPL environment
+func (r *REPL) eval(strExpr string) (float64, error) {
+	if strings.TrimSpace(strExpr) == "" {
+		return 0, fmt.Errorf("empty expression")
+	}
+
+	expr, err := evaluator.Parse(strExpr)
+	if err != nil {
+		return 0, err
+	}
+
+	vars := make(map[evaluator.Variable]evaluator.Empty)
+	if err := expr.Check(vars); err != nil {
+		return 0, err
+	}
+
+	// Variable.Eval returns zero for unknown variables, so report them here instead
+	var undefined []string
+	for variable := range vars {
+		if _, ok := r.env[variable]; !ok {
+			undefined = append(undefined, string(variable))
+		}
+	}
+	if len(undefined) > 0 {
+		sort.Strings(undefined)
+		return 0, fmt.Errorf("undefined variable %s", strings.Join(undefined, ", "))
+	}
+
+	return expr.Eval(r.env), nil
+}
+
+// The meta-commands with their descriptions
+var metaCommands = map[string]string{
+	":help":    "show this help",
+	":vars":    "list the defined variables",
+	":funcs":   "list the supported functions",
+	":tree":    "print the syntax tree of an expression, e.g. :tree pow(x, 2) + 1",
+	":unset":   "delete a variable, e.g. :unset x",
+	":history": "list the history",
+	":quit":    "exit the REPL",
+}
+
+func (r *REPL) executeMeta(statement string) (string, error) {
+	command, argument, _ := strings.Cut(statement, " ")
+	argument = strings.TrimSpace(argument)
+
+	switch command {
+	case ":help":
+		return r.help(), nil
+	case ":vars":
+		return r.vars(), nil
+	case ":funcs":
+		return funcs(), nil
+	case ":tree":
+		return tree(argument)
+	case ":unset":
+		if _, ok := r.env[evaluator.Variable(argument)]; !ok {
+			return "", fmt.Errorf("undefined variable %s", argument)
+		}
+		delete(r.env, evaluator.Variable(argument))
+		return "", nil
+	case ":history":
+		var b strings.Builder
+		for i, entry := range r.history.entries {
+			fmt.Fprintf(&b, "%4d  %s\n", i+1, entry)
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	case ":quit", ":q":
+		return "", errQuit
+	default:
+		return "", fmt.Errorf("unknown command %s, type :help", command)
+	}
+}
+
+func (r *REPL) help() string {
+	var b strings.Builder
+
+	b.WriteString("Statements:\n")
+	b.WriteString("  <expr>          evaluate an expression, e.g. sqrt(A / pi)\n")
+	b.WriteString("  <name> = <expr> assign the value of an expression to a variable\n")
+	b.WriteString("  Unclosed parentheses or a trailing '\\' continue a statement on the next line.\n")
+	b.WriteString("Commands:\n")
+	for _, command := range sortedKeys(metaCommands) {
+		fmt.Fprintf(&b, "  %-15s %s\n", command, metaCommands[command])
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (r *REPL) vars() string {
+	names := make([]string, 0, len(r.env))
+	for name := range r.env {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s = %g", name, r.env[evaluator.Variable(name)]))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func funcs() string {
+	functions := evaluator.Functions()
+
+	lines := make([]string, 0, len(functions))
+	for _, name := range sortedKeys(functions) {
+		lines = append(lines, fmt.Sprintf("%s/%d", name, functions[name]))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Prints the expression tree level by level with the String(levels) method of the expression
+func tree(strExpr string) (string, error) {
+	if strExpr == "" {
+		return "", fmt.Errorf("empty expression")
+	}
+
+	expr, err := evaluator.Parse(strExpr)
+	if err != nil {
+		return "", err
+	}
+
+	levels := make(map[int]string)
+	expr.String(levels)
+
+	lines := make([]string, 0, len(levels))
+	for i := 0; i < len(levels); i++ {
+		lines = append(lines, strings.TrimSuffix(levels[i], ", "))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,148 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExecute(t *testing.T) {
+	var (
+		tests = []struct {
+			statement string
+			want      string
+			wantErr   string
+		}{
+			{"1 + 2", "3", ""},
+			{"x = 3", "x = 3", ""},
+			{"pow(x, 2) + 1", "10", ""},
+			{"y = x * 2", "y = 6", ""},
+			{"x + y", "9", ""},
+			{"z + 1", "", "undefined variable z"},
+			{"a + b", "", "undefined variable a, b"},
+			{"sqrt = 4", "", "cannot assign to function sqrt"},
+			{"log(10)", "", `unknown function "log"`},
+			{"x = ", "", "empty expression"},
+			{":unset y", "", ""},
+			{"y", "", "undefined variable y"},
+			{":vars", "e = 2.718281828459045\npi = 3.141592653589793\nx = 3", ""},
+			{":funcs", "pow/2\nsin/1\nsqrt/1", ""},
+			{":tree -x + 1", "0| B + B\n1| -U, 1\n2| x", ""},
+			{":nope", "", "unknown command :nope, type :help"},
+		}
+
+		repl = New(Config{HistoryFile: "-"})
+	)
+
+	for _, test := range tests {
+		got, err := repl.Execute(test.statement)
+
+		var gotErr string
+		if err != nil {
+			gotErr = err.Error()
+		}
+
+		if got != test.want || gotErr != test.wantErr {
+			t.Errorf("Execute(%q) = %q, %q; want %q, %q", test.statement, got, gotErr, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestRunMultilineAndHistory(t *testing.T) {
+	const input = "a = pow(2,\n10)\nb = 1 + \\\n2\n\na + b\n:quit\nnever evaluated\n"
+
+	var (
+		historyFile = filepath.Join(t.TempDir(), "history")
+		out         strings.Builder
+	)
+
+	if err := New(Config{HistoryFile: historyFile}).Run(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+
+	for _, want := range []string{"a = 1024\n", "b = 3\n", "1027\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output %q doesn't contain %q", out.String(), want)
+		}
+	}
+	if strings.Contains(out.String(), "never evaluated") {
+		t.Errorf("statements after :quit were evaluated: %q", out.String())
+	}
+
+	content, err := os.ReadFile(historyFile)
+	if err != nil {
+		t.Fatalf("reading history: %s", err)
+	}
+	if want := "a = pow(2, 10)\nb = 1 +  2\na + b\n:quit\n"; string(content) != want {
+		t.Errorf("history file = %q, want %q", content, want)
+	}
+
+	// The history is loaded by the next session
+	repl := New(Config{HistoryFile: historyFile})
+	if err := repl.Run(strings.NewReader(""), &out); err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if len(repl.history.entries) != 4 {
+		t.Errorf("loaded %d history entries, want 4", len(repl.history.entries))
+	}
+}
+
+func TestRunKeepsUnloadedHistory(t *testing.T) {
+	// The line is too long for the scanner, so the history fails to load
+	var (
+		historyFile = filepath.Join(t.TempDir(), "history")
+		content     = strings.Repeat("x", 1<<17) + "\n"
+		out         strings.Builder
+	)
+	if err := os.WriteFile(historyFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := New(Config{HistoryFile: historyFile}).Run(strings.NewReader("1 + 2\n"), &out); err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+
+	got, err := os.ReadFile(historyFile)
+	if err != nil {
+		t.Fatalf("reading history: %s", err)
+	}
+	if string(got) != content {
+		t.Errorf("history file is overwritten with %q", got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	var (
+		tests = []struct {
+			line, wantPrefix string
+			wantCandidates   []string
+		}{
+			{"s", "s", []string{"sin(", "speed", "sqrt("}},
+			{"1 + sq", "sq", []string{"sqrt("}},
+			{"pow(sp", "sp", []string{"speed"}},
+			{":v", "v", []string{"vars"}},
+			{"1 + ", "", nil},
+		}
+
+		repl = New(Config{HistoryFile: "-"})
+	)
+
+	repl.env["speed"] = 1
+
+	for _, test := range tests {
+		line := []rune(test.line)
+
+		candidates, start := repl.complete(line, len(line))
+
+		if !reflect.DeepEqual(candidates, test.wantCandidates) || string(line[start:]) != test.wantPrefix {
+			t.Errorf("complete(%q) = %q, %q; want %q, %q",
+				test.line, candidates, string(line[start:]), test.wantCandidates, test.wantPrefix)
+		}
+	}
+
+	if got := commonPrefix([]string{"sin(", "sqrt("}); got != "s" {
+		t.Errorf("commonPrefix = %q, want %q", got, "s")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package repl
+
+import "errors"
+
+// The line editor isn't supported on this platform, so the input is always read as is
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (restore func() error, err error) {
+	return nil, errors.New("raw terminal mode isn't supported")
+}
@@ -5,6 +5,7 @@
 
 // The thumbnail package produces thumbnail-size images from
 // larger images.  Only JPEG images are currently supported.
+// See the thumbnail subpackage for the filters, the formats, the cache and the batch command.
 package chapter8
 
 import (
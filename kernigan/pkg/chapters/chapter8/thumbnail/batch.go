@@ -0,0 +1,162 @@
+package thumbnail
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Result is the outcome of a file of a batch: the thumbnail file or the error
+type Result struct {
+	File  string
+	Thumb string
+	Err   error
+}
+
+/*
+Batch makes the thumbnails of the files with at most workers of them at a time, all the CPUs if workers isn't
+positive. The thumbnail of "dir/foo.jpg" is "foo.thumb.jpg" in outDir or in dir if outDir is empty, with the
+extension of the output format. A failed file doesn't stop the rest, the results are in the order of the files.
+Once the context is done, the files not started yet fail with its error. The files whose thumbnails would have the
+same name whatever the extension, like "a/foo.jpg" and "b/foo.png" with outDir, fail but the first one rather than
+overwrite its thumbnail.
+*/
+func Batch(ctx context.Context, files []string, outDir string, opts Options, workers int) []Result {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var (
+		results = make([]Result, len(files))
+		indices = make(chan int)
+		wg      sync.WaitGroup
+		stems   = make(map[string]string, len(files))
+	)
+
+	// The output format of a file is known only once it's decoded, so the names are compared without the extension
+	for i, file := range files {
+		stem := thumbStem(file, outDir)
+		if first, ok := stems[stem]; ok {
+			results[i] = Result{File: file, Err: fmt.Errorf("%s: the thumbnail name collides with the one of %s", file, first)}
+			continue
+		}
+		stems[stem] = file
+	}
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if results[i].Err == nil {
+					results[i] = makeFile(ctx, files[i], outDir, opts)
+				}
+			}
+		}()
+	}
+
+	for i := range files {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	return results
+}
+
+func makeFile(ctx context.Context, file, outDir string, opts Options) Result {
+	res := Result{File: file}
+	if res.Err = ctx.Err(); res.Err != nil {
+		return res
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	thumb, format, err := Make(data, opts)
+	if err != nil {
+		res.Err = fmt.Errorf("%s: %w", file, err)
+		return res
+	}
+
+	res.Thumb = thumbStem(file, outDir) + format.Ext()
+	res.Err = os.WriteFile(res.Thumb, thumb, 0o644)
+	return res
+}
+
+// thumbStem returns the path of the thumbnail of the file without the extension
+func thumbStem(file, outDir string) string {
+	dir := outDir
+	if dir == "" {
+		dir = filepath.Dir(file)
+	}
+	base := filepath.Base(file)
+	return filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".thumb")
+}
+
+/*
+Main is the batch command line: it makes the thumbnails of the files given as the arguments, prints a line per file
+and returns the exit code, 1 if any file failed and 2 for the bad usage.
+
+	thumbnail [-w 128] [-h 128] [-mode fit] [-filter lanczos] [-format jpeg] [-q 85] [-out dir] [-j workers] files...
+*/
+func Main(args []string, stdout, stderr io.Writer) int {
+	var (
+		flags   = flag.NewFlagSet("thumbnail", flag.ContinueOnError)
+		opts    Options
+		mode    = flags.String("mode", string(Fit), "fit, fill or crop")
+		filter  = flags.String("filter", Lanczos.Name, "box, bilinear or lanczos")
+		format  = flags.String("format", "", "jpeg, png or gif, the format of the source by default")
+		outDir  = flags.String("out", "", "the directory of the thumbnails, the one of the source by default")
+		workers = flags.Int("j", runtime.NumCPU(), "the number of the files made at a time")
+	)
+	flags.SetOutput(stderr)
+	flags.IntVar(&opts.Width, "w", 128, "the width of the thumbnails, derived from the height if 0")
+	flags.IntVar(&opts.Height, "h", 128, "the height of the thumbnails, derived from the width if 0")
+	flags.IntVar(&opts.Quality, "q", 85, "the JPEG quality or the PNG compression from 1 to 100")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	opts.Mode = Mode(*mode)
+	var ok bool
+	if opts.Filter, ok = Filters[*filter]; !ok {
+		fmt.Fprintf(stderr, "unknown filter %q\n", *filter)
+		return 2
+	}
+	if *format != "" {
+		f, err := ParseFormat(*format)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		opts.Format = f
+	}
+	if err := opts.Validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(stderr, "no files")
+		return 2
+	}
+
+	code := 0
+	for _, res := range Batch(context.Background(), flags.Args(), *outDir, opts, *workers) {
+		if res.Err != nil {
+			fmt.Fprintln(stderr, res.Err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(stdout, "%s -> %s\n", res.File, res.Thumb)
+	}
+	return code
+}
@@ -0,0 +1,12 @@
+// The thumbnail command makes the thumbnails of the image files, see thumbnail.Main
+package main
+
+import (
+	"os"
+
+	"golang/pkg/chapters/chapter8/thumbnail"
+)
+
+func main() {
+	os.Exit(thumbnail.Main(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,140 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+/*
+Orientation returns the EXIF orientation of the JPEG data, from 1 to 8, or 1 if the data has none. The orientation
+tells how the camera was held, the image is stored as the sensor saw it:
+
+	1 as is          2 mirrored       3 upside down    4 flipped
+	5 transposed     6 turned right   7 transversed    8 turned left
+*/
+func Orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	// Walk the markers up to the start of the scan looking for APP1 with the Exif header
+	for p := 2; p+4 <= len(data); {
+		if data[p] != 0xFF {
+			return 1
+		}
+		marker := data[p+1]
+		if marker == 0xD8 || marker >= 0xD0 && marker <= 0xD7 || marker == 0xFF {
+			p++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		n := int(binary.BigEndian.Uint16(data[p+2:]))
+		if n < 2 || p+2+n > len(data) {
+			return 1
+		}
+		segment := data[p+4 : p+2+n]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		p += 2 + n
+	}
+	return 1
+}
+
+// tiffOrientation finds the orientation tag in the first directory of the TIFF structure of the EXIF data
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		// The tag, the type, the count and the value of the entry
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			return 1
+		}
+
+		const (
+			tagOrientation = 0x0112
+			typeShort      = 3
+		)
+		if order.Uint16(tiff[e:]) != tagOrientation {
+			continue
+		}
+		if order.Uint16(tiff[e+2:]) != typeShort {
+			return 1
+		}
+		if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 1
+	}
+	return 1
+}
+
+// Orient turns the image by the EXIF orientation, so it looks the right way up
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	var (
+		b    = src.Bounds()
+		w, h = b.Dx(), b.Dy()
+		dw   = w
+		dh   = h
+	)
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	// at maps the destination pixel to the source one
+	var at func(x, y int) (int, int)
+	switch orientation {
+	case 2:
+		at = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3:
+		at = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4:
+		at = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5:
+		at = func(x, y int) (int, int) { return y, x }
+	case 6:
+		at = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7:
+		at = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8:
+		at = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := at(x, y)
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,243 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// The largest source file the handler reads
+const maxSourceBytes = 64 << 20
+
+/*
+ParseOptions reads the thumbnail options from the query:
+
+	w, h      size of the thumbnail, a missing side is derived from the other one
+	mode      fit, fill or crop
+	filter    box, bilinear or lanczos
+	format    jpeg, png or gif, negotiated with the Accept header if missing
+	q         JPEG quality or PNG compression from 1 to 100
+*/
+func ParseOptions(query url.Values) (Options, error) {
+	var opts Options
+
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"w", &opts.Width}, {"h", &opts.Height}, {"q", &opts.Quality}} {
+		s := query.Get(p.name)
+		if s == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return opts, fmt.Errorf("bad %s %q", p.name, s)
+		}
+		*p.dst = v
+	}
+
+	opts.Mode = Mode(query.Get("mode"))
+
+	if name := query.Get("filter"); name != "" {
+		filter, ok := Filters[name]
+		if !ok {
+			return opts, fmt.Errorf("unknown filter %q", name)
+		}
+		opts.Filter = filter
+	}
+
+	if s := query.Get("format"); s != "" {
+		format, err := ParseFormat(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.Format = format
+	}
+
+	return opts, opts.Validate()
+}
+
+/*
+Negotiate picks the output format for the Accept header: the most preferred of the supported formats, the source
+one when it's among the equally preferred. An empty header accepts anything. It fails if the header accepts none of
+the formats.
+*/
+func Negotiate(accept string, source Format) (Format, error) {
+	if strings.TrimSpace(accept) == "" {
+		return source, nil
+	}
+
+	var (
+		best    Format
+		bestQ   = 0.0
+		quality = func(f Format) float64 {
+			q := 0.0
+			for _, r := range strings.Split(accept, ",") {
+				mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+				if err != nil {
+					continue
+				}
+
+				// The specific ranges take precedence over the wildcards
+				var specificity int
+				switch mediaType {
+				case f.ContentType():
+					specificity = 2
+				case "image/*":
+					specificity = 1
+				case "*/*":
+				default:
+					continue
+				}
+
+				v := 1.0
+				if s, ok := params["q"]; ok {
+					if v, err = strconv.ParseFloat(s, 64); err != nil {
+						continue
+					}
+				}
+				if specificity == 2 {
+					return v
+				}
+				q = max(q, v)
+			}
+			return q
+		}
+	)
+
+	// The source goes first, so it wins the ties
+	for _, f := range []Format{source, JPEG, PNG, GIF} {
+		if q := quality(f); q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("none of the formats is acceptable for %q", accept)
+	}
+	return best, nil
+}
+
+/*
+Handler serves the thumbnails of the images of root at /thumb?src=path&w=&h=&mode=, see ParseOptions for the rest of
+the parameters. The src path is relative to root and can't leave it. The thumbnails are kept in the cache unless it
+is nil. At most one request per CPU reads and resizes a source at a time, the ones beyond that get 503 with
+Retry-After.
+*/
+func Handler(root fs.FS, cache *Cache) http.Handler {
+	renders := make(chan struct{}, runtime.NumCPU())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		src := query.Get("src")
+		if !fs.ValidPath(src) || src == "." {
+			http.Error(w, fmt.Sprintf("bad src %q", src), http.StatusBadRequest)
+			return
+		}
+		opts, err := ParseOptions(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The slot is held from the read of the source, which may take maxSourceBytes of memory
+		select {
+		case renders <- struct{}{}:
+			defer func() { <-renders }()
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many thumbnails in progress", http.StatusServiceUnavailable)
+			return
+		}
+
+		data, err := readSource(root, src)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, source, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", src, err), http.StatusUnsupportedMediaType)
+			return
+		}
+		if opts.Format == "" {
+			if opts.Format, err = Negotiate(r.Header.Get("Accept"), Format(source)); err != nil {
+				http.Error(w, err.Error(), http.StatusNotAcceptable)
+				return
+			}
+			w.Header().Set("Vary", "Accept")
+		}
+
+		key := Key(data, opts)
+		etag := `"` + key + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		thumb, ok := []byte(nil), false
+		if cache != nil {
+			if thumb, ok, err = cache.Get(key); err != nil {
+				log.Printf("thumbnail cache: %v", err)
+			}
+		}
+		if !ok {
+			if thumb, _, err = Make(data, opts); err != nil {
+				http.Error(w, fmt.Sprintf("%s: %s", src, err), http.StatusUnprocessableEntity)
+				return
+			}
+			if cache != nil {
+				if err := cache.Put(key, thumb); err != nil {
+					log.Printf("thumbnail cache: %v", err)
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", opts.Format.ContentType())
+		w.Write(thumb)
+	})
+}
+
+// readSource reads the file of root refusing the ones larger than maxSourceBytes
+func readSource(root fs.FS, name string) ([]byte, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxSourceBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSourceBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxSourceBytes)
+	}
+	return data, nil
+}
+
+// Server serves the thumbnails of the images of the current directory at localhost:8000/thumb
+func Server() {
+	cache := &Cache{Dir: filepath.Join(os.TempDir(), "thumbnails")}
+	http.Handle("/thumb", Handler(os.DirFS("."), cache))
+	log.Fatal(http.ListenAndServe("localhost:8000", nil))
+}
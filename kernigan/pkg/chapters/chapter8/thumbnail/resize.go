@@ -0,0 +1,231 @@
+package thumbnail
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Filter is a resampling kernel, the kernel is zero beyond the support
+type Filter struct {
+	Name    string
+	Support float64
+	Kernel  func(x float64) float64
+}
+
+var (
+	// Box averages the source pixels under the destination one, the sharpest and the blockiest
+	Box = Filter{"box", 0.5, func(x float64) float64 {
+		if x >= -0.5 && x < 0.5 {
+			return 1
+		}
+		return 0
+	}}
+	// Bilinear is the triangle kernel, the linear interpolation when upscaling
+	Bilinear = Filter{"bilinear", 1, func(x float64) float64 {
+		return math.Max(1-math.Abs(x), 0)
+	}}
+	// Lanczos is the three lobe Lanczos kernel, the best detail at the cost of a slight ringing
+	Lanczos = Filter{"lanczos", 3, func(x float64) float64 {
+		if x <= -3 || x >= 3 {
+			return 0
+		}
+		return sinc(x) * sinc(x/3)
+	}}
+)
+
+// Filters are the filters by name
+var Filters = map[string]Filter{Box.Name: Box, Bilinear.Name: Bilinear, Lanczos.Name: Lanczos}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// Mode is how the image is fitted into the requested size
+type Mode string
+
+const (
+	// Fit scales the image to fit into the size, keeping the aspect ratio, so one side may be shorter
+	Fit Mode = "fit"
+	// Fill stretches the image to the size exactly
+	Fill Mode = "fill"
+	// Crop scales the image to cover the size, keeping the aspect ratio, and cuts off the overflow around the center
+	Crop Mode = "crop"
+)
+
+/*
+Thumbnail resizes src to width x height in the mode. A zero width or height is derived from the other one and the
+aspect ratio of src.
+*/
+func Thumbnail(src image.Image, width, height int, mode Mode, filter Filter) (*image.RGBA, error) {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 {
+		return nil, fmt.Errorf("empty image %dx%d", sw, sh)
+	}
+	if width < 0 || height < 0 || width == 0 && height == 0 {
+		return nil, fmt.Errorf("invalid size %dx%d", width, height)
+	}
+
+	scaled := func(n, num, den int) int {
+		return max(int(math.Round(float64(n)*float64(num)/float64(den))), 1)
+	}
+	switch {
+	case width == 0:
+		width = scaled(sw, height, sh)
+	case height == 0:
+		height = scaled(sh, width, sw)
+	}
+
+	switch mode {
+	case Fill:
+		return Resize(src, width, height, filter), nil
+	case Fit:
+		// The side limiting the scale gets the requested length, the other one is shorter
+		if sw*height > sh*width {
+			return Resize(src, width, scaled(sh, width, sw), filter), nil
+		}
+		return Resize(src, scaled(sw, height, sh), height, filter), nil
+	case Crop:
+		// Cut the source to the aspect ratio of the size first
+		cw, ch := sw, sh
+		if sw*height > sh*width {
+			cw = scaled(sh, width, height)
+		} else {
+			ch = scaled(sw, height, width)
+		}
+		x0, y0 := b.Min.X+(sw-cw)/2, b.Min.Y+(sh-ch)/2
+		return Resize(subImage(src, image.Rect(x0, y0, x0+cw, y0+ch)), width, height, filter), nil
+	default:
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+}
+
+// subImage returns the part of src, the images without SubImage are copied
+func subImage(src image.Image, r image.Rectangle) image.Image {
+	if s, ok := src.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+
+	dst := image.NewRGBA(r)
+	draw.Draw(dst, r, src, r.Min, draw.Src)
+	return dst
+}
+
+/*
+Resize resamples src to width x height with the filter: the rows are resampled first, then the columns of the
+result. When the image is shrunk, the kernel is stretched by the scale, so every source pixel contributes to the
+destination. The image is filtered with the premultiplied alpha, so the transparent pixels don't bleed their colors.
+*/
+func Resize(src image.Image, width, height int, filter Filter) *image.RGBA {
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		b := src.Bounds()
+		rgba = image.NewRGBA(b)
+		draw.Draw(rgba, b, src, b.Min, draw.Src)
+	}
+
+	var (
+		b  = rgba.Bounds()
+		sw = b.Dx()
+		sh = b.Dy()
+		xw = weights(width, sw, filter)
+		yw = weights(height, sh, filter)
+		// The rows resampled to the width, 4 channels per pixel
+		tmp = make([]float64, sh*width*4)
+		dst = image.NewRGBA(image.Rect(0, 0, width, height))
+	)
+
+	for y := 0; y < sh; y++ {
+		row := rgba.Pix[rgba.PixOffset(b.Min.X, b.Min.Y+y):]
+		out := tmp[y*width*4:]
+		for x, w := range xw {
+			var acc [4]float64
+			for k, c := range w.coeffs {
+				p := row[(w.start+k)*4:]
+				acc[0] += c * float64(p[0])
+				acc[1] += c * float64(p[1])
+				acc[2] += c * float64(p[2])
+				acc[3] += c * float64(p[3])
+			}
+			copy(out[x*4:x*4+4], acc[:])
+		}
+	}
+
+	for y, w := range yw {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			var acc [4]float64
+			for k, c := range w.coeffs {
+				p := tmp[((w.start+k)*width+x)*4:]
+				acc[0] += c * p[0]
+				acc[1] += c * p[1]
+				acc[2] += c * p[2]
+				acc[3] += c * p[3]
+			}
+
+			// The negative lobes may overshoot, and a premultiplied color can't exceed its alpha
+			a := clamp(acc[3], 255)
+			out[x*4+0] = uint8(clamp(acc[0], a) + 0.5)
+			out[x*4+1] = uint8(clamp(acc[1], a) + 0.5)
+			out[x*4+2] = uint8(clamp(acc[2], a) + 0.5)
+			out[x*4+3] = uint8(a + 0.5)
+		}
+	}
+	return dst
+}
+
+func clamp(v, hi float64) float64 {
+	return math.Min(math.Max(v, 0), hi)
+}
+
+// weight are the coefficients of the source pixels from start on for a destination pixel
+type weight struct {
+	start  int
+	coeffs []float64
+}
+
+// weights returns the coefficients of the source pixels for each of the dst destination ones
+func weights(dst, src int, filter Filter) []weight {
+	var (
+		scale   = float64(src) / float64(dst)
+		stretch = math.Max(scale, 1)
+		support = filter.Support * stretch
+		ws      = make([]weight, dst)
+	)
+
+	for i := range ws {
+		center := (float64(i) + 0.5) * scale
+		lo := max(int(math.Floor(center-support)), 0)
+		hi := min(int(math.Ceil(center+support)), src)
+
+		var (
+			coeffs = make([]float64, 0, hi-lo)
+			sum    float64
+		)
+		for j := lo; j < hi; j++ {
+			c := filter.Kernel((float64(j) + 0.5 - center) / stretch)
+			coeffs = append(coeffs, c)
+			sum += c
+		}
+
+		if sum == 0 {
+			// The kernel misses all the pixels, take the nearest one
+			j := min(int(center), src-1)
+			ws[i] = weight{start: j, coeffs: []float64{1}}
+			continue
+		}
+		for k := range coeffs {
+			coeffs[k] /= sum
+		}
+		ws[i] = weight{start: lo, coeffs: coeffs}
+	}
+	return ws
+}
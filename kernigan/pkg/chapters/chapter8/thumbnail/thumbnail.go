@@ -0,0 +1,187 @@
+/*
+Package thumbnail makes the thumbnails of the JPEG, PNG and GIF images: it resizes them with the box, bilinear or
+Lanczos filter to fit, fill or cover the requested size, turns the JPEG photos the right way up and encodes the
+result in any of the three formats. The thumbnails are served over HTTP with an on-disk cache or made in batches.
+*/
+package thumbnail
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is an image format, the names are the ones of image.Decode
+type Format string
+
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	GIF  Format = "gif"
+)
+
+// ParseFormat returns the format by its name or file extension, "jpg" stands for JPEG
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimPrefix(s, "."))); f {
+	case JPEG, PNG, GIF:
+		return f, nil
+	case "jpg":
+		return JPEG, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", s)
+	}
+}
+
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+// Ext is the file extension of the format with the dot
+func (f Format) Ext() string {
+	if f == JPEG {
+		return ".jpg"
+	}
+	return "." + string(f)
+}
+
+// The bounds of the sizes, so a malicious image or request can't exhaust the memory
+const (
+	MaxSide   = 4096
+	MaxPixels = 50 << 20
+)
+
+// Options describe the thumbnail to make
+type Options struct {
+	// The size of the thumbnail, a zero side is derived from the other one, 128x128 if both are zero
+	Width, Height int
+	// Fit by default
+	Mode Mode
+	// Lanczos by default
+	Filter Filter
+	// The format of the source by default
+	Format Format
+	// The JPEG quality from 1 to 100 or the PNG compression from 1 for the fastest to 100 for the best, 85 by default
+	Quality int
+}
+
+func (o Options) withDefaults(source Format) Options {
+	if o.Width == 0 && o.Height == 0 {
+		o.Width, o.Height = 128, 128
+	}
+	if o.Mode == "" {
+		o.Mode = Fit
+	}
+	if o.Filter.Kernel == nil {
+		o.Filter = Lanczos
+	}
+	if o.Format == "" {
+		o.Format = source
+	}
+	if o.Quality == 0 {
+		o.Quality = 85
+	}
+	return o
+}
+
+// Validate reports the options no thumbnail can be made with
+func (o Options) Validate() error {
+	switch {
+	case o.Width < 0 || o.Height < 0 || o.Width > MaxSide || o.Height > MaxSide:
+		return fmt.Errorf("invalid size %dx%d, the sides are at most %d", o.Width, o.Height, MaxSide)
+	case o.Mode != "" && o.Mode != Fit && o.Mode != Fill && o.Mode != Crop:
+		return fmt.Errorf("unknown mode %q", o.Mode)
+	case o.Format != "" && o.Format != JPEG && o.Format != PNG && o.Format != GIF:
+		return fmt.Errorf("unknown format %q", o.Format)
+	case o.Quality < 0 || o.Quality > 100:
+		return fmt.Errorf("invalid quality %d", o.Quality)
+	}
+	return nil
+}
+
+// key identifies the thumbnail of a source with the options in the cache
+func (o Options) key() string {
+	return strings.Join([]string{
+		strconv.Itoa(o.Width), strconv.Itoa(o.Height), string(o.Mode), o.Filter.Name, string(o.Format),
+		strconv.Itoa(o.Quality),
+	}, ":")
+}
+
+// Decode decodes the image checking its size first, the JPEG images are turned by their EXIF orientation
+func Decode(data []byte) (image.Image, Format, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, "", fmt.Errorf("the image is too large: %dx%d", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	f := Format(format)
+	if f == JPEG {
+		img = Orient(img, Orientation(data))
+	}
+	return img, f, nil
+}
+
+// Encode writes the image in the format with the quality of Options.Quality
+func Encode(w io.Writer, img image.Image, format Format, quality int) error {
+	if quality <= 0 {
+		quality = 85
+	}
+
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case PNG:
+		level := png.DefaultCompression
+		switch {
+		case quality <= 33:
+			level = png.BestSpeed
+		case quality > 66:
+			level = png.BestCompression
+		}
+		return (&png.Encoder{CompressionLevel: level}).Encode(w, img)
+	case GIF:
+		// The GIF has no quality, the colors are dithered to the Plan 9 palette
+		return gif.Encode(w, img, &gif.Options{NumColors: len(palette.Plan9), Drawer: draw.FloydSteinberg})
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Make makes the thumbnail of the image data and returns it with its format
+func Make(data []byte, opts Options) ([]byte, Format, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	src, format, err := Decode(data)
+	if err != nil {
+		return nil, "", err
+	}
+	opts = opts.withDefaults(format)
+
+	thumb, err := Thumbnail(src, opts.Width, opts.Height, opts.Mode, opts.Filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, thumb, opts.Format, opts.Quality); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), opts.Format, nil
+}
@@ -0,0 +1,448 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// gradient is the image with the red growing to the right and the green growing downward
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / max(w-1, 1)), uint8(y * 255 / max(h-1, 1)), 0, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResize(t *testing.T) {
+	solid := image.NewUniform(color.RGBA{10, 200, 30, 255})
+	src := image.NewRGBA(image.Rect(0, 0, 37, 23))
+	for y := 0; y < 23; y++ {
+		for x := 0; x < 37; x++ {
+			src.Set(x, y, solid.C)
+		}
+	}
+
+	// A solid image stays solid with any filter both ways
+	for _, f := range []Filter{Box, Bilinear, Lanczos} {
+		for _, size := range [][2]int{{10, 7}, {100, 60}} {
+			dst := Resize(src, size[0], size[1], f)
+			if b := dst.Bounds(); b.Dx() != size[0] || b.Dy() != size[1] {
+				t.Fatalf("%s: size %v", f.Name, b)
+			}
+			for y := 0; y < size[1]; y++ {
+				for x := 0; x < size[0]; x++ {
+					if got := dst.RGBAAt(x, y); got != solid.C {
+						t.Fatalf("%s %v: pixel (%d, %d) = %v", f.Name, size, x, y, got)
+					}
+				}
+			}
+		}
+	}
+
+	// The box filter averages the checkerboard to gray
+	checker := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				checker.Set(x, y, color.White)
+			} else {
+				checker.Set(x, y, color.Black)
+			}
+		}
+	}
+	if got := Resize(checker, 4, 4, Box).RGBAAt(1, 1); got.R < 127 || got.R > 128 || got.A != 255 {
+		t.Errorf("the box filter averages to %v", got)
+	}
+
+	// The transparent pixels don't bleed their color
+	half := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	half.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	half.SetNRGBA(1, 0, color.NRGBA{0, 255, 0, 0})
+	if got := Resize(half, 1, 1, Box).RGBAAt(0, 0); got.G != 0 || got.A < 127 || got.A > 128 {
+		t.Errorf("the half transparent average is %v", got)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	src := gradient(400, 200)
+
+	tests := []struct {
+		w, h   int
+		mode   Mode
+		dw, dh int
+	}{
+		{100, 100, Fit, 100, 50},
+		{100, 0, Fit, 100, 50},
+		{0, 20, Fit, 40, 20},
+		{100, 100, Fill, 100, 100},
+		{100, 100, Crop, 100, 100},
+		{50, 100, Crop, 50, 100},
+	}
+	for _, test := range tests {
+		dst, err := Thumbnail(src, test.w, test.h, test.mode, Bilinear)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := dst.Bounds(); b.Dx() != test.dw || b.Dy() != test.dh {
+			t.Errorf("%dx%d %s: got %dx%d, want %dx%d", test.w, test.h, test.mode, b.Dx(), b.Dy(), test.dw, test.dh)
+		}
+	}
+
+	// The crop keeps the middle of the source: the red of the left edge is about the one of x = 100
+	dst, _ := Thumbnail(src, 100, 100, Crop, Bilinear)
+	if r := dst.RGBAAt(0, 50).R; r < 60 || r > 70 {
+		t.Errorf("the cropped left edge has red %d", r)
+	}
+
+	if _, err := Thumbnail(src, 10, 10, "zoom", Box); err == nil {
+		t.Error("an unknown mode succeeded")
+	}
+	if _, err := Thumbnail(src, 0, 0, Fit, Box); err == nil {
+		t.Error("the zero size succeeded")
+	}
+}
+
+// exifJPEG returns the JPEG of img with the APP1 segment of the orientation in the byte order
+func exifJPEG(t *testing.T, img image.Image, orientation uint16, order binary.AppendByteOrder) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	var tiff []byte
+	if order == binary.LittleEndian {
+		tiff = append(tiff, "II"...)
+	} else {
+		tiff = append(tiff, "MM"...)
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	// One entry: the orientation short, then no next directory
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = order.AppendUint16(tiff, 0x0112)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = order.AppendUint16(tiff, 0)
+	tiff = order.AppendUint32(tiff, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := buf.Bytes()
+	return append(append(data[:2:2], app1...), data[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	img := gradient(40, 20)
+
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for o := uint16(1); o <= 8; o++ {
+			if got := Orientation(exifJPEG(t, img, o, order)); got != int(o) {
+				t.Errorf("%v: Orientation() = %d, want %d", order, got, o)
+			}
+		}
+	}
+	if got := Orientation(encodePNG(t, img)); got != 1 {
+		t.Errorf("PNG orientation %d", got)
+	}
+
+	// Turned right, the bottom left corner of the source, full green, is the top left one
+	src, _, err := Decode(exifJPEG(t, img, 6, binary.BigEndian))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := src.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Fatalf("the turned image is %dx%d", b.Dx(), b.Dy())
+	}
+	if r, g, _, _ := src.At(0, 0).RGBA(); r>>8 > 30 || g>>8 < 220 {
+		t.Errorf("the top left corner is %v", src.At(0, 0))
+	}
+
+	// The transforms and their inverses
+	inverse := map[int]int{2: 2, 3: 3, 4: 4, 5: 5, 6: 8, 7: 7, 8: 6}
+	for o, inv := range inverse {
+		back := Orient(Orient(img, o), inv)
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 40; x++ {
+				if back.At(x, y) != color.Color(img.RGBAAt(x, y)) {
+					t.Fatalf("orientation %d and back differs at (%d, %d)", o, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		source Format
+		want   Format
+	}{
+		{"", PNG, PNG},
+		{"*/*", GIF, GIF},
+		{"image/*", JPEG, JPEG},
+		{"image/png", JPEG, PNG},
+		{"image/webp,image/jpeg;q=0.8,image/png;q=0.9", GIF, PNG},
+		{"image/*;q=0.5,image/gif", PNG, GIF},
+		{"image/*,image/png;q=0", PNG, JPEG},
+		{"text/html", PNG, ""},
+	}
+	for _, test := range tests {
+		got, err := Negotiate(test.accept, test.source)
+		if got != test.want || (err != nil) != (test.want == "") {
+			t.Errorf("Negotiate(%q, %s) = %q, %v, want %q", test.accept, test.source, got, err, test.want)
+		}
+	}
+}
+
+func TestMake(t *testing.T) {
+	data := encodePNG(t, gradient(300, 150))
+
+	for _, format := range []Format{JPEG, PNG, GIF} {
+		thumb, got, err := Make(data, Options{Width: 64, Format: format, Quality: 50})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != format {
+			t.Errorf("format %s, want %s", got, format)
+		}
+
+		config, name, err := image.DecodeConfig(bytes.NewReader(thumb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Format(name) != format || config.Width != 64 || config.Height != 32 {
+			t.Errorf("%s: %s %dx%d", format, name, config.Width, config.Height)
+		}
+	}
+
+	if _, _, err := Make([]byte("not an image"), Options{}); err == nil {
+		t.Error("Make() of garbage succeeded")
+	}
+	if _, _, err := Make(data, Options{Width: MaxSide + 1}); err == nil {
+		t.Error("Make() of the huge size succeeded")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	root := fstest.MapFS{
+		"photos/a.png": {Data: encodePNG(t, gradient(200, 100))},
+		"notes.txt":    {Data: []byte("hello")},
+	}
+	cache := &Cache{Dir: t.TempDir()}
+
+	srv := httptest.NewServer(Handler(root, cache))
+	defer srv.Close()
+
+	get := func(path, accept, etag string) *http.Response {
+		t.Helper()
+
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("/thumb?src=photos/a.png&w=50&h=50&mode=crop", "", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	etag := resp.Header.Get("ETag")
+
+	// The thumbnail is in the cache under its content address
+	if _, ok, _ := cache.Get(strings.Trim(etag, `"`)); !ok {
+		t.Error("the thumbnail isn't cached")
+	}
+	if resp := get("/thumb?src=photos/a.png&w=50&h=50&mode=crop", "", etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional request status %d", resp.StatusCode)
+	}
+
+	if resp := get("/thumb?src=photos/a.png&w=50", "image/jpeg", ""); resp.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("negotiated content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	for path, status := range map[string]int{
+		"/thumb?src=../etc/passwd":             http.StatusBadRequest,
+		"/thumb?src=/etc/passwd":               http.StatusBadRequest,
+		"/thumb?src=photos/b.png":              http.StatusNotFound,
+		"/thumb?src=notes.txt":                 http.StatusUnsupportedMediaType,
+		"/thumb?src=photos/a.png&mode=stretch": http.StatusBadRequest,
+		"/thumb?src=photos/a.png&w=-1":         http.StatusBadRequest,
+	} {
+		if resp := get(path, "", ""); resp.StatusCode != status {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+	if resp := get("/thumb?src=photos/a.png", "text/html", ""); resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("unacceptable format status %d", resp.StatusCode)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	files := []string{
+		write("a.png", encodePNG(t, gradient(64, 64))),
+		write("broken.png", []byte("garbage")),
+		filepath.Join(dir, "missing.png"),
+		write("b.png", encodePNG(t, gradient(32, 16))),
+	}
+
+	results := Batch(context.Background(), files, "", Options{Width: 16, Format: JPEG}, 2)
+	for i, res := range results {
+		if res.File != files[i] {
+			t.Fatalf("result %d is of %s", i, res.File)
+		}
+		failed := i == 1 || i == 2
+		if (res.Err != nil) != failed {
+			t.Errorf("%s: error %v", res.File, res.Err)
+		}
+	}
+	if want := filepath.Join(dir, "b.thumb.jpg"); results[3].Thumb != want {
+		t.Errorf("thumbnail %s, want %s", results[3].Thumb, want)
+	}
+	if _, err := os.Stat(results[0].Thumb); err != nil {
+		t.Error(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := Main([]string{"-w", "8", "-out", t.TempDir(), files[0], files[1]}, &stdout, &stderr); code != 1 {
+		t.Errorf("Main() = %d, want 1", code)
+	}
+	if !strings.Contains(stdout.String(), "a.png -> ") || !strings.Contains(stderr.String(), "broken.png") {
+		t.Errorf("stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+// blockingFS is the file system whose Open waits for release after signalling opened
+type blockingFS struct {
+	fs.FS
+	opened, release chan struct{}
+}
+
+func (b blockingFS) Open(name string) (fs.File, error) {
+	b.opened <- struct{}{}
+	<-b.release
+	return b.FS.Open(name)
+}
+
+func TestHandlerRenders(t *testing.T) {
+	root := blockingFS{
+		FS:      fstest.MapFS{"a.png": {Data: encodePNG(t, gradient(20, 20))}},
+		opened:  make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	srv := httptest.NewServer(Handler(root, nil))
+	defer srv.Close()
+
+	// Every slot is taken by a request blocked reading the source
+	done := make(chan struct{})
+	for range runtime.NumCPU() {
+		go func() {
+			if resp, err := http.Get(srv.URL + "?src=a.png&w=8"); err == nil {
+				resp.Body.Close()
+			}
+			done <- struct{}{}
+		}()
+		<-root.opened
+	}
+
+	resp, err := http.Get(srv.URL + "?src=a.png&w=8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("status %d, Retry-After %q; want 503 and the header", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	close(root.release)
+	for range runtime.NumCPU() {
+		<-done
+	}
+}
+
+func TestBatchCollisions(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		out   = t.TempDir()
+		files []string
+	)
+	for _, name := range []string{"a/x.png", "b/x.png", "b/y.png", "b/x.gif"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, encodePNG(t, gradient(16, 16)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	// The first x keeps its thumbnail, the other ones fail instead of overwriting it
+	results := Batch(context.Background(), files, out, Options{Width: 8}, 2)
+	for i, res := range results {
+		collides := i == 1 || i == 3
+		if (res.Err != nil) != collides {
+			t.Errorf("%s: error %v", res.File, res.Err)
+		}
+	}
+	if want := filepath.Join(out, "x.thumb.png"); results[0].Thumb != want {
+		t.Errorf("thumbnail %s, want %s", results[0].Thumb, want)
+	}
+
+	// Without outDir the thumbnails stay in the directories of the sources
+	for _, res := range Batch(context.Background(), files[:2], "", Options{Width: 8}, 2) {
+		if res.Err != nil {
+			t.Errorf("%s: error %v", res.File, res.Err)
+		}
+	}
+}
@@ -32,6 +32,7 @@ func httpGetBody(url string) (interface{}, error) {
 }
 
 // A Memo caches the results of calling a Func.
+// See the sub7/memo package for the generic one with eviction, TTL and cancellation.
 type Memo struct {
 	f     Func
 	cache map[string]result
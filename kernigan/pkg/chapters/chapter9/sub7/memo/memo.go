@@ -0,0 +1,272 @@
+/*
+Package memo is the concurrent non-blocking cache of section 9.7 grown into a reusable one.
+
+Like MemoEntry.GetDuplicateSuppressing, a Memo calls the function once per key however many goroutines ask for it
+at the same time. Besides, it takes keys and values of any types, drops the least recently used entries beyond its
+size, expires the entries after their TTL and doesn't cache the errors unless asked to. A caller can abandon the
+waiting through its context, while the computation goes on for the others.
+*/
+package memo
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Func is the type of the function to memoize. The context is cancelled once all the callers waiting for the result
+// have left, it carries the values of the context of the caller that started the computation.
+type Func[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// Option configures a Memo
+type Option func(o *options)
+
+type options struct {
+	maxEntries  int
+	ttl         time.Duration
+	cacheErrors bool
+	now         func() time.Time
+}
+
+// MaxEntries bounds the number of the cached results, the least recently used ones are evicted beyond it.
+// The computations in progress don't count. Zero, the default, means no bound.
+func MaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// TTL sets the time the results are kept for, zero, the default, means forever
+func TTL(d time.Duration) Option {
+	return func(o *options) {
+		o.ttl = d
+	}
+}
+
+// CacheErrors makes the errors cached as the values are, by default the next Get calls the function again
+func CacheErrors() Option {
+	return func(o *options) {
+		o.cacheErrors = true
+	}
+}
+
+// Clock replaces time.Now for the expiration, it's meant for tests
+func Clock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// Stats are the counters of a Memo
+type Stats struct {
+	// The calls served from the cache, including the ones that have waited for a computation in progress
+	Hits uint64
+	// The calls that have started a computation
+	Misses uint64
+	// The results dropped to keep the size bound
+	Evictions uint64
+	// The results dropped after their TTL
+	Expirations uint64
+}
+
+// entry is a result of the function, ready is closed once it's computed
+type entry[K comparable, V any] struct {
+	key   K
+	ready chan struct{}
+	value V
+	err   error
+
+	// The callers waiting for the result and the cancellation of the computation once they've all left
+	waiters int
+	cancel  context.CancelFunc
+
+	expires time.Time
+	// The element in the LRU list, nil until the result is ready
+	elem *list.Element
+}
+
+// A Memo caches the results of calling a Func, it's safe for concurrent use
+type Memo[K comparable, V any] struct {
+	f Func[K, V]
+	options
+
+	mu      sync.Mutex
+	entries map[K]*entry[K, V]
+	// The ready entries, the most recently used in front
+	lru   list.List
+	stats Stats
+}
+
+func New[K comparable, V any](f Func[K, V], opts ...Option) *Memo[K, V] {
+	memo := &Memo[K, V]{
+		f:       f,
+		options: options{now: time.Now},
+		entries: make(map[K]*entry[K, V]),
+	}
+	for _, opt := range opts {
+		opt(&memo.options)
+	}
+	return memo
+}
+
+/*
+Get returns the result of the function for the key, calling it if the result isn't cached. The concurrent calls for
+the same key wait for the single computation. If ctx is done first, Get returns ctx.Err() and the computation goes on
+for the other callers, it's cancelled when nobody waits for it anymore.
+*/
+func (memo *Memo[K, V]) Get(ctx context.Context, key K) (V, error) {
+	memo.mu.Lock()
+
+	e := memo.entries[key]
+	if e != nil && e.elem != nil && memo.expired(e) {
+		memo.remove(e)
+		memo.stats.Expirations++
+		e = nil
+	}
+
+	if e == nil {
+		// This is the first request for this key, the computation isn't bound to the caller's cancellation
+		var fctx context.Context
+
+		e = &entry[K, V]{key: key, ready: make(chan struct{})}
+		fctx, e.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		memo.entries[key] = e
+		memo.stats.Misses++
+
+		go memo.call(fctx, e)
+	} else {
+		memo.stats.Hits++
+		if e.elem != nil {
+			memo.lru.MoveToFront(e.elem)
+			memo.mu.Unlock()
+			return e.value, e.err
+		}
+	}
+
+	e.waiters++
+	memo.mu.Unlock()
+
+	select {
+	case <-e.ready:
+		return e.value, e.err
+	case <-ctx.Done():
+	}
+
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	e.waiters--
+	select {
+	case <-e.ready:
+		// The result came along with the cancellation
+		return e.value, e.err
+	default:
+	}
+	if e.waiters == 0 {
+		// The cancelled computation is forgotten, so the next caller starts a new one instead of joining it
+		e.cancel()
+		if memo.entries[key] == e {
+			delete(memo.entries, key)
+		}
+	}
+
+	var zero V
+	return zero, ctx.Err()
+}
+
+// call computes the entry and either caches the result or forgets the entry
+func (memo *Memo[K, V]) call(ctx context.Context, e *entry[K, V]) {
+	defer e.cancel()
+
+	value, err := memo.safeCall(ctx, e.key)
+
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	e.value, e.err = value, err
+	defer close(e.ready)
+
+	// The entry may have been forgotten during the computation
+	if memo.entries[e.key] != e {
+		return
+	}
+	if err != nil && !memo.cacheErrors {
+		delete(memo.entries, e.key)
+		return
+	}
+
+	if memo.ttl > 0 {
+		e.expires = memo.now().Add(memo.ttl)
+	}
+	e.elem = memo.lru.PushFront(e)
+
+	for memo.maxEntries > 0 && memo.lru.Len() > memo.maxEntries {
+		memo.remove(memo.lru.Back().Value.(*entry[K, V]))
+		memo.stats.Evictions++
+	}
+}
+
+// safeCall turns a panic of the function into an error, otherwise it would crash the program from the goroutine
+// nobody waits on
+func (memo *Memo[K, V]) safeCall(ctx context.Context, key K) (value V, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("memo: function panicked for key %v: %v", key, p)
+		}
+	}()
+
+	return memo.f(ctx, key)
+}
+
+func (memo *Memo[K, V]) expired(e *entry[K, V]) bool {
+	return memo.ttl > 0 && !memo.now().Before(e.expires)
+}
+
+// remove drops the ready entry
+func (memo *Memo[K, V]) remove(e *entry[K, V]) {
+	delete(memo.entries, e.key)
+	memo.lru.Remove(e.elem)
+	e.elem = nil
+}
+
+// Forget drops the cached result for the key, a computation in progress completes for its callers but isn't cached
+func (memo *Memo[K, V]) Forget(key K) {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	e := memo.entries[key]
+	if e == nil {
+		return
+	}
+	if e.elem != nil {
+		memo.remove(e)
+		return
+	}
+	delete(memo.entries, key)
+}
+
+// Purge drops all the cached results, the computations in progress aren't cached either
+func (memo *Memo[K, V]) Purge() {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	clear(memo.entries)
+	memo.lru.Init()
+}
+
+// Len returns the number of the cached results, the expired ones that haven't been requested since included
+func (memo *Memo[K, V]) Len() int {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	return memo.lru.Len()
+}
+
+func (memo *Memo[K, V]) Stats() Stats {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	return memo.stats
+}
@@ -0,0 +1,310 @@
+package memo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counter is a memoized function counting its calls per key
+type counter struct {
+	mu    sync.Mutex
+	calls map[int]int
+}
+
+func (c *counter) f(_ context.Context, key int) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.calls == nil {
+		c.calls = make(map[int]int)
+	}
+	c.calls[key]++
+	return fmt.Sprint(key), nil
+}
+
+func (c *counter) count(key int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls[key]
+}
+
+func TestConcurrentDuplicateSuppression(t *testing.T) {
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+		calls   atomic.Int32
+	)
+
+	memo := New(func(_ context.Context, key string) (int, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return len(key), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if v, err := memo.Get(context.Background(), "hello"); v != 5 || err != nil {
+				t.Errorf("Get() = %d, %v, want 5", v, err)
+			}
+		}()
+	}
+
+	<-started
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("function called %d times, want 1", n)
+	}
+	if stats := memo.Stats(); stats.Misses != 1 || stats.Hits != 19 {
+		t.Errorf("Stats() = %+v, want 1 miss and 19 hits", stats)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	var (
+		c    = &counter{}
+		memo = New(c.f, MaxEntries(2))
+		ctx  = context.Background()
+	)
+
+	memo.Get(ctx, 1)
+	memo.Get(ctx, 2)
+	memo.Get(ctx, 1) // 2 is the least recently used now
+	memo.Get(ctx, 3)
+
+	if n := memo.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+
+	memo.Get(ctx, 1)
+	memo.Get(ctx, 2)
+	if c.count(1) != 1 || c.count(2) != 2 {
+		t.Errorf("calls for 1 and 2: %d, %d, want 1, 2", c.count(1), c.count(2))
+	}
+	if stats := memo.Stats(); stats.Evictions != 2 {
+		t.Errorf("%d evictions, want 2", stats.Evictions)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	var (
+		c    = &counter{}
+		now  = time.Unix(0, 0)
+		memo = New(c.f, TTL(time.Minute), Clock(func() time.Time { return now }))
+		ctx  = context.Background()
+	)
+
+	memo.Get(ctx, 1)
+	now = now.Add(59 * time.Second)
+	memo.Get(ctx, 1)
+	if n := c.count(1); n != 1 {
+		t.Fatalf("%d calls before the expiration, want 1", n)
+	}
+
+	now = now.Add(time.Second)
+	memo.Get(ctx, 1)
+	if n := c.count(1); n != 2 {
+		t.Errorf("%d calls after the expiration, want 2", n)
+	}
+	if stats := memo.Stats(); stats.Expirations != 1 {
+		t.Errorf("%d expirations, want 1", stats.Expirations)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var (
+		calls int
+		ctx   = context.Background()
+		boom  = errors.New("boom")
+	)
+	f := func(_ context.Context, key int) (int, error) {
+		calls++
+		return 0, boom
+	}
+
+	memo := New(f)
+	memo.Get(ctx, 1)
+	if _, err := memo.Get(ctx, 1); !errors.Is(err, boom) {
+		t.Errorf("Get() error = %v, want %v", err, boom)
+	}
+	if calls != 2 {
+		t.Errorf("failing function called %d times, want 2", calls)
+	}
+
+	calls = 0
+	memo = New(f, CacheErrors())
+	memo.Get(ctx, 1)
+	memo.Get(ctx, 1)
+	if calls != 1 {
+		t.Errorf("failing function called %d times with CacheErrors, want 1", calls)
+	}
+
+	memo = New(func(context.Context, int) (int, error) { panic("oops") })
+	if _, err := memo.Get(ctx, 1); err == nil {
+		t.Error("panic not reported")
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	var (
+		release  = make(chan struct{})
+		fctxDone = make(chan struct{})
+	)
+
+	memo := New(func(ctx context.Context, key int) (int, error) {
+		if key == 1 {
+			<-release
+			return key * 2, nil
+		}
+
+		<-ctx.Done()
+		close(fctxDone)
+		return 0, ctx.Err()
+	})
+
+	// The impatient caller leaves, the patient one gets the result
+	impatient, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := memo.Get(impatient, 1)
+		errc <- err
+	}()
+
+	result := make(chan int)
+	go func() {
+		for memo.Stats().Misses == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		v, _ := memo.Get(context.Background(), 1)
+		result <- v
+	}()
+
+	for memo.Stats().Hits == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Get() error = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if v := <-result; v != 2 {
+		t.Errorf("Get() = %d, want 2", v)
+	}
+
+	// The computation is cancelled once all the callers have left
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := memo.Get(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-fctxDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("abandoned computation not cancelled")
+	}
+}
+
+func TestRetryAfterCancellation(t *testing.T) {
+	var (
+		started = make(chan struct{}, 2)
+		calls   atomic.Int32
+	)
+
+	// The first computation blocks until it's cancelled, the next one completes
+	memo := New(func(ctx context.Context, key int) (int, error) {
+		started <- struct{}{}
+		if calls.Add(1) == 1 {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return key * 2, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := memo.Get(ctx, 1)
+		errc <- err
+	}()
+	<-started
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled Get() error = %v, want %v", err, context.Canceled)
+	}
+
+	// The caller with a live context doesn't join the cancelled computation
+	if v, err := memo.Get(context.Background(), 1); err != nil || v != 2 {
+		t.Errorf("Get() = %d, %v, want 2, nil", v, err)
+	}
+}
+
+func TestForgetAndPurge(t *testing.T) {
+	var (
+		c    = &counter{}
+		memo = New(c.f)
+		ctx  = context.Background()
+	)
+
+	memo.Get(ctx, 1)
+	memo.Get(ctx, 2)
+	memo.Forget(1)
+	memo.Get(ctx, 1)
+	memo.Get(ctx, 2)
+	if c.count(1) != 2 || c.count(2) != 1 {
+		t.Errorf("calls for 1 and 2: %d, %d, want 2, 1", c.count(1), c.count(2))
+	}
+
+	memo.Purge()
+	if n := memo.Len(); n != 0 {
+		t.Errorf("Len() = %d after Purge, want 0", n)
+	}
+}
+
+func TestConcurrentRace(t *testing.T) {
+	var (
+		c    = &counter{}
+		memo = New(c.f, MaxEntries(10), TTL(time.Millisecond))
+		wg   sync.WaitGroup
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			for j := 0; j < 1000; j++ {
+				key := (i * j) % 25
+				if v, err := memo.Get(ctx, key); err != nil || v != fmt.Sprint(key) {
+					t.Errorf("Get(%d) = %q, %v", key, v, err)
+					return
+				}
+				if j%100 == 0 {
+					memo.Forget(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := memo.Len(); n > 10 {
+		t.Errorf("Len() = %d, want at most 10", n)
+	}
+}
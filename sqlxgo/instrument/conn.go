@@ -0,0 +1,324 @@
+package instrument
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+/*
+conn wraps a driver connection. It implements the optional interfaces of database/sql itself and falls back to what
+the wrapped connection supports: driver.ErrSkip makes database/sql take the other way, e.g. prepare the statement
+the connection can't execute directly.
+*/
+type conn struct {
+	driver.Conn
+	driver *Driver
+	// The ID of the transaction in progress, zero for none
+	tx uint64
+}
+
+func (c *conn) event(op, query string, args []driver.NamedValue) event {
+	return event{op: op, query: query, args: args, tx: c.tx, start: time.Now(), affected: -1}
+}
+
+func (c *conn) done(ctx context.Context, e event, err error) {
+	e.duration = time.Since(e.start)
+	e.err = err
+	c.driver.record(ctx, e)
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	e := c.event("prepare", query, nil)
+
+	var (
+		s   driver.Stmt
+		err error
+	)
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	c.done(ctx, e, err)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, conn: c, query: query}, nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	id := c.driver.txIDs.Add(1)
+	e := c.event("begin", "", nil)
+	e.tx = id
+
+	var (
+		t   driver.Tx
+		err error
+	)
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		t, err = bc.BeginTx(ctx, opts)
+	} else {
+		if opts.Isolation != 0 || opts.ReadOnly {
+			err = errors.New("instrument: the driver doesn't support the transaction options")
+		} else {
+			t, err = c.Conn.Begin()
+		}
+	}
+	c.done(ctx, e, err)
+	if err != nil {
+		return nil, err
+	}
+
+	c.tx = id
+	return &tx{Tx: t, conn: c, id: id, ctx: ctx}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	e := c.event("exec", query, args)
+	res, err := ec.ExecContext(ctx, query, args)
+	if err == nil {
+		e.affected = rowsAffected(res)
+	}
+	c.done(ctx, e, err)
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	e := c.event("query", query, args)
+	r, err := qc.QueryContext(ctx, query, args)
+	if err != nil {
+		c.done(ctx, e, err)
+		return nil, err
+	}
+	return &rows{Rows: r, conn: c, ctx: ctx, event: e}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func rowsAffected(res driver.Result) int64 {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+type tx struct {
+	driver.Tx
+	conn *conn
+	id   uint64
+	ctx  context.Context
+}
+
+func (t *tx) Commit() error {
+	e := t.conn.event("commit", "", nil)
+	err := t.Tx.Commit()
+	t.conn.tx = 0
+	t.conn.done(t.ctx, e, err)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	e := t.conn.event("rollback", "", nil)
+	err := t.Tx.Rollback()
+	t.conn.tx = 0
+	t.conn.done(t.ctx, e, err)
+	return err
+}
+
+type stmt struct {
+	driver.Stmt
+	conn  *conn
+	query string
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e := s.conn.event("exec", s.query, args)
+
+	var (
+		res driver.Result
+		err error
+	)
+	if sc, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = sc.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedToValues(args); err == nil {
+			res, err = s.Stmt.Exec(values)
+		}
+	}
+	if err == nil {
+		e.affected = rowsAffected(res)
+	}
+	s.conn.done(ctx, e, err)
+	return res, err
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	e := s.conn.event("query", s.query, args)
+
+	var (
+		r   driver.Rows
+		err error
+	)
+	if sc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		r, err = sc.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedToValues(args); err == nil {
+			r, err = s.Stmt.Query(values)
+		}
+	}
+	if err != nil {
+		s.conn.done(ctx, e, err)
+		return nil, err
+	}
+	return &rows{Rows: r, conn: s.conn, ctx: ctx, event: e}, nil
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+/*
+rows records the query when it's closed, so the duration includes the fetching of the rows, and the error of the
+fetching is the error of the query. The optional interfaces of the column types fall back to what database/sql
+assumes without them.
+*/
+type rows struct {
+	driver.Rows
+	conn  *conn
+	ctx   context.Context
+	event event
+	err   error
+	done  bool
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+func (r *rows) Close() error {
+	err := r.Rows.Close()
+	if !r.done {
+		r.done = true
+		r.conn.done(r.ctx, r.event, errors.Join(r.err, err))
+	}
+	return err
+}
+
+func (r *rows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *rows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return ct.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(any)).Elem()
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return ct.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *rows) ColumnTypeLength(index int) (int64, bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return ct.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return ct.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *rows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ct.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+// namedToValues converts the arguments for the drivers without the named ones
+func namedToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, errors.New("instrument: the driver doesn't support the named arguments")
+		}
+		values[i] = a.Value
+	}
+	return values, nil
+}
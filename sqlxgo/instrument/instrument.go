@@ -0,0 +1,232 @@
+/*
+Package instrument wraps a database/sql driver to show what runs against the database: every statement is logged
+via slog with its redacted arguments, duration, affected rows and transaction, the slow ones are warned about, the
+same statement repeated within a request is reported as a probable N+1 query, and the latency of every statement is
+kept in a histogram.
+
+	drv := instrument.Register("pgx-instrumented", stdlib.GetDefaultDriver(), instrument.Config{
+		SlowThreshold: 100 * time.Millisecond,
+	})
+	db, err := sql.Open("pgx-instrumented", dsn)
+	...
+	http.Handle("/metrics", drv.MetricsHandler())
+	http.Handle("/", instrument.Middleware(app))
+*/
+package instrument
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config is what the instrumentation logs and measures
+type Config struct {
+	// The logger of the statements, slog.Default() by default
+	Logger *slog.Logger
+	// The level the statements are logged at, the slow ones are logged at Warn and the failed ones at Error
+	Level slog.Level
+	// The duration a statement is slow from, the slow statements aren't reported if it's zero
+	SlowThreshold time.Duration
+	// The number of the runs of a statement within a scope it's reported as an N+1 query from, 10 by default, the
+	// negative number turns the detection off
+	NPlusOneThreshold int
+	// Redact returns the value of an argument to log, RedactStrings by default
+	Redact func(v driver.Value) any
+	// The upper bounds of the latency histogram buckets, DefaultBuckets by default
+	Buckets []time.Duration
+	// The number of the distinct statements the histograms are kept for, the rest are counted as one, 1000 by default
+	MaxStatements int
+}
+
+// DefaultBuckets are the default latency buckets, from a millisecond to 10 seconds
+var DefaultBuckets = []time.Duration{
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond, time.Second,
+	2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// RedactStrings keeps the numbers, the booleans, the times and the NULLs, and hides the strings and the bytes but
+// their length, they are the arguments that may hold the personal data or the secrets
+func RedactStrings(v driver.Value) any {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("<redacted %d chars>", len(v))
+	case []byte:
+		return fmt.Sprintf("<redacted %d bytes>", len(v))
+	default:
+		return v
+	}
+}
+
+// Driver is the instrumented driver, it implements driver.DriverContext
+type Driver struct {
+	driver  driver.Driver
+	config  Config
+	metrics *metrics
+	txIDs   atomic.Uint64
+}
+
+// Wrap returns the instrumented driver
+func Wrap(d driver.Driver, config Config) *Driver {
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.NPlusOneThreshold == 0 {
+		config.NPlusOneThreshold = 10
+	}
+	if config.Redact == nil {
+		config.Redact = RedactStrings
+	}
+	if config.Buckets == nil {
+		config.Buckets = DefaultBuckets
+	}
+	if config.MaxStatements <= 0 {
+		config.MaxStatements = 1000
+	}
+
+	return &Driver{driver: d, config: config, metrics: newMetrics(config.Buckets, config.MaxStatements)}
+}
+
+// Register registers the instrumented driver with database/sql under the name and returns it
+func Register(name string, d driver.Driver, config Config) *Driver {
+	w := Wrap(d, config)
+	sql.Register(name, w)
+	return w
+}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, driver: d}, nil
+}
+
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	if dc, ok := d.driver.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &connector{Connector: c, driver: d}, nil
+	}
+	return &connector{Connector: dsnConnector{dsn: dsn, driver: d.driver}, driver: d}, nil
+}
+
+// Connector returns the instrumented connector for sql.OpenDB
+func (d *Driver) Connector(c driver.Connector) driver.Connector {
+	return &connector{Connector: c, driver: d}
+}
+
+type connector struct {
+	driver.Connector
+	driver *Driver
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: cn, driver: c.driver}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// dsnConnector is the connector of the drivers without one
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// event is a statement or a transaction step to report
+type event struct {
+	op       string
+	query    string
+	args     []driver.NamedValue
+	tx       uint64
+	start    time.Time
+	duration time.Duration
+	affected int64
+	err      error
+}
+
+// record logs the event, counts it in its histogram and in the scope of the context
+func (d *Driver) record(ctx context.Context, e event) {
+	if errors.Is(e.err, driver.ErrSkip) {
+		// The statement is retried the other way, it's recorded then
+		return
+	}
+
+	query := normalize(e.query)
+	if query != "" && e.op != "prepare" {
+		d.metrics.observe(query, e.duration, e.err != nil)
+	}
+
+	var (
+		logger = d.config.Logger
+		level  = d.config.Level
+		msg    = "sql " + e.op
+	)
+	switch {
+	case e.err != nil:
+		level, msg = slog.LevelError, msg+" failed"
+	case d.config.SlowThreshold > 0 && e.duration >= d.config.SlowThreshold && query != "":
+		level, msg = slog.LevelWarn, "slow sql "+e.op
+	}
+
+	if logger.Enabled(ctx, level) {
+		attrs := []slog.Attr{slog.Duration("duration", e.duration)}
+		if query != "" {
+			attrs = append(attrs, slog.String("query", query))
+		}
+		if len(e.args) > 0 {
+			args := make([]any, len(e.args))
+			for i, a := range e.args {
+				args[i] = d.config.Redact(a.Value)
+			}
+			attrs = append(attrs, slog.Any("args", args))
+		}
+		if e.affected >= 0 {
+			attrs = append(attrs, slog.Int64("rows_affected", e.affected))
+		}
+		if e.tx != 0 {
+			attrs = append(attrs, slog.Uint64("tx", e.tx))
+		}
+		if e.err != nil {
+			attrs = append(attrs, slog.String("err", e.err.Error()))
+		}
+		logger.LogAttrs(ctx, level, msg, attrs...)
+	}
+
+	if query != "" && (e.op == "exec" || e.op == "query") && d.config.NPlusOneThreshold > 0 {
+		if s := scopeFrom(ctx); s != nil {
+			if n, first := s.count(query, d.config.NPlusOneThreshold); first {
+				logger.LogAttrs(ctx, slog.LevelWarn, "probable N+1 sql query",
+					slog.String("query", query), slog.Int("count", n), slog.String("scope", s.name))
+			}
+		}
+	}
+}
+
+// normalize collapses the whitespace, so the same statement formatted differently is counted as one
+func normalize(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
@@ -0,0 +1,219 @@
+package instrument
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sqlxgo/repo/memdb"
+)
+
+// open returns the database over the instrumented memdb driver logging into the returned buffer
+func open(t *testing.T, config Config) (*sql.DB, *Driver, *bytes.Buffer) {
+	t.Helper()
+
+	var logs bytes.Buffer
+	config.Logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	d := Wrap(memdb.Driver{}, config)
+
+	connector, err := d.OpenConnector(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() {
+		db.Close()
+		memdb.Drop(t.Name())
+	})
+
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	return db, d, &logs
+}
+
+// entries returns the logged records with the message
+func entries(t *testing.T, logs *bytes.Buffer, msg string) []map[string]any {
+	t.Helper()
+
+	var found []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if e["msg"] == msg {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func TestLogging(t *testing.T) {
+	ctx := context.Background()
+	db, _, logs := open(t, Config{})
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO users (id, name) VALUES (?, ?), (?, ?)`, 1, "alice", 2, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	execs := entries(t, logs, "sql exec")
+	if len(execs) != 2 {
+		t.Fatalf("%d exec records:\n%s", len(execs), logs)
+	}
+	insert := execs[1]
+	if insert["query"] != "INSERT INTO users (id, name) VALUES (?, ?), (?, ?)" || insert["rows_affected"] != 2.0 ||
+		insert["level"] != "INFO" {
+		t.Errorf("insert record %v", insert)
+	}
+	if args, _ := insert["args"].([]any); len(args) != 4 || args[0] != 1.0 || args[1] != "<redacted 5 chars>" {
+		t.Errorf("insert args %v", insert["args"])
+	}
+
+	begin, commit := entries(t, logs, "sql begin"), entries(t, logs, "sql commit")
+	if len(begin) != 1 || len(commit) != 1 || begin[0]["tx"] == nil ||
+		insert["tx"] != begin[0]["tx"] || commit[0]["tx"] != begin[0]["tx"] {
+		t.Errorf("begin %v, insert %v, commit %v", begin, insert, commit)
+	}
+	if _, ok := execs[0]["tx"]; ok {
+		t.Errorf("the statement outside the transaction logged with one: %v", execs[0])
+	}
+
+	logs.Reset()
+	if _, err := db.Exec(`INSERT INTO users (id, name) VALUES (?, ?)`, 1, "carol"); err == nil {
+		t.Fatal("the duplicate key was inserted")
+	}
+	if failed := entries(t, logs, "sql exec failed"); len(failed) != 1 || failed[0]["level"] != "ERROR" ||
+		!strings.Contains(failed[0]["err"].(string), "23505") {
+		t.Errorf("failed records %v", failed)
+	}
+}
+
+func TestSlow(t *testing.T) {
+	db, _, logs := open(t, Config{SlowThreshold: time.Nanosecond, Level: slog.LevelDebug})
+
+	rows, err := db.Query(`SELECT   name
+		FROM users`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	slow := entries(t, logs, "slow sql query")
+	if len(slow) != 1 || slow[0]["level"] != "WARN" || slow[0]["query"] != "SELECT name FROM users" {
+		t.Errorf("slow records %v:\n%s", slow, logs)
+	}
+}
+
+func TestQueryFetchTime(t *testing.T) {
+	db, _, logs := open(t, Config{})
+	if _, err := db.Exec(`INSERT INTO users (id, name) VALUES (?, ?)`, 1, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	logs.Reset()
+
+	// The query is recorded when its rows are closed, with the time spent reading them
+	rows, err := db.Query(`SELECT name FROM users`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(strings.TrimSpace(logs.String())) != 0 {
+		t.Errorf("the query recorded before its rows were read:\n%s", logs)
+	}
+	for rows.Next() {
+		time.Sleep(20 * time.Millisecond)
+	}
+	rows.Close()
+
+	queries := entries(t, logs, "sql query")
+	if len(queries) != 1 {
+		t.Fatalf("%d query records:\n%s", len(queries), logs)
+	}
+	if d := time.Duration(queries[0]["duration"].(float64)); d < 20*time.Millisecond {
+		t.Errorf("the query took %s, the reading of its rows is missing", d)
+	}
+}
+
+func TestNPlusOne(t *testing.T) {
+	db, _, logs := open(t, Config{NPlusOneThreshold: 3})
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for id := range 5 {
+			var name string
+			db.QueryRowContext(r.Context(), `SELECT name FROM users WHERE id = ?`, id).Scan(&name)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	warnings := entries(t, logs, "probable N+1 sql query")
+	if len(warnings) != 1 || warnings[0]["count"] != 3.0 || warnings[0]["scope"] != "GET /users" ||
+		warnings[0]["query"] != "SELECT name FROM users WHERE id = ?" {
+		t.Errorf("N+1 warnings %v", warnings)
+	}
+
+	// The statements outside a scope aren't counted
+	logs.Reset()
+	for range 5 {
+		db.Exec(`DELETE FROM users`)
+	}
+	if warnings := entries(t, logs, "probable N+1 sql query"); len(warnings) != 0 {
+		t.Errorf("N+1 warnings outside a scope %v", warnings)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	db, d, _ := open(t, Config{Buckets: []time.Duration{time.Hour}, MaxStatements: 3})
+
+	for range 3 {
+		db.Exec(`DELETE FROM users`)
+	}
+	db.Exec(`DELETE FROM nowhere`)
+	db.Exec(`UPDATE users SET name = 'x'`)
+
+	stats := make(map[string]StatementStats)
+	for _, s := range d.Stats() {
+		stats[s.Query] = s
+	}
+	if len(stats) != 4 {
+		t.Fatalf("stats of %d statements: %+v", len(stats), stats)
+	}
+	if s := stats["DELETE FROM users"]; s.Count != 3 || s.Errors != 0 || len(s.Counts) != 2 || s.Counts[0] != 3 {
+		t.Errorf("DELETE FROM users stats %+v", s)
+	}
+	// The create table and the deletes took the room, the update is counted as the other
+	if s := stats["DELETE FROM nowhere"]; s.Count != 1 || s.Errors != 1 {
+		t.Errorf("DELETE FROM nowhere stats %+v", s)
+	}
+	if s := stats[otherStatements]; s.Count != 1 {
+		t.Errorf("%s stats %+v", otherStatements, s)
+	}
+
+	rec := httptest.NewRecorder()
+	d.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`# TYPE sql_statement_duration_seconds histogram`,
+		`sql_statement_duration_seconds_bucket{query="DELETE FROM users",le="3600"} 3`,
+		`sql_statement_duration_seconds_bucket{query="DELETE FROM users",le="+Inf"} 3`,
+		`sql_statement_duration_seconds_count{query="DELETE FROM users"} 3`,
+		`sql_statement_errors_total{query="DELETE FROM nowhere"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the metrics lack %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package instrument
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// otherStatements is the statement the ones beyond Config.MaxStatements are counted as
+const otherStatements = "<other>"
+
+// StatementStats is the latency histogram of a statement
+type StatementStats struct {
+	Query  string
+	Count  uint64
+	Errors uint64
+	Total  time.Duration
+	// The upper bounds of the buckets and the number of the runs not longer than each of them, the last count is
+	// the one of all the runs like the +Inf bucket of Prometheus
+	Buckets []time.Duration
+	Counts  []uint64
+}
+
+type histogram struct {
+	// The runs of each bucket alone, the last one is for the runs longer than the last bound
+	counts []atomic.Uint64
+	errors atomic.Uint64
+	total  atomic.Int64
+}
+
+type metrics struct {
+	buckets []time.Duration
+	max     int
+
+	mu         sync.RWMutex
+	statements map[string]*histogram
+}
+
+func newMetrics(buckets []time.Duration, max int) *metrics {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &metrics{buckets: buckets, max: max, statements: make(map[string]*histogram)}
+}
+
+func (m *metrics) histogram(query string) *histogram {
+	m.mu.RLock()
+	h, ok := m.statements[query]
+	m.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if h, ok := m.statements[query]; ok {
+		return h
+	}
+	// The statements built with the literals instead of the placeholders would grow the map forever
+	if len(m.statements) >= m.max {
+		query = otherStatements
+		if h, ok := m.statements[query]; ok {
+			return h
+		}
+	}
+	h = &histogram{counts: make([]atomic.Uint64, len(m.buckets)+1)}
+	m.statements[query] = h
+	return h
+}
+
+func (m *metrics) observe(query string, d time.Duration, failed bool) {
+	h := m.histogram(query)
+
+	i, _ := slices.BinarySearch(m.buckets, d)
+	h.counts[i].Add(1)
+	h.total.Add(int64(d))
+	if failed {
+		h.errors.Add(1)
+	}
+}
+
+// Stats returns the histograms of the statements sorted by the total time, the most expensive go first
+func (d *Driver) Stats() []StatementStats {
+	m := d.metrics
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make([]StatementStats, 0, len(m.statements))
+	for query, h := range m.statements {
+		s := StatementStats{
+			Query:   query,
+			Errors:  h.errors.Load(),
+			Total:   time.Duration(h.total.Load()),
+			Buckets: m.buckets,
+			Counts:  make([]uint64, len(h.counts)),
+		}
+		// The cumulative counts
+		for i := range h.counts {
+			s.Count += h.counts[i].Load()
+			s.Counts[i] = s.Count
+		}
+		stats = append(stats, s)
+	}
+
+	slices.SortFunc(stats, func(a, b StatementStats) int {
+		if a.Total != b.Total {
+			return int(b.Total - a.Total)
+		}
+		return strings.Compare(a.Query, b.Query)
+	})
+	return stats
+}
+
+// WriteMetrics writes the histograms in the Prometheus text format as sql_statement_duration_seconds labeled with
+// the statements
+func (d *Driver) WriteMetrics(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("# HELP sql_statement_duration_seconds The duration of the SQL statements.\n")
+	b.WriteString("# TYPE sql_statement_duration_seconds histogram\n")
+	stats := d.Stats()
+	for _, s := range stats {
+		label := `query="` + escapeLabel(s.Query) + `"`
+		for i, bound := range s.Buckets {
+			fmt.Fprintf(&b, "sql_statement_duration_seconds_bucket{%s,le=%q} %d\n",
+				label, strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), s.Counts[i])
+		}
+		fmt.Fprintf(&b, "sql_statement_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", label, s.Count)
+		fmt.Fprintf(&b, "sql_statement_duration_seconds_sum{%s} %g\n", label, s.Total.Seconds())
+		fmt.Fprintf(&b, "sql_statement_duration_seconds_count{%s} %d\n", label, s.Count)
+	}
+
+	b.WriteString("# HELP sql_statement_errors_total The number of the failed SQL statements.\n")
+	b.WriteString("# TYPE sql_statement_errors_total counter\n")
+	for _, s := range stats {
+		fmt.Fprintf(&b, "sql_statement_errors_total{query=\"%s\"} %d\n", escapeLabel(s.Query), s.Errors)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// MetricsHandler serves the histograms for Prometheus
+func (d *Driver) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		d.WriteMetrics(w)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,45 @@
+package instrument
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type scopeKey struct{}
+
+// scope counts the runs of the statements within a unit of work, e.g. a request, to find the N+1 queries
+type scope struct {
+	name string
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// WithScope returns the context the statements are counted in for the N+1 detection under the name
+func WithScope(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &scope{name: name, counts: make(map[string]int)})
+}
+
+// Middleware runs every request in its own scope named by the method and the path
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithScope(r.Context(), r.Method+" "+r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func scopeFrom(ctx context.Context) *scope {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	return s
+}
+
+// count counts a run of the query, first reports the run that reached the threshold, so it's warned about once
+func (s *scope) count(query string, threshold int) (n int, first bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[query]++
+	n = s.counts[query]
+	return n, n == threshold
+}
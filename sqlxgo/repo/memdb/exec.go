@@ -0,0 +1,423 @@
+package memdb
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// statement is a parsed statement, run returns the number of the affected rows or the rows selected
+type statement interface {
+	run(s *snapshot, args []driver.Value) (int64, *rows, error)
+	// The number of the arguments the statement needs
+	params() int
+}
+
+// expr is a placeholder or a literal, the param of a literal is -1
+type expr struct {
+	param int
+	value driver.Value
+}
+
+func (e expr) eval(args []driver.Value) driver.Value {
+	if e.param >= 0 {
+		return args[e.param]
+	}
+	return e.value
+}
+
+func (e expr) params() int {
+	return e.param + 1
+}
+
+type cond struct {
+	column string
+	op     string
+	value  expr
+}
+
+type assignment struct {
+	column string
+	value  expr
+}
+
+func condParams(conds []cond) int {
+	n := 0
+	for _, c := range conds {
+		n = max(n, c.value.params())
+	}
+	return n
+}
+
+// filter returns the indices of the rows of the table meeting the conditions
+func filter(t *table, conds []cond, args []driver.Value) ([]int, error) {
+	cols := make([]int, len(conds))
+	for i, c := range conds {
+		if cols[i] = t.index(c.column); cols[i] < 0 {
+			return nil, errorf(UndefinedColumn, "column %q does not exist", c.column)
+		}
+	}
+
+	var matched []int
+rows:
+	for r, row := range t.rows {
+		for i, c := range conds {
+			if !c.match(row[cols[i]], args) {
+				continue rows
+			}
+		}
+		matched = append(matched, r)
+	}
+	return matched, nil
+}
+
+// match compares the value with the condition, any comparison with NULL is false like in SQL
+func (c cond) match(v driver.Value, args []driver.Value) bool {
+	switch c.op {
+	case "is null":
+		return v == nil
+	case "is not null":
+		return v != nil
+	}
+
+	cmp, ok := compare(v, c.value.eval(args))
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=", "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// compare compares the numbers, the strings, the booleans and the times, the rest isn't comparable
+func compare(a, b driver.Value) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+
+	switch x := a.(type) {
+	case string:
+		if y, ok := text(b); ok {
+			return bytes.Compare([]byte(x), []byte(y)), true
+		}
+	case []byte:
+		if y, ok := text(b); ok {
+			return bytes.Compare(x, []byte(y)), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	}
+	return 0, false
+}
+
+func number(v driver.Value) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+func text(v driver.Value) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case []byte:
+		return string(x), true
+	}
+	return "", false
+}
+
+// check enforces NOT NULL and the primary key on the rows of the table
+func check(t *table) error {
+	var key []int
+	for i, c := range t.columns {
+		if c.primary {
+			key = append(key, i)
+		}
+	}
+
+	seen := make(map[string]bool, len(t.rows))
+	for _, row := range t.rows {
+		for i, c := range t.columns {
+			if c.notNull && row[i] == nil {
+				return errorf(NotNullViolation, "null value in column %q violates not-null constraint", c.name)
+			}
+		}
+		if len(key) == 0 {
+			continue
+		}
+
+		var k []byte
+		for _, i := range key {
+			k = fmt.Appendf(k, "%T:%v\x00", row[i], row[i])
+		}
+		if seen[string(k)] {
+			return errorf(UniqueViolation, "duplicate key value violates unique constraint")
+		}
+		seen[string(k)] = true
+	}
+	return nil
+}
+
+type createTable struct {
+	name        string
+	ifNotExists bool
+	columns     []column
+}
+
+func (st *createTable) params() int { return 0 }
+
+func (st *createTable) run(s *snapshot, _ []driver.Value) (int64, *rows, error) {
+	if s.tables[st.name] != nil {
+		if st.ifNotExists {
+			return 0, nil, nil
+		}
+		return 0, nil, errorf(DuplicateTable, "relation %q already exists", st.name)
+	}
+
+	s.tables[st.name] = &table{columns: st.columns}
+	s.written[st.name] = true
+	return 0, nil, nil
+}
+
+type dropTable struct {
+	name     string
+	ifExists bool
+}
+
+func (st *dropTable) params() int { return 0 }
+
+func (st *dropTable) run(s *snapshot, _ []driver.Value) (int64, *rows, error) {
+	if s.tables[st.name] == nil {
+		if st.ifExists {
+			return 0, nil, nil
+		}
+		return 0, nil, errorf(UndefinedTable, "table %q does not exist", st.name)
+	}
+
+	s.tables[st.name] = nil
+	s.written[st.name] = true
+	return 0, nil, nil
+}
+
+type insert struct {
+	table   string
+	columns []string
+	rows    [][]expr
+}
+
+func (st *insert) params() int {
+	n := 0
+	for _, row := range st.rows {
+		for _, e := range row {
+			n = max(n, e.params())
+		}
+	}
+	return n
+}
+
+func (st *insert) run(s *snapshot, args []driver.Value) (int64, *rows, error) {
+	t, err := s.writable(st.table)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	cols := make([]int, len(st.columns))
+	for i, name := range st.columns {
+		if cols[i] = t.index(name); cols[i] < 0 {
+			return 0, nil, errorf(UndefinedColumn, "column %q of relation %q does not exist", name, st.table)
+		}
+	}
+
+	for _, values := range st.rows {
+		row := make([]driver.Value, len(t.columns))
+		for i, e := range values {
+			row[cols[i]] = e.eval(args)
+		}
+		t.rows = append(t.rows, row)
+	}
+	return int64(len(st.rows)), nil, check(t)
+}
+
+type selectStmt struct {
+	table   string
+	columns []string
+	count   bool
+	where   []cond
+	orderBy string
+	desc    bool
+	limit   expr
+}
+
+func (st *selectStmt) params() int {
+	return max(condParams(st.where), st.limit.params())
+}
+
+func (st *selectStmt) run(s *snapshot, args []driver.Value) (int64, *rows, error) {
+	t, err := s.table(st.table)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	matched, err := filter(t, st.where, args)
+	if err != nil {
+		return 0, nil, err
+	}
+	if st.count {
+		return 0, &rows{columns: []string{"count"}, data: [][]driver.Value{{int64(len(matched))}}}, nil
+	}
+
+	cols := make([]int, 0, len(t.columns))
+	names := st.columns
+	if names == nil {
+		for i, c := range t.columns {
+			cols = append(cols, i)
+			names = append(names, c.name)
+		}
+	} else {
+		for _, name := range names {
+			i := t.index(name)
+			if i < 0 {
+				return 0, nil, errorf(UndefinedColumn, "column %q does not exist", name)
+			}
+			cols = append(cols, i)
+		}
+	}
+
+	data := make([][]driver.Value, len(matched))
+	for i, r := range matched {
+		data[i] = t.rows[r]
+	}
+	if st.orderBy != "" {
+		col := t.index(st.orderBy)
+		if col < 0 {
+			return 0, nil, errorf(UndefinedColumn, "column %q does not exist", st.orderBy)
+		}
+		sortRows(data, col, st.desc)
+	}
+	if limit, ok := st.limit.eval(args).(int64); ok && limit >= 0 && int(limit) < len(data) {
+		data = data[:limit]
+	}
+
+	res := &rows{columns: names, data: make([][]driver.Value, len(data))}
+	for i, row := range data {
+		out := make([]driver.Value, len(cols))
+		for j, c := range cols {
+			out[j] = row[c]
+		}
+		res.data[i] = out
+	}
+	return 0, res, nil
+}
+
+type update struct {
+	table string
+	set   []assignment
+	where []cond
+}
+
+func (st *update) params() int {
+	n := condParams(st.where)
+	for _, a := range st.set {
+		n = max(n, a.value.params())
+	}
+	return n
+}
+
+func (st *update) run(s *snapshot, args []driver.Value) (int64, *rows, error) {
+	t, err := s.writable(st.table)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	cols := make([]int, len(st.set))
+	for i, a := range st.set {
+		if cols[i] = t.index(a.column); cols[i] < 0 {
+			return 0, nil, errorf(UndefinedColumn, "column %q of relation %q does not exist", a.column, st.table)
+		}
+	}
+
+	matched, err := filter(t, st.where, args)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, r := range matched {
+		for i, a := range st.set {
+			t.rows[r][cols[i]] = a.value.eval(args)
+		}
+	}
+	return int64(len(matched)), nil, check(t)
+}
+
+type deleteStmt struct {
+	table string
+	where []cond
+}
+
+func (st *deleteStmt) params() int {
+	return condParams(st.where)
+}
+
+func (st *deleteStmt) run(s *snapshot, args []driver.Value) (int64, *rows, error) {
+	t, err := s.writable(st.table)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	matched, err := filter(t, st.where, args)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	kept := t.rows[:0]
+	for r, row := range t.rows {
+		if len(matched) > 0 && matched[0] == r {
+			matched = matched[1:]
+			continue
+		}
+		kept = append(kept, row)
+	}
+	n := int64(len(t.rows) - len(kept))
+	t.rows = kept
+	return n, nil, nil
+}
@@ -0,0 +1,422 @@
+/*
+Package memdb is an in-process database/sql driver keeping the tables in memory. It understands a small subset of
+SQL, enough for the repositories and the migrations to be tested without a database server:
+
+	CREATE TABLE [IF NOT EXISTS] t (col type [NOT NULL] [PRIMARY KEY], ..., [PRIMARY KEY (col, ...)])
+	DROP TABLE [IF EXISTS] t
+	INSERT INTO t (col, ...) VALUES (v, ...), ...
+	SELECT * | count(*) | col, ... FROM t [WHERE cond] [ORDER BY col [ASC | DESC]] [LIMIT n]
+	UPDATE t SET col = v, ... [WHERE cond]
+	DELETE FROM t [WHERE cond]
+
+where a value is a ? or $n placeholder, a number, a 'string' or NULL, and a condition is the comparisons of the
+columns with the values joined by AND. The types of the columns are ignored.
+
+The data source name is the name of the database, the connections with the same name share the tables. The
+transactions see a snapshot of the database taken at their start. A transaction writing a table changed by another
+one since the snapshot fails to commit with SerializationFailure, like the serializable transactions of Postgres.
+*/
+package memdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+func init() {
+	sql.Register("memdb", Driver{})
+}
+
+// The SQLSTATE codes of the errors, the same as the Postgres ones
+const (
+	SerializationFailure = "40001"
+	NotNullViolation     = "23502"
+	UniqueViolation      = "23505"
+	SyntaxError          = "42601"
+	UndefinedColumn      = "42703"
+	UndefinedTable       = "42P01"
+	DuplicateTable       = "42P07"
+)
+
+// Error is an error of a statement with its SQLSTATE code
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("memdb: %s (SQLSTATE %s)", e.Message, e.Code)
+}
+
+// SQLState returns the code, the drivers of Postgres report theirs with the same method
+func (e *Error) SQLState() string {
+	return e.Code
+}
+
+func errorf(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+var (
+	databasesMu sync.Mutex
+	databases   = make(map[string]*database)
+)
+
+// Drop forgets the database of the name, the open connections keep using it
+func Drop(name string) {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	delete(databases, name)
+}
+
+// Driver is the driver registered as "memdb"
+type Driver struct{}
+
+func (Driver) Open(name string) (driver.Conn, error) {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	db, ok := databases[name]
+	if !ok {
+		db = &database{tables: make(map[string]*table)}
+		databases[name] = db
+	}
+	return &conn{db: db}, nil
+}
+
+/*
+database holds the committed tables. A committed table is never changed: a write clones it and the commit replaces
+it, so a snapshot is merely a copy of the map.
+*/
+type database struct {
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+type table struct {
+	columns []column
+	rows    [][]driver.Value
+}
+
+type column struct {
+	name    string
+	notNull bool
+	primary bool
+}
+
+func (t *table) clone() *table {
+	rows := make([][]driver.Value, len(t.rows))
+	for i, row := range t.rows {
+		rows[i] = append([]driver.Value(nil), row...)
+	}
+	return &table{columns: t.columns, rows: rows}
+}
+
+func (t *table) index(name string) int {
+	for i, c := range t.columns {
+		if c.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// snapshot is the view of a transaction on the database
+type snapshot struct {
+	start   map[string]*table
+	tables  map[string]*table
+	written map[string]bool
+}
+
+func (db *database) snapshot() *snapshot {
+	s := &snapshot{
+		start:   make(map[string]*table, len(db.tables)),
+		tables:  make(map[string]*table, len(db.tables)),
+		written: make(map[string]bool),
+	}
+	for name, t := range db.tables {
+		s.start[name] = t
+		s.tables[name] = t
+	}
+	return s
+}
+
+// commit publishes the tables written by the snapshot unless another commit has changed them since it was taken,
+// the lock of the database is held by the caller
+func (db *database) commit(s *snapshot) error {
+	for name := range s.written {
+		if db.tables[name] != s.start[name] {
+			return errorf(SerializationFailure, "could not serialize access due to concurrent update of %s", name)
+		}
+	}
+	for name := range s.written {
+		if t := s.tables[name]; t != nil {
+			db.tables[name] = t
+		} else {
+			delete(db.tables, name)
+		}
+	}
+	return nil
+}
+
+// table returns the table to read
+func (s *snapshot) table(name string) (*table, error) {
+	t := s.tables[name]
+	if t == nil {
+		return nil, errorf(UndefinedTable, "relation %q does not exist", name)
+	}
+	return t, nil
+}
+
+// writable returns the copy of the table the snapshot may change
+func (s *snapshot) writable(name string) (*table, error) {
+	t, err := s.table(name)
+	if err != nil {
+		return nil, err
+	}
+	if !s.written[name] || t == s.start[name] {
+		t = t.clone()
+		s.tables[name] = t
+		s.written[name] = true
+	}
+	return t, nil
+}
+
+type conn struct {
+	db *database
+	tx *snapshot
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	stmts, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, stmts: stmts}, nil
+}
+
+func (c *conn) Close() error {
+	c.tx = nil
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("memdb: a transaction is already in progress")
+	}
+
+	c.db.mu.Lock()
+	c.tx = c.db.snapshot()
+	c.db.mu.Unlock()
+
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	stmts, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return c.exec(stmts, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	stmts, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return c.query(stmts, args)
+}
+
+// run runs the statements in the transaction of the connection or in a transaction of their own
+func (c *conn) run(f func(s *snapshot) error) error {
+	if c.tx != nil {
+		return f(c.tx)
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	s := c.db.snapshot()
+	if err := f(s); err != nil {
+		return err
+	}
+	return c.db.commit(s)
+}
+
+func (c *conn) exec(stmts []statement, args []driver.NamedValue) (driver.Result, error) {
+	values, err := bind(stmts, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var affected int64
+	err = c.run(func(s *snapshot) error {
+		for _, st := range stmts {
+			n, _, err := st.run(s, values)
+			if err != nil {
+				return err
+			}
+			affected += n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *conn) query(stmts []statement, args []driver.NamedValue) (driver.Rows, error) {
+	if len(stmts) != 1 {
+		return nil, errorf(SyntaxError, "a query must be a single statement, got %d", len(stmts))
+	}
+	values, err := bind(stmts, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var res *rows
+	err = c.run(func(s *snapshot) error {
+		var err error
+		_, res, err = stmts[0].run(s, values)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		res = &rows{}
+	}
+	return res, nil
+}
+
+// bind returns the values of the placeholders checking there are as many arguments as the statements need
+func bind(stmts []statement, args []driver.NamedValue) ([]driver.Value, error) {
+	need := 0
+	for _, st := range stmts {
+		need = max(need, st.params())
+	}
+	if len(args) != need {
+		return nil, fmt.Errorf("memdb: got %d arguments, want %d", len(args), need)
+	}
+
+	values := make([]driver.Value, len(args))
+	for _, a := range args {
+		if a.Ordinal < 1 || a.Ordinal > len(values) {
+			return nil, fmt.Errorf("memdb: bad argument ordinal %d", a.Ordinal)
+		}
+		values[a.Ordinal-1] = a.Value
+	}
+	return values, nil
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	c := t.conn
+	if c.tx == nil {
+		return errors.New("memdb: no transaction in progress")
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	s := c.tx
+	c.tx = nil
+	return c.db.commit(s)
+}
+
+func (t *tx) Rollback() error {
+	if t.conn.tx == nil {
+		return errors.New("memdb: no transaction in progress")
+	}
+	t.conn.tx = nil
+	return nil
+}
+
+type stmt struct {
+	conn  *conn
+	stmts []statement
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	need := 0
+	for _, st := range s.stmts {
+		need = max(need, st.params())
+	}
+	return need
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.exec(s.stmts, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.query(s.stmts, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nv
+}
+
+type rows struct {
+	columns []string
+	data    [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	r.data = nil
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.data[0])
+	r.data = r.data[1:]
+	return nil
+}
+
+// sortRows orders the rows by the column, the NULLs go last
+func sortRows(data [][]driver.Value, col int, desc bool) {
+	sort.SliceStable(data, func(i, j int) bool {
+		a, b := data[i][col], data[j][col]
+		switch {
+		case a == nil || b == nil:
+			return a != nil && b == nil
+		case desc:
+			c, ok := compare(a, b)
+			return ok && c > 0
+		default:
+			c, ok := compare(a, b)
+			return ok && c < 0
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package memdb
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("memdb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		Drop(t.Name())
+	})
+	return db
+}
+
+func code(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+func TestStatements(t *testing.T) {
+	db := open(t)
+
+	mustExec := func(query string, args ...any) sql.Result {
+		t.Helper()
+
+		res, err := db.Exec(query, args...)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return res
+	}
+
+	mustExec(`
+		CREATE TABLE place (
+			country text NOT NULL,
+			city VARCHAR(64),
+			telcode INTEGER,
+
+			PRIMARY KEY (country)
+		);
+		-- The second statement of the query
+		INSERT INTO place (country, telcode) VALUES ('Hong Kong', 852), ('Singapore', 65);
+	`)
+	mustExec(`INSERT INTO place (country, city, telcode) VALUES ($1, $2, $3)`, "South Africa", "Johannesburg", 27)
+
+	if n, _ := mustExec(`UPDATE place SET city = ? WHERE country = ?`, "Singapore", "Singapore").RowsAffected(); n != 1 {
+		t.Errorf("UPDATE affected %d rows", n)
+	}
+
+	rows, err := db.Query(`SELECT country, city FROM place WHERE telcode > ? AND city IS NOT NULL ORDER BY telcode DESC`, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for rows.Next() {
+		var country, city string
+		if err := rows.Scan(&country, &city); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, country+"/"+city)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "Singapore/Singapore" || got[1] != "South Africa/Johannesburg" {
+		t.Errorf("SELECT = %v", got)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT count(*) FROM place WHERE city IS NULL`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("count = %d, %v", count, err)
+	}
+
+	var city sql.NullString
+	if err := db.QueryRow(`SELECT city FROM place WHERE country = 'Hong Kong' LIMIT 1`).Scan(&city); err != nil || city.Valid {
+		t.Errorf("city = %v, %v", city, err)
+	}
+
+	if n, _ := mustExec(`DELETE FROM place WHERE telcode <= 65`).RowsAffected(); n != 2 {
+		t.Errorf("DELETE affected %d rows", n)
+	}
+
+	for query, want := range map[string]string{
+		`INSERT INTO place (country) VALUES ('Hong Kong')`:  UniqueViolation,
+		`INSERT INTO place (city) VALUES ('Paris')`:         NotNullViolation,
+		`SELECT * FROM planet`:                              UndefinedTable,
+		`SELECT altitude FROM place`:                        UndefinedColumn,
+		`CREATE TABLE place (id INTEGER)`:                   DuplicateTable,
+		`SELEKT * FROM place`:                               SyntaxError,
+		`INSERT INTO place (country, city) VALUES ('Peru')`: SyntaxError,
+	} {
+		if _, err := db.Exec(query); code(err) != want {
+			t.Errorf("%s: error %v, want SQLSTATE %s", query, err, want)
+		}
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	db := open(t)
+
+	if _, err := db.Exec(`CREATE TABLE account (id INTEGER PRIMARY KEY, balance INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO account (id, balance) VALUES (1, 100)`); err != nil {
+		t.Fatal(err)
+	}
+
+	balance := func(q interface {
+		QueryRow(string, ...any) *sql.Row
+	}) int {
+		t.Helper()
+
+		var b int
+		if err := q.QueryRow(`SELECT balance FROM account WHERE id = 1`).Scan(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// The rolled back changes are gone
+	tx, _ := db.Begin()
+	tx.Exec(`UPDATE account SET balance = 0 WHERE id = 1`)
+	if b := balance(tx); b != 0 {
+		t.Errorf("the transaction sees the balance %d", b)
+	}
+	tx.Rollback()
+	if b := balance(db); b != 100 {
+		t.Errorf("the balance after the rollback is %d", b)
+	}
+
+	// The transaction sees its snapshot, and the lost update fails to commit
+	tx, _ = db.Begin()
+	b := balance(tx)
+	if _, err := db.Exec(`UPDATE account SET balance = 150 WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(tx); b != 100 {
+		t.Errorf("the snapshot has the balance %d", b)
+	}
+	if _, err := tx.Exec(`UPDATE account SET balance = ? WHERE id = 1`, b+10); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); code(err) != SerializationFailure {
+		t.Errorf("Commit() error = %v, want a serialization failure", err)
+	}
+	if b := balance(db); b != 150 {
+		t.Errorf("the balance after the failed commit is %d", b)
+	}
+
+	// The read only transaction commits whatever happened meanwhile
+	tx, _ = db.Begin()
+	balance(tx)
+	db.Exec(`UPDATE account SET balance = 0 WHERE id = 1`)
+	if err := tx.Commit(); err != nil {
+		t.Errorf("the read only Commit() error = %v", err)
+	}
+}
@@ -0,0 +1,542 @@
+package memdb
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokParam
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// The zero based index of a placeholder
+	param int
+}
+
+// tokenize splits the query into the tokens, the ? placeholders are numbered in order and $n ones by n
+func tokenize(query string) ([]token, error) {
+	var (
+		tokens []token
+		next   int
+		rs     = []rune(query)
+	)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_' || rs[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: strings.ToLower(string(rs[i:j]))})
+			i = j
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			if j == len(rs) {
+				return nil, errorf(SyntaxError, "unterminated quoted identifier")
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(rs[i+1 : j])})
+			i = j + 1
+		case unicode.IsDigit(r) || r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1]):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(rs[i:j])})
+			i = j
+		case r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs); j++ {
+				if rs[j] == '\'' {
+					// The doubled quote is the quote itself
+					if j+1 < len(rs) && rs[j+1] == '\'' {
+						sb.WriteRune('\'')
+						j++
+						continue
+					}
+					break
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j == len(rs) {
+				return nil, errorf(SyntaxError, "unterminated string")
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String()})
+			i = j + 1
+		case r == '?':
+			tokens = append(tokens, token{kind: tokParam, param: next})
+			next++
+			i++
+		case r == '$':
+			j := i + 1
+			for j < len(rs) && unicode.IsDigit(rs[j]) {
+				j++
+			}
+			n, err := strconv.Atoi(string(rs[i+1 : j]))
+			if err != nil || n < 1 {
+				return nil, errorf(SyntaxError, "bad placeholder %q", string(rs[i:j]))
+			}
+			tokens = append(tokens, token{kind: tokParam, param: n - 1})
+			i = j
+		case strings.ContainsRune("<>!", r) && i+1 < len(rs) && rs[i+1] == '=', r == '<' && i+1 < len(rs) && rs[i+1] == '>':
+			tokens = append(tokens, token{kind: tokSymbol, text: string(rs[i : i+2])})
+			i += 2
+		case strings.ContainsRune("(),;*=<>", r):
+			tokens = append(tokens, token{kind: tokSymbol, text: string(r)})
+			i++
+		default:
+			return nil, errorf(SyntaxError, "unexpected %q", r)
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{kind: tokSymbol, text: ""}
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// is reports whether the next token is the keyword or the symbol
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokIdent || t.kind == tokSymbol) && t.text == text && !p.done()
+}
+
+// accept skips the keywords or the symbols if they come next
+func (p *parser) accept(texts ...string) bool {
+	for i, text := range texts {
+		if p.pos+i >= len(p.tokens) {
+			return false
+		}
+		t := p.tokens[p.pos+i]
+		if (t.kind != tokIdent && t.kind != tokSymbol) || t.text != text {
+			return false
+		}
+	}
+	p.pos += len(texts)
+	return true
+}
+
+func (p *parser) expect(texts ...string) error {
+	if !p.accept(texts...) {
+		return p.unexpected(strings.Join(texts, " "))
+	}
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	if p.done() {
+		return errorf(SyntaxError, "unexpected end of the statement, want %s", want)
+	}
+	t := p.peek()
+	text := t.text
+	if t.kind == tokParam {
+		text = "$" + strconv.Itoa(t.param+1)
+	}
+	return errorf(SyntaxError, "unexpected %q, want %s", text, want)
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent || p.done() {
+		return "", p.unexpected("a name")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+// identList parses the comma separated names in the parentheses
+func (p *parser) identList() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+
+		if p.accept(")") {
+			return names, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) value() (expr, error) {
+	t := p.peek()
+	switch {
+	case p.done():
+		return expr{}, p.unexpected("a value")
+	case t.kind == tokParam:
+		p.pos++
+		return expr{param: t.param}, nil
+	case t.kind == tokString:
+		p.pos++
+		return expr{param: -1, value: t.text}, nil
+	case t.kind == tokNumber:
+		p.pos++
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return expr{param: -1, value: i}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return expr{}, errorf(SyntaxError, "bad number %q", t.text)
+		}
+		return expr{param: -1, value: f}, nil
+	case t.kind == tokIdent && t.text == "null":
+		p.pos++
+		return expr{param: -1}, nil
+	case t.kind == tokIdent && (t.text == "true" || t.text == "false"):
+		p.pos++
+		return expr{param: -1, value: t.text == "true"}, nil
+	default:
+		return expr{}, p.unexpected("a value")
+	}
+}
+
+// where parses the optional WHERE clause
+func (p *parser) where() ([]cond, error) {
+	if !p.accept("where") {
+		return nil, nil
+	}
+
+	var conds []cond
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+
+		var c cond
+		switch {
+		case p.accept("is", "not", "null"):
+			c = cond{column: col, op: "is not null", value: expr{param: -1}}
+		case p.accept("is", "null"):
+			c = cond{column: col, op: "is null", value: expr{param: -1}}
+		default:
+			t := p.peek()
+			switch t.text {
+			case "=", "!=", "<>", "<", "<=", ">", ">=":
+				if t.kind == tokSymbol {
+					break
+				}
+				fallthrough
+			default:
+				return nil, p.unexpected("a comparison")
+			}
+			p.pos++
+
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			c = cond{column: col, op: t.text, value: v}
+		}
+		conds = append(conds, c)
+
+		if !p.accept("and") {
+			return conds, nil
+		}
+	}
+}
+
+// parse splits the query into the statements separated by semicolons
+func parse(query string) ([]statement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		stmts []statement
+		p     = &parser{tokens: tokens}
+	)
+	for !p.done() {
+		if p.accept(";") {
+			continue
+		}
+
+		st, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, st)
+
+		if !p.done() {
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(stmts) == 0 {
+		return nil, errorf(SyntaxError, "empty query")
+	}
+	return stmts, nil
+}
+
+func (p *parser) statement() (statement, error) {
+	switch {
+	case p.accept("create", "table"):
+		return p.createTable()
+	case p.accept("drop", "table"):
+		st := &dropTable{ifExists: p.accept("if", "exists")}
+		var err error
+		st.name, err = p.ident()
+		return st, err
+	case p.accept("insert", "into"):
+		return p.insert()
+	case p.accept("select"):
+		return p.selectStmt()
+	case p.accept("update"):
+		return p.update()
+	case p.accept("delete", "from"):
+		st := &deleteStmt{}
+		var err error
+		if st.table, err = p.ident(); err != nil {
+			return nil, err
+		}
+		st.where, err = p.where()
+		return st, err
+	default:
+		return nil, p.unexpected("a statement")
+	}
+}
+
+func (p *parser) createTable() (statement, error) {
+	st := &createTable{ifNotExists: p.accept("if", "not", "exists")}
+
+	var err error
+	if st.name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var primary []string
+	for {
+		if p.accept("primary", "key") {
+			names, err := p.identList()
+			if err != nil {
+				return nil, err
+			}
+			primary = append(primary, names...)
+		} else {
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			col := column{name: name}
+
+			// Skip the type and the constraints but the ones the driver enforces
+			for depth := 0; !p.done(); {
+				switch {
+				case depth == 0 && (p.is(",") || p.is(")")):
+				case p.accept("not", "null"):
+					col.notNull = true
+					continue
+				case p.accept("primary", "key"):
+					col.primary, col.notNull = true, true
+					continue
+				case p.accept("("):
+					depth++
+					continue
+				case p.accept(")"):
+					depth--
+					continue
+				default:
+					p.pos++
+					continue
+				}
+				break
+			}
+			st.columns = append(st.columns, col)
+		}
+
+		if p.accept(")") {
+			break
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range primary {
+		found := false
+		for i := range st.columns {
+			if st.columns[i].name == name {
+				st.columns[i].primary, st.columns[i].notNull, found = true, true, true
+			}
+		}
+		if !found {
+			return nil, errorf(UndefinedColumn, "column %q named in key does not exist", name)
+		}
+	}
+	return st, nil
+}
+
+func (p *parser) insert() (statement, error) {
+	st := &insert{}
+
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if st.columns, err = p.identList(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("values"); err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+
+		var row []expr
+		for {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, v)
+
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if len(row) != len(st.columns) {
+			return nil, errorf(SyntaxError, "INSERT has %d columns but %d values", len(st.columns), len(row))
+		}
+		st.rows = append(st.rows, row)
+
+		if !p.accept(",") {
+			return st, nil
+		}
+	}
+}
+
+func (p *parser) selectStmt() (statement, error) {
+	st := &selectStmt{limit: expr{param: -1, value: int64(-1)}}
+
+	switch {
+	case p.accept("*"):
+	case p.accept("count", "(", "*", ")"):
+		st.count = true
+	default:
+		for {
+			col, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			st.columns = append(st.columns, col)
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expect("from"); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if st.where, err = p.where(); err != nil {
+		return nil, err
+	}
+
+	if p.accept("order", "by") {
+		if st.orderBy, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if !p.accept("asc") {
+			st.desc = p.accept("desc")
+		}
+	}
+	if p.accept("limit") {
+		if st.limit, err = p.value(); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func (p *parser) update() (statement, error) {
+	st := &update{}
+
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("set"); err != nil {
+		return nil, err
+	}
+
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		st.set = append(st.set, assignment{column: col, value: v})
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	st.where, err = p.where()
+	return st, err
+}
@@ -0,0 +1,286 @@
+package repo
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration is a versioned change of the schema with the SQL applying and reverting it
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+/*
+LoadMigrations reads the migrations from the root of fsys, the files are named <version>_<name>.up.sql and
+<version>_<name>.down.sql. The other files are ignored, the down file is optional. The migrations are sorted by
+their versions, the versions are numbers, so 0001_a.up.sql and 1_a.up.sql are two up files of the same migration and
+fail the loading.
+*/
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		byVersion = make(map[int64]*Migration)
+		// The file of every version and direction seen
+		files = make(map[string]string)
+	)
+	for _, e := range entries {
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: bad version", e.Name())
+		}
+		key := strconv.FormatInt(version, 10) + "." + m[3]
+		if other, ok := files[key]; ok {
+			return nil, fmt.Errorf("migration %d has two %s files: %s and %s", version, m[3], other, e.Name())
+		}
+		files[key] = e.Name()
+
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+//go:embed migrations/*.sql
+var schema embed.FS
+
+// Migrations returns the migrations of the place and products tables of the examples
+func Migrations() ([]Migration, error) {
+	sub, err := fs.Sub(schema, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return LoadMigrations(sub)
+}
+
+/*
+Migrator applies and reverts the migrations keeping the versions applied in the schema_migrations table. Every
+migration runs in a transaction of its own together with the bookkeeping, so a failed one leaves the schema at the
+previous version. The DDL is transactional in Postgres, so that holds for the CREATE and ALTER statements too.
+
+Up and Down run on a single connection of the pool, so they work with a pool of one connection as well.
+*/
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+
+	// AdvisoryLock makes Up and Down hold a Postgres advisory lock, so the replicas starting at the same moment
+	// migrate one after another and the later ones find the migrations applied. It needs a database with
+	// pg_advisory_lock, whatever the name of the driver; without it the callers must serialize the migrations
+	AdvisoryLock bool
+}
+
+func NewMigrator(db *DB, migrations []Migration) *Migrator {
+	migrations = slices.Clone(migrations)
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// migrationLock is the key of the advisory lock of the migrators
+const migrationLock = 0x6d6967726174696f // "migratio"
+
+/*
+connect takes the connection Up and Down run on and, with AdvisoryLock, the advisory lock of the migrators on it.
+The lock belongs to the session, so it's released on the same connection by the returned release, which closes the
+connection too.
+*/
+func (m *Migrator) connect(ctx context.Context) (conn *sqlx.Conn, release func(), err error) {
+	conn, err = m.db.Connx(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !m.AdvisoryLock {
+		return conn, func() { conn.Close() }, nil
+	}
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("locking the migrations: %w", err)
+	}
+	return conn, func() {
+		// Closing the connection releases the lock too, unless it goes back to the pool
+		conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLock)
+		conn.Close()
+	}, nil
+}
+
+// queryer is a *sqlx.DB or a *sqlx.Conn
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+const createVersions = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT PRIMARY KEY,
+	name TEXT NOT NULL
+)`
+
+// applied returns the versions applied in the ascending order, it fails on the versions it has no migrations for
+func (m *Migrator) applied(ctx context.Context, q queryer) ([]int64, error) {
+	if _, err := q.ExecContext(ctx, createVersions); err != nil {
+		return nil, err
+	}
+
+	var versions []int64
+	err := q.SelectContext(ctx, &versions, `SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		if _, ok := m.find(v); !ok {
+			return nil, fmt.Errorf("the database has the migration %d unknown to the migrator", v)
+		}
+	}
+	return versions, nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	i, ok := slices.BinarySearchFunc(m.migrations, version, func(mig Migration, v int64) int {
+		return cmp.Compare(mig.Version, v)
+	})
+	if !ok {
+		return Migration{}, false
+	}
+	return m.migrations[i], true
+}
+
+// Version returns the latest version applied, zero for none
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	versions, err := m.applied(ctx, m.db)
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Up applies the migrations not applied yet in the order of their versions and returns their number
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	conn, release, err := m.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	versions, err := m.applied(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, mig := range m.migrations {
+		if slices.Contains(versions, mig.Version) {
+			continue
+		}
+
+		err := m.tx(ctx, conn, func(tx *sqlx.Tx) error {
+			if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, tx.Rebind(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`),
+				mig.Version, mig.Name)
+			return err
+		})
+		if err != nil {
+			return n, fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Down reverts the latest steps migrations applied and returns their number
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	conn, release, err := m.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	versions, err := m.applied(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i := len(versions) - 1; i >= 0 && n < steps; i-- {
+		mig, _ := m.find(versions[i])
+		if mig.Down == "" {
+			return n, fmt.Errorf("migration %d_%s can't be reverted, it has no down file", mig.Version, mig.Name)
+		}
+
+		err := m.tx(ctx, conn, func(tx *sqlx.Tx) error {
+			if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM schema_migrations WHERE version = ?`), mig.Version)
+			return err
+		})
+		if err != nil {
+			return n, fmt.Errorf("reverting migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// tx runs fn in a transaction on the connection of the migrator, it isn't retried as the migrations don't race
+func (m *Migrator) tx(ctx context.Context, conn *sqlx.Conn, fn func(tx *sqlx.Tx) error) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return run(tx, fn)
+}
@@ -0,0 +1,7 @@
+CREATE TABLE place (
+	country TEXT NOT NULL,
+	city TEXT,
+	telcode INTEGER,
+
+	PRIMARY KEY (country)
+);
@@ -0,0 +1,5 @@
+CREATE TABLE products (
+	pid SERIAL PRIMARY KEY,
+	quantity INTEGER,
+	price INTEGER
+);
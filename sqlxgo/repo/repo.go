@@ -0,0 +1,122 @@
+/*
+Package repo is a small repository layer over sqlx: a typed Repository for the queries of a row type, transactions
+retried on the serialization failures and a runner of the versioned migrations. The queries are written with the ?
+placeholders and rebound for the driver, so the same code runs against Postgres and the in-memory driver of the
+tests.
+*/
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The SQLSTATE codes of the errors a transaction is worth retrying after
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// DB is a database with the retrying transaction helper
+type DB struct {
+	*sqlx.DB
+
+	// The isolation level of the transactions of WithTx, serializable by default
+	Isolation sql.IsolationLevel
+	// The number of the attempts of a transaction failing to serialize, 3 by default
+	MaxAttempts int
+	// The pause before the second attempt, doubled for each next one and jittered, 10ms by default
+	Backoff time.Duration
+}
+
+// Open opens the database and checks the connection
+func Open(ctx context.Context, driverName, dsn string) (*DB, error) {
+	db, err := sqlx.ConnectContext(ctx, driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{DB: db}, nil
+}
+
+/*
+WithTx runs fn in a transaction: it's committed if fn succeeds and rolled back if fn fails or panics. When the
+transaction fails with a serialization failure or a deadlock, the database has given up on it in favor of a
+concurrent one, so it's run again from the start. Thus fn must not have the side effects outside the transaction.
+*/
+func (db *DB) WithTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	var (
+		attempts = db.MaxAttempts
+		backoff  = db.Backoff
+	)
+	if attempts <= 0 {
+		attempts = 3
+	}
+	if backoff <= 0 {
+		backoff = 10 * time.Millisecond
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := db.tx(ctx, fn)
+		if err == nil || !Retryable(err) {
+			return err
+		}
+		if attempt == attempts {
+			return fmt.Errorf("the transaction failed %d times: %w", attempts, err)
+		}
+
+		// The jitter keeps the conflicting transactions from retrying in lockstep
+		pause := backoff<<(attempt-1)/2 + time.Duration(rand.Int63n(int64(backoff<<(attempt-1)/2)+1))
+		select {
+		case <-time.After(pause):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (db *DB) tx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	isolation := db.Isolation
+	if isolation == sql.LevelDefault {
+		isolation = sql.LevelSerializable
+	}
+
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: isolation})
+	if err != nil {
+		return err
+	}
+	return run(tx, fn)
+}
+
+// run runs fn in tx, which is committed if fn succeeds and rolled back if fn fails or panics
+func run(tx *sqlx.Tx, fn func(tx *sqlx.Tx) error) error {
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Retryable reports whether the error is a serialization failure or a deadlock, the drivers reporting the SQLSTATE
+// with the SQLState method are recognized, the one of pgx among them
+func Retryable(err error) bool {
+	var state interface{ SQLState() string }
+	if !errors.As(err, &state) {
+		return false
+	}
+
+	code := state.SQLState()
+	return code == serializationFailure || code == deadlockDetected
+}
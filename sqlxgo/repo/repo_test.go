@@ -0,0 +1,300 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"sqlxgo/repo/memdb"
+)
+
+// Place is the row of the place table of the sqlxusing examples
+type Place struct {
+	Country       string
+	City          sql.NullString
+	TelephoneCode sql.NullInt32 `db:"telcode"`
+}
+
+// open returns the in-memory database of the test, migrated if migrate is set
+func open(t *testing.T, migrate bool) *DB {
+	t.Helper()
+
+	raw, err := sql.Open("memdb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		raw.Close()
+		memdb.Drop(t.Name())
+	})
+	db := &DB{DB: sqlx.NewDb(raw, "memdb")}
+
+	if migrate {
+		migrations, err := Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewMigrator(db, migrations).Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[0].Name != "create_place" ||
+		migrations[1].Version != 2 || migrations[1].Down == "" {
+		t.Errorf("Migrations() = %+v", migrations)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"no up file": {"0001_a.down.sql": {Data: []byte("DROP TABLE a")}},
+		"two names": {
+			"0001_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER)")},
+			"0001_b.up.sql": {Data: []byte("CREATE TABLE b (id INTEGER)")},
+		},
+		"zero version": {"0_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER)")}},
+		"two up files": {
+			"0001_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER)")},
+			"1_a.up.sql":    {Data: []byte("CREATE TABLE a (id BIGINT)")},
+		},
+		"two down files": {
+			"1_a.up.sql":      {Data: []byte("CREATE TABLE a (id INTEGER)")},
+			"1_a.down.sql":    {Data: []byte("DROP TABLE a")},
+			"0001_a.down.sql": {Data: []byte("DROP TABLE IF EXISTS a")},
+		},
+	} {
+		if _, err := LoadMigrations(fsys); err == nil {
+			t.Errorf("%s: LoadMigrations() succeeded", name)
+		}
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	var (
+		ctx = context.Background()
+		db  = open(t, false)
+	)
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMigrator(db, migrations)
+
+	version := func() int64 {
+		t.Helper()
+
+		v, err := m.Version(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tableExists := func(name string) bool {
+		_, err := db.ExecContext(ctx, "DELETE FROM "+name)
+		return err == nil
+	}
+
+	if v := version(); v != 0 {
+		t.Errorf("the version of the empty database is %d", v)
+	}
+	if n, err := m.Up(ctx); n != 2 || err != nil {
+		t.Fatalf("Up() = %d, %v", n, err)
+	}
+	if n, err := m.Up(ctx); n != 0 || err != nil {
+		t.Errorf("the second Up() = %d, %v", n, err)
+	}
+	if v := version(); v != 2 || !tableExists("place") || !tableExists("products") {
+		t.Errorf("version %d after Up()", v)
+	}
+
+	if n, err := m.Down(ctx, 1); n != 1 || err != nil {
+		t.Fatalf("Down(1) = %d, %v", n, err)
+	}
+	if v := version(); v != 1 || !tableExists("place") || tableExists("products") {
+		t.Errorf("version %d after Down(1)", v)
+	}
+	if n, err := m.Down(ctx, 10); n != 1 || err != nil {
+		t.Errorf("Down(10) = %d, %v", n, err)
+	}
+	if v := version(); v != 0 || tableExists("place") {
+		t.Errorf("version %d after Down(10)", v)
+	}
+
+	// A failed migration leaves the schema at the previous version
+	broken := append(migrations, Migration{Version: 3, Name: "broken", Up: "CREATE TABLE x (id INTEGER); SELEKT 1"})
+	m = NewMigrator(db, broken)
+	if n, err := m.Up(ctx); n != 2 || err == nil {
+		t.Errorf("Up() with the broken migration = %d, %v", n, err)
+	}
+	if v := version(); v != 2 || tableExists("x") {
+		t.Errorf("version %d after the broken migration", v)
+	}
+
+	// The migrator doesn't know the version of the database
+	if _, err := NewMigrator(db, migrations[:1]).Up(ctx); err == nil {
+		t.Error("Up() with the unknown version applied succeeded")
+	}
+}
+
+func TestMigratorSingleConnection(t *testing.T) {
+	db := open(t, false)
+	db.SetMaxOpenConns(1)
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The migrator doesn't take a second connection from the pool of one
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if n, err := NewMigrator(db, migrations).Up(ctx); n != 2 || err != nil {
+		t.Fatalf("Up() = %d, %v", n, err)
+	}
+
+	// The lock is taken when asked for whatever the driver, the in-memory one has no advisory locks
+	m := NewMigrator(db, migrations)
+	m.AdvisoryLock = true
+	if _, err := m.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "locking the migrations") {
+		t.Errorf("Down() with the advisory lock = %v", err)
+	}
+}
+
+func TestRepository(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		db     = open(t, true)
+		places = NewRepository[Place](db)
+	)
+
+	_, err := places.NamedExec(ctx, `INSERT INTO place (country, city, telcode) VALUES (:country, :city, :telcode)`, []Place{
+		{Country: "Hong Kong", TelephoneCode: sql.NullInt32{Int32: 852, Valid: true}},
+		{Country: "Singapore", TelephoneCode: sql.NullInt32{Int32: 65, Valid: true}},
+		{Country: "South Africa", City: sql.NullString{String: "Johannesburg", Valid: true},
+			TelephoneCode: sql.NullInt32{Int32: 27, Valid: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := places.Get(ctx, `SELECT * FROM place WHERE country = ?`, "South Africa")
+	if err != nil || p.City.String != "Johannesburg" || p.TelephoneCode.Int32 != 27 {
+		t.Errorf("Get() = %+v, %v", p, err)
+	}
+	if _, err := places.Get(ctx, `SELECT * FROM place WHERE country = ?`, "Atlantis"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of the missing place error = %v", err)
+	}
+
+	selected, err := places.Select(ctx, `SELECT * FROM place WHERE telcode > ? ORDER BY telcode`, 50)
+	if err != nil || len(selected) != 2 || selected[0].Country != "Singapore" || selected[1].Country != "Hong Kong" {
+		t.Errorf("Select() = %+v, %v", selected, err)
+	}
+
+	named, err := places.NamedSelect(ctx, `SELECT * FROM place WHERE telcode < :telcode`,
+		map[string]any{"telcode": 50})
+	if err != nil || len(named) != 1 || named[0].Country != "South Africa" {
+		t.Errorf("NamedSelect() = %+v, %v", named, err)
+	}
+
+	count, err := NewRepository[int](db).Get(ctx, `SELECT count(*) FROM place WHERE city IS NULL`)
+	if err != nil || count != 2 {
+		t.Errorf("count = %d, %v", count, err)
+	}
+
+	names, err := NewRepository[string](db).Select(ctx, `SELECT country FROM place ORDER BY country DESC LIMIT 1`)
+	if err != nil || len(names) != 1 || names[0] != "South Africa" {
+		t.Errorf("names = %v, %v", names, err)
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		db     = open(t, true)
+		places = NewRepository[Place](db)
+	)
+	db.Backoff = 1
+
+	if _, err := places.Exec(ctx, `INSERT INTO place (country, telcode) VALUES (?, ?)`, "Singapore", 65); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first attempt loses the update to the concurrent one and runs again on the fresh data
+	attempts := 0
+	err := db.WithTx(ctx, func(tx *sqlx.Tx) error {
+		attempts++
+
+		p, err := places.WithTx(tx).Get(ctx, `SELECT * FROM place WHERE country = ?`, "Singapore")
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			if _, err := places.Exec(ctx, `UPDATE place SET telcode = 650 WHERE country = 'Singapore'`); err != nil {
+				return err
+			}
+		}
+
+		_, err = places.WithTx(tx).Exec(ctx, `UPDATE place SET telcode = ? WHERE country = ?`,
+			p.TelephoneCode.Int32+1, p.Country)
+		return err
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("WithTx() = %v after %d attempts", err, attempts)
+	}
+	if p, _ := places.Get(ctx, `SELECT * FROM place WHERE country = 'Singapore'`); p.TelephoneCode.Int32 != 651 {
+		t.Errorf("telcode %d, want 651", p.TelephoneCode.Int32)
+	}
+
+	// The conflict on every attempt gives up after MaxAttempts
+	attempts = 0
+	db.MaxAttempts = 2
+	err = db.WithTx(ctx, func(tx *sqlx.Tx) error {
+		attempts++
+		places.WithTx(tx).Exec(ctx, `UPDATE place SET telcode = 1`)
+		_, err := places.Exec(ctx, `UPDATE place SET telcode = 2`)
+		return err
+	})
+	if !Retryable(err) || attempts != 2 {
+		t.Errorf("WithTx() = %v after %d attempts", err, attempts)
+	}
+
+	// The other errors roll back at once
+	attempts = 0
+	errBoom := errors.New("boom")
+	err = db.WithTx(ctx, func(tx *sqlx.Tx) error {
+		attempts++
+		places.WithTx(tx).Exec(ctx, `DELETE FROM place`)
+		return errBoom
+	})
+	if err != errBoom || attempts != 1 {
+		t.Errorf("WithTx() = %v after %d attempts", err, attempts)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic didn't propagate")
+			}
+		}()
+		db.WithTx(ctx, func(tx *sqlx.Tx) error {
+			places.WithTx(tx).Exec(ctx, `DELETE FROM place`)
+			panic("boom")
+		})
+	}()
+
+	if count, _ := NewRepository[int](db).Get(ctx, `SELECT count(*) FROM place`); count != 1 {
+		t.Errorf("%d places after the rollbacks", count)
+	}
+}
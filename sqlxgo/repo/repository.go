@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNotFound is returned by Get when the query selects no row
+var ErrNotFound = errors.New("repo: not found")
+
+/*
+Repository runs the queries selecting the rows of type T, a struct mapped by the db tags like sqlx does or a single
+column type like int or string. It queries either the database or a transaction.
+*/
+type Repository[T any] struct {
+	q sqlx.ExtContext
+}
+
+// NewRepository returns the repository querying q, a *sqlx.DB, a *sqlx.Tx or a *DB
+func NewRepository[T any](q sqlx.ExtContext) *Repository[T] {
+	return &Repository[T]{q: q}
+}
+
+// WithTx returns the repository running the queries in the transaction
+func (r *Repository[T]) WithTx(tx *sqlx.Tx) *Repository[T] {
+	return &Repository[T]{q: tx}
+}
+
+// Get returns the first row the query selects or ErrNotFound
+func (r *Repository[T]) Get(ctx context.Context, query string, args ...any) (T, error) {
+	var v T
+	err := sqlx.GetContext(ctx, r.q, &v, r.q.Rebind(query), args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return v, ErrNotFound
+	}
+	return v, err
+}
+
+// Select returns all the rows the query selects
+func (r *Repository[T]) Select(ctx context.Context, query string, args ...any) ([]T, error) {
+	var vs []T
+	if err := sqlx.SelectContext(ctx, r.q, &vs, r.q.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// NamedSelect returns the rows the query with the :name parameters taken from arg selects
+func (r *Repository[T]) NamedSelect(ctx context.Context, query string, arg any) ([]T, error) {
+	query, args, err := r.q.BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return r.Select(ctx, query, args...)
+}
+
+// Exec runs the statement
+func (r *Repository[T]) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.q.ExecContext(ctx, r.q.Rebind(query), args...)
+}
+
+// NamedExec runs the statement with the :name parameters taken from arg, a struct, a map or a slice of them
+func (r *Repository[T]) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	return sqlx.NamedExecContext(ctx, r.q, query, arg)
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -13,161 +12,425 @@ const (
 	defaultMaxWorkers = 10
 	defaultQueueSize  = 10
 	defaultDur        = 250 * time.Millisecond
+
+	// maxErrors is the number of the latest failures kept for Err, the earlier ones are only counted
+	maxErrors = 100
+)
+
+// Priority is the lane of a task, the queued tasks of the higher lane always run first
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	High
+
+	priorities = int(High) + 1
+)
+
+var (
+	// ErrClosed is returned by Submit once Shutdown or Stop was called
+	ErrClosed = errors.New("worker pool closed")
+	// ErrStopped is the result of the queued tasks Stop dropped
+	ErrStopped = errors.New("worker pool stopped before the task ran")
 )
 
 type (
-	Task struct{ ID int }
+	// Func is the work done for every task, it should give up when the context is cancelled
+	Func[In, Out any] func(ctx context.Context, in In) (Out, error)
+
+	// Result is the outcome of a task sent to the Results channel
+	Result[In, Out any] struct {
+		In  In
+		Out Out
+		Err error
+	}
+
+	// Future is the outcome of a submitted task
+	Future[Out any] struct {
+		done chan struct{}
+		out  Out
+		err  error
+	}
 
-	WorkerPool struct {
-		taskQueue  chan Task
-		workQueue  chan Task
-		shutdownCh chan struct{}
-		closeOnce  *sync.Once
-		isStopped  *atomic.Bool
-		dur        time.Duration
-		maxWorkers int
+	/*
+		Pool runs the tasks of type In on a resizable set of workers with fn. The tasks wait in a bounded queue
+		split into the priority lanes, Submit blocks for the timeout when it's full. Every task gives a Future, the
+		results are also sent to the Results channel if it's turned on with WithResults, and the latest failures are
+		kept for Err.
+
+		Shutdown stops accepting the tasks and waits for the queued ones to finish, Stop cancels the running tasks
+		and drops the queued ones. Cancelling the context of NewPool is the same as Stop.
+	*/
+	Pool[In, Out any] struct {
+		fn      Func[In, Out]
+		ctx     context.Context
+		cancel  context.CancelFunc
+		results chan Result[In, Out]
+		timeout time.Duration
+
+		mu       sync.Mutex
+		work     *sync.Cond // Signalled when a task is queued, the pool shrinks or closes
+		space    chan struct{}
+		lanes    [priorities][]job[In, Out]
+		queued   int
+		maxQueue int
+		workers  int // The target number of the workers
+		running  int
+		state    poolState
+		errs     []error
+		dropped  int // The failures pushed out of errs
+		wg       sync.WaitGroup
+
+		closeOnce sync.Once
+		done      chan struct{}
+		stopAfter func() bool
 	}
 
-	WorkerPoolOption func(wp *WorkerPool)
+	PoolOption func(c *poolConfig)
+
+	poolConfig struct {
+		workers int
+		queue   int
+		timeout time.Duration
+		results int // The buffer of the results channel, negative for no channel
+	}
+
+	job[In, Out any] struct {
+		in     In
+		future *Future[Out]
+	}
+
+	poolState int
 )
 
-func WithMaxWorkers(cnt int) WorkerPoolOption {
-	if cnt == 0 {
+const (
+	running poolState = iota
+	draining
+	stopped
+)
+
+// WithMaxWorkers sets the number of the workers, defaultMaxWorkers by default
+func WithMaxWorkers(cnt int) PoolOption {
+	if cnt <= 0 {
 		cnt = defaultMaxWorkers
 	}
 
-	return func(wp *WorkerPool) {
-		wp.workQueue = make(chan Task, cnt)
+	return func(c *poolConfig) {
+		c.workers = cnt
 	}
 }
 
-func WithMaxQueue(n int) WorkerPoolOption {
+// WithMaxQueue sets the number of the tasks waiting for a worker, defaultQueueSize by default
+func WithMaxQueue(n int) PoolOption {
 	if n <= 0 {
 		n = defaultQueueSize
 	}
 
-	return func(wp *WorkerPool) {
-		wp.taskQueue = make(chan Task, n)
+	return func(c *poolConfig) {
+		c.queue = n
 	}
 }
 
-func WithTimeout(dur time.Duration) WorkerPoolOption {
+// WithTimeout sets how long Submit waits for the room in the queue, defaultDur by default
+func WithTimeout(dur time.Duration) PoolOption {
 	if dur <= 0 {
 		dur = defaultDur
 	}
 
-	return func(wp *WorkerPool) {
-		wp.dur = dur
+	return func(c *poolConfig) {
+		c.timeout = dur
 	}
 }
 
-func NewWorkerPool(ctx context.Context, opts ...WorkerPoolOption) *WorkerPool {
-	wp := &WorkerPool{
-		shutdownCh: make(chan struct{}),
-		closeOnce:  &sync.Once{},
-		isStopped:  &atomic.Bool{},
+// WithResults turns on the Results channel with the buffer. The workers wait for the results to be read, so the
+// channel must be drained until it's closed
+func WithResults(buffer int) PoolOption {
+	return func(c *poolConfig) {
+		c.results = max(buffer, 0)
 	}
+}
 
+// NewPool starts the pool running fn for the tasks
+func NewPool[In, Out any](ctx context.Context, fn Func[In, Out], opts ...PoolOption) *Pool[In, Out] {
+	c := poolConfig{
+		workers: defaultMaxWorkers,
+		queue:   defaultQueueSize,
+		timeout: defaultDur,
+		results: -1,
+	}
 	for i := range opts {
-		opts[i](wp)
+		opts[i](&c)
+	}
+
+	p := &Pool[In, Out]{
+		fn:       fn,
+		timeout:  c.timeout,
+		space:    make(chan struct{}),
+		maxQueue: c.queue,
+		done:     make(chan struct{}),
+	}
+	p.work = sync.NewCond(&p.mu)
+	p.ctx, p.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	if c.results >= 0 {
+		p.results = make(chan Result[In, Out], c.results)
 	}
 
-	wp.setDefaults()
+	p.Resize(c.workers)
+	p.mu.Lock()
+	p.stopAfter = context.AfterFunc(ctx, p.Stop)
+	p.mu.Unlock()
 
-	// Dispatcher
-	go wp.dispatcher(ctx)
+	return p
+}
 
-	// Workers
-	for i := range wp.maxWorkers {
-		go wp.worker(ctx, i)
-	}
+// Results returns the channel of the results closed after the pool, nil without WithResults
+func (p *Pool[In, Out]) Results() <-chan Result[In, Out] {
+	return p.results
+}
 
-	return wp
+// Submit queues the task with the Normal priority
+func (p *Pool[In, Out]) Submit(ctx context.Context, in In) (*Future[Out], error) {
+	return p.SubmitPriority(ctx, Normal, in)
 }
 
-func (wp *WorkerPool) setDefaults() {
-	if wp.taskQueue == nil {
-		wp.taskQueue = make(chan Task)
+// SubmitPriority queues the task to the lane, waiting for the room in the queue until the timeout or ctx is done
+func (p *Pool[In, Out]) SubmitPriority(ctx context.Context, prio Priority, in In) (*Future[Out], error) {
+	if prio < Low || prio > High {
+		return nil, fmt.Errorf("unknown priority %d", prio)
 	}
 
-	if wp.workQueue == nil {
-		wp.workQueue = make(chan Task)
-	}
-}
+	t := time.NewTimer(p.timeout)
+	defer t.Stop()
 
-// dispatcher — только он пишет из taskQueue в workQueue
-func (wp *WorkerPool) dispatcher(ctx context.Context) {
 	for {
+		p.mu.Lock()
+		if p.state != running {
+			p.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if p.queued < p.maxQueue {
+			f := &Future[Out]{done: make(chan struct{})}
+			p.lanes[prio] = append(p.lanes[prio], job[In, Out]{in: in, future: f})
+			p.queued++
+			p.work.Signal()
+			p.mu.Unlock()
+			return f, nil
+		}
+		space := p.space
+		p.mu.Unlock()
+
 		select {
+		case <-space:
 		case <-ctx.Done():
-			return
+			return nil, ctx.Err()
+		case <-t.C:
+			return nil, fmt.Errorf("failed to add the task after %s", p.timeout)
+		}
+	}
+}
 
-		case task, ok := <-wp.taskQueue:
-			if !ok {
-				return
-			}
+// Resize sets the number of the workers, at least one. The extra workers exit after their current tasks
+func (p *Pool[In, Out]) Resize(n int) {
+	n = max(n, 1)
 
-			if !wp.isStopped.Load() {
-				wp.workQueue <- task
-			}
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-		case <-wp.shutdownCh:
-			return
+	if p.state != running {
+		return
+	}
+	p.workers = n
+	for p.running < n {
+		p.running++
+		p.wg.Add(1)
+		go p.worker()
+	}
+	p.work.Broadcast()
+}
+
+// Workers returns the number of the workers the pool is sized to
+func (p *Pool[In, Out]) Workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
+	return p.workers
+}
+
+// Err returns the errors of the latest maxErrors failed tasks joined, preceded by the count of the earlier ones
+func (p *Pool[In, Out]) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.dropped > 0 {
+		return errors.Join(append([]error{fmt.Errorf("%d earlier tasks failed", p.dropped)}, p.errs...)...)
+	}
+	return errors.Join(p.errs...)
+}
+
+// Shutdown stops accepting the tasks and waits for the queued and the running ones. If ctx is done first, the pool
+// is stopped like by Stop and the error of ctx is returned
+func (p *Pool[In, Out]) Shutdown(ctx context.Context) error {
+	p.close(draining)
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.Stop()
+		return ctx.Err()
+	}
+}
+
+// Stop cancels the context of the running tasks, drops the queued ones and waits for the workers to exit. It must
+// not be called from a task
+func (p *Pool[In, Out]) Stop() {
+	p.close(stopped)
+	<-p.done
+}
+
+func (p *Pool[In, Out]) close(state poolState) {
+	p.mu.Lock()
+	if state <= p.state {
+		p.mu.Unlock()
+		return
+	}
+	p.state = state
+
+	if state == stopped {
+		p.cancel()
+		for prio := range p.lanes {
+			for _, j := range p.lanes[prio] {
+				j.future.complete(*new(Out), ErrStopped)
+			}
+			p.lanes[prio] = nil
 		}
+		p.queued = 0
 	}
+	// Wakes up the waiting submitters and workers to see the new state
+	close(p.space)
+	p.space = make(chan struct{})
+	p.work.Broadcast()
+	p.mu.Unlock()
+
+	p.closeOnce.Do(func() {
+		// It's nil when the context was done before NewPool returned
+		p.mu.Lock()
+		stopAfter := p.stopAfter
+		p.mu.Unlock()
+		if stopAfter != nil {
+			stopAfter()
+		}
+		go func() {
+			p.wg.Wait()
+			p.cancel()
+			if p.results != nil {
+				close(p.results)
+			}
+			close(p.done)
+		}()
+	})
 }
 
-func (wp *WorkerPool) worker(ctx context.Context, id int) {
+func (p *Pool[In, Out]) worker() {
+	defer p.wg.Done()
+
 	for {
-		select {
-		case <-ctx.Done():
+		j, ok := p.next()
+		if !ok {
 			return
+		}
 
-		case v, ok := <-wp.workQueue:
-			if !ok {
-				return
+		out, err := p.run(j.in)
+		if err != nil {
+			p.mu.Lock()
+			p.errs = append(p.errs, fmt.Errorf("task %v: %w", j.in, err))
+			if len(p.errs) > maxErrors {
+				p.errs = p.errs[1:]
+				p.dropped++
 			}
-
-			if wp.isStopped.Load() {
-				return
+			p.mu.Unlock()
+		}
+		j.future.complete(out, err)
+
+		if p.results != nil {
+			r := Result[In, Out]{In: j.in, Out: out, Err: err}
+			// The result goes to the buffer if there's room, the stopped pool doesn't wait for the reader
+			select {
+			case p.results <- r:
+			default:
+				select {
+				case p.results <- r:
+				case <-p.ctx.Done():
+				}
 			}
-
-			fmt.Printf("Worker %d handling task %d\n", id, v.ID)
-			// Imitating work...
-			time.Sleep(2 * time.Second)
-			fmt.Printf("Worker %d finished task %d\n", id, v.ID)
 		}
 	}
 }
 
-func (wp *WorkerPool) AddTask(task Task) error {
-	return wp.addTask(task)
-}
+// next waits for the queued task of the highest priority, false means the worker has to exit
+func (p *Pool[In, Out]) next() (job[In, Out], bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-func (wp *WorkerPool) addTask(task Task) error {
-	if wp.isStopped.Load() {
-		return errors.New("worker pool stopped")
+	for {
+		switch {
+		case p.state == stopped, p.running > p.workers:
+			p.running--
+			return job[In, Out]{}, false
+
+		case p.queued > 0:
+			for prio := High; prio >= Low; prio-- {
+				if lane := p.lanes[prio]; len(lane) > 0 {
+					j := lane[0]
+					lane[0] = job[In, Out]{}
+					p.lanes[prio] = lane[1:]
+					p.queued--
+
+					close(p.space)
+					p.space = make(chan struct{})
+					return j, true
+				}
+			}
+
+		case p.state == draining:
+			p.running--
+			return job[In, Out]{}, false
+
+		default:
+			p.work.Wait()
+		}
 	}
+}
 
-	t := time.NewTicker(wp.dur)
-	defer t.Stop()
+// run calls fn, the task that panics fails instead of taking the worker down
+func (p *Pool[In, Out]) run(in In) (out Out, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
 
-	select {
-	case wp.taskQueue <- task:
-		return nil
+	return p.fn(p.ctx, in)
+}
 
-	case <-t.C:
-		return fmt.Errorf("не удалось добавить задачу после %s", wp.dur)
-	}
+func (f *Future[Out]) complete(out Out, err error) {
+	f.out, f.err = out, err
+	close(f.done)
+}
 
+// Done returns the channel closed when the task is finished or dropped
+func (f *Future[Out]) Done() <-chan struct{} {
+	return f.done
 }
 
-// Закрыть пул корректно
-func (wp *WorkerPool) Close() {
-	wp.closeOnce.Do(func() {
-		close(wp.taskQueue)
-		close(wp.shutdownCh)
-		wp.isStopped.Store(true)
-	})
+// Wait waits for the outcome of the task until ctx is done
+func (f *Future[Out]) Wait(ctx context.Context) (Out, error) {
+	select {
+	case <-f.done:
+		return f.out, f.err
+	case <-ctx.Done():
+		var zero Out
+		return zero, ctx.Err()
+	}
 }
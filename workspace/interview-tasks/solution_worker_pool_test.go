@@ -0,0 +1,401 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func double(_ context.Context, in int) (int, error) {
+	return in * 2, nil
+}
+
+// blocker is the task function that waits for release or the cancellation, started reports the started tasks
+type blocker struct {
+	started chan int
+	release chan struct{}
+}
+
+func newBlocker() *blocker {
+	return &blocker{started: make(chan int, 100), release: make(chan struct{})}
+}
+
+func (b *blocker) run(ctx context.Context, in int) (int, error) {
+	b.started <- in
+	select {
+	case <-b.release:
+		return in, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func submit(t *testing.T, p *Pool[int, int], prio Priority, in int) *Future[int] {
+	t.Helper()
+
+	f, err := p.SubmitPriority(context.Background(), prio, in)
+	if err != nil {
+		t.Fatalf("SubmitPriority(%d): %v", in, err)
+	}
+	return f
+}
+
+func TestPoolFutures(t *testing.T) {
+	ctx := context.Background()
+	p := NewPool(ctx, double, WithMaxWorkers(3))
+
+	var futures []*Future[int]
+	for i := range 20 {
+		f, err := p.Submit(ctx, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		futures = append(futures, f)
+	}
+	for i, f := range futures {
+		if out, err := f.Wait(ctx); out != i*2 || err != nil {
+			t.Errorf("task %d = %d, %v", i, out, err)
+		}
+	}
+
+	if err := p.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+	if _, err := p.Submit(ctx, 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit() after Shutdown() = %v", err)
+	}
+}
+
+func TestPoolResultsAndErrors(t *testing.T) {
+	ctx := context.Background()
+	errOdd := errors.New("odd")
+	p := NewPool(ctx, func(_ context.Context, in int) (string, error) {
+		switch {
+		case in == 3:
+			panic("three")
+		case in%2 == 1:
+			return "", errOdd
+		default:
+			return "even", nil
+		}
+	}, WithMaxWorkers(2), WithResults(0))
+
+	go func() {
+		for i := range 6 {
+			if _, err := p.Submit(ctx, i); err != nil {
+				t.Error(err)
+			}
+		}
+		p.Shutdown(ctx)
+	}()
+
+	var ok, failed int
+	for r := range p.Results() {
+		if r.Err != nil {
+			failed++
+		} else if r.Out == "even" {
+			ok++
+		}
+	}
+	if ok != 3 || failed != 3 {
+		t.Errorf("%d ok and %d failed results", ok, failed)
+	}
+
+	err := p.Err()
+	if !errors.Is(err, errOdd) || len(err.(interface{ Unwrap() []error }).Unwrap()) != 3 {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestPoolErrorsBounded(t *testing.T) {
+	ctx := context.Background()
+	p := NewPool(ctx, func(_ context.Context, in int) (int, error) {
+		return 0, errors.New("failed")
+	}, WithMaxWorkers(1))
+
+	const tasks = maxErrors + 50
+	for i := range tasks {
+		if _, err := p.Submit(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The latest failures are kept, the earlier ones are counted
+	errs := p.Err().(interface{ Unwrap() []error }).Unwrap()
+	if len(errs) != maxErrors+1 || errs[0].Error() != "50 earlier tasks failed" ||
+		errs[maxErrors].Error() != fmt.Sprintf("task %d: failed", tasks-1) {
+		t.Errorf("Err() has %d errors: %v ... %v", len(errs), errs[0], errs[len(errs)-1])
+	}
+}
+
+func TestPoolPriorities(t *testing.T) {
+	b := newBlocker()
+	p := NewPool(context.Background(), b.run, WithMaxWorkers(1), WithMaxQueue(10))
+	defer p.Stop()
+
+	// The single worker is busy while the tasks are queued
+	submit(t, p, Normal, 0)
+	<-b.started
+
+	submit(t, p, Low, 1)
+	submit(t, p, Normal, 2)
+	submit(t, p, High, 3)
+	submit(t, p, Low, 4)
+	submit(t, p, High, 5)
+	close(b.release)
+
+	want := []int{3, 5, 2, 1, 4}
+	for _, w := range want {
+		if got := <-b.started; got != w {
+			t.Fatalf("started %d, want %d of %v", got, w, want)
+		}
+	}
+
+	if _, err := p.SubmitPriority(context.Background(), Priority(7), 0); err == nil {
+		t.Error("SubmitPriority() with the unknown priority succeeded")
+	}
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	b := newBlocker()
+	p := NewPool(context.Background(), b.run, WithMaxWorkers(1), WithMaxQueue(1), WithTimeout(10*time.Millisecond))
+	defer p.Stop()
+
+	submit(t, p, Normal, 0)
+	<-b.started
+	submit(t, p, Normal, 1)
+
+	if _, err := p.Submit(context.Background(), 2); err == nil {
+		t.Error("Submit() to the full queue succeeded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = NewPool(context.Background(), b.run, WithMaxWorkers(1), WithMaxQueue(1), WithTimeout(time.Hour))
+	defer p.Stop()
+	submit(t, p, Normal, 0)
+	<-b.started
+	submit(t, p, Normal, 1)
+	if _, err := p.Submit(ctx, 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("Submit() with the cancelled context = %v", err)
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	var (
+		active, peak atomic.Int32
+		release      = make(chan struct{})
+	)
+	p := NewPool(context.Background(), func(ctx context.Context, in int) (int, error) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			if m := peak.Load(); n <= m || peak.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+		return in, nil
+	}, WithMaxWorkers(2), WithMaxQueue(100))
+
+	var futures []*Future[int]
+	for i := range 10 {
+		futures = append(futures, submit(t, p, Normal, i))
+	}
+	waitFor(t, func() bool { return active.Load() == 2 })
+
+	p.Resize(5)
+	if p.Workers() != 5 {
+		t.Errorf("Workers() = %d after Resize(5)", p.Workers())
+	}
+	waitFor(t, func() bool { return active.Load() == 5 })
+
+	p.Resize(0)
+	if p.Workers() != 1 {
+		t.Errorf("Workers() = %d after Resize(0)", p.Workers())
+	}
+	close(release)
+	for _, f := range futures {
+		if _, err := f.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if peak.Load() != 5 {
+		t.Errorf("%d tasks ran at once, want 5", peak.Load())
+	}
+	p.Shutdown(context.Background())
+}
+
+func TestPoolShutdownDrains(t *testing.T) {
+	b := newBlocker()
+	p := NewPool(context.Background(), b.run, WithMaxWorkers(1), WithMaxQueue(5))
+
+	var futures []*Future[int]
+	for i := range 5 {
+		futures = append(futures, submit(t, p, Normal, i))
+	}
+	<-b.started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- p.Shutdown(context.Background()) }()
+
+	// The queued tasks still run, the new ones are refused
+	waitFor(t, func() bool {
+		_, err := p.Submit(context.Background(), 9)
+		return errors.Is(err, ErrClosed)
+	})
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown() = %v before the tasks finished", err)
+	default:
+	}
+
+	close(b.release)
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+	for i, f := range futures {
+		if out, err := f.Wait(context.Background()); out != i || err != nil {
+			t.Errorf("task %d = %d, %v", i, out, err)
+		}
+	}
+}
+
+func TestPoolShutdownTimeout(t *testing.T) {
+	b := newBlocker()
+	p := NewPool(context.Background(), b.run, WithMaxWorkers(1), WithMaxQueue(5))
+
+	running := submit(t, p, Normal, 0)
+	queued := submit(t, p, Normal, 1)
+	<-b.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() = %v", err)
+	}
+
+	// The running task is cancelled, the queued one is dropped
+	if _, err := running.Wait(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Errorf("the running task error %v", err)
+	}
+	if _, err := queued.Wait(context.Background()); !errors.Is(err, ErrStopped) {
+		t.Errorf("the queued task error %v", err)
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	b := newBlocker()
+	p := NewPool(context.Background(), b.run, WithMaxWorkers(2), WithMaxQueue(5), WithResults(10))
+
+	futures := []*Future[int]{submit(t, p, Normal, 0), submit(t, p, Normal, 1), submit(t, p, Normal, 2)}
+	<-b.started
+	<-b.started
+
+	p.Stop()
+	for i, f := range futures {
+		select {
+		case <-f.Done():
+		default:
+			t.Fatalf("task %d isn't done after Stop()", i)
+		}
+	}
+	if _, err := futures[2].Wait(context.Background()); !errors.Is(err, ErrStopped) {
+		t.Errorf("the queued task error %v", err)
+	}
+
+	// The results of the cancelled tasks were sent, the channel is closed
+	n := 0
+	for range p.Results() {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("%d results after Stop()", n)
+	}
+
+	// Everything after Stop is a no-op
+	p.Stop()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() after Stop() = %v", err)
+	}
+	if _, err := p.Submit(context.Background(), 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit() after Stop() = %v", err)
+	}
+	p.Resize(3)
+	if p.Workers() != 2 {
+		t.Errorf("Resize() after Stop() changed the workers to %d", p.Workers())
+	}
+}
+
+func TestPoolParentContext(t *testing.T) {
+	b := newBlocker()
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewPool(ctx, b.run, WithMaxWorkers(1))
+
+	f := submit(t, p, Normal, 0)
+	<-b.started
+	cancel()
+
+	if _, err := f.Wait(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Errorf("the task error %v", err)
+	}
+	waitFor(t, func() bool {
+		_, err := p.Submit(context.Background(), 1)
+		return errors.Is(err, ErrClosed)
+	})
+	p.Stop()
+}
+
+// TestPoolConcurrentClose races the submitters, the resizes, Shutdown and Stop, the race detector and the absence
+// of the panics and the deadlocks are what's checked
+func TestPoolConcurrentClose(t *testing.T) {
+	for range 50 {
+		p := NewPool(context.Background(), double, WithMaxWorkers(2), WithMaxQueue(2), WithResults(1))
+
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range 20 {
+					f, err := p.SubmitPriority(context.Background(), Priority(j%3), i*j)
+					if err != nil {
+						continue
+					}
+					if out, err := f.Wait(context.Background()); err == nil && out != i*j*2 {
+						t.Errorf("task %d = %d", i*j, out)
+					}
+				}
+			}()
+		}
+		wg.Add(4)
+		go func() { defer wg.Done(); p.Resize(4) }()
+		go func() { defer wg.Done(); p.Shutdown(context.Background()) }()
+		go func() { defer wg.Done(); p.Stop() }()
+		go func() {
+			defer wg.Done()
+			for range p.Results() {
+			}
+		}()
+		wg.Wait()
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("the condition wasn't met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}